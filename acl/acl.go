@@ -0,0 +1,236 @@
+// Package acl provides users with passwords, command permissions and key patterns
+package acl
+
+import (
+	"fmt"
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+访问控制列表(ACL)
+每个连接以一个用户的身份执行命令，未认证的连接是 default 用户
+	AUTH username password 以指定用户认证，AUTH password 以 default 用户认证
+	default 用户为 nopass 时，连接不需要认证；配置了 requirepass 时，requirepass 为 default 用户的密码
+执行命令前检查：
+	1. 用户的命令规则是否允许执行该命令，命令的分类(@read、@write 等)在注册命令时指定
+	2. 命令涉及的 key 是否匹配用户的某个 key 模式
+被拒绝的命令和失败的认证记录在 ACL LOG 中
+配置了 aclfile 时，启动时从该文件加载用户，ACL SAVE 保存到该文件，ACL LOAD 重新加载
+*/
+
+// DefaultUser is the user of connections which have not authenticated
+const DefaultUser = "default"
+
+const (
+	defaultLogMaxLen = 128
+	logGroupInterval = 60 * time.Second // 该时间内相同的拒绝记录合并为一条，增加计数
+)
+
+// ACL holds all users
+type ACL struct {
+	mu    sync.RWMutex
+	users map[string]*User
+
+	logMu sync.Mutex
+	logs  []*logEntry // 最新的记录在前
+}
+
+// logEntry is a record of denied command or failed authentication
+type logEntry struct {
+	count      int
+	reason     string // command、key、channel、auth
+	context    string // toplevel
+	object     string // 被拒绝的命令、key、频道，认证失败时为 AUTH
+	username   string
+	clientInfo string
+	createdAt  time.Time
+	updatedAt  time.Time
+}
+
+// MakeACL creates ACL with default user, and loads users from aclfile if configured
+func MakeACL() (*ACL, error) {
+	a := &ACL{
+		users: map[string]*User{DefaultUser: makeDefaultUser()},
+	}
+	if config.Properties.AclFile != "" {
+		if err := a.load(config.Properties.AclFile); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// makeDefaultUser creates default user according to requirepass
+func makeDefaultUser() *User {
+	user := newUser(DefaultUser)
+	rules := []string{"on", "~*", "&*", "+@all"}
+	if requirePass := config.Load().RequirePass; requirePass != "" {
+		rules = append(rules, ">"+requirePass)
+	} else {
+		rules = append(rules, "nopass")
+	}
+	_ = user.applyRules(rules)
+	return user
+}
+
+// SetDefaultPassword replaces the passwords of default user, called when requirepass is changed by CONFIG SET
+// 与 Redis 相同，密码为空时 default 用户变为 nopass
+func (a *ACL) SetDefaultPassword(password string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var user *User
+	if existing, ok := a.users[DefaultUser]; ok {
+		user = existing.clone()
+	} else {
+		user = makeDefaultUser()
+	}
+	rules := []string{"resetpass", "nopass"}
+	if password != "" {
+		rules = []string{"resetpass", ">" + password}
+	}
+	_ = user.applyRules(rules)
+	a.users[DefaultUser] = user
+}
+
+// getUser returns the user, returns nil if not exists
+func (a *ACL) getUser(name string) *User {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.users[name]
+}
+
+// IsDefaultNoPass returns whether connections could execute commands as default user without AUTH
+func (a *ACL) IsDefaultNoPass() bool {
+	user := a.getUser(DefaultUser)
+	return user != nil && user.Enabled && user.NoPass
+}
+
+// IsUserEnabled returns whether the user exists and is on
+func (a *ACL) IsUserEnabled(username string) bool {
+	user := a.getUser(username)
+	return user != nil && user.Enabled
+}
+
+// Authenticate checks the password of user
+func (a *ACL) Authenticate(username string, password string) bool {
+	user := a.getUser(username)
+	return user != nil && user.checkPassword(password)
+}
+
+// Check checks whether the user of connection could execute the command, returns error reply if denied
+func (a *ACL) Check(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	user := a.getUser(c.GetUser())
+	if user == nil || !user.Enabled {
+		// 用户已被删除或禁用，需要重新认证
+		c.SetAuthenticated(false)
+		return reply.MakeErrReply("NOAUTH Authentication required.")
+	}
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if _, known := commandCategories(cmdName); !known {
+		return nil // 未知命令由数据库回复错误
+	}
+	if !user.canExecute(cmdName) {
+		a.addLog(c, "command", cmdName)
+		return reply.MakeErrReply(fmt.Sprintf("NOPERM User %s has no permissions to run the '%s' command", user.Name, cmdName))
+	}
+	writeKeys, readKeys := database.GetRelatedKeys(cmdLine)
+	for _, keys := range [][]string{writeKeys, readKeys} {
+		for _, key := range keys {
+			if !user.canAccessKey(key) {
+				a.addLog(c, "key", key)
+				return reply.MakeErrReply("NOPERM No permissions to access a key")
+			}
+		}
+	}
+	return nil
+}
+
+// CheckKeyPrefix checks whether the user of connection could access all keys with the prefix, used by CLIENT TRACKING BCAST
+func (a *ACL) CheckKeyPrefix(c resp.Connection, prefix string) resp.Reply {
+	user := a.getUser(c.GetUser())
+	if user == nil || !user.canAccessPrefix(prefix) {
+		a.addLog(c, "key", prefix)
+		return reply.MakeErrReply("NOPERM No permissions to access a key")
+	}
+	return nil
+}
+
+// CheckChannel checks whether the user of connection could access the channel, returns error reply if denied
+func (a *ACL) CheckChannel(c resp.Connection, channel string) resp.Reply {
+	user := a.getUser(c.GetUser())
+	if user == nil || !user.canAccessChannel(channel) {
+		a.addLog(c, "channel", channel)
+		return reply.MakeErrReply("NOPERM No permissions to access a channel")
+	}
+	return nil
+}
+
+// LogAuthFailure records a failed AUTH
+func (a *ACL) LogAuthFailure(c resp.Connection, username string) {
+	a.addLogWithUser(c, "auth", "AUTH", username)
+}
+
+func (a *ACL) addLog(c resp.Connection, reason string, object string) {
+	a.addLogWithUser(c, reason, object, c.GetUser())
+}
+
+func (a *ACL) addLogWithUser(c resp.Connection, reason string, object string, username string) {
+	now := time.Now()
+	clientInfo := fmt.Sprintf("id=%d addr=%s name=%s user=%s db=%d",
+		c.GetID(), c.RemoteAddr().String(), c.GetName(), c.GetUser(), c.GetDBIndex())
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	for i, entry := range a.logs {
+		if entry.reason == reason && entry.object == object && entry.username == username &&
+			now.Sub(entry.updatedAt) < logGroupInterval {
+			entry.count++
+			entry.updatedAt = now
+			entry.clientInfo = clientInfo
+			// 移到最前
+			copy(a.logs[1:i+1], a.logs[:i])
+			a.logs[0] = entry
+			return
+		}
+	}
+	entry := &logEntry{
+		count:      1,
+		reason:     reason,
+		context:    "toplevel",
+		object:     object,
+		username:   username,
+		clientInfo: clientInfo,
+		createdAt:  now,
+		updatedAt:  now,
+	}
+	a.logs = append([]*logEntry{entry}, a.logs...)
+	if maxLen := getLogMaxLen(); len(a.logs) > maxLen {
+		a.logs = a.logs[:maxLen]
+	}
+}
+
+func getLogMaxLen() int {
+	maxLen := config.Load().AclLogMaxLen
+	if maxLen <= 0 {
+		return defaultLogMaxLen
+	}
+	return maxLen
+}
+
+// userNames returns names of all users in order
+func (a *ACL) userNames() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,174 @@
+package acl
+
+import (
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func makeTestConn(t *testing.T, user string) *connection.Connection {
+	local, remote := net.Pipe()
+	t.Cleanup(func() {
+		_ = local.Close()
+		_ = remote.Close()
+	})
+	c := connection.NewConn(local)
+	c.SetUser(user)
+	return c
+}
+
+func toCmdLine(cmd ...string) [][]byte {
+	args := make([][]byte, len(cmd))
+	for i, s := range cmd {
+		args[i] = []byte(s)
+	}
+	return args
+}
+
+func TestCheck(t *testing.T) {
+	a := &ACL{users: map[string]*User{DefaultUser: makeDefaultUser()}}
+	user := newUser("alice")
+	if err := user.applyRules([]string{"on", "nopass", "~user:*", "+@read"}); err != nil {
+		t.Fatal(err)
+	}
+	a.users["alice"] = user
+	c := makeTestConn(t, "alice")
+
+	tests := []struct {
+		cmdLine []string
+		errMsg  string
+	}{
+		{cmdLine: []string{"get", "user:1"}},
+		{cmdLine: []string{"GET", "user:1"}},
+		{cmdLine: []string{"get", "order:1"}, errMsg: "NOPERM No permissions to access a key"},
+		{cmdLine: []string{"mget", "user:1", "order:1"}, errMsg: "NOPERM No permissions to access a key"},
+		{cmdLine: []string{"set", "user:1", "v"}, errMsg: "NOPERM User alice has no permissions to run the 'set' command"},
+		{cmdLine: []string{"nosuchcommand"}},
+	}
+	for _, tt := range tests {
+		result := a.Check(c, toCmdLine(tt.cmdLine...))
+		if tt.errMsg == "" {
+			if result != nil {
+				t.Errorf("%v should be allowed, got %s", tt.cmdLine, result.ToBytes())
+			}
+			continue
+		}
+		if errReply, ok := result.(reply.ErrorReply); !ok || errReply.Error() != tt.errMsg {
+			t.Errorf("%v: expected %q, got %v", tt.cmdLine, tt.errMsg, result)
+		}
+	}
+
+	// 禁用用户之后需要重新认证
+	a.users["alice"].Enabled = false
+	c.SetAuthenticated(true)
+	result := a.Check(c, toCmdLine("get", "user:1"))
+	if errReply, ok := result.(reply.ErrorReply); !ok || !strings.HasPrefix(errReply.Error(), "NOAUTH") {
+		t.Errorf("disabled user: expected NOAUTH, got %v", result)
+	}
+}
+
+func TestLoadAndSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "users.acl")
+	a := &ACL{users: map[string]*User{DefaultUser: makeDefaultUser()}}
+	if err := a.load(path); err != nil {
+		t.Fatalf("missing aclfile should be ignored on first start: %v", err)
+	}
+	if r := a.Exec(makeTestConn(t, DefaultUser), toCmdLine("acl", "setuser", "alice", "on", ">secret", "~user:*", "&news", "+@read", "-keys")); reply.IsErrorReply(r) {
+		t.Fatalf("setuser failed: %s", r.ToBytes())
+	}
+	if err := a.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &ACL{users: map[string]*User{DefaultUser: makeDefaultUser()}}
+	if err := loaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(loaded.userNames(), ","), "alice,default"; got != want {
+		t.Fatalf("loaded users %s, want %s", got, want)
+	}
+	for _, name := range a.userNames() {
+		if got, want := loaded.getUser(name).describe(), a.getUser(name).describe(); got != want {
+			t.Errorf("user %s: loaded %q, saved %q", name, got, want)
+		}
+	}
+	if !loaded.Authenticate("alice", "secret") {
+		t.Error("alice should authenticate with the saved password")
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "no user keyword", content: "alice on nopass\n"},
+		{name: "duplicate user", content: "user alice on\nuser alice off\n"},
+		{name: "invalid rule", content: "user alice on +nosuchcommand\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "users.acl")
+			if err := os.WriteFile(path, []byte("# users\n\n"+tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			a := &ACL{users: map[string]*User{DefaultUser: makeDefaultUser()}}
+			if err := a.load(path); err == nil {
+				t.Fatal("expected error")
+			}
+			// 出错时不修改现有用户
+			if got := strings.Join(a.userNames(), ","); got != DefaultUser {
+				t.Errorf("users should be kept, got %s", got)
+			}
+		})
+	}
+}
+
+func TestLogGrouping(t *testing.T) {
+	a := &ACL{users: map[string]*User{DefaultUser: makeDefaultUser()}}
+	user := newUser("alice")
+	if err := user.applyRules([]string{"on", "nopass", "allkeys", "+get"}); err != nil {
+		t.Fatal(err)
+	}
+	a.users["alice"] = user
+	c := makeTestConn(t, "alice")
+
+	a.Check(c, toCmdLine("set", "k", "v"))
+	a.Check(c, toCmdLine("del", "k"))
+	a.Check(c, toCmdLine("set", "k", "v"))
+	a.LogAuthFailure(c, "bob")
+
+	type entry struct {
+		reason string
+		object string
+		user   string
+		count  int
+	}
+	expected := []entry{
+		{reason: "auth", object: "AUTH", user: "bob", count: 1},
+		// 重复的记录合并，并移到最前
+		{reason: "command", object: "set", user: "alice", count: 2},
+		{reason: "command", object: "del", user: "alice", count: 1},
+	}
+	if len(a.logs) != len(expected) {
+		t.Fatalf("expected %d log entries, got %d", len(expected), len(a.logs))
+	}
+	for i, want := range expected {
+		got := a.logs[i]
+		if got.reason != want.reason || got.object != want.object || got.username != want.user || got.count != want.count {
+			t.Errorf("entry %d: got %+v, want %+v", i, *got, want)
+		}
+	}
+
+	r, ok := a.Exec(c, toCmdLine("acl", "log", "1")).(*reply.MultiRawReply)
+	if !ok || len(r.Replies) != 1 {
+		t.Fatalf("ACL LOG 1 should return 1 entry, got %v", r)
+	}
+	if r := a.Exec(c, toCmdLine("acl", "log", "reset")); reply.IsErrorReply(r) || len(a.logs) != 0 {
+		t.Error("ACL LOG RESET should clear entries")
+	}
+}
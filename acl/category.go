@@ -0,0 +1,113 @@
+package acl
+
+import (
+	"go-redis/database"
+	"sort"
+	"strings"
+)
+
+// allCategory is the pseudo category which contains all commands
+const allCategory = "all"
+
+// categoryNames are the categories which could be granted by +@category
+var categoryNames = []string{
+	"keyspace", "read", "write", "string", "fast", "slow", "admin", "dangerous", "connection", "pubsub",
+}
+
+// serverCommands are the ACL categories of commands which are not in the command table of database
+// 由协议层、StandaloneDatabase 或集群处理的命令
+var serverCommands = map[string][]string{
+	"auth":     {"@fast", "@connection"},
+	"hello":    {"@fast", "@connection"},
+	"quit":     {"@fast", "@connection"},
+	"select":   {"@fast", "@connection"},
+	"flushall": {"@keyspace", "@write", "@slow", "@dangerous"},
+	"acl":      {"@admin", "@slow", "@dangerous"},
+	"info":     {"@slow", "@dangerous"},
+	"config":   {"@admin", "@slow", "@dangerous"},
+	"client":   {"@admin", "@slow", "@dangerous", "@connection"},
+
+	// 发布订阅
+	"subscribe":   {"@pubsub", "@slow"},
+	"unsubscribe": {"@pubsub", "@slow"},
+	"publish":     {"@pubsub", "@fast"},
+
+	// 集群命令
+	"cluster":    {"@admin", "@slow", "@dangerous"},
+	"asking":     {"@fast", "@connection"},
+	"readonly":   {"@fast", "@connection"},
+	"readwrite":  {"@fast", "@connection"},
+	"readpolicy": {"@fast", "@connection"},
+	"migrate":    {"@keyspace", "@write", "@slow", "@dangerous"},
+
+	// 节点之间的内部命令
+	"restore-asking": {"@keyspace", "@write", "@slow", "@dangerous"},
+	"psync":          {"@admin", "@slow", "@dangerous"},
+	"replconf":       {"@admin", "@slow", "@dangerous"},
+	"prepare":        {"@admin", "@slow", "@dangerous"},
+	"commit":         {"@admin", "@slow", "@dangerous"},
+	"rollback":       {"@admin", "@slow", "@dangerous"},
+	"exec-local":     {"@admin", "@slow", "@dangerous"},
+	"cache-get":      {"@admin", "@slow", "@dangerous"},
+}
+
+// commandCategories returns the categories of command, ok is false if the command is unknown
+func commandCategories(name string) ([]string, bool) {
+	if categories, ok := serverCommands[name]; ok {
+		return categories, true
+	}
+	return database.GetCommandCategories(name)
+}
+
+// isValidCategory returns whether the category could be used in +@category
+func isValidCategory(category string) bool {
+	if category == allCategory {
+		return true
+	}
+	for _, name := range categoryNames {
+		if name == category {
+			return true
+		}
+	}
+	return false
+}
+
+// allCommandNames returns all known commands in order
+func allCommandNames() []string {
+	names := database.GetCommandNames()
+	for name := range serverCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandsInCategory returns the commands of category in order
+func commandsInCategory(category string) []string {
+	result := make([]string, 0)
+	for _, name := range allCommandNames() {
+		if category == allCategory {
+			result = append(result, name)
+			continue
+		}
+		categories, _ := commandCategories(name)
+		for _, c := range categories {
+			if strings.TrimPrefix(c, "@") == category {
+				result = append(result, name)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// IsWriteCommand returns whether the command is in @write category
+func IsWriteCommand(name string) bool {
+	categories, _ := commandCategories(name)
+	for _, c := range categories {
+		if c == "@write" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package acl
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec executes ACL subcommands
+// ACL SETUSER|GETUSER|DELUSER|LIST|USERS|WHOAMI|CAT|LOG|SAVE|LOAD
+func (a *ACL) Exec(c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("acl")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "setuser":
+		return a.execSetUser(args[2:])
+	case "getuser":
+		return a.execGetUser(args[2:])
+	case "deluser":
+		return a.execDelUser(args[2:])
+	case "list":
+		return a.execList()
+	case "users":
+		return toMultiBulk(a.userNames())
+	case "whoami":
+		return reply.MakeBulkReply([]byte(c.GetUser()))
+	case "cat":
+		return execCat(args[2:])
+	case "log":
+		return a.execLog(args[2:])
+	case "save":
+		return a.execSave()
+	case "load":
+		return a.execLoad()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[1]) + "'. Try ACL HELP.")
+}
+
+// ACL SETUSER username [rule [rule ...]]
+// 所有规则都合法时才修改用户
+func (a *ACL) execSetUser(args [][]byte) resp.Reply {
+	if len(args) < 1 {
+		return reply.MakeArgNumErrReply("acl|setuser")
+	}
+	name := string(args[0])
+	if name == "" || strings.ContainsAny(name, " \t\r\n") {
+		return reply.MakeErrReply("ERR Usernames can't contain spaces or null characters")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var user *User
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	} else {
+		user = newUser(name)
+	}
+	for _, arg := range args[1:] {
+		if err := user.applyRule(string(arg)); err != nil {
+			return reply.MakeErrReply("ERR Error in ACL SETUSER modifier '" + string(arg) + "': " + err.Error())
+		}
+	}
+	user.compile()
+	a.users[name] = user
+	return reply.MakeOkReply()
+}
+
+// ACL GETUSER username
+func (a *ACL) execGetUser(args [][]byte) resp.Reply {
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("acl|getuser")
+	}
+	user := a.getUser(string(args[0]))
+	if user == nil {
+		return reply.MakeNullBulkReply()
+	}
+	flags := []string{"off"}
+	if user.Enabled {
+		flags[0] = "on"
+	}
+	if user.NoPass {
+		flags = append(flags, "nopass")
+	}
+	keys := make([]string, len(user.Keys))
+	for i, key := range user.Keys {
+		keys[i] = "~" + key
+	}
+	channels := make([]string, len(user.Channels))
+	for i, channel := range user.Channels {
+		channels[i] = "&" + channel
+	}
+	return reply.MakeMapReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("flags")), toMultiBulk(flags),
+		reply.MakeBulkReply([]byte("passwords")), toMultiBulk(user.sortedPasswords()),
+		reply.MakeBulkReply([]byte("commands")), reply.MakeBulkReply([]byte(strings.Join(user.CommandRules, " "))),
+		reply.MakeBulkReply([]byte("keys")), reply.MakeBulkReply([]byte(strings.Join(keys, " "))),
+		reply.MakeBulkReply([]byte("channels")), reply.MakeBulkReply([]byte(strings.Join(channels, " "))),
+	})
+}
+
+// ACL DELUSER username [username ...]
+func (a *ACL) execDelUser(args [][]byte) resp.Reply {
+	if len(args) < 1 {
+		return reply.MakeArgNumErrReply("acl|deluser")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for _, arg := range args {
+		if string(arg) == DefaultUser {
+			return reply.MakeErrReply("ERR The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, arg := range args {
+		if _, ok := a.users[string(arg)]; ok {
+			delete(a.users, string(arg))
+			deleted++
+		}
+	}
+	return reply.MakeIntReply(int64(deleted))
+}
+
+// ACL LIST
+// user default on nopass ~* &* +@all
+func (a *ACL) execList() resp.Reply {
+	names := a.userNames()
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		if user := a.getUser(name); user != nil {
+			lines = append(lines, "user "+user.Name+" "+user.describe())
+		}
+	}
+	return toMultiBulk(lines)
+}
+
+// ACL CAT [category]
+func execCat(args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return toMultiBulk(categoryNames)
+	}
+	if len(args) != 1 {
+		return reply.MakeArgNumErrReply("acl|cat")
+	}
+	category := strings.ToLower(string(args[0]))
+	if !isValidCategory(category) {
+		return reply.MakeErrReply("ERR Unknown category '" + category + "'")
+	}
+	return toMultiBulk(commandsInCategory(category))
+}
+
+// ACL LOG [count|RESET]
+func (a *ACL) execLog(args [][]byte) resp.Reply {
+	count := -1
+	if len(args) > 1 {
+		return reply.MakeArgNumErrReply("acl|log")
+	}
+	if len(args) == 1 {
+		if strings.EqualFold(string(args[0]), "reset") {
+			a.logMu.Lock()
+			a.logs = nil
+			a.logMu.Unlock()
+			return reply.MakeOkReply()
+		}
+		n, err := strconv.Atoi(string(args[0]))
+		if err != nil || n < 0 {
+			return reply.MakeErrReply("ERR value is out of range, must be positive")
+		}
+		count = n
+	}
+	a.logMu.Lock()
+	defer a.logMu.Unlock()
+	now := time.Now()
+	entries := make([]resp.Reply, 0, len(a.logs))
+	for _, entry := range a.logs {
+		if count >= 0 && len(entries) >= count {
+			break
+		}
+		age := now.Sub(entry.createdAt).Seconds()
+		entries = append(entries, reply.MakeMapReply([]resp.Reply{
+			reply.MakeBulkReply([]byte("count")), reply.MakeIntReply(int64(entry.count)),
+			reply.MakeBulkReply([]byte("reason")), reply.MakeBulkReply([]byte(entry.reason)),
+			reply.MakeBulkReply([]byte("context")), reply.MakeBulkReply([]byte(entry.context)),
+			reply.MakeBulkReply([]byte("object")), reply.MakeBulkReply([]byte(entry.object)),
+			reply.MakeBulkReply([]byte("username")), reply.MakeBulkReply([]byte(entry.username)),
+			reply.MakeBulkReply([]byte("age-seconds")), reply.MakeDoubleReply(age),
+			reply.MakeBulkReply([]byte("client-info")), reply.MakeBulkReply([]byte(entry.clientInfo)),
+		}))
+	}
+	return reply.MakeMultiRawReply(entries)
+}
+
+// ACL SAVE
+func (a *ACL) execSave() resp.Reply {
+	path, err := getAclFile()
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	if err = a.save(path); err != nil {
+		return reply.MakeErrReply("ERR There was an error trying to save the ACLs. Please check the server logs for more information: " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+// ACL LOAD
+func (a *ACL) execLoad() resp.Reply {
+	path, err := getAclFile()
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	if err = a.load(path); err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeOkReply()
+}
+
+func toMultiBulk(list []string) resp.Reply {
+	args := make([][]byte, len(list))
+	for i, s := range list {
+		args[i] = []byte(s)
+	}
+	return reply.MakeMultiBulkReply(args)
+}
@@ -0,0 +1,99 @@
+package acl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// load replaces all users with the users in aclfile
+// 每行一个用户：user <name> <rules...>，空行和 # 开头的行被忽略
+// 文件中有任何错误时不修改现有用户；文件中没有 default 用户时保留现有的 default 用户
+func (a *ACL) load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) && a.getUser(DefaultUser) != nil && len(a.userNames()) == 1 {
+			// 首次启动时文件还不存在，ACL SAVE 时创建
+			logger.Warn("aclfile " + path + " does not exist")
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "user" {
+			return fmt.Errorf("%s:%d: line should start with user keyword", path, lineNum)
+		}
+		name := fields[1]
+		if _, ok := users[name]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, lineNum, name)
+		}
+		user := newUser(name)
+		if err := user.applyRules(fields[2:]); err != nil {
+			return fmt.Errorf("%s:%d: %v", path, lineNum, err)
+		}
+		users[name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := users[DefaultUser]; !ok {
+		users[DefaultUser] = a.users[DefaultUser]
+	}
+	a.users = users
+	return nil
+}
+
+// save writes all users to aclfile
+// 先写入临时文件再重命名，避免写入过程中出错导致文件损坏
+func (a *ACL) save(path string) error {
+	var builder strings.Builder
+	for _, name := range a.userNames() {
+		user := a.getUser(name)
+		if user == nil {
+			continue
+		}
+		builder.WriteString("user " + user.Name + " " + user.describe() + "\n")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.WriteString(builder.String()); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getAclFile returns the path of aclfile, returns error if not configured
+func getAclFile() (string, error) {
+	if config.Properties.AclFile == "" {
+		return "", errors.New("This Redis instance is not configured to use an ACL file. " +
+			"You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE " +
+			"(assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+	}
+	return config.Properties.AclFile, nil
+}
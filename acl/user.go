@@ -0,0 +1,316 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go-redis/lib/wildcard"
+	"sort"
+	"strings"
+)
+
+// User is an ACL user, users are immutable, SETUSER creates a modified copy
+// 用户创建后不再修改，修改规则时复制一份，检查权限时不需要加锁
+type User struct {
+	Name      string
+	Enabled   bool                // on/off，禁用的用户不能认证
+	NoPass    bool                // nopass，任意密码都可以认证
+	Passwords map[string]struct{} // 密码的 SHA-256 哈希(十六进制)
+
+	Keys     []string // 允许访问的 key 的模式，~pattern
+	Channels []string // 允许访问的频道的模式，&pattern
+	// 命令规则，按照设置的顺序依次生效，如 +@all -flushall
+	// +@all、-@all 会覆盖之前所有的命令规则，只保留自身
+	CommandRules []string
+
+	keyPatterns     []*wildcard.Pattern
+	channelPatterns []*wildcard.Pattern
+	allowed         map[string]struct{} // 根据命令规则计算出的允许执行的命令
+}
+
+// newUser creates a user which is off and could do nothing
+func newUser(name string) *User {
+	return &User{
+		Name:         name,
+		Passwords:    make(map[string]struct{}),
+		CommandRules: []string{"-@all"},
+		allowed:      make(map[string]struct{}),
+	}
+}
+
+// hashPassword returns the hex SHA-256 hash of password
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// clone returns a copy of user which could be modified
+func (u *User) clone() *User {
+	copied := &User{
+		Name:         u.Name,
+		Enabled:      u.Enabled,
+		NoPass:       u.NoPass,
+		Passwords:    make(map[string]struct{}, len(u.Passwords)),
+		Keys:         append([]string(nil), u.Keys...),
+		Channels:     append([]string(nil), u.Channels...),
+		CommandRules: append([]string(nil), u.CommandRules...),
+	}
+	for hash := range u.Passwords {
+		copied.Passwords[hash] = struct{}{}
+	}
+	copied.compile()
+	return copied
+}
+
+// applyRules applies rules to user in order
+func (u *User) applyRules(rules []string) error {
+	for _, rule := range rules {
+		if err := u.applyRule(rule); err != nil {
+			return err
+		}
+	}
+	u.compile()
+	return nil
+}
+
+// applyRule applies a rule of ACL SETUSER
+// on off nopass resetpass >password <password #hash !hash
+// ~pattern allkeys resetkeys &pattern allchannels resetchannels
+// +command -command +@category -@category allcommands nocommands reset
+func (u *User) applyRule(rule string) error {
+	if rule == "" {
+		return errors.New("empty rule")
+	}
+	switch strings.ToLower(rule) {
+	case "on":
+		u.Enabled = true
+		return nil
+	case "off":
+		u.Enabled = false
+		return nil
+	case "nopass":
+		u.NoPass = true
+		u.Passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.NoPass = false
+		u.Passwords = make(map[string]struct{})
+		return nil
+	case "allkeys":
+		u.Keys = []string{"*"}
+		return nil
+	case "resetkeys":
+		u.Keys = nil
+		return nil
+	case "allchannels":
+		u.Channels = []string{"*"}
+		return nil
+	case "resetchannels":
+		u.Channels = nil
+		return nil
+	case "allcommands":
+		return u.applyRule("+@all")
+	case "nocommands":
+		return u.applyRule("-@all")
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@all"} {
+			_ = u.applyRule(r)
+		}
+		return nil
+	}
+	arg := rule[1:]
+	switch rule[0] {
+	case '>':
+		u.Passwords[hashPassword(arg)] = struct{}{}
+		u.NoPass = false
+	case '<':
+		hash := hashPassword(arg)
+		if _, ok := u.Passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.Passwords, hash)
+	case '#':
+		if _, err := hex.DecodeString(arg); err != nil || len(arg) != sha256.Size*2 {
+			return errors.New("the password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+		}
+		u.Passwords[strings.ToLower(arg)] = struct{}{}
+		u.NoPass = false
+	case '!':
+		if _, ok := u.Passwords[strings.ToLower(arg)]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.Passwords, strings.ToLower(arg))
+	case '~':
+		if !contains(u.Keys, "*") {
+			u.Keys = appendUnique(u.Keys, arg)
+		}
+	case '&':
+		if !contains(u.Channels, "*") {
+			u.Channels = appendUnique(u.Channels, arg)
+		}
+	case '+', '-':
+		return u.applyCommandRule(rule)
+	default:
+		return errors.New("syntax error")
+	}
+	return nil
+}
+
+// applyCommandRule adds +command, -command, +@category or -@category
+func (u *User) applyCommandRule(rule string) error {
+	name := strings.ToLower(rule[1:])
+	if strings.HasPrefix(name, "@") {
+		category := name[1:]
+		if !isValidCategory(category) {
+			return errors.New("unknown command category '" + category + "'")
+		}
+		if category == allCategory {
+			u.CommandRules = []string{rule[:1] + "@all"}
+			return nil
+		}
+	} else if _, ok := commandCategories(name); !ok {
+		return errors.New("unknown command '" + name + "'")
+	}
+	u.CommandRules = append(u.CommandRules, rule[:1]+name)
+	return nil
+}
+
+// compile compiles patterns and computes allowed commands
+func (u *User) compile() {
+	u.keyPatterns = compilePatterns(u.Keys)
+	u.channelPatterns = compilePatterns(u.Channels)
+	u.allowed = make(map[string]struct{})
+	for _, rule := range u.CommandRules {
+		allow := rule[0] == '+'
+		var names []string
+		if strings.HasPrefix(rule[1:], "@") {
+			names = commandsInCategory(rule[2:])
+		} else {
+			names = []string{rule[1:]}
+		}
+		for _, name := range names {
+			if allow {
+				u.allowed[name] = struct{}{}
+			} else {
+				delete(u.allowed, name)
+			}
+		}
+	}
+}
+
+func compilePatterns(patterns []string) []*wildcard.Pattern {
+	result := make([]*wildcard.Pattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		result = append(result, wildcard.CompilePattern(pattern))
+	}
+	return result
+}
+
+// canExecute returns whether the user could execute the command
+func (u *User) canExecute(cmdName string) bool {
+	_, ok := u.allowed[cmdName]
+	return ok
+}
+
+// canAccessKey returns whether the key matches the key patterns of user
+func (u *User) canAccessKey(key string) bool {
+	return matchPatterns(u.Keys, u.keyPatterns, key)
+}
+
+// canAccessPrefix returns whether all keys with the prefix could be accessed
+// 只有 * 或形如 prefix* 且前缀包含该前缀的 key 模式才覆盖所有以该前缀开头的 key
+func (u *User) canAccessPrefix(prefix string) bool {
+	for _, raw := range u.Keys {
+		if raw == "*" {
+			return true
+		}
+		base, ok := strings.CutSuffix(raw, "*")
+		if ok && !strings.ContainsAny(base, "*?[\\") && strings.HasPrefix(prefix, base) {
+			return true
+		}
+	}
+	return false
+}
+
+// canAccessChannel returns whether the channel matches the channel patterns of user
+func (u *User) canAccessChannel(channel string) bool {
+	return matchPatterns(u.Channels, u.channelPatterns, channel)
+}
+
+func matchPatterns(raw []string, patterns []*wildcard.Pattern, s string) bool {
+	for i, pattern := range patterns {
+		if raw[i] == "*" || pattern.IsMatch(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword returns whether the password is correct
+func (u *User) checkPassword(password string) bool {
+	if !u.Enabled {
+		return false
+	}
+	if u.NoPass {
+		return true
+	}
+	_, ok := u.Passwords[hashPassword(password)]
+	return ok
+}
+
+// sortedPasswords returns password hashes in order
+func (u *User) sortedPasswords() []string {
+	hashes := make([]string, 0, len(u.Passwords))
+	for hash := range u.Passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// describe returns the rules which could recreate the user
+// 格式与 ACL LIST 及 aclfile 相同：on nopass #hash ~pattern &pattern +@all
+func (u *User) describe() string {
+	parts := make([]string, 0)
+	if u.Enabled {
+		parts = append(parts, "on")
+	} else {
+		parts = append(parts, "off")
+	}
+	if u.NoPass {
+		parts = append(parts, "nopass")
+	}
+	for _, hash := range u.sortedPasswords() {
+		parts = append(parts, "#"+hash)
+	}
+	for _, key := range u.Keys {
+		parts = append(parts, "~"+key)
+	}
+	if len(u.Channels) == 0 {
+		parts = append(parts, "resetchannels")
+	}
+	for _, channel := range u.Channels {
+		parts = append(parts, "&"+channel)
+	}
+	parts = append(parts, u.CommandRules...)
+	return strings.Join(parts, " ")
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func appendUnique(list []string, s string) []string {
+	if s == "*" {
+		return []string{"*"}
+	}
+	if contains(list, s) {
+		return list
+	}
+	return append(list, s)
+}
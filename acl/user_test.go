@@ -0,0 +1,165 @@
+package acl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyRulePassword(t *testing.T) {
+	hash := hashPassword("secret")
+	tests := []struct {
+		name     string
+		rules    []string
+		password string
+		want     bool
+		wantErr  bool
+	}{
+		{name: "off user", rules: []string{">secret"}, password: "secret", want: false},
+		{name: "plain password", rules: []string{"on", ">secret"}, password: "secret", want: true},
+		{name: "wrong password", rules: []string{"on", ">secret"}, password: "other", want: false},
+		{name: "hash", rules: []string{"on", "#" + hash}, password: "secret", want: true},
+		{name: "uppercase hash", rules: []string{"on", "#" + strings.ToUpper(hash)}, password: "secret", want: true},
+		{name: "remove by hash", rules: []string{"on", ">secret", "!" + hash}, password: "secret", want: false},
+		{name: "remove by password", rules: []string{"on", ">secret", "<secret"}, password: "secret", want: false},
+		{name: "nopass", rules: []string{"on", ">secret", "nopass"}, password: "any", want: true},
+		{name: "resetpass", rules: []string{"on", "nopass", "resetpass"}, password: "", want: false},
+		{name: "reset", rules: []string{"on", ">secret", "reset"}, password: "secret", want: false},
+		{name: "short hash", rules: []string{"on", "#abc"}, wantErr: true},
+		{name: "invalid hash", rules: []string{"on", "#" + strings.Repeat("z", 64)}, wantErr: true},
+		{name: "remove unknown hash", rules: []string{"on", "!" + hash}, wantErr: true},
+		{name: "remove unknown password", rules: []string{"on", "<secret"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := newUser("alice")
+			err := user.applyRules(tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyRules(%v) error: %v", tt.rules, err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := user.checkPassword(tt.password); got != tt.want {
+				t.Errorf("checkPassword(%q) = %v, want %v", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyRuleReset(t *testing.T) {
+	user := newUser("alice")
+	if err := user.applyRules([]string{"on", ">secret", "~*", "&*", "+@all", "reset"}); err != nil {
+		t.Fatal(err)
+	}
+	if user.Enabled || len(user.Passwords) > 0 || len(user.Keys) > 0 || len(user.Channels) > 0 {
+		t.Errorf("reset user should be off without passwords and patterns: %s", user.describe())
+	}
+	if user.canExecute("get") {
+		t.Error("reset user should not execute any command")
+	}
+}
+
+func TestCommandRules(t *testing.T) {
+	tests := []struct {
+		rules   []string
+		allowed []string
+		denied  []string
+		wantErr bool
+	}{
+		{rules: []string{"+@all"}, allowed: []string{"get", "set", "flushall", "acl"}},
+		{rules: []string{"+@all", "-@dangerous"}, allowed: []string{"get", "set"}, denied: []string{"flushall", "keys", "acl"}},
+		{rules: []string{"+@read"}, allowed: []string{"get", "exists", "keys"}, denied: []string{"set", "del"}},
+		{rules: []string{"+@write", "-del"}, allowed: []string{"set"}, denied: []string{"get", "del"}},
+		{rules: []string{"+get", "+set"}, allowed: []string{"get", "set"}, denied: []string{"del"}},
+		// +@all、-@all 覆盖之前的规则
+		{rules: []string{"+get", "-@all", "+set"}, allowed: []string{"set"}, denied: []string{"get"}},
+		{rules: []string{"allcommands", "nocommands"}, denied: []string{"get"}},
+		{rules: []string{"+@unknown"}, wantErr: true},
+		{rules: []string{"+nosuchcommand"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		user := newUser("alice")
+		err := user.applyRules(tt.rules)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("applyRules(%v) error: %v", tt.rules, err)
+		}
+		for _, name := range tt.allowed {
+			if !user.canExecute(name) {
+				t.Errorf("%v: %s should be allowed", tt.rules, name)
+			}
+		}
+		for _, name := range tt.denied {
+			if user.canExecute(name) {
+				t.Errorf("%v: %s should be denied", tt.rules, name)
+			}
+		}
+	}
+}
+
+func TestCategoryExpansion(t *testing.T) {
+	read := commandsInCategory("read")
+	for _, name := range []string{"get", "exists", "keys"} {
+		if !contains(read, name) {
+			t.Errorf("@read should contain %s", name)
+		}
+	}
+	if contains(read, "set") {
+		t.Error("@read should not contain set")
+	}
+	if all := commandsInCategory(allCategory); len(all) != len(allCommandNames()) {
+		t.Errorf("@all should contain all %d commands, got %d", len(allCommandNames()), len(all))
+	}
+	if !IsWriteCommand("set") || IsWriteCommand("get") {
+		t.Error("only set should be write command")
+	}
+}
+
+func TestKeyPatterns(t *testing.T) {
+	tests := []struct {
+		rules    []string
+		key      string
+		prefix   string
+		access   bool
+		accessed bool // canAccessPrefix
+	}{
+		{rules: []string{"allkeys"}, key: "any", prefix: "", access: true, accessed: true},
+		{rules: []string{"~user:*"}, key: "user:1", prefix: "user:", access: true, accessed: true},
+		{rules: []string{"~user:*"}, key: "order:1", prefix: "user:1:", access: false, accessed: true},
+		{rules: []string{"~user:*"}, key: "user", prefix: "user", access: false, accessed: false},
+		{rules: []string{"~user:?"}, key: "user:1", prefix: "user:", access: true, accessed: false},
+		{rules: []string{"~us*r:*"}, key: "user:1", prefix: "user:", access: true, accessed: false},
+		{rules: []string{"~a", "~b*"}, key: "a", prefix: "b", access: true, accessed: true},
+		{rules: []string{"~user:*", "resetkeys"}, key: "user:1", prefix: "user:", access: false, accessed: false},
+	}
+	for _, tt := range tests {
+		user := newUser("alice")
+		if err := user.applyRules(tt.rules); err != nil {
+			t.Fatal(err)
+		}
+		if got := user.canAccessKey(tt.key); got != tt.access {
+			t.Errorf("%v: canAccessKey(%q) = %v", tt.rules, tt.key, got)
+		}
+		if got := user.canAccessPrefix(tt.prefix); got != tt.accessed {
+			t.Errorf("%v: canAccessPrefix(%q) = %v", tt.rules, tt.prefix, got)
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	user := newUser("alice")
+	rules := []string{"on", ">secret", "~user:*", "&news", "+@read", "-keys"}
+	if err := user.applyRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	// describe 的规则可以重新创建相同的用户
+	recreated := newUser("alice")
+	if err := recreated.applyRules(strings.Fields(user.describe())); err != nil {
+		t.Fatal(err)
+	}
+	if recreated.describe() != user.describe() {
+		t.Errorf("recreated user %q differs from %q", recreated.describe(), user.describe())
+	}
+	if !recreated.checkPassword("secret") || !recreated.canExecute("get") || recreated.canExecute("keys") {
+		t.Error("recreated user should have the same permissions")
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 // CmdLine is alias for [][]byte, represents a command line
@@ -34,6 +36,10 @@ type AofHandler struct {
 	aofFile     *os.File              // AOF 文件句柄
 	aofFilename string                // AOF 文件名称
 	currentDB   int                   // 上一次写指令的数据库索引
+	writeFailed atomic.Bool           // 最近一次写入文件是否失败，INFO persistence 中的 aof_last_write_status
+	mu          sync.RWMutex          // 关闭 aofChan 时等待正在写入缓冲区的协程
+	closed      bool                  // 已经关闭，不再接收命令
+	done        chan struct{}         // 缓冲区中的命令全部写入文件后关闭
 }
 
 // NewAOFHandler creates a new aof.AofHandler
@@ -46,27 +52,68 @@ func NewAOFHandler(db databaseface.Database) (*AofHandler, error) {
 	// 恢复数据
 	handler.LoadAof()
 	// 打开文件，追加写入，文件不存在则创建，以读写方式打开，权限 0600
-	aofFile, err := os.OpenFile(handler.aofFilename, os.O_APPEND|os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
+	if err := handler.open(os.O_APPEND | os.O_CREATE | os.O_RDWR); err != nil {
 		return nil, err
 	}
+	return handler, nil
+}
+
+// CreateAOFHandler creates an aof.AofHandler writing to an empty AOF file without loading it
+// 用于运行时通过 CONFIG SET appendonly yes 开启 AOF，原文件被清空，由调用方写入当前的数据
+func CreateAOFHandler(db databaseface.Database) (*AofHandler, error) {
+	handler := &AofHandler{
+		db:          db,
+		aofFilename: config.Properties.AppendFilename,
+	}
+	if err := handler.open(os.O_TRUNC | os.O_CREATE | os.O_WRONLY); err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+// open opens AOF file and starts writing commands in buffer
+func (handler *AofHandler) open(flag int) error {
+	aofFile, err := os.OpenFile(handler.aofFilename, flag, 0600)
+	if err != nil {
+		return err
+	}
 
 	// 保存文件句柄，初始化 handler，并初始化 AOF 写入缓冲区
 	handler.aofFile = aofFile
 	handler.aofChan = make(chan *payload, aofQueueSize)
+	handler.done = make(chan struct{})
 
 	// 异步落盘
 	go func() {
 		handler.handleAof()
+		close(handler.done)
 	}()
-	return handler, nil
+	return nil
+}
+
+// Close stops receiving commands, and closes AOF file after commands in buffer are written
+// 用于运行时通过 CONFIG SET appendonly no 关闭 AOF
+func (handler *AofHandler) Close() {
+	handler.mu.Lock()
+	if handler.closed {
+		handler.mu.Unlock()
+		return
+	}
+	handler.closed = true
+	close(handler.aofChan)
+	handler.mu.Unlock()
+	<-handler.done
+	_ = handler.aofFile.Close()
 }
 
 // AddAof send command to aof goroutine through channel
 // 将用户指令塞到 Channel 缓冲区中
 // 这里一定需要记录 db 索引，因为 AOF 文件中写入的命令，可能不是当前数据库的
 func (handler *AofHandler) AddAof(dbIndex int, cmdLine CmdLine) {
-	if config.Properties.AppendOnly && handler.aofChan != nil {
+	handler.mu.RLock()
+	defer handler.mu.RUnlock()
+	// 关闭 AOF 时 handler 被关闭，不需要再检查 appendonly 参数
+	if handler.aofChan != nil && !handler.closed {
 		handler.aofChan <- &payload{
 			cmdLine: cmdLine,
 			dbIndex: dbIndex,
@@ -91,6 +138,7 @@ func (handler *AofHandler) handleAof() {
 			data := reply.MakeMultiBulkReply(
 				utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
 			_, err := handler.aofFile.Write(data)
+			handler.writeFailed.Store(err != nil)
 			if err != nil {
 				logger.Warn(err)
 				continue // skip this command
@@ -104,6 +152,7 @@ func (handler *AofHandler) handleAof() {
 		// 2. 数据库不需切换，直接写入指令
 		data := reply.MakeMultiBulkReply(p.cmdLine).ToBytes()
 		_, err := handler.aofFile.Write(data)
+		handler.writeFailed.Store(err != nil)
 		if err != nil {
 			logger.Warn(err)
 		}
@@ -154,3 +203,22 @@ func (handler *AofHandler) LoadAof() {
 		}
 	}
 }
+
+// CurrentSize returns the size of AOF file
+func (handler *AofHandler) CurrentSize() int64 {
+	info, err := handler.aofFile.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+// PendingCount returns the number of commands waiting to be written
+func (handler *AofHandler) PendingCount() int {
+	return len(handler.aofChan)
+}
+
+// LastWriteOK returns whether the last write to AOF file succeeded
+func (handler *AofHandler) LastWriteOK() bool {
+	return !handler.writeFailed.Load()
+}
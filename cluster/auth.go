@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/tlsutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+节点之间的认证与加密
+1. cluster-auth(未配置时使用 masterauth)
+	集群模式必须配置其中之一，否则无法启动；requirepass 是普通客户端的密码，不能用于认证节点
+	节点连接其他节点后先发送 AUTH cluster-auth，集群内部命令(PREPARE、EXEC-LOCAL、PSYNC 等)
+	和修改拓扑的 CLUSTER 子命令(SETSLOT、MEET、FORGET 等)需要认证后才能执行，管理员可以使用 cluster-auth 认证后执行
+	集群总线的消息使用 cluster-auth 计算 HMAC 签名，签名包括发送时间和随机数，
+	签名不正确、发送时间相差超过 maxMessageAge(节点之间的时钟需要同步)或重复的消息被丢弃
+2. tls-cluster yes
+	命令转发、主从复制、集群总线都使用 TLS，证书由 tls-cert-file、tls-key-file 配置，使用 tls-ca-cert-file 验证对方的证书
+	节点的端口同时接受普通客户端连接和其他节点的 TLS 连接
+*/
+
+// isPeerCommand returns whether the command could only be sent by other nodes
+func isPeerCommand(cmdName string) bool {
+	switch cmdName {
+	case "prepare", "commit", "rollback", "exec-local", "restore-asking", "psync", "replconf", "cache-get":
+		return true
+	}
+	return false
+}
+
+// isPeerClusterCommand returns whether the CLUSTER subcommand changes topology and could only be sent by other nodes
+func isPeerClusterCommand(subCmd string) bool {
+	switch subCmd {
+	case "setslot", "meet", "forget", "failover", "rebalance", "replicate":
+		return true
+	}
+	return false
+}
+
+// isPeerOnly returns whether the command line requires peer authentication
+func isPeerOnly(cmdName string, cmdLine [][]byte) bool {
+	if isPeerCommand(cmdName) {
+		return true
+	}
+	return cmdName == "cluster" && len(cmdLine) > 1 && isPeerClusterCommand(strings.ToLower(string(cmdLine[1])))
+}
+
+// errNoClusterAuth is returned when neither cluster-auth nor masterauth is configured in cluster mode
+var errNoClusterAuth = errors.New("cluster mode requires cluster-auth or masterauth to authenticate nodes")
+
+// getClusterAuth returns the password used between nodes, cluster-auth is preferred over masterauth
+func getClusterAuth() string {
+	if config.Properties.ClusterAuth != "" {
+		return config.Properties.ClusterAuth
+	}
+	return config.Properties.MasterAuth
+}
+
+// isPeerAuthenticated returns whether the connection is authenticated by cluster-auth or masterauth
+// 没有配置节点密码时拒绝所有节点命令
+func isPeerAuthenticated(c resp.Connection) bool {
+	auth := getClusterAuth()
+	if auth == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.GetPassword()), []byte(auth)) == 1
+}
+
+// IsPeerPassword returns whether the password is the one used between nodes
+// 使用该密码认证的连接是其他节点，不受 ACL 限制
+func IsPeerPassword(password []byte) bool {
+	auth := getClusterAuth()
+	return auth != "" && subtle.ConstantTimeCompare(password, []byte(auth)) == 1
+}
+
+// getPeerTLSConfig returns the tls config to connect other nodes, returns nil if tls-cluster is disabled
+func getPeerTLSConfig() (*tls.Config, error) {
+	if !config.Properties.TLSCluster {
+		return nil, nil
+	}
+	return tlsutil.ClientConfig(config.Properties.TLSCertFile, config.Properties.TLSKeyFile, config.Properties.TLSCACertFile)
+}
+
+// dialPeer connects to other node, TLS is used if tls-cluster is enabled
+func dialPeer(addr string, timeout time.Duration) (net.Conn, error) {
+	tlsConfig, err := getPeerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig == nil {
+		return net.DialTimeout("tcp", addr, timeout)
+	}
+	return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, tlsConfig)
+}
+
+// listenBus listens on cluster bus, TLS is used if tls-cluster is enabled
+// 集群总线只有节点会连接，配置了 CA 时要求对方提供证书
+func listenBus(addr string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil || !config.Properties.TLSCluster {
+		return listener, err
+	}
+	tlsConfig, err := tlsutil.ServerConfig(config.Properties.TLSCertFile, config.Properties.TLSKeyFile, config.Properties.TLSCACertFile)
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+	if tlsConfig.ClientCAs != nil {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tls.NewListener(listener, tlsConfig), nil
+}
+
+// maxMessageAge is the max difference between the sending time of gossip message and local time
+const maxMessageAge = 30 * time.Second
+
+// nonceCache records nonces of gossip messages received within maxMessageAge to reject replayed messages
+type nonceCache struct {
+	mu        sync.Mutex
+	nonces    map[string]time.Time // 随机数 -> 消息的发送时间
+	lastSweep time.Time            // 上次清理过期随机数的时间
+}
+
+var receivedNonces = &nonceCache{nonces: make(map[string]time.Time)}
+
+// add records the nonce, returns false if it has been received
+// 超过 maxMessageAge 的消息已经被拒绝，不需要继续记录它们的随机数
+func (n *nonceCache) add(nonce string, sentAt time.Time) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if _, ok := n.nonces[nonce]; ok {
+		return false
+	}
+	if now := time.Now(); now.Sub(n.lastSweep) > time.Second {
+		n.lastSweep = now
+		for key, t := range n.nonces {
+			if now.Sub(t) > maxMessageAge {
+				delete(n.nonces, key)
+			}
+		}
+	}
+	n.nonces[nonce] = sentAt
+	return true
+}
+
+// signMessage sets the sending time, nonce and signature of gossip message
+func signMessage(msg *gossipMessage) {
+	msg.Signature = ""
+	msg.Time = time.Now().UnixMilli()
+	nonce := make([]byte, 16)
+	_, _ = rand.Read(nonce)
+	msg.Nonce = hex.EncodeToString(nonce)
+	if auth := getClusterAuth(); auth != "" {
+		msg.Signature = messageMAC(msg, auth)
+	}
+}
+
+// verifyMessage checks the signature of gossip message, and rejects stale or replayed message
+// 没有配置节点密码时拒绝所有消息
+func verifyMessage(msg *gossipMessage) bool {
+	auth := getClusterAuth()
+	if auth == "" {
+		return false
+	}
+	signature := msg.Signature
+	msg.Signature = ""
+	expected := messageMAC(msg, auth)
+	msg.Signature = signature
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return false
+	}
+	sentAt := time.UnixMilli(msg.Time)
+	if age := time.Since(sentAt); age > maxMessageAge || age < -maxMessageAge {
+		return false
+	}
+	return msg.Nonce != "" && receivedNonces.add(msg.Nonce, sentAt)
+}
+
+// messageMAC returns HMAC-SHA256 of message without signature
+func messageMAC(msg *gossipMessage, auth string) string {
+	data, _ := json.Marshal(msg)
+	mac := hmac.New(sha256.New, []byte(auth))
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil))
+}
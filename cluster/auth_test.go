@@ -0,0 +1,325 @@
+package cluster
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"go-redis/config"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// certAuthority is a self-signed CA generated for tests
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // CA 证书的 PEM 文件
+}
+
+var serialNumber int64
+
+func newCertTemplate(cn string) *x509.Certificate {
+	serialNumber++
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeCA generates a self-signed CA and writes its certificate into dir
+func makeCA(t *testing.T, dir string, name string) *certAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := newCertTemplate(name)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name+".crt")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &certAuthority{cert: cert, key: key, file: file}
+}
+
+// issue generates a certificate for 127.0.0.1 signed by ca, which could be used by both server and client
+// returns the certificate file and key file
+func (ca *certAuthority) issue(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := newCertTemplate(name)
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// setupTLSCluster enables tls-cluster with the given certificate, restores config after test
+func setupTLSCluster(t *testing.T, certFile, keyFile, caFile string) {
+	old := *config.Properties
+	t.Cleanup(func() {
+		*config.Properties = old
+	})
+	config.Properties.TLSCluster = true
+	config.Properties.TLSCertFile = certFile
+	config.Properties.TLSKeyFile = keyFile
+	config.Properties.TLSCACertFile = caFile
+}
+
+// exchange sends a line through the connection dialed by dialPeer and reads the echo
+func exchange(conn net.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("PING\n")); err != nil {
+		return err
+	}
+	buf := make([]byte, 5)
+	_, err := conn.Read(buf)
+	return err
+}
+
+// startEchoBus listens on cluster bus and echoes the data received
+func startEchoBus(t *testing.T) string {
+	listener, err := listenBus("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 64)
+				n, err := conn.Read(buf)
+				if err == nil {
+					_, _ = conn.Write(buf[:n])
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPeerTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "node")
+	setupTLSCluster(t, certFile, keyFile, ca.file)
+	addr := startEchoBus(t)
+
+	conn, err := dialPeer(addr, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if err = exchange(conn); err != nil {
+		t.Errorf("peer with certificate signed by CA should be accepted: %v", err)
+	}
+}
+
+func TestPeerTLSRejectUnknownCA(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "node")
+	other := makeCA(t, dir, "other")
+	otherCert, otherKey := other.issue(t, dir, "intruder")
+	setupTLSCluster(t, certFile, keyFile, ca.file)
+	addr := startEchoBus(t)
+
+	// 客户端证书由其他 CA 签发
+	config.Properties.TLSCertFile = otherCert
+	config.Properties.TLSKeyFile = otherKey
+	conn, err := dialPeer(addr, time.Second)
+	if err == nil {
+		err = exchange(conn)
+		_ = conn.Close()
+	}
+	if err == nil {
+		t.Error("peer with certificate signed by unknown CA should be rejected")
+	}
+
+	// 不提供客户端证书
+	config.Properties.TLSCertFile = ""
+	config.Properties.TLSKeyFile = ""
+	conn, err = dialPeer(addr, time.Second)
+	if err == nil {
+		err = exchange(conn)
+		_ = conn.Close()
+	}
+	if err == nil {
+		t.Error("peer without certificate should be rejected")
+	}
+}
+
+func TestPeerTLSRejectUntrustedServer(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, dir, "node")
+	other := makeCA(t, dir, "other")
+	setupTLSCluster(t, certFile, keyFile, ca.file)
+	addr := startEchoBus(t)
+
+	// 使用其他 CA 验证服务端证书
+	config.Properties.TLSCACertFile = other.file
+	conn, err := dialPeer(addr, time.Second)
+	if err == nil {
+		err = exchange(conn)
+		_ = conn.Close()
+	}
+	if err == nil {
+		t.Error("server with certificate signed by unknown CA should be rejected")
+	}
+}
+
+func setupClusterAuth(t *testing.T, auth string) {
+	old := *config.Properties
+	t.Cleanup(func() {
+		*config.Properties = old
+	})
+	config.Properties.ClusterAuth = auth
+}
+
+func TestVerifyMessage(t *testing.T) {
+	setupClusterAuth(t, "secret")
+	msg := &gossipMessage{Type: msgPing, Sender: "127.0.0.1:6399", Slots: []byte{1, 2, 3}}
+	signMessage(msg)
+	replayed := *msg
+	if !verifyMessage(msg) {
+		t.Fatal("signed message should be accepted")
+	}
+	if verifyMessage(&replayed) {
+		t.Error("replayed message should be rejected")
+	}
+
+	tampered := &gossipMessage{Type: msgPing, Sender: "127.0.0.1:6399"}
+	signMessage(tampered)
+	tampered.Sender = "127.0.0.1:6398"
+	if verifyMessage(tampered) {
+		t.Error("tampered message should be rejected")
+	}
+
+	stale := &gossipMessage{Type: msgPing, Sender: "127.0.0.1:6399"}
+	signMessage(stale)
+	stale.Time = time.Now().Add(-2 * maxMessageAge).UnixMilli()
+	stale.Signature = ""
+	stale.Signature = messageMAC(stale, "secret")
+	if verifyMessage(stale) {
+		t.Error("stale message should be rejected")
+	}
+
+	config.Properties.ClusterAuth = "other"
+	wrongAuth := &gossipMessage{Type: msgPing, Sender: "127.0.0.1:6399"}
+	signMessage(wrongAuth)
+	config.Properties.ClusterAuth = "secret"
+	if verifyMessage(wrongAuth) {
+		t.Error("message signed by other password should be rejected")
+	}
+
+	config.Properties.ClusterAuth = ""
+	unsigned := &gossipMessage{Type: msgPing, Sender: "127.0.0.1:6399"}
+	signMessage(unsigned)
+	if verifyMessage(unsigned) {
+		t.Error("message should be rejected when no password is configured")
+	}
+}
+
+func TestIsPeerAuthenticated(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	c := connection.NewConn(local)
+	defer c.Close()
+
+	tests := []struct {
+		clusterAuth string
+		masterAuth  string
+		requirePass string
+		password    string
+		want        bool
+	}{
+		{clusterAuth: "secret", password: "secret", want: true},
+		{clusterAuth: "secret", password: "wrong", want: false},
+		{clusterAuth: "secret", masterAuth: "master", password: "master", want: false},
+		{masterAuth: "master", password: "master", want: true},
+		// requirepass 是普通客户端的密码，不能用于认证节点
+		{requirePass: "client", password: "client", want: false},
+		{password: "", want: false},
+	}
+	for _, tt := range tests {
+		setupClusterAuth(t, tt.clusterAuth)
+		config.Properties.MasterAuth = tt.masterAuth
+		config.Properties.RequirePass = tt.requirePass
+		c.SetPassword(tt.password)
+		if got := isPeerAuthenticated(c); got != tt.want {
+			t.Errorf("cluster-auth %q masterauth %q requirepass %q password %q: got %v, want %v",
+				tt.clusterAuth, tt.masterAuth, tt.requirePass, tt.password, got, tt.want)
+		}
+	}
+}
+
+func TestIsPeerOnly(t *testing.T) {
+	tests := []struct {
+		cmdLine  []string
+		peerOnly bool
+	}{
+		{[]string{"prepare", "tx", "set", "k", "v"}, true},
+		{[]string{"psync", "?", "-1"}, true},
+		{[]string{"cluster", "SETSLOT", "1", "NODE", "127.0.0.1:6399"}, true},
+		{[]string{"cluster", "meet", "127.0.0.1", "6399"}, true},
+		{[]string{"cluster", "forget", "127.0.0.1:6399"}, true},
+		{[]string{"cluster", "failover"}, true},
+		{[]string{"cluster", "rebalance"}, true},
+		{[]string{"cluster", "nodes"}, false},
+		{[]string{"cluster", "slots"}, false},
+		{[]string{"cluster"}, false},
+		{[]string{"get", "k"}, false},
+	}
+	for _, tt := range tests {
+		if got := isPeerOnly(tt.cmdLine[0], utils.ToCmdLine(tt.cmdLine...)); got != tt.peerOnly {
+			t.Errorf("isPeerOnly(%v) = %v, want %v", tt.cmdLine, got, tt.peerOnly)
+		}
+	}
+}
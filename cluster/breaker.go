@@ -0,0 +1,176 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/resp/client"
+	"go-redis/resp/reply"
+	"sync"
+	stdatomic "sync/atomic"
+	"time"
+)
+
+/*
+节点熔断器(circuit breaker)
+节点宕机后，每条转发的命令都要等到超时才能失败。熔断器记录每个节点连续失败的次数：
+	closed    正常放行请求，连续失败 cluster-breaker-failures 次后进入 open
+	open      直接回复错误，不再访问该节点；经过 cluster-breaker-cooldown 后进入 half-open
+	half-open 只放行一个探测请求，成功则恢复为 closed，失败则重新进入 open
+只有连接错误(超时、连接断开等)算作失败，节点回复的错误(如 WRONGTYPE)说明节点可用
+*/
+
+const (
+	defaultPoolSize        = 8
+	defaultPoolMaxIdle     = 8
+	defaultPoolIdleTimeout = 60000
+	defaultPeerTimeout     = 3000
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 5000
+)
+
+// breaker states
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = []string{"closed", "open", "half-open"}
+
+// breaker is the circuit breaker of a peer, it also collects the stats of requests to the peer
+type breaker struct {
+	mu       sync.Mutex
+	state    int
+	failures int       // 连续失败的次数
+	openedAt time.Time // 进入 open 状态的时间
+	probing  bool      // half-open 状态下是否已经放行了探测请求
+	trips    int       // 进入 open 状态的次数
+
+	requests stdatomic.Uint64 // 发送给该节点的请求数
+	errors   stdatomic.Uint64 // 连接错误的请求数
+	rejected stdatomic.Uint64 // 熔断期间直接失败的请求数
+}
+
+// allow returns whether a request could be sent to the peer
+func (b *breaker) allow() bool {
+	allowed, _ := b.acquire()
+	return allowed
+}
+
+// acquire returns whether a request could be sent to the peer, and whether it is the probe in half-open state
+func (b *breaker) acquire() (allowed bool, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Since(b.openedAt) < getBreakerCooldown() {
+			b.rejected.Add(1)
+			return false, false
+		}
+		b.state = breakerHalfOpen
+		b.probing = true
+		probe = true
+	case breakerHalfOpen:
+		// 探测请求返回之前，其他请求直接失败
+		if b.probing {
+			b.rejected.Add(1)
+			return false, false
+		}
+		b.probing = true
+		probe = true
+	}
+	b.requests.Add(1)
+	return true, probe
+}
+
+// record records the result of a request allowed by breaker
+func (b *breaker) record(ret resp.Reply) {
+	if client.IsConnErr(ret) {
+		b.onFailure()
+	} else {
+		b.onSuccess()
+	}
+}
+
+func (b *breaker) onSuccess() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures = 0
+	b.probing = false
+	b.state = breakerClosed
+}
+
+func (b *breaker) onFailure() {
+	b.errors.Add(1)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.probing = false
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.failures >= getBreakerFailures()) {
+		b.state = breakerOpen
+		b.openedAt = time.Now()
+		b.trips++
+	}
+}
+
+// rejectReply is the reply of requests rejected by breaker
+func rejectReply(peer string) resp.Reply {
+	return reply.MakeErrReply("ERR peer " + peer + " is unavailable, circuit breaker is open")
+}
+
+// getBreaker returns the circuit breaker of peer, creates it if not exists
+func (cluster *ClusterDatabase) getBreaker(peer string) *breaker {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	b, ok := cluster.peerBreakers[peer]
+	if !ok {
+		b = &breaker{}
+		cluster.peerBreakers[peer] = b
+	}
+	return b
+}
+
+func getPoolSize() int {
+	if config.Properties.ClusterPoolSize <= 0 {
+		return defaultPoolSize
+	}
+	return config.Properties.ClusterPoolSize
+}
+
+func getPoolMaxIdle() int {
+	if config.Properties.ClusterPoolMaxIdle <= 0 {
+		return defaultPoolMaxIdle
+	}
+	return config.Properties.ClusterPoolMaxIdle
+}
+
+func getPoolIdleTimeout() time.Duration {
+	timeout := config.Properties.ClusterPoolIdleTimeout
+	if timeout <= 0 {
+		timeout = defaultPoolIdleTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+func getPeerTimeout() time.Duration {
+	timeout := config.Properties.ClusterPeerTimeout
+	if timeout <= 0 {
+		timeout = defaultPeerTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+func getBreakerFailures() int {
+	if config.Properties.ClusterBreakerFailures <= 0 {
+		return defaultBreakerFailures
+	}
+	return config.Properties.ClusterBreakerFailures
+}
+
+func getBreakerCooldown() time.Duration {
+	cooldown := config.Properties.ClusterBreakerCooldown
+	if cooldown <= 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	return time.Duration(cooldown) * time.Millisecond
+}
@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"github.com/jolestar/go-commons-pool/v2"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
 	"go-redis/resp/client"
+	"time"
 )
 
 // connectionFactory 连接工厂结构
@@ -16,14 +19,36 @@ type connectionFactory struct {
 
 // MakeObject 创建连接
 func (f *connectionFactory) MakeObject(ctx context.Context) (*pool.PooledObject, error) {
+	c, err := makePeerClient(f.Peer)
+	if err != nil {
+		return nil, err
+	}
+	return pool.NewPooledObject(c), nil
+}
+
+// makePeerClient connects to peer and authenticates with cluster-auth
+// tls-cluster 开启时使用 TLS 连接
+func makePeerClient(peer string) (*client.Client, error) {
+	tlsConfig, err := getPeerTLSConfig()
+	if err != nil {
+		return nil, err
+	}
 	// 创建客户端连接
-	c, err := client.MakeClient(f.Peer)
+	c, err := client.MakeTLSClient(peer, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
+	c.SetTimeout(getPeerTimeout())
 	// 启动连接到服务端
 	c.Start()
-	return pool.NewPooledObject(c), nil
+	// 认证失败时关闭连接，不放入连接池
+	if auth := getClusterAuth(); auth != "" {
+		if err := c.Auth(auth); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
 // DestroyObject 销毁连接
@@ -39,10 +64,45 @@ func (f *connectionFactory) DestroyObject(ctx context.Context, object *pool.Pool
 }
 
 // ValidateObject 验证连接
-// 无需验证
+// 借出连接、检查空闲连接时发送 PING，回复 PONG 才认为连接可用，不可用的连接会被销毁
 func (f *connectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	// do validate
-	return true
+	c, ok := object.Object.(*client.Client)
+	if !ok {
+		return false
+	}
+	return validateClient(c)
+}
+
+// validateClient sends PING and returns whether the peer replied PONG
+func validateClient(c *client.Client) bool {
+	ret := c.Send(utils.ToCmdLine("PING"))
+	return string(ret.ToBytes()) == "+PONG\r\n"
+}
+
+// peerMuxCron validates multiplexed clients periodically like idle connections in pool
+// 转发使用的多路复用连接不在连接池中，按照 cluster-pool-idle-timeout 的间隔发送 PING，替换不可用的连接
+func (cluster *ClusterDatabase) peerMuxCron() {
+	ticker := time.NewTicker(getPoolIdleTimeout())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cluster.gossipDone:
+			return
+		case <-ticker.C:
+		}
+		cluster.peerMu.Lock()
+		clients := make(map[string]*client.Client, len(cluster.peerMux))
+		for key, c := range cluster.peerMux {
+			clients[key] = c
+		}
+		cluster.peerMu.Unlock()
+		for key, c := range clients {
+			if !validateClient(c) {
+				logger.Warn("relay connection to " + key + " is broken, reconnect on next relay")
+				cluster.dropPeerMuxByKey(key, c)
+			}
+		}
+	}
 }
 
 // ActivateObject 激活连接
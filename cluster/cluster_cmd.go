@@ -0,0 +1,187 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// execCluster executes CLUSTER subcommands
+// CLUSTER SLOTS / CLUSTER NODES / CLUSTER INFO / CLUSTER KEYSLOT key / CLUSTER MYID
+// CLUSTER SETSLOT / CLUSTER GETKEYSINSLOT / CLUSTER COUNTKEYSINSLOT / CLUSTER REBALANCE
+// CLUSTER MEET / CLUSTER FORGET / CLUSTER REPLICATE / CLUSTER FAILOVER
+// 支持集群协议的客户端通过 CLUSTER SLOTS、CLUSTER NODES 获取 slot 与节点的对应关系
+func execCluster(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("cluster")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "slots":
+		return execClusterSlots(cluster)
+	case "nodes":
+		return execClusterNodes(cluster)
+	case "info":
+		return execClusterInfo(cluster)
+	case "myid":
+		return reply.MakeBulkReply([]byte(nodeID(cluster.self)))
+	case "keyslot":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("cluster|keyslot")
+		}
+		return reply.MakeIntReply(int64(getSlot(string(args[2]))))
+	case "setslot":
+		return execClusterSetSlot(cluster, c, args)
+	case "getkeysinslot":
+		return execClusterGetKeysInSlot(cluster, c, args)
+	case "countkeysinslot":
+		return execClusterCountKeysInSlot(cluster, c, args)
+	case "rebalance":
+		return execClusterRebalance(cluster, c, args)
+	case "meet":
+		return execClusterMeet(cluster, c, args)
+	case "forget":
+		return execClusterForget(cluster, c, args)
+	case "replicate":
+		return execClusterReplicate(cluster, c, args)
+	case "failover":
+		return execClusterFailover(cluster, c, args)
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try CLUSTER HELP.")
+}
+
+// execClusterSlots returns slot ranges and the node serving them
+// 每个元素格式：[起始 slot, 结束 slot, [ip, port, id]]
+func execClusterSlots(cluster *ClusterDatabase) resp.Reply {
+	ranges := cluster.topology.getSlotRanges()
+	replies := make([]resp.Reply, 0, len(ranges))
+	for _, r := range ranges {
+		host, port := splitAddr(r.node)
+		nodeReply := reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeBulkReply([]byte(host)),
+			reply.MakeIntReply(int64(port)),
+			reply.MakeBulkReply([]byte(nodeID(r.node))),
+		})
+		replies = append(replies, reply.MakeMultiRawReply([]resp.Reply{
+			reply.MakeIntReply(int64(r.start)),
+			reply.MakeIntReply(int64(r.end)),
+			nodeReply,
+		}))
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// execClusterNodes returns cluster nodes in redis `nodes.conf` format
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func execClusterNodes(cluster *ClusterDatabase) resp.Reply {
+	cluster.topology.mu.RLock()
+	defer cluster.topology.mu.RUnlock()
+	return reply.MakeBulkReply([]byte(cluster.topology.formatNodes()))
+}
+
+// execClusterInfo returns the state of cluster
+// 存在未分配的 slot 或负责 slot 的节点已下线时，集群状态为 fail
+func execClusterInfo(cluster *ClusterDatabase) resp.Reply {
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	assigned, pfail, fail := 0, 0, 0
+	owners := make(map[string]struct{})
+	for _, node := range t.slots {
+		if node == "" {
+			continue
+		}
+		assigned++
+		owners[node] = struct{}{}
+		if info, ok := t.nodes[node]; ok {
+			if info.flags&nodeFlagFail > 0 {
+				fail++
+			} else if info.flags&nodeFlagPFail > 0 {
+				pfail++
+			}
+		}
+	}
+	state := "ok"
+	if assigned < slotCount || fail > 0 {
+		state = "fail"
+	}
+	lines := []string{
+		"cluster_enabled:1",
+		"cluster_state:" + state,
+		"cluster_slots_assigned:" + strconv.Itoa(assigned),
+		"cluster_slots_ok:" + strconv.Itoa(assigned-pfail-fail),
+		"cluster_slots_pfail:" + strconv.Itoa(pfail),
+		"cluster_slots_fail:" + strconv.Itoa(fail),
+		"cluster_known_nodes:" + strconv.Itoa(len(t.nodes)),
+		"cluster_size:" + strconv.Itoa(len(owners)),
+		"cluster_current_epoch:" + strconv.FormatUint(t.currentEpoch, 10),
+		"cluster_my_epoch:" + strconv.FormatUint(t.nodes[t.self].configEpoch, 10),
+	}
+	return reply.MakeBulkReply([]byte(strings.Join(lines, "\r\n") + "\r\n"))
+}
+
+// execClusterMeet adds the node into cluster
+// CLUSTER MEET ip port [cluster-bus-port]
+// 本节点通过集群总线向对方发送 MEET，双方通过 gossip 将对方介绍给集群中的其他节点
+func execClusterMeet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 4 && len(args) != 5 {
+		return reply.MakeArgNumErrReply("cluster|meet")
+	}
+	port, err := strconv.Atoi(string(args[3]))
+	if err != nil || port <= 0 || port > 65535 {
+		return reply.MakeErrReply("ERR Invalid base port specified: " + string(args[3]))
+	}
+	busPort := port + busPortOffset
+	if len(args) == 5 {
+		busPort, err = strconv.Atoi(string(args[4]))
+		if err != nil || busPort <= 0 || busPort > 65535 {
+			return reply.MakeErrReply("ERR Invalid bus port specified: " + string(args[4]))
+		}
+	}
+	if net.ParseIP(string(args[2])) == nil {
+		return reply.MakeErrReply("ERR Invalid node address specified: " + string(args[2]) + ":" + string(args[3]))
+	}
+	node := net.JoinHostPort(string(args[2]), strconv.Itoa(port))
+	if node == cluster.self {
+		return reply.MakeOkReply()
+	}
+	cluster.meet(node, busPort)
+	return reply.MakeOkReply()
+}
+
+// execClusterForget removes the node from cluster
+// CLUSTER FORGET node-id
+// 60 秒内不会通过 gossip 重新加入，需要在所有节点上执行
+func execClusterForget(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("cluster|forget")
+	}
+	node, ok := cluster.topology.findNode(string(args[2]))
+	if !ok {
+		return reply.MakeErrReply("ERR Unknown node " + string(args[2]))
+	}
+	if node == cluster.self {
+		return reply.MakeErrReply("ERR I tried hard but I can't forget myself...")
+	}
+	cluster.topology.mu.RLock()
+	slots := cluster.topology.countSlots(node)
+	cluster.topology.mu.RUnlock()
+	if slots > 0 {
+		return reply.MakeErrReply("ERR Can't forget a node which still serves hash slots")
+	}
+	cluster.topology.removeNode(node, forgetBanTime)
+	cluster.closePeerPool(node)
+	return reply.MakeOkReply()
+}
+
+// splitAddr splits "host:port" into host and port
+func splitAddr(addr string) (string, int) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr, 0
+	}
+	port, _ := strconv.Atoi(portStr)
+	return host, port
+}
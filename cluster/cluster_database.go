@@ -3,17 +3,24 @@ package cluster
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	pool "github.com/jolestar/go-commons-pool/v2"
 	"go-redis/config"
 	"go-redis/database"
-	databaseface "go-redis/interface/database"
+	"go-redis/datastruct/dict"
 	"go-redis/interface/resp"
-	"go-redis/lib/consistenthash"
 	"go-redis/lib/logger"
+	"go-redis/lib/sync/atomic"
+	"go-redis/lib/utils"
+	"go-redis/resp/client"
 	"go-redis/resp/reply"
+	"net"
 	"runtime/debug"
 	"strings"
+	"sync"
+	stdatomic "sync/atomic"
+	"time"
 )
 
 // ClusterDatabase represents a node of godis cluster
@@ -21,62 +28,282 @@ import (
 // Redis 集群结构
 // 一个集群中的一个节点，就是一个单节点的 StandaloneDatabase 结构
 type ClusterDatabase struct {
-	self           string                      // 自身节点地址
-	nodes          []string                    // 整个集群中的所有节点地址
-	peerPicker     *consistenthash.NodeMap     // 节点选择器，使用一致性哈希管理器
-	peerConnection map[string]*pool.ObjectPool // 节点连接池。需要实现连接的创建、销毁、获取、返回等功能
-	db             databaseface.Database       // 集群所在节点自身的数据库
+	self           string                       // 自身节点地址
+	topology       *topology                    // 集群拓扑，记录每个 slot 由哪个节点负责
+	redirect       bool                         // 是否为重定向模式。重定向模式下不转发命令，而是回复 MOVED/ASK
+	peerMu         sync.Mutex                   // 保护 peerConnection、peerMux，新节点加入时会动态创建连接池
+	peerConnection map[string]*pool.ObjectPool  // 节点连接池。需要实现连接的创建、销毁、获取、返回等功能
+	peerMux        map[string]*client.Client    // 转发命令共用的多路复用连接，并发的转发请求合并为批量写入
+	peerBreakers   map[string]*breaker          // 节点熔断器，节点不可用时快速失败
+	db             *database.StandaloneDatabase // 集群所在节点自身的数据库
+	rebalancing    atomic.Boolean               // 是否正在进行 slot 迁移
+	busListener    net.Listener                 // 集群总线
+	gossipDone     chan struct{}                // 关闭时停止集群总线
+	replication    *replication                 // 主从复制状态
+	failoverAt     time.Time                    // 主节点下线后，计划发起故障转移选举的时间
+	electing       atomic.Boolean               // 是否正在进行故障转移选举
+	pausedUntil    stdatomic.Int64              // 手动故障转移时暂停客户端命令，直到该时间(纳秒)
+	transactions   *dict.SyncDict               // 本节点参与的分布式事务，事务 id -> *transaction
+	txSeq          stdatomic.Uint64             // 作为协调者时生成事务 id 的序号
+
+	configuredReplicas map[string][]string // cluster-replicas 配置的从节点，主节点 -> 从节点
+	readPolicies       sync.Map            // 连接的读策略，resp.Connection -> string，未设置时使用 cluster-read-policy
+	readSeq            stdatomic.Uint64    // 轮流读从节点的序号
+
+	nearCache *nearCache // 热点 key 近端缓存，未开启 cluster-near-cache 时为 nil
+	tracking  *tracking  // 其他节点缓存的本节点负责的 key，修改时通知这些节点
 }
 
+// cluster modes
+const (
+	modeProxy    = "proxy"    // 非本节点的 key，由本节点转发给负责的节点，适用于普通客户端
+	modeRedirect = "redirect" // 非本节点的 key，回复 MOVED/ASK，适用于支持集群协议的客户端
+)
+
 // MakeClusterDatabase creates and starts a node of cluster
 func MakeClusterDatabase() *ClusterDatabase {
+	// 没有节点密码时无法区分节点与普通客户端，拒绝启动
+	if getClusterAuth() == "" {
+		logger.Fatal(errNoClusterAuth.Error())
+	}
 	// 初始化集群中的单节点数据库
 	cluster := &ClusterDatabase{
 		self:           config.Properties.Self,
 		db:             database.NewStandaloneDatabase(),
-		peerPicker:     consistenthash.NewNodeMap(nil),
+		redirect:       strings.ToLower(config.Properties.ClusterMode) == modeRedirect,
 		peerConnection: make(map[string]*pool.ObjectPool),
-	}
+		peerMux:        make(map[string]*client.Client),
+		peerBreakers:   make(map[string]*breaker),
+		gossipDone:     make(chan struct{}),
+		replication:    makeReplication(),
+		transactions:   dict.MakeSyncDict(),
 
-	// 初始化节点数量。容量：自身 + 其他 Redis 节点
-	// 将自身信息也添加到集群节点列表中
-	nodes := make([]string, 0, len(config.Properties.Peers)+1)
-	nodes = append(nodes, config.Properties.Self)
-	for _, peer := range config.Properties.Peers {
-		nodes = append(nodes, peer)
+		configuredReplicas: parseReplicasConfig(config.Properties.ClusterReplicas),
+		tracking:           makeTracking(),
+	}
+	if config.Properties.ClusterNearCache && !cluster.redirect {
+		cluster.nearCache = makeNearCache()
 	}
-	cluster.nodes = nodes
+	// 重启后的事务 id 不能与重启前的重复，参与者可能还保留着重启前的事务
+	cluster.txSeq.Store(uint64(time.Now().UnixNano()))
+	// 按 slot 记录 key，迁移 slot 时不需要遍历所有 key
+	cluster.db.EnableSlotIndex(slotCount, getSlot)
+	// 写命令同时发送给从节点
+	cluster.db.AddWriteListener(cluster.replication.feed)
+	// 被其他节点缓存的 key 被修改时，通知这些节点删除缓存
+	cluster.db.AddWriteListener(cluster.onWrite)
 
-	// 将所有的节点添加到一致性哈希管理器中
-	cluster.peerPicker.AddNode(nodes...)
+	// 节点重启时，以 nodes.conf 中保存的拓扑为准
+	saved, err := loadNodesConf(getNodesConf())
+	if err != nil {
+		logger.Error(fmt.Sprintf("load %s failed: %v", getNodesConf(), err))
+	}
+	if saved != nil {
+		if saved.self != "" && saved.self != cluster.self {
+			logger.Warn(fmt.Sprintf("myself in %s is %s, but self is %s", getNodesConf(), saved.self, cluster.self))
+		}
+		cluster.topology = newTopology(cluster.self, nil)
+		cluster.topology.load(saved)
+		logger.Info("load cluster topology from " + getNodesConf())
+	} else if config.Properties.ClusterReplicaOf != "" {
+		// 从节点不负责 slot，从配置的节点或主节点的 gossip 中获取拓扑
+		cluster.topology = newTopology(cluster.self, nil)
+		cluster.bootstrapTopology()
+		cluster.replicaOf(config.Properties.ClusterReplicaOf)
+	} else {
+		// 将所有的 slot 平均分配给自身和配置中的节点
+		cluster.topology = newTopology(cluster.self, config.Properties.Peers)
+		// 集群已经在运行(新节点加入)时，以其他节点上的拓扑为准
+		cluster.bootstrapTopology()
+	}
+	cluster.topology.setBusPort(cluster.self, configBusPort(cluster.self))
 
 	// 初始化连接池
 	// 对每一个兄弟节点，都传入连接工厂
 	// 连接工厂传入之后，连接池会自动 新建 & 维护 连接个数
-	// 这里使用默认的连接池配置，会根据每个兄弟节点之间新建 8 个空闲连接
-	ctx := context.Background()
 	for _, peer := range config.Properties.Peers {
-		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{
-			Peer: peer,
-		})
+		cluster.getPeerPool(peer)
 	}
+
+	// 启动集群总线，与其他节点交换拓扑、检测故障
+	if err = cluster.startGossip(); err != nil {
+		logger.Error("start cluster bus failed: " + err.Error())
+	}
+	go cluster.peerMuxCron()
+	cluster.reconcileReplication()
 	return cluster
 }
 
+// replicaOf makes current node a replica of master configured by cluster-replicaof
+// 通过 MEET 加入主节点所在的集群
+func (cluster *ClusterDatabase) replicaOf(master string) {
+	if master == cluster.self {
+		return
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	if t.countSlots(t.self) > 0 {
+		t.mu.Unlock()
+		logger.Warn("cluster-replicaof is ignored since current node serves slots")
+		return
+	}
+	t.nodes[t.self].master = master
+	t.mu.Unlock()
+	// 从其他节点加载的拓扑中已经有主节点，但主节点还不认识本节点，仍然需要发送 MEET
+	cluster.meet(master, t.getBusPort(master))
+}
+
+// bootstrapTopology loads slot assignment from a running peer
+// 新节点加入时，集群中的 slot 可能已经迁移过，与按配置计算的结果不同
+// 依次询问配置中的节点，以第一个完整分配了所有 slot 的节点拓扑为准
+func (cluster *ClusterDatabase) bootstrapTopology() {
+	for _, peer := range config.Properties.Peers {
+		peerClient, err := makePeerClient(peer)
+		if err != nil {
+			continue
+		}
+		ret := peerClient.Send(utils.ToCmdLine("CLUSTER", "NODES"))
+		peerClient.Close()
+		nodesReply, ok := ret.(*reply.BulkReply)
+		if !ok {
+			continue
+		}
+		saved, err := parseClusterNodes(string(nodesReply.Arg))
+		if err != nil {
+			logger.Warn(fmt.Sprintf("parse topology of %s failed: %v", peer, err))
+			continue
+		}
+		if saved.assigned < slotCount {
+			logger.Warn(fmt.Sprintf("some slots are not assigned in topology of %s", peer))
+			continue
+		}
+		// 迁移状态属于对方节点，不能加载
+		saved.migrating = nil
+		saved.importing = nil
+		cluster.topology.load(saved)
+		logger.Info("load cluster topology from " + peer)
+		return
+	}
+}
+
+// getPeerPool returns the connection pool of peer, creates it if not exists
+// 连接池大小、空闲连接数、空闲超时时间由 cluster-pool-* 配置
+// 借出连接时、定期检查空闲连接时通过 PING 验证连接，销毁断开的连接
+func (cluster *ClusterDatabase) getPeerPool(peer string) *pool.ObjectPool {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	p, ok := cluster.peerConnection[peer]
+	if !ok {
+		poolConfig := pool.NewDefaultPoolConfig()
+		poolConfig.MaxTotal = getPoolSize()
+		poolConfig.MaxIdle = getPoolMaxIdle()
+		poolConfig.MinEvictableIdleTime = getPoolIdleTimeout()
+		poolConfig.TimeBetweenEvictionRuns = getPoolIdleTimeout()
+		poolConfig.TestOnBorrow = true
+		poolConfig.TestWhileIdle = true
+		p = pool.NewObjectPool(context.Background(), &connectionFactory{
+			Peer: peer,
+		}, poolConfig)
+		cluster.peerConnection[peer] = p
+	}
+	return p
+}
+
+// getPeerMux returns the multiplexed client of peer which is shared by all relays, creates it if not exists
+// 与连接池使用相同的连接工厂创建连接
+// readOnly: 转发给从节点的读命令使用另一个执行过 READONLY 的连接
+func (cluster *ClusterDatabase) getPeerMux(peer string, readOnly bool) (*client.Client, error) {
+	key := muxKey(peer, readOnly)
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	if c, ok := cluster.peerMux[key]; ok {
+		return c, nil
+	}
+	factory := &connectionFactory{Peer: peer}
+	object, err := factory.MakeObject(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	c := object.Object.(*client.Client)
+	if readOnly {
+		if ret := c.Handshake(utils.ToCmdLine("READONLY")); !reply.IsOKReply(ret) {
+			c.Close()
+			return nil, errors.New("READONLY failed: " + string(ret.ToBytes()))
+		}
+	}
+	cluster.peerMux[key] = c
+	return c, nil
+}
+
+// dropPeerMux removes the multiplexed client of peer after connection error, the next relay creates a new one
+// 其他协程可能已经替换了连接，只删除仍然是 c 的缓存；Close 等待正在进行的请求结束，异步关闭
+func (cluster *ClusterDatabase) dropPeerMux(peer string, readOnly bool, c *client.Client) {
+	cluster.dropPeerMuxByKey(muxKey(peer, readOnly), c)
+}
+
+// dropPeerMuxByKey removes the multiplexed client cached by key if it is still c
+func (cluster *ClusterDatabase) dropPeerMuxByKey(key string, c *client.Client) {
+	cluster.peerMu.Lock()
+	cached, ok := cluster.peerMux[key]
+	if ok && cached == c {
+		delete(cluster.peerMux, key)
+	}
+	cluster.peerMu.Unlock()
+	if ok && cached == c {
+		go c.Close()
+	}
+}
+
+func muxKey(peer string, readOnly bool) string {
+	if readOnly {
+		return peer + "#readonly"
+	}
+	return peer
+}
+
+// closePeerPool closes the connection pool of peer, used when the peer leaves the cluster
+func (cluster *ClusterDatabase) closePeerPool(peer string) {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	if p, ok := cluster.peerConnection[peer]; ok {
+		p.Close(context.Background())
+		delete(cluster.peerConnection, peer)
+	}
+	for _, key := range []string{muxKey(peer, false), muxKey(peer, true)} {
+		if c, ok := cluster.peerMux[key]; ok {
+			c.Close()
+			delete(cluster.peerMux, key)
+		}
+	}
+	delete(cluster.peerBreakers, peer)
+}
+
 // CmdFunc represents the handler of a redis command
 // 声明 集群命令处理函数
 type CmdFunc func(cluster *ClusterDatabase, c resp.Connection, cmdAndArgs [][]byte) resp.Reply
 
 // Close stops current node of cluster
 func (cluster *ClusterDatabase) Close() {
+	cluster.stopGossip()
 	cluster.db.Close()
 }
 
-var router = makeRouter()
+var router map[string]CmdFunc
+
+func init() {
+	// 部分集群命令(如 slot 迁移)需要调用 Exec，在 init 中初始化避免初始化循环
+	router = makeRouter()
+}
 
 // Exec executes command on cluster
 // 集群的命令执行，代替单机版的命令执行
-func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result resp.Reply) {
+func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	return cluster.exec(c, cmdLine, false)
+}
+
+// exec executes command on cluster, internal is true if the command is sent by current node itself
+// 本节点自身发起的命令(如 REBALANCE 在本节点执行 CLUSTER SETSLOT)不需要检查节点认证
+func (cluster *ClusterDatabase) exec(c resp.Connection, cmdLine [][]byte, internal bool) (result resp.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
 			logger.Warn(fmt.Sprintf("error occurs: %v\n%s", err, string(debug.Stack())))
@@ -86,16 +313,37 @@ func (cluster *ClusterDatabase) Exec(c resp.Connection, cmdLine [][]byte) (resul
 
 	// 拿到第一个指令名称
 	cmdName := strings.ToLower(string(cmdLine[0]))
+	// ASKING 仅对紧随其后的一条命令有效，执行完毕后清除
+	if cmdName != "asking" {
+		defer c.SetAsking(false)
+	}
 	// 拿到方法
 	cmdFunc, ok := router[cmdName]
 	if !ok {
 		return reply.MakeErrReply("ERR unknown command '" + cmdName + "', or not supported in cluster mode")
 	}
+	// 集群内部命令、修改拓扑的 CLUSTER 子命令只能由认证过的节点发送
+	if !internal && isPeerOnly(cmdName, cmdLine) && !isPeerAuthenticated(c) {
+		return reply.MakeErrReply("NOAUTH Authentication required.")
+	}
+	if !isNodeCommand(cmdName) {
+		cluster.waitPausedClients()
+	}
+	// 重定向模式下，数据命令只在本节点执行，key 不属于本节点时回复 MOVED/ASK
+	if cluster.redirect && !isNodeCommand(cmdName) {
+		return cluster.execRedirect(c, cmdName, cmdLine)
+	}
 	result = cmdFunc(cluster, c, cmdLine)
+	// 本节点转发的写命令立即删除本地缓存，不等待负责的节点通知
+	if cluster.nearCache != nil && !isNodeCommand(cmdName) && !isReadOnlyCommand(cmdName) {
+		cluster.nearCache.invalidateCommand(c.GetDBIndex(), cmdName, cmdLine)
+	}
 	return
 }
 
 // AfterClientClose does some clean after client close connection
 func (cluster *ClusterDatabase) AfterClientClose(c resp.Connection) {
+	cluster.replication.removeReplica(c)
+	cluster.readPolicies.Delete(c)
 	cluster.db.AfterClientClose(c)
 }
@@ -3,13 +3,20 @@ package cluster
 import (
 	"context"
 	"errors"
+	"fmt"
+	"go-redis/config"
 	"go-redis/interface/resp"
 	"go-redis/lib/utils"
 	"go-redis/resp/client"
 	"go-redis/resp/reply"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 )
 
+const defaultBroadcastTimeout = 5000 // 群发命令的默认超时时间(毫秒)
+
 /*
 不同的命令，集群的各个节点中要表现出不同的行为
 1. PING（本地执行）
@@ -18,25 +25,32 @@ import (
 
 2. SET、GET（命令转发）
 	用户访问单个节点，执行 SET、GET 操作的话，需要通过一致性哈希管理器获取到对应的节点，然后转发给该节点执行。
-	转发需要用到连接池，将自身节点伪装成 Redis 客户端，发送用户命令到 目标节点，再将 目标节点的回复转发给用户。
+	转发时将自身节点伪装成 Redis 客户端，发送用户命令到 目标节点，再将 目标节点的回复转发给用户。
+	同一个目标节点的转发共用一个多路复用连接，并发的请求合并为批量写入(pipeline)，回复按发送顺序返回给各个请求。
 
 3. FLUSHDB（命令群发）
 	用户希望清空数据库，那么需要将集群中的，所有节点的数据都清空。
-    这里就是命令群发。
+    这里就是命令群发。所有节点并发执行，部分节点失败时回复哪些节点成功、哪些节点失败及原因。
 */
 
 // getPeerClient gets peer client
 // 通过连接地址，在连接池中拿到一个连接对象
+// the caller should record the result of request by breaker
+// 节点熔断时直接返回错误；借出连接后，调用方需要通过熔断器记录请求的结果
 func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, error) {
-	factory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return nil, errors.New("connection factory not found")
+	b := cluster.getBreaker(peer)
+	if !b.allow() {
+		return nil, errors.New("peer " + peer + " is unavailable, circuit breaker is open")
 	}
+	factory := cluster.getPeerPool(peer)
 
-	// 借一个连接对象
+	// 借一个连接对象，等待空闲连接的时间不超过 cluster-peer-timeout
 	// 注意：该连接对象需要还到连接池中。否则将进行连接泄漏或者连接池耗尽
-	raw, err := factory.BorrowObject(context.Background())
+	ctx, cancel := context.WithTimeout(context.Background(), getPeerTimeout())
+	defer cancel()
+	raw, err := factory.BorrowObject(ctx)
 	if err != nil {
+		b.onFailure()
 		return nil, err
 	}
 
@@ -49,11 +63,7 @@ func (cluster *ClusterDatabase) getPeerClient(peer string) (*client.Client, erro
 
 // returnPeerClient 业务处理完毕后，给连接池中还回去一个连接对象
 func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client.Client) error {
-	connectionFactory, ok := cluster.peerConnection[peer]
-	if !ok {
-		return errors.New("connection factory not found")
-	}
-	return connectionFactory.ReturnObject(context.Background(), peerClient)
+	return cluster.getPeerPool(peer).ReturnObject(context.Background(), peerClient)
 }
 
 // relay relays command to peer
@@ -61,34 +71,190 @@ func (cluster *ClusterDatabase) returnPeerClient(peer string, peerClient *client
 // cannot call Prepare, Commit, execRollback of self node
 // 实现转发
 func (cluster *ClusterDatabase) relay(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.doRelay(peer, c, args, false)
+}
+
+// relayAsking relays command to the node which is importing the slot, ASKING will be sent before the command
+// slot 迁移过程中，key 已经迁移到目标节点，需要先发送 ASKING 才能在目标节点上执行
+func (cluster *ClusterDatabase) relayAsking(peer string, c resp.Connection, args [][]byte) resp.Reply {
+	return cluster.doRelay(peer, c, args, true)
+}
+
+func (cluster *ClusterDatabase) doRelay(peer string, c resp.Connection, args [][]byte, asking bool) resp.Reply {
+	// 读命令按照连接的读策略，可能转发给从节点
+	readOnly := false
+	if !asking {
+		peer, readOnly = cluster.pickReadNode(c, peer, args)
+	}
 	// 如果需要转发的地址是本身，则直接执行命令
 	if peer == cluster.self {
 		// to self db
 		return cluster.db.Exec(c, args)
 	}
 
-	// 从连接池中获取连接对象
-	peerClient, err := cluster.getPeerClient(peer)
+	// 节点熔断时快速失败，不必等待超时
+	b := cluster.getBreaker(peer)
+	allowed, probe := b.acquire()
+	if !allowed {
+		return rejectReply(peer)
+	}
+	// 所有转发共用一个多路复用连接，不需要借还连接池中的连接
+	peerClient, err := cluster.getPeerMux(peer, readOnly)
+	if err == nil && probe && !validateClient(peerClient) {
+		// half-open 的探测请求与借出连接池中的连接一样先验证连接，熔断前建立的连接可能已经不可用，重新建立连接
+		cluster.dropPeerMux(peer, readOnly, peerClient)
+		peerClient, err = cluster.getPeerMux(peer, readOnly)
+	}
 	if err != nil {
+		b.onFailure()
 		return reply.MakeErrReply(err.Error())
 	}
-	// 归还连接
-	defer func() {
-		_ = cluster.returnPeerClient(peer, peerClient)
-	}()
 
 	// 注意：发送命令到其他节点时，需要在相同的 DB 下执行命令！！！
-	peerClient.Send(utils.ToCmdLine("SELECT", strconv.Itoa(c.GetDBIndex())))
-	// 发送命令到其他节点
-	return peerClient.Send(args)
+	// 连接记住当前选择的 DB，只有 DB 变化时才会在命令之前发送 SELECT，且不需要等待 SELECT 的回复
+	var ret resp.Reply
+	if asking {
+		ret = peerClient.SendAsking(c.GetDBIndex(), args)
+	} else {
+		// 发送命令到其他节点
+		ret = peerClient.SendInDB(c.GetDBIndex(), args)
+	}
+	b.record(ret)
+	if client.IsConnErr(ret) {
+		// 连接可能已经不可用(如对端无响应)，下次转发时重新建立连接
+		cluster.dropPeerMux(peer, readOnly, peerClient)
+	}
+	return ret
+}
+
+// nodeResult is the reply of a node in multicast
+type nodeResult struct {
+	node  string
+	reply resp.Reply
+}
+
+// multicastResult holds the replies of all nodes in multicast, sorted by node
+type multicastResult struct {
+	results []*nodeResult
 }
 
-// broadcast broadcasts command to all node in cluster
-// 实现广播。广播的返回值是每个节点的回复，所以返回值是一个 map
-func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte) map[string]resp.Reply {
-	result := make(map[string]resp.Reply)
-	for _, node := range cluster.nodes {
-		result[node] = cluster.relay(node, c, args)
+// multicast sends command lines to nodes concurrently, and waits until all nodes replied or deadline exceeded
+// groupMap: node -> command line sent to the node
+// send: 向节点发送命令的方法
+// 超过 cluster-broadcast-timeout 仍未回复的节点，结果为超时错误
+func (cluster *ClusterDatabase) multicast(groupMap map[string]CmdLine, send func(node string, cmdLine CmdLine) resp.Reply) *multicastResult {
+	ch := make(chan *nodeResult, len(groupMap))
+	for node, cmdLine := range groupMap {
+		go func(node string, cmdLine CmdLine) {
+			defer func() {
+				if err := recover(); err != nil {
+					ch <- &nodeResult{node: node, reply: reply.MakeErrReply(fmt.Sprintf("ERR %v", err))}
+				}
+			}()
+			ch <- &nodeResult{node: node, reply: send(node, cmdLine)}
+		}(node, cmdLine)
+	}
+
+	timeout := getBroadcastTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	replied := make(map[string]*nodeResult, len(groupMap))
+	for len(replied) < len(groupMap) {
+		select {
+		case result := <-ch:
+			replied[result.node] = result
+		case <-timer.C:
+			// 未回复的节点记为超时，goroutine 中的回复写入带缓冲的 channel 后被丢弃
+			for node := range groupMap {
+				if _, ok := replied[node]; !ok {
+					replied[node] = &nodeResult{node: node, reply: reply.MakeErrReply("ERR timeout after " + timeout.String())}
+				}
+			}
+		}
+	}
+
+	result := &multicastResult{results: make([]*nodeResult, 0, len(replied))}
+	for _, r := range replied {
+		result.results = append(result.results, r)
 	}
+	sort.Slice(result.results, func(i, j int) bool {
+		return result.results[i].node < result.results[j].node
+	})
 	return result
 }
+
+// failed returns the results of nodes which replied error
+func (r *multicastResult) failed() []*nodeResult {
+	var failed []*nodeResult
+	for _, result := range r.results {
+		if reply.IsErrorReply(result.reply) {
+			failed = append(failed, result)
+		}
+	}
+	return failed
+}
+
+// errReply describes which nodes succeeded and which failed, returns nil if all nodes succeeded
+// eg: ERR FLUSHDB failed on 1 of 3 nodes: 127.0.0.1:6381 (ERR timeout after 5s); succeeded on 127.0.0.1:6379, 127.0.0.1:6380
+func (r *multicastResult) errReply(action string) reply.ErrorReply {
+	failed := r.failed()
+	if len(failed) == 0 {
+		return nil
+	}
+	failures := make([]string, 0, len(failed))
+	succeeded := make([]string, 0, len(r.results))
+	for _, result := range r.results {
+		if reply.IsErrorReply(result.reply) {
+			failures = append(failures, result.node+" ("+errorMessage(result.reply)+")")
+		} else {
+			succeeded = append(succeeded, result.node)
+		}
+	}
+	msg := "ERR " + action + " failed on " + strconv.Itoa(len(failed)) + " of " + strconv.Itoa(len(r.results)) +
+		" nodes: " + strings.Join(failures, ", ")
+	if len(succeeded) > 0 {
+		msg += "; succeeded on " + strings.Join(succeeded, ", ")
+	}
+	return reply.MakeErrReply(msg)
+}
+
+// getBroadcastTimeout returns cluster-broadcast-timeout
+func getBroadcastTimeout() time.Duration {
+	timeout := config.Properties.ClusterBroadcastTimeout
+	if timeout <= 0 {
+		timeout = defaultBroadcastTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// broadcast broadcasts command to all masters in cluster concurrently
+// 实现广播。每个节点只在本地执行命令，不会再次广播
+// 从节点通过主从复制得到主节点执行的写命令，不需要广播
+func (cluster *ClusterDatabase) broadcast(c resp.Connection, args [][]byte) *multicastResult {
+	groupMap := make(map[string]CmdLine)
+	for _, node := range cluster.topology.getMasters() {
+		groupMap[node] = args
+	}
+	return cluster.multicast(groupMap, func(node string, cmdLine CmdLine) resp.Reply {
+		return cluster.relayLocal(node, c, cmdLine)
+	})
+}
+
+// relayLocal relays command to node, and the node executes it on its own database
+// 普通的转发到达其他节点后仍会按照集群的逻辑执行(如 FLUSHDB 会再次广播)，
+// 这里通过 EXEC-LOCAL 要求目标节点直接在本地数据库执行
+func (cluster *ClusterDatabase) relayLocal(node string, c resp.Connection, args [][]byte) resp.Reply {
+	if node == cluster.self {
+		return cluster.db.Exec(c, args)
+	}
+	return cluster.relay(node, c, utils.ToCmdLine2("Exec-Local", args...))
+}
+
+// execLocal executes command on the database of current node
+// EXEC-LOCAL cmd args...
+func execLocal(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("exec-local")
+	}
+	return cluster.db.Exec(c, args[1:])
+}
@@ -0,0 +1,65 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"testing"
+	"time"
+)
+
+func TestMulticastDeadline(t *testing.T) {
+	timeout := config.Properties.ClusterBroadcastTimeout
+	config.Properties.ClusterBroadcastTimeout = 100
+	defer func() { config.Properties.ClusterBroadcastTimeout = timeout }()
+
+	cluster := makeTestCluster("127.0.0.1:6399")
+	block := make(chan struct{})
+	defer close(block)
+	groupMap := map[string]CmdLine{
+		"127.0.0.1:6379": nil,
+		"127.0.0.1:6380": nil,
+		"127.0.0.1:6381": nil,
+		"127.0.0.1:6382": nil,
+	}
+	start := time.Now()
+	result := cluster.multicast(groupMap, func(node string, cmdLine CmdLine) resp.Reply {
+		switch node {
+		case "127.0.0.1:6380":
+			return reply.MakeErrReply("ERR failed")
+		case "127.0.0.1:6381":
+			<-block // 不回复的节点不会阻塞其他节点的结果
+		case "127.0.0.1:6382":
+			panic("boom")
+		}
+		return reply.MakeOkReply()
+	})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("multicast should return after deadline, took %s", elapsed)
+	}
+	if len(result.results) != len(groupMap) {
+		t.Fatalf("expected %d results, got %d", len(groupMap), len(result.results))
+	}
+	if len(result.failed()) != 3 {
+		t.Errorf("expected 3 failed nodes, got %d", len(result.failed()))
+	}
+	expected := "ERR FLUSHDB failed on 3 of 4 nodes: 127.0.0.1:6380 (ERR failed), " +
+		"127.0.0.1:6381 (ERR timeout after 100ms), 127.0.0.1:6382 (ERR boom); succeeded on 127.0.0.1:6379"
+	if errReply := result.errReply("FLUSHDB"); errReply == nil || errReply.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, errReply)
+	}
+}
+
+func TestMulticastAllSucceeded(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399")
+	result := cluster.multicast(map[string]CmdLine{"127.0.0.1:6379": nil, "127.0.0.1:6380": nil},
+		func(node string, cmdLine CmdLine) resp.Reply {
+			return reply.MakeOkReply()
+		})
+	if errReply := result.errReply("FLUSHDB"); errReply != nil {
+		t.Errorf("expected no error, got %s", errReply.Error())
+	}
+	if result.results[0].node != "127.0.0.1:6379" {
+		t.Error("results should be sorted by node")
+	}
+}
@@ -3,38 +3,43 @@ package cluster
 import (
 	"go-redis/interface/resp"
 	"go-redis/resp/reply"
+	"strings"
 )
 
-// del atomically removes given writeKeys from cluster, writeKeys can be distributed on any node
-// if the given writeKeys are distributed on different node, Del will use try-commit-catch to remove them
-// del k1 k2 k3 k4...
-// 通过广播群发给所有节点，每个节点回复删除的个数
+// del atomically removes given keys from cluster, keys can be distributed on any node
+// if the given keys are distributed on different node, del will use try-commit-catch to remove them
+// del k1 k2 k3 k4... / unlink k1 k2 k3 k4...
+// key 都在同一个节点时直接转发；分布在多个节点时，通过 TCC 在每个节点删除各自的 key
 // 最终返回删除的总个数
 func del(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	// 借用广播逻辑，来实现 del
-	replies := cluster.broadcast(c, args)
-	var errReply reply.ErrorReply
-	// 统计删除的个数
-	var deleted int64 = 0
-	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	keys := getRelatedKeys(cmdName, args)
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	cmdLines := cluster.splitByNode(args)
+	if len(cmdLines) == 1 {
+		for node := range cmdLines {
+			return cluster.relay(node, c, args)
 		}
+	}
+
+	replies, aborted := cluster.execTCC(c, cmdLines)
+	if aborted != nil {
+		return aborted
+	}
 
-		// 所有节点的回复是 int reply，表示删除的个数
+	// 所有节点的回复是 int reply，表示删除的个数，累加
+	var deleted int64 = 0
+	for _, v := range replies {
 		intReply, ok := v.(*reply.IntReply)
 		if !ok {
-			errReply = reply.MakeErrReply("error")
+			return reply.MakeErrReply("ERR unexpected reply of " + strings.ToUpper(cmdName) + ": " + string(v.ToBytes()))
 		}
-
-		// 累加
 		deleted += intReply.Code
 	}
-
-	// 如果错误为空，则返回删除的个数
-	if errReply == nil {
-		return reply.MakeIntReply(deleted)
-	}
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return reply.MakeIntReply(deleted)
 }
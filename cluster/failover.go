@@ -0,0 +1,343 @@
+package cluster
+
+import (
+	"fmt"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/reply"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+故障转移
+1. 主节点被多数主节点标记为 FAIL 后，它的从节点等待一段时间后发起选举：
+	等待时间 = 500ms + 排名 * 1s + 随机 0~500ms，排名为复制偏移量比自己大的从节点个数，
+	因此偏移量最大(数据最新)的从节点最先发起选举
+2. 从节点增大集群纪元，向所有主节点发送 AUTH-REQUEST，每个主节点在一个纪元内只投一票
+3. 获得多数主节点的投票后，从节点将配置纪元设置为选举纪元，接管旧主节点的 slot 并通知所有节点，
+	其他节点收到纪元更大的 slot 声明后更新路由，旧主节点恢复后成为新主节点的从节点
+4. 选举失败时，等待 2 * cluster-node-timeout 后重试
+
+手动故障转移 CLUSTER FAILOVER [FORCE|TAKEOVER]，在从节点上执行
+	默认：主节点暂停客户端请求，从节点追上主节点的复制偏移量后发起选举，不丢失数据
+	FORCE：主节点不可达时使用，不等待主节点，直接发起选举
+	TAKEOVER：不经过选举，直接增大配置纪元接管 slot，用于多数主节点不可用时
+*/
+
+const (
+	failoverRankDelay    = time.Second            // 每个排名增加的选举等待时间
+	failoverBaseDelay    = 500 * time.Millisecond // 选举的基础等待时间
+	manualFailoverPause  = 5 * time.Second        // 手动故障转移时主节点暂停客户端的时间
+	manualFailoverPoll   = 100 * time.Millisecond
+	pausedClientsRecheck = 10 * time.Millisecond
+)
+
+// failoverCron checks whether the master of current node is failed, starts election if needed
+func (cluster *ClusterDatabase) failoverCron() {
+	t := cluster.topology
+	t.mu.RLock()
+	self := t.nodes[t.self]
+	master, ok := t.nodes[self.master]
+	if !ok || master.flags&nodeFlagFail == 0 || t.countSlots(master.addr) == 0 {
+		t.mu.RUnlock()
+		cluster.failoverAt = time.Time{}
+		return
+	}
+	offset := cluster.replication.getOffset()
+	rank := 0
+	for node, info := range t.nodes {
+		if node != t.self && info.master == self.master && info.flags&nodeFlagFail == 0 && info.replOffset > offset {
+			rank++
+		}
+	}
+	t.mu.RUnlock()
+
+	now := time.Now()
+	if cluster.failoverAt.IsZero() {
+		delay := failoverBaseDelay + time.Duration(rank)*failoverRankDelay +
+			time.Duration(rand.Int63n(int64(failoverBaseDelay)))
+		cluster.failoverAt = now.Add(delay)
+		logger.Info(fmt.Sprintf("master %s is failed, start election in %v (rank %d, offset %d)",
+			master.addr, delay, rank, offset))
+		return
+	}
+	if now.Before(cluster.failoverAt) || cluster.electing.Get() {
+		return
+	}
+	// 选举失败时在 2 * cluster-node-timeout 后重试
+	cluster.failoverAt = now.Add(2 * getNodeTimeout())
+	go cluster.runElection(false)
+}
+
+// runElection requests votes from masters, takes over slots of master if won
+func (cluster *ClusterDatabase) runElection(force bool) bool {
+	if cluster.electing.Get() {
+		return false
+	}
+	cluster.electing.Set(true)
+	defer cluster.electing.Set(false)
+
+	t := cluster.topology
+	t.mu.Lock()
+	if t.nodes[t.self].master == "" {
+		t.mu.Unlock()
+		return false
+	}
+	t.currentEpoch++
+	epoch := t.currentEpoch
+	t.changed = true
+	msg := cluster.makeMessage(msgAuthRequest)
+	msg.Force = force
+	masters := make(map[string]struct{})
+	for _, owner := range t.slots {
+		if owner != "" {
+			masters[owner] = struct{}{}
+		}
+	}
+	needed := len(masters)/2 + 1
+	voters := make([]string, 0, len(masters))
+	for owner := range masters {
+		if info, ok := t.nodes[owner]; ok && owner != t.self && info.flags&nodeFlagFail == 0 {
+			voters = append(voters, busAddr(owner, info.busPort))
+		}
+	}
+	t.mu.Unlock()
+	logger.Info(fmt.Sprintf("start failover election for epoch %d, %d votes needed", epoch, needed))
+
+	votes := 0
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, addr := range voters {
+		wg.Add(1)
+		go func(addr string) {
+			defer wg.Done()
+			ret, err := cluster.sendMessage(addr, msg, true)
+			if err != nil || ret.Type != msgAuthAck {
+				return
+			}
+			cluster.handleMessage(ret)
+			mu.Lock()
+			votes++
+			mu.Unlock()
+		}(addr)
+	}
+	wg.Wait()
+	if votes < needed {
+		logger.Warn(fmt.Sprintf("failover election for epoch %d failed, got %d/%d votes", epoch, votes, needed))
+		return false
+	}
+	logger.Info(fmt.Sprintf("failover election for epoch %d won, got %d/%d votes", epoch, votes, needed))
+	cluster.takeOver(epoch)
+	return true
+}
+
+// handleAuthRequest votes for the replica if its master is failed, caller should hold the lock
+// 只有负责 slot 的主节点可以投票，每个纪元只投一票，同一个主节点的从节点在 2 * cluster-node-timeout 内只投一票
+func (cluster *ClusterDatabase) handleAuthRequest(sender *nodeInfo, msg *gossipMessage) *gossipMessage {
+	t := cluster.topology
+	if t.countSlots(t.self) == 0 || msg.Master == "" {
+		return nil
+	}
+	master, ok := t.nodes[msg.Master]
+	if !ok {
+		return nil
+	}
+	if !msg.Force && master.flags&nodeFlagFail == 0 {
+		logger.Info(fmt.Sprintf("refuse to vote for %s: master %s is not failed", sender.addr, msg.Master))
+		return nil
+	}
+	if msg.CurrentEpoch < t.currentEpoch || t.lastVoteEpoch >= msg.CurrentEpoch {
+		logger.Info(fmt.Sprintf("refuse to vote for %s: already voted for epoch %d", sender.addr, t.lastVoteEpoch))
+		return nil
+	}
+	if time.Since(master.votedAt) < 2*getNodeTimeout() {
+		logger.Info(fmt.Sprintf("refuse to vote for %s: voted for a replica of %s recently", sender.addr, msg.Master))
+		return nil
+	}
+	t.lastVoteEpoch = msg.CurrentEpoch
+	master.votedAt = time.Now()
+	t.changed = true
+	logger.Info(fmt.Sprintf("vote for %s in epoch %d", sender.addr, msg.CurrentEpoch))
+	return cluster.makeMessage(msgAuthAck)
+}
+
+// takeOver makes current node the master of slots served by its master
+// epoch 为 0 时直接增大集群纪元(TAKEOVER)
+func (cluster *ClusterDatabase) takeOver(epoch uint64) {
+	t := cluster.topology
+	t.mu.Lock()
+	self := t.nodes[t.self]
+	oldMaster := self.master
+	if oldMaster == "" {
+		t.mu.Unlock()
+		return
+	}
+	if epoch == 0 || epoch < t.currentEpoch {
+		t.currentEpoch++
+		epoch = t.currentEpoch
+	}
+	self.configEpoch = epoch
+	self.master = ""
+	taken := 0
+	for slot, owner := range t.slots {
+		if owner == oldMaster {
+			t.slots[slot] = t.self
+			taken++
+		}
+	}
+	for node, info := range t.nodes {
+		if node != t.self && (node == oldMaster || info.master == oldMaster) {
+			info.master = t.self
+		}
+	}
+	t.changed = true
+	msg := cluster.makeMessage(msgPong)
+	addrs := make([]string, 0, len(t.nodes))
+	for node, info := range t.nodes {
+		if node != t.self {
+			addrs = append(addrs, busAddr(node, info.busPort))
+		}
+	}
+	t.mu.Unlock()
+	logger.Info(fmt.Sprintf("failover: take over %d slots of %s with config epoch %d", taken, oldMaster, epoch))
+
+	// 立即通知所有节点 slot 的新归属
+	cluster.reconcileReplication()
+	for _, addr := range addrs {
+		go func(addr string) {
+			_, _ = cluster.sendMessage(addr, msg, false)
+		}(addr)
+	}
+}
+
+// handleManualFailoverStart pauses clients and replies the replication offset, caller should hold the lock
+func (cluster *ClusterDatabase) handleManualFailoverStart(sender *nodeInfo) *gossipMessage {
+	t := cluster.topology
+	if sender.master != t.self {
+		return nil
+	}
+	cluster.pauseClients(manualFailoverPause)
+	logger.Info("manual failover requested by " + sender.addr + ", clients paused")
+	return cluster.makeMessage(msgPong)
+}
+
+// manualFailover waits until current node catches up with its master, then starts election
+func (cluster *ClusterDatabase) manualFailover() {
+	t := cluster.topology
+	t.mu.Lock()
+	master, ok := t.nodes[t.nodes[t.self].master]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	addr := busAddr(master.addr, master.busPort)
+	msg := cluster.makeMessage(msgMFStart)
+	t.mu.Unlock()
+
+	ret, err := cluster.sendMessage(addr, msg, true)
+	if err != nil || ret.Type != msgPong {
+		logger.Warn("manual failover aborted: master did not respond")
+		return
+	}
+	cluster.handleMessage(ret)
+	deadline := time.Now().Add(manualFailoverPause)
+	for cluster.replication.getOffset() < ret.ReplOffset {
+		if time.Now().After(deadline) {
+			logger.Warn(fmt.Sprintf("manual failover aborted: offset %d can't catch up with master offset %d",
+				cluster.replication.getOffset(), ret.ReplOffset))
+			return
+		}
+		time.Sleep(manualFailoverPoll)
+	}
+	cluster.runElection(true)
+}
+
+// pauseClients blocks data commands of clients for the given duration
+func (cluster *ClusterDatabase) pauseClients(duration time.Duration) {
+	cluster.pausedUntil.Store(time.Now().Add(duration).UnixNano())
+}
+
+// resumeClients unblocks data commands
+func (cluster *ClusterDatabase) resumeClients() {
+	cluster.pausedUntil.Store(0)
+}
+
+// waitPausedClients blocks until clients are resumed
+// 手动故障转移完成后，暂停期间的命令会被路由到新的主节点
+func (cluster *ClusterDatabase) waitPausedClients() {
+	for time.Now().UnixNano() < cluster.pausedUntil.Load() {
+		time.Sleep(pausedClientsRecheck)
+	}
+}
+
+// execClusterFailover starts a manual failover on replica
+// CLUSTER FAILOVER [FORCE|TAKEOVER]
+func execClusterFailover(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) > 3 {
+		return reply.MakeSyntaxErrReply()
+	}
+	option := ""
+	if len(args) == 3 {
+		option = strings.ToLower(string(args[2]))
+		if option != "force" && option != "takeover" {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	t := cluster.topology
+	t.mu.RLock()
+	master := t.nodes[t.self].master
+	masterInfo, known := t.nodes[master]
+	t.mu.RUnlock()
+	if master == "" {
+		return reply.MakeErrReply("ERR You should send CLUSTER FAILOVER to a replica")
+	}
+	if !known {
+		return reply.MakeErrReply("ERR I'm a replica but my master is unknown to me")
+	}
+	switch option {
+	case "takeover":
+		cluster.takeOver(0)
+	case "force":
+		go cluster.runElection(true)
+	default:
+		if masterInfo.flags&nodeFlagFail > 0 {
+			return reply.MakeErrReply("ERR Master is down or failed, please use CLUSTER FAILOVER FORCE")
+		}
+		go cluster.manualFailover()
+	}
+	return reply.MakeOkReply()
+}
+
+// execClusterReplicate makes current node a replica of the given node
+// CLUSTER REPLICATE node-id
+// 本节点不能负责任何 slot，开始复制后本节点的数据会被清空
+func execClusterReplicate(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("cluster|replicate")
+	}
+	node, ok := cluster.topology.findNode(string(args[2]))
+	if !ok {
+		return reply.MakeErrReply("ERR Unknown node " + string(args[2]))
+	}
+	if node == cluster.self {
+		return reply.MakeErrReply("ERR Can't replicate myself")
+	}
+	t := cluster.topology
+	t.mu.Lock()
+	if t.nodes[node].master != "" {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR I can only replicate a master, not a replica.")
+	}
+	if t.countSlots(t.self) > 0 {
+		t.mu.Unlock()
+		return reply.MakeErrReply("ERR To set a master the node must be empty and without assigned slots.")
+	}
+	t.nodes[t.self].master = node
+	t.changed = true
+	t.mu.Unlock()
+	cluster.reconcileReplication()
+	return reply.MakeOkReply()
+}
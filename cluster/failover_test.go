@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func TestHandleAuthRequest(t *testing.T) {
+	self, master, replica := "127.0.0.1:6399", "127.0.0.1:6400", "127.0.0.1:6401"
+	cluster := makeTestCluster(self, master, replica)
+	topo := cluster.topology
+	topo.nodes[replica].master = master
+	sender := topo.nodes[replica]
+	request := &gossipMessage{Type: msgAuthRequest, Sender: replica, Master: master, CurrentEpoch: 1}
+
+	if cluster.handleAuthRequest(sender, request) != nil {
+		t.Fatal("should not vote when master is not failed")
+	}
+	topo.nodes[master].flags |= nodeFlagFail
+	if cluster.handleAuthRequest(sender, request) == nil {
+		t.Fatal("should vote when master is failed")
+	}
+	if cluster.handleAuthRequest(sender, request) != nil {
+		t.Fatal("should vote only once in an epoch")
+	}
+	request.CurrentEpoch = 2
+	if cluster.handleAuthRequest(sender, request) != nil {
+		t.Fatal("should not vote for replicas of the same master again within 2 * cluster-node-timeout")
+	}
+}
+
+func TestTakeOver(t *testing.T) {
+	self, master, other := "127.0.0.1:6399", "127.0.0.1:6400", "127.0.0.1:6401"
+	cluster := makeTestCluster(self, master, other)
+	topo := cluster.topology
+	// 本节点原来是 master 的从节点，不负责 slot
+	for slot, owner := range topo.slots {
+		if owner == self {
+			topo.slots[slot] = master
+		}
+	}
+	topo.nodes[self].master = master
+	topo.currentEpoch = 3
+
+	cluster.takeOver(5)
+	if topo.nodes[self].master != "" || topo.nodes[self].configEpoch != 5 {
+		t.Fatalf("self should be master with config epoch 5, got master %q epoch %d", topo.nodes[self].master, topo.nodes[self].configEpoch)
+	}
+	if topo.nodes[master].master != self {
+		t.Errorf("old master should become replica of self")
+	}
+	for slot, owner := range topo.slots {
+		if owner == master {
+			t.Fatalf("slot %d is still served by old master", slot)
+		}
+	}
+	if topo.countSlots(self) == 0 || topo.countSlots(other) == 0 {
+		t.Error("self should take over slots of old master only")
+	}
+}
@@ -0,0 +1,562 @@
+package cluster
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"net"
+	"strconv"
+	"time"
+)
+
+/*
+集群总线
+每个节点在 port+10000(或 cluster-port) 上监听集群总线，节点之间通过短连接交换 JSON 格式的消息：
+1. PING/PONG：每秒向所有节点发送 PING，对方回复 PONG。
+	消息中携带发送者的纪元、负责的 slot，以及发送者眼中其他节点的状态(gossip)
+2. MEET：CLUSTER MEET 后向新节点发送，只有 MEET 消息能让对方接纳未知的发送者
+3. FAIL：将某个节点标记为已下线后，通知所有节点
+4. AUTH-REQUEST/AUTH-ACK：从节点发起故障转移选举时请求主节点投票，见 failover.go
+5. MFSTART：手动故障转移时，从节点请求主节点暂停客户端并返回复制偏移量
+
+故障检测
+1. 超过 cluster-node-timeout 没有收到某节点的消息，将其标记为 PFAIL(疑似下线)
+2. 通过 gossip 收集其他主节点的 PFAIL 报告，多数主节点都认为其疑似下线时标记为 FAIL，并广播 FAIL 消息
+3. 再次收到该节点的消息时清除 PFAIL/FAIL 标记
+
+slot 归属
+多个节点声明负责同一个 slot 时，配置纪元大的节点胜出。
+节点得到新的 slot 时增大自身的配置纪元，使新的归属通过 PING/PONG 传播到整个集群
+*/
+
+const (
+	busPortOffset      = 10000            // 集群总线端口 = 服务端口 + 10000
+	gossipInterval     = time.Second      // 发送 PING 的周期
+	defaultNodeTimeout = 15000            // cluster-node-timeout 默认值，单位毫秒
+	forgetBanTime      = 60 * time.Second // CLUSTER FORGET 后禁止节点重新加入的时间
+	defaultNodesConf   = "nodes.conf"
+)
+
+// message types
+const (
+	msgPing = "ping"
+	msgPong = "pong"
+	msgMeet = "meet"
+	msgFail = "fail"
+
+	msgAuthRequest = "auth-request"
+	msgAuthAck     = "auth-ack"
+	msgMFStart     = "mfstart"
+	msgInvalidate  = "invalidate" // 被跟踪的 key 被修改，通知缓存了该 key 的节点
+)
+
+// gossipMessage is the message exchanged on cluster bus
+type gossipMessage struct {
+	Type         string        `json:"type"`
+	Sender       string        `json:"sender"`  // 发送者地址
+	BusPort      int           `json:"busPort"` // 发送者的集群总线端口
+	CurrentEpoch uint64        `json:"currentEpoch"`
+	ConfigEpoch  uint64        `json:"configEpoch"`
+	Slots        []byte        `json:"slots"` // 发送者负责的 slot，位图
+	Gossip       []*gossipNode `json:"gossip,omitempty"`
+	FailNode     string        `json:"failNode,omitempty"` // FAIL 消息中已下线的节点
+	Master       string        `json:"master,omitempty"`   // 发送者是从节点时，为其主节点地址
+	ReplOffset   int64         `json:"replOffset"`         // 发送者的复制偏移量
+	Force        bool          `json:"force,omitempty"`    // 手动故障转移，主节点没有下线也可以投票
+	DB           int           `json:"db,omitempty"`       // INVALIDATE 消息中 key 所在的数据库，-1 表示所有数据库
+	Keys         []string      `json:"keys,omitempty"`     // INVALIDATE 消息中被修改的 key，为空表示清空数据库
+	Time         int64         `json:"time"`               // 发送时间(毫秒)，用于拒绝过期的消息
+	Nonce        string        `json:"nonce"`              // 随机数，用于拒绝重放的消息
+	Signature    string        `json:"sig,omitempty"`      // 使用 cluster-auth 计算的 HMAC 签名
+}
+
+// gossipNode is the state of another node in the view of sender
+type gossipNode struct {
+	Addr    string `json:"addr"`
+	BusPort int    `json:"busPort"`
+	PFail   bool   `json:"pfail"` // 发送者认为该节点疑似下线或已下线
+}
+
+// getNodeTimeout returns cluster-node-timeout
+func getNodeTimeout() time.Duration {
+	timeout := config.Properties.ClusterNodeTimeout
+	if timeout <= 0 {
+		timeout = defaultNodeTimeout
+	}
+	return time.Duration(timeout) * time.Millisecond
+}
+
+// getNodesConf returns the filename of nodes.conf
+func getNodesConf() string {
+	if config.Properties.ClusterConfigFile != "" {
+		return config.Properties.ClusterConfigFile
+	}
+	return defaultNodesConf
+}
+
+// configBusPort returns the cluster bus port of current node in config
+func configBusPort(self string) int {
+	if config.Properties.ClusterPort > 0 {
+		return config.Properties.ClusterPort
+	}
+	_, port := splitAddr(self)
+	return port + busPortOffset
+}
+
+// startGossip listens on cluster bus and starts the cron which sends PING periodically
+func (cluster *ClusterDatabase) startGossip() error {
+	busPort := cluster.topology.getBusPort(cluster.self)
+	listener, err := listenBus(net.JoinHostPort(config.Properties.Bind, strconv.Itoa(busPort)))
+	if err != nil {
+		return err
+	}
+	cluster.busListener = listener
+	logger.Info(fmt.Sprintf("cluster bus listening on %d", busPort))
+	go cluster.serveGossip(listener)
+	go cluster.gossipCron()
+	return nil
+}
+
+// stopGossip stops cluster bus and saves nodes.conf
+func (cluster *ClusterDatabase) stopGossip() {
+	close(cluster.gossipDone)
+	if cluster.busListener != nil {
+		_ = cluster.busListener.Close()
+	}
+	if err := cluster.topology.saveNodesConf(getNodesConf()); err != nil {
+		logger.Error("save nodes.conf failed: " + err.Error())
+	}
+}
+
+// serveGossip accepts connections on cluster bus
+func (cluster *ClusterDatabase) serveGossip(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-cluster.gossipDone:
+				return
+			default:
+			}
+			logger.Warn("cluster bus accept failed: " + err.Error())
+			time.Sleep(gossipInterval)
+			continue
+		}
+		go cluster.handleGossipConn(conn)
+	}
+}
+
+// handleGossipConn reads a message, and writes the reply if needed
+func (cluster *ClusterDatabase) handleGossipConn(conn net.Conn) {
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(getNodeTimeout()))
+	msg := &gossipMessage{}
+	if err := json.NewDecoder(conn).Decode(msg); err != nil {
+		return
+	}
+	if !verifyMessage(msg) {
+		logger.Warn("drop cluster bus message with invalid signature from " + conn.RemoteAddr().String())
+		return
+	}
+	// 失效消息不携带拓扑信息，不需要更新发送者的状态
+	if msg.Type == msgInvalidate {
+		cluster.handleInvalidate(msg)
+		return
+	}
+	ret := cluster.handleMessage(msg)
+	if ret != nil {
+		signMessage(ret)
+		_ = json.NewEncoder(conn).Encode(ret)
+	}
+}
+
+// sendMessage sends message to the node, and waits for reply if expectReply is true
+func (cluster *ClusterDatabase) sendMessage(busAddr string, msg *gossipMessage, expectReply bool) (*gossipMessage, error) {
+	timeout := getNodeTimeout() / 2
+	conn, err := dialPeer(busAddr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	// 同一条消息可能并发发送给多个节点，每次发送使用新的随机数签名
+	signed := *msg
+	signMessage(&signed)
+	if err = json.NewEncoder(conn).Encode(&signed); err != nil {
+		return nil, err
+	}
+	if !expectReply {
+		return nil, nil
+	}
+	ret := &gossipMessage{}
+	if err = json.NewDecoder(conn).Decode(ret); err != nil {
+		return nil, err
+	}
+	if !verifyMessage(ret) {
+		return nil, errors.New("invalid signature of reply from " + busAddr)
+	}
+	return ret, nil
+}
+
+// gossipCron sends PING to all nodes, detects failures and saves nodes.conf periodically
+func (cluster *ClusterDatabase) gossipCron() {
+	ticker := time.NewTicker(gossipInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cluster.gossipDone:
+			return
+		case <-ticker.C:
+		}
+		cluster.tracking.removeExpired()
+		cluster.pingNodes()
+		for _, failed := range cluster.detectFailures() {
+			cluster.broadcastFail(failed)
+		}
+		cluster.reconcileReplication()
+		cluster.failoverCron()
+		if err := cluster.topology.saveNodesConf(getNodesConf()); err != nil {
+			logger.Error("save nodes.conf failed: " + err.Error())
+		}
+	}
+}
+
+// pingNodes sends PING (or MEET for nodes in handshake) to all nodes which have no pending PING
+func (cluster *ClusterDatabase) pingNodes() {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for node, info := range t.nodes {
+		if node == t.self || !info.pingSent.IsZero() {
+			continue
+		}
+		info.pingSent = now
+		msgType := msgPing
+		if info.flags&nodeFlagMeet > 0 {
+			msgType = msgMeet
+		}
+		go cluster.pingNode(node, busAddr(node, info.busPort), cluster.makeMessage(msgType))
+	}
+}
+
+// pingNode sends PING to the node and handles the PONG
+func (cluster *ClusterDatabase) pingNode(node string, addr string, msg *gossipMessage) {
+	start := time.Now()
+	ret, err := cluster.sendMessage(addr, msg, true)
+	if err == nil {
+		cluster.topology.updateRTT(node, time.Since(start))
+		cluster.handleMessage(ret)
+		return
+	}
+	// 清除发送时间，下个周期重试
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if info, ok := t.nodes[node]; ok {
+		info.pingSent = time.Time{}
+	}
+}
+
+// makeMessage creates message with the state of current node, caller should hold the lock
+func (cluster *ClusterDatabase) makeMessage(msgType string) *gossipMessage {
+	t := cluster.topology
+	self := t.nodes[t.self]
+	msg := &gossipMessage{
+		Type:         msgType,
+		Sender:       t.self,
+		BusPort:      self.busPort,
+		CurrentEpoch: t.currentEpoch,
+		ConfigEpoch:  self.configEpoch,
+		Slots:        make([]byte, slotCount/8),
+		Master:       self.master,
+		ReplOffset:   cluster.replication.getOffset(),
+	}
+	for slot, owner := range t.slots {
+		if owner == t.self {
+			msg.Slots[slot/8] |= 1 << (slot % 8)
+		}
+	}
+	for node, info := range t.nodes {
+		if node == t.self || info.flags&nodeFlagMeet > 0 {
+			continue
+		}
+		msg.Gossip = append(msg.Gossip, &gossipNode{
+			Addr:    node,
+			BusPort: info.busPort,
+			PFail:   info.flags&(nodeFlagPFail|nodeFlagFail) > 0,
+		})
+	}
+	return msg
+}
+
+// handleMessage updates topology by message, returns the reply message
+func (cluster *ClusterDatabase) handleMessage(msg *gossipMessage) *gossipMessage {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if msg.Sender == "" || msg.Sender == t.self {
+		return nil
+	}
+	sender, known := t.nodes[msg.Sender]
+	if !known {
+		// 只有 MEET 消息能让本节点接纳未知的节点
+		if msg.Type != msgMeet || t.isForgotten(msg.Sender) {
+			return nil
+		}
+		sender = t.addNode(msg.Sender)
+		logger.Info("node " + msg.Sender + " joined the cluster")
+	}
+
+	// 收到消息说明发送者可达
+	sender.pingSent = time.Time{}
+	sender.pongReceived = time.Now()
+	if sender.flags&(nodeFlagPFail|nodeFlagFail) > 0 {
+		logger.Info("node " + msg.Sender + " is reachable again")
+		t.changed = true
+	}
+	sender.flags &^= nodeFlagPFail | nodeFlagFail | nodeFlagMeet
+	if sender.busPort != msg.BusPort && msg.BusPort > 0 {
+		sender.busPort = msg.BusPort
+		t.changed = true
+	}
+	if msg.CurrentEpoch > t.currentEpoch {
+		t.currentEpoch = msg.CurrentEpoch
+		t.changed = true
+	}
+	if msg.ConfigEpoch != sender.configEpoch {
+		sender.configEpoch = msg.ConfigEpoch
+		t.changed = true
+	}
+	if msg.ConfigEpoch > t.currentEpoch {
+		t.currentEpoch = msg.ConfigEpoch
+	}
+	if msg.Master != sender.master {
+		sender.master = msg.Master
+		t.changed = true
+	}
+	sender.replOffset = msg.ReplOffset
+	// 刚通过 MEET 加入的节点不能声明 slot 或标记其他节点 FAIL，加入后的下一条消息才会生效
+	if !known {
+		return cluster.makeMessage(msgPong)
+	}
+	cluster.updateSlots(sender, msg.Slots)
+	cluster.handleEpochCollision(sender)
+	for _, g := range msg.Gossip {
+		cluster.handleGossip(sender, g)
+	}
+	if msg.Type == msgFail {
+		if failed, ok := t.nodes[msg.FailNode]; ok && msg.FailNode != t.self && failed.flags&nodeFlagFail == 0 {
+			failed.flags |= nodeFlagFail
+			t.changed = true
+			logger.Warn("node " + msg.FailNode + " is marked as FAIL by " + msg.Sender)
+		}
+	}
+	switch msg.Type {
+	case msgPing, msgMeet:
+		return cluster.makeMessage(msgPong)
+	case msgAuthRequest:
+		return cluster.handleAuthRequest(sender, msg)
+	case msgMFStart:
+		return cluster.handleManualFailoverStart(sender)
+	}
+	return nil
+}
+
+// updateSlots assigns the slots claimed by sender to it if sender has greater config epoch, caller should hold the lock
+// 正在迁入本节点的 slot 由迁移流程决定归属，这里不做修改
+// 失去全部 slot 的主节点(如故障转移后恢复的旧主节点)及其从节点，改为复制 sender
+func (cluster *ClusterDatabase) updateSlots(sender *nodeInfo, bitmap []byte) {
+	t := cluster.topology
+	if len(bitmap) != slotCount/8 {
+		return
+	}
+	losers := make(map[string]struct{})
+	defer func() {
+		for loser := range losers {
+			if t.countSlots(loser) > 0 {
+				continue
+			}
+			for node, info := range t.nodes {
+				if node == sender.addr || (node != loser && info.master != loser) {
+					continue
+				}
+				info.master = sender.addr
+				if node == t.self {
+					cluster.resumeClients()
+					logger.Warn("lost all slots, become replica of " + sender.addr)
+				}
+			}
+		}
+	}()
+	for i := 0; i < slotCount; i++ {
+		if bitmap[i/8]&(1<<(i%8)) == 0 {
+			continue
+		}
+		slot := uint32(i)
+		owner := t.slots[slot]
+		if owner == sender.addr {
+			continue
+		}
+		if _, importing := t.importing[slot]; importing {
+			continue
+		}
+		if ownerInfo, ok := t.nodes[owner]; ok && ownerInfo.configEpoch >= sender.configEpoch {
+			continue
+		}
+		if owner == t.self {
+			delete(t.migrating, slot)
+			logger.Warn(fmt.Sprintf("slot %d is taken over by %s", slot, sender.addr))
+		}
+		if owner != "" {
+			losers[owner] = struct{}{}
+		}
+		t.slots[slot] = sender.addr
+		t.changed = true
+	}
+}
+
+// handleEpochCollision bumps config epoch of current node if it collides with the sender, caller should hold the lock
+// 两个主节点的配置纪元相同时，slot 冲突无法决出胜负，由节点 id 较小的一方增大纪元
+func (cluster *ClusterDatabase) handleEpochCollision(sender *nodeInfo) {
+	t := cluster.topology
+	self := t.nodes[t.self]
+	if sender.configEpoch != self.configEpoch || t.countSlots(sender.addr) == 0 || t.countSlots(t.self) == 0 {
+		return
+	}
+	if nodeID(sender.addr) <= nodeID(t.self) {
+		return
+	}
+	t.currentEpoch++
+	self.configEpoch = t.currentEpoch
+	t.changed = true
+	logger.Info(fmt.Sprintf("config epoch collision with %s, set config epoch to %d", sender.addr, self.configEpoch))
+}
+
+// handleGossip handles the state of another node in the view of sender, caller should hold the lock
+// 未知的节点会被加入集群并发送 MEET；来自主节点的 PFAIL 报告用于判定 FAIL
+func (cluster *ClusterDatabase) handleGossip(sender *nodeInfo, g *gossipNode) {
+	t := cluster.topology
+	if g.Addr == t.self || g.Addr == "" {
+		return
+	}
+	node, ok := t.nodes[g.Addr]
+	if !ok {
+		if t.isForgotten(g.Addr) || g.PFail {
+			return
+		}
+		node = t.addNode(g.Addr)
+		node.busPort = g.BusPort
+		node.flags |= nodeFlagMeet
+		logger.Info("discover node " + g.Addr + " from " + sender.addr)
+		return
+	}
+	if g.PFail && t.countSlots(sender.addr) > 0 {
+		node.failReports[sender.addr] = time.Now()
+	} else {
+		delete(node.failReports, sender.addr)
+	}
+}
+
+// detectFailures marks nodes as PFAIL or FAIL, returns the nodes newly marked as FAIL
+func (cluster *ClusterDatabase) detectFailures() []string {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timeout := getNodeTimeout()
+	now := time.Now()
+
+	// 负责 slot 的节点为主节点，需要多数主节点同意才能标记为 FAIL
+	masters := make(map[string]struct{})
+	for _, owner := range t.slots {
+		if owner != "" {
+			masters[owner] = struct{}{}
+		}
+	}
+	needed := len(masters)/2 + 1
+
+	failed := make([]string, 0)
+	for node, info := range t.nodes {
+		if node == t.self || info.flags&nodeFlagMeet > 0 {
+			continue
+		}
+		if info.flags&(nodeFlagPFail|nodeFlagFail) == 0 && now.Sub(info.pongReceived) > timeout {
+			info.flags |= nodeFlagPFail
+			t.changed = true
+			logger.Warn("node " + node + " is marked as PFAIL")
+		}
+		for reporter, reportTime := range info.failReports {
+			if now.Sub(reportTime) > timeout*2 {
+				delete(info.failReports, reporter)
+			}
+		}
+		if info.flags&nodeFlagPFail == 0 || info.flags&nodeFlagFail > 0 || len(masters) == 0 {
+			continue
+		}
+		reports := len(info.failReports)
+		if _, ok := masters[t.self]; ok {
+			reports++
+		}
+		if reports >= needed {
+			info.flags |= nodeFlagFail
+			t.changed = true
+			failed = append(failed, node)
+			logger.Warn(fmt.Sprintf("node %s is marked as FAIL, %d masters reported", node, reports))
+		}
+	}
+	return failed
+}
+
+// broadcastFail tells all nodes that the node is failed
+func (cluster *ClusterDatabase) broadcastFail(failed string) {
+	t := cluster.topology
+	t.mu.Lock()
+	msg := cluster.makeMessage(msgFail)
+	msg.FailNode = failed
+	addrs := make([]string, 0, len(t.nodes))
+	for node, info := range t.nodes {
+		if node != t.self && node != failed {
+			addrs = append(addrs, busAddr(node, info.busPort))
+		}
+	}
+	t.mu.Unlock()
+	for _, addr := range addrs {
+		go func(addr string) {
+			_, _ = cluster.sendMessage(addr, msg, false)
+		}(addr)
+	}
+}
+
+// meet adds the node into cluster, MEET will be sent by cron until it replies
+func (cluster *ClusterDatabase) meet(node string, busPort int) {
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.forgotten, node)
+	info := t.addNode(node)
+	info.busPort = busPort
+	if info.pongReceived.Before(time.Now().Add(-getNodeTimeout())) || info.flags&nodeFlagFail > 0 {
+		info.pongReceived = time.Now()
+	}
+	info.flags |= nodeFlagMeet
+	info.pingSent = time.Time{}
+}
+
+// getBusPort returns the cluster bus port of the node
+func (t *topology) getBusPort(node string) int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if info, ok := t.nodes[node]; ok {
+		return info.busPort
+	}
+	_, port := splitAddr(node)
+	return port + busPortOffset
+}
+
+// busAddr returns the address of cluster bus of the node
+func busAddr(node string, busPort int) string {
+	host, _ := splitAddr(node)
+	return net.JoinHostPort(host, strconv.Itoa(busPort))
+}
@@ -0,0 +1,92 @@
+package cluster
+
+import (
+	"testing"
+)
+
+func allSlots() []byte {
+	bitmap := make([]byte, slotCount/8)
+	for i := range bitmap {
+		bitmap[i] = 0xff
+	}
+	return bitmap
+}
+
+func TestHandleMessageUnknownSender(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399", "127.0.0.1:6400")
+	stranger := "127.0.0.1:6500"
+
+	ping := &gossipMessage{Type: msgPing, Sender: stranger, ConfigEpoch: 100, Slots: allSlots()}
+	if cluster.handleMessage(ping) != nil {
+		t.Error("ping from unknown node should be ignored")
+	}
+	if _, ok := cluster.topology.nodes[stranger]; ok {
+		t.Fatal("unknown node should not be added by ping")
+	}
+
+	meet := &gossipMessage{
+		Type:        msgMeet,
+		Sender:      stranger,
+		ConfigEpoch: 100,
+		Slots:       allSlots(),
+		FailNode:    "127.0.0.1:6400",
+	}
+	if cluster.handleMessage(meet) == nil {
+		t.Error("meet should be replied")
+	}
+	if _, ok := cluster.topology.nodes[stranger]; !ok {
+		t.Fatal("node should be added by meet")
+	}
+	for slot, owner := range cluster.topology.slots {
+		if owner == stranger {
+			t.Fatalf("slot %d is claimed by the node just met", slot)
+		}
+	}
+	meet.Type = msgFail
+	cluster.handleMessage(meet)
+	if cluster.topology.nodes["127.0.0.1:6400"].flags&nodeFlagFail == 0 {
+		t.Error("FAIL from known node should be accepted")
+	}
+}
+
+func TestHandleMessageSlotClaim(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	var slot uint32
+	for i, owner := range cluster.topology.slots {
+		if owner == self {
+			slot = uint32(i)
+			break
+		}
+	}
+	bitmap := make([]byte, slotCount/8)
+	bitmap[slot/8] |= 1 << (slot % 8)
+
+	// config epoch 不大于 slot 当前负责节点时不修改
+	cluster.handleMessage(&gossipMessage{Type: msgPing, Sender: peer, Slots: bitmap})
+	if owner := cluster.topology.slots[slot]; owner != self {
+		t.Fatalf("slot %d should not be taken by node with same epoch, owner: %s", slot, owner)
+	}
+	cluster.handleMessage(&gossipMessage{Type: msgPing, Sender: peer, ConfigEpoch: 1, CurrentEpoch: 1, Slots: bitmap})
+	if owner := cluster.topology.slots[slot]; owner != peer {
+		t.Fatalf("slot %d should be taken by node with greater epoch, owner: %s", slot, owner)
+	}
+}
+
+func TestHandleMessageFail(t *testing.T) {
+	self, peer, failed := "127.0.0.1:6399", "127.0.0.1:6400", "127.0.0.1:6401"
+	cluster := makeTestCluster(self, peer, failed)
+	cluster.handleMessage(&gossipMessage{Type: msgFail, Sender: peer, FailNode: failed})
+	if cluster.topology.nodes[failed].flags&nodeFlagFail == 0 {
+		t.Fatal("node should be marked as FAIL")
+	}
+	cluster.handleMessage(&gossipMessage{Type: msgFail, Sender: peer, FailNode: self})
+	if cluster.topology.nodes[self].flags&nodeFlagFail > 0 {
+		t.Error("self should not be marked as FAIL")
+	}
+	// 收到已下线节点的消息后恢复
+	cluster.handleMessage(&gossipMessage{Type: msgPing, Sender: failed})
+	if cluster.topology.nodes[failed].flags&nodeFlagFail > 0 {
+		t.Error("node should be reachable again")
+	}
+}
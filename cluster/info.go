@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"fmt"
+	"sort"
+)
+
+// InfoFields returns the fields of current node in INFO
+// section: replication 主从复制状态；cluster 集群状态；peers 与每个节点之间的连接池、熔断器状态及请求统计；
+// nearcache 热点 key 缓存统计；其他 section 由本节点的数据库返回
+func (cluster *ClusterDatabase) InfoFields(section string) []string {
+	switch section {
+	case "replication":
+		return cluster.replication.info()
+	case "cluster":
+		return cluster.clusterInfo()
+	case "peers":
+		return cluster.peersInfo()
+	case "nearcache":
+		return cluster.nearCacheInfo()
+	}
+	return cluster.db.InfoFields(section)
+}
+
+// ResetStats resets the stats of database, peers and near cache
+// 熔断器的状态不受影响，只清零请求统计
+func (cluster *ClusterDatabase) ResetStats() {
+	cluster.db.ResetStats()
+	cluster.peerMu.Lock()
+	for _, b := range cluster.peerBreakers {
+		b.requests.Store(0)
+		b.errors.Store(0)
+		b.rejected.Store(0)
+	}
+	cluster.peerMu.Unlock()
+	if nc := cluster.nearCache; nc != nil {
+		nc.hits.Store(0)
+		nc.misses.Store(0)
+		nc.invalidations.Store(0)
+	}
+}
+
+func (cluster *ClusterDatabase) clusterInfo() []string {
+	mode := modeProxy
+	if cluster.redirect {
+		mode = modeRedirect
+	}
+	return []string{
+		"cluster_enabled:1",
+		"cluster_mode:" + mode,
+	}
+}
+
+// peersInfo returns the stats of every peer
+// peer0:addr=127.0.0.1:6380,breaker=closed,failures=0,trips=0,requests=10,errors=0,rejected=0,pool_active=0,pool_idle=1
+func (cluster *ClusterDatabase) peersInfo() []string {
+	cluster.peerMu.Lock()
+	defer cluster.peerMu.Unlock()
+	peers := make([]string, 0, len(cluster.peerBreakers))
+	for peer := range cluster.peerBreakers {
+		peers = append(peers, peer)
+	}
+	sort.Strings(peers)
+
+	fields := make([]string, 0, len(peers))
+	for i, peer := range peers {
+		b := cluster.peerBreakers[peer]
+		b.mu.Lock()
+		state, failures, trips := b.state, b.failures, b.trips
+		b.mu.Unlock()
+		active, idle := 0, 0
+		if p, ok := cluster.peerConnection[peer]; ok {
+			active, idle = p.GetNumActive(), p.GetNumIdle()
+		}
+		fields = append(fields, fmt.Sprintf("peer%d:addr=%s,breaker=%s,failures=%d,trips=%d,requests=%d,errors=%d,rejected=%d,pool_active=%d,pool_idle=%d",
+			i, peer, breakerStateNames[state], failures, trips, b.requests.Load(), b.errors.Load(), b.rejected.Load(), active, idle))
+	}
+	return fields
+}
+
+// nearCacheInfo returns the stats of near cache
+func (cluster *ClusterDatabase) nearCacheInfo() []string {
+	nc := cluster.nearCache
+	if nc == nil {
+		return []string{"near_cache_enabled:0"}
+	}
+	return []string{
+		"near_cache_enabled:1",
+		fmt.Sprintf("near_cache_keys:%d", nc.size()),
+		fmt.Sprintf("near_cache_hits:%d", nc.hits.Load()),
+		fmt.Sprintf("near_cache_misses:%d", nc.misses.Load()),
+		fmt.Sprintf("near_cache_invalidations:%d", nc.invalidations.Load()),
+	}
+}
@@ -0,0 +1,38 @@
+package cluster
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestInfoFields(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399", "127.0.0.1:6400", "127.0.0.1:6401")
+	cluster.getBreaker("127.0.0.1:6401").record(reply.MakeOkReply())
+	b := cluster.getBreaker("127.0.0.1:6400")
+	b.acquire()
+	b.onFailure()
+
+	want := []string{
+		"peer0:addr=127.0.0.1:6400,breaker=closed,failures=1,trips=0,requests=1,errors=1,rejected=0,pool_active=0,pool_idle=0",
+		"peer1:addr=127.0.0.1:6401,breaker=closed,failures=0,trips=0,requests=0,errors=0,rejected=0,pool_active=0,pool_idle=0",
+	}
+	if got := cluster.InfoFields("peers"); !reflect.DeepEqual(got, want) {
+		t.Errorf("peers: got %v, want %v", got, want)
+	}
+	if got := cluster.InfoFields("cluster"); !reflect.DeepEqual(got, []string{"cluster_enabled:1", "cluster_mode:proxy"}) {
+		t.Errorf("cluster: got %v", got)
+	}
+	if got := cluster.InfoFields("replication"); len(got) == 0 || got[0] != "role:master" {
+		t.Errorf("replication: got %v", got)
+	}
+
+	// 其他 section 由本节点的数据库返回
+	cluster.db.Exec(connection.NewConn(nil), utils.ToCmdLine("SET", "k", "v"))
+	if got := strings.Join(cluster.InfoFields("keyspace"), ","); got != "db0:keys=1,expires=0,avg_ttl=0" {
+		t.Errorf("keyspace: got %q", got)
+	}
+}
@@ -0,0 +1,179 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+/*
+key 规格(key spec)：描述命令行中哪些参数是 key，用于找到负责的节点、按节点拆分多 key 命令
+	first 第一个 key 的位置(命令名为 0)
+	last  最后一个 key 的位置，负数表示从末尾开始计数，-1 为最后一个参数
+	step  相邻两个 key 的间隔，如 MSET k1 v1 k2 v2 的 step 为 2，key 后面的 step-1 个参数随 key 一起拆分
+多 key 命令的 key 分布在多个节点时：
+	EXISTS、TOUCH 等可以合并结果的命令，按节点拆分后分别执行，再合并结果
+	DEL、UNLINK、MSET、MSETNX 通过 TCC 保证原子性
+	其他命令无法合并结果，回复 CROSSSLOT
+*/
+
+// keySpec describes the position of keys in command line
+type keySpec struct {
+	first int // 第一个 key 的位置
+	last  int // 最后一个 key 的位置，负数表示从末尾开始计数
+	step  int // 相邻两个 key 的间隔
+
+	readOnly bool // 只读命令，可以由从节点执行
+}
+
+// keySpecs of commands which have keys, commands not in the table have no key
+// 同时标记命令是读命令还是写命令
+var keySpecs = map[string]*keySpec{
+	"get":            {first: 1, last: 1, step: 1, readOnly: true},
+	"set":            {first: 1, last: 1, step: 1},
+	"setnx":          {first: 1, last: 1, step: 1},
+	"getset":         {first: 1, last: 1, step: 1},
+	"strlen":         {first: 1, last: 1, step: 1, readOnly: true},
+	"type":           {first: 1, last: 1, step: 1, readOnly: true},
+	"dump":           {first: 1, last: 1, step: 1, readOnly: true},
+	"restore":        {first: 1, last: 1, step: 1},
+	"restore-asking": {first: 1, last: 1, step: 1},
+	"exists":         {first: 1, last: -1, step: 1, readOnly: true},
+	"touch":          {first: 1, last: -1, step: 1, readOnly: true},
+	"del":            {first: 1, last: -1, step: 1},
+	"unlink":         {first: 1, last: -1, step: 1},
+	"mget":           {first: 1, last: -1, step: 1, readOnly: true},
+	"mset":           {first: 1, last: -1, step: 2},
+	"msetnx":         {first: 1, last: -1, step: 2},
+	"rename":         {first: 1, last: 2, step: 1},
+	"renamenx":       {first: 1, last: 2, step: 1},
+}
+
+// keyPositions returns the positions of keys in command line
+func (spec *keySpec) keyPositions(cmdLine [][]byte) []int {
+	last := spec.last
+	if last < 0 {
+		last = len(cmdLine) + last
+	}
+	if last >= len(cmdLine) {
+		last = len(cmdLine) - 1
+	}
+	positions := make([]int, 0)
+	for i := spec.first; i <= last; i += spec.step {
+		positions = append(positions, i)
+	}
+	return positions
+}
+
+// isReadOnlyCommand returns whether the command doesn't modify data
+// 执行过 READONLY 的连接可以在从节点上执行这些命令，proxy 模式下按照读策略转发给从节点
+func isReadOnlyCommand(cmdName string) bool {
+	spec, ok := keySpecs[cmdName]
+	return ok && spec.readOnly
+}
+
+// getRelatedKeys returns the keys of the given command line
+func getRelatedKeys(cmdName string, cmdLine [][]byte) []string {
+	spec, ok := keySpecs[cmdName]
+	if !ok {
+		return nil
+	}
+	positions := spec.keyPositions(cmdLine)
+	keys := make([]string, len(positions))
+	for i, pos := range positions {
+		keys[i] = string(cmdLine[pos])
+	}
+	return keys
+}
+
+// splitByNode splits multi-key command into command lines of each node
+// 参数依次为：命令名、第一个 key 之前的参数、属于该节点的 key(及其后 step-1 个参数)、最后一个 key 之后的参数
+func (cluster *ClusterDatabase) splitByNode(cmdLine [][]byte) map[string]CmdLine {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	spec := keySpecs[cmdName]
+	positions := spec.keyPositions(cmdLine)
+	if len(positions) == 0 {
+		return nil
+	}
+	end := positions[len(positions)-1] + spec.step // 最后一个 key 及其参数之后的位置
+	if end > len(cmdLine) {
+		end = len(cmdLine)
+	}
+
+	groups := make(map[string][][]byte)
+	for _, pos := range positions {
+		node := cluster.topology.pickNode(getSlot(string(cmdLine[pos])))
+		groupEnd := pos + spec.step
+		if groupEnd > end {
+			groupEnd = end
+		}
+		groups[node] = append(groups[node], cmdLine[pos:groupEnd]...)
+	}
+	result := make(map[string]CmdLine, len(groups))
+	for node, group := range groups {
+		nodeCmdLine := make(CmdLine, 0, len(cmdLine))
+		nodeCmdLine = append(nodeCmdLine, cmdLine[:spec.first]...)
+		nodeCmdLine = append(nodeCmdLine, group...)
+		nodeCmdLine = append(nodeCmdLine, cmdLine[end:]...)
+		result[node] = nodeCmdLine
+	}
+	return result
+}
+
+// groupBy groups keys by the node which serves them
+// 按照 key 所在的节点分组，返回 node -> keys
+func (cluster *ClusterDatabase) groupBy(keys []string) map[string][]string {
+	result := make(map[string][]string)
+	for _, key := range keys {
+		node := cluster.topology.pickNode(getSlot(key))
+		result[node] = append(result[node], key)
+	}
+	return result
+}
+
+// relayByKeySpec relays command to the node which serves all of its keys
+// 所有 key 在同一个 slot 时按照 slot 的迁移状态转发；在同一个节点的不同 slot 时转发给该节点；分布在多个节点时回复 CROSSSLOT
+func (cluster *ClusterDatabase) relayByKeySpec(c resp.Connection, args [][]byte) resp.Reply {
+	keys := getRelatedKeys(strings.ToLower(string(args[0])), args)
+	if len(keys) == 0 {
+		return cluster.db.Exec(c, args)
+	}
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	groupMap := cluster.groupBy(keys)
+	if len(groupMap) > 1 {
+		return reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+	}
+	for node := range groupMap {
+		return cluster.relay(node, c, args)
+	}
+	return nil
+}
+
+// sumByNode splits command by node and returns the sum of integer replies
+// EXISTS k1 k2 k3、TOUCH k1 k2 k3
+func sumByNode(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	keys := getRelatedKeys(strings.ToLower(string(args[0])), args)
+	if len(keys) == 0 {
+		return reply.MakeArgNumErrReply(strings.ToLower(string(args[0])))
+	}
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	result := cluster.multicast(cluster.splitByNode(args), func(node string, cmdLine CmdLine) resp.Reply {
+		return cluster.relay(node, c, cmdLine)
+	})
+	if errReply := result.errReply(strings.ToUpper(string(args[0]))); errReply != nil {
+		return errReply
+	}
+	var sum int64
+	for _, r := range result.results {
+		intReply, ok := r.reply.(*reply.IntReply)
+		if !ok {
+			return reply.MakeErrReply("ERR unexpected reply from " + r.node)
+		}
+		sum += intReply.Code
+	}
+	return reply.MakeIntReply(sum)
+}
@@ -0,0 +1,82 @@
+package cluster
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strings"
+	"testing"
+)
+
+func TestSplitByNode(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	local, remote := keyOn(cluster, self), keyOn(cluster, peer)
+	tests := []struct {
+		cmdLine  []string
+		expected map[string]string
+	}{
+		{
+			cmdLine:  []string{"EXISTS", local, remote, local},
+			expected: map[string]string{self: "EXISTS " + local + " " + local, peer: "EXISTS " + remote},
+		},
+		{
+			cmdLine:  []string{"MSET", local, "1", remote, "2"},
+			expected: map[string]string{self: "MSET " + local + " 1", peer: "MSET " + remote + " 2"},
+		},
+		{
+			// 缺少最后一个 value 时不越界
+			cmdLine:  []string{"MSET", local, "1", remote},
+			expected: map[string]string{self: "MSET " + local + " 1", peer: "MSET " + remote},
+		},
+		{
+			cmdLine:  []string{"DEL", local},
+			expected: map[string]string{self: "DEL " + local},
+		},
+	}
+	for _, tt := range tests {
+		groups := cluster.splitByNode(utils.ToCmdLine(tt.cmdLine...))
+		if len(groups) != len(tt.expected) {
+			t.Errorf("%v: expected %d nodes, got %d", tt.cmdLine, len(tt.expected), len(groups))
+			continue
+		}
+		for node, cmdLine := range groups {
+			args := make([]string, len(cmdLine))
+			for i, arg := range cmdLine {
+				args[i] = string(arg)
+			}
+			if got := strings.Join(args, " "); got != tt.expected[node] {
+				t.Errorf("%v: %s got %q, want %q", tt.cmdLine, node, got, tt.expected[node])
+			}
+		}
+	}
+}
+
+func TestMultiKeyCommands(t *testing.T) {
+	peer, remote := startRemoteNode(t)
+	self := "127.0.0.1:6399"
+	cluster := makeTestCluster(self, peer)
+	c := connection.NewConn(nil)
+	local1, remote1 := keyOn(cluster, self), keyOn(cluster, peer)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", local1, "a"))
+	remote.Exec(c, utils.ToCmdLine("SET", remote1, "b"))
+
+	ret := cluster.Exec(c, utils.ToCmdLine("EXISTS", local1, remote1, "{"+local1+"}missing", remote1))
+	if intReply, ok := ret.(*reply.IntReply); !ok || intReply.Code != 3 {
+		t.Errorf("EXISTS should sum replies of nodes, got %s", ret.ToBytes())
+	}
+	ret = cluster.Exec(c, utils.ToCmdLine("TOUCH", local1, remote1))
+	if intReply, ok := ret.(*reply.IntReply); !ok || intReply.Code != 2 {
+		t.Errorf("TOUCH should sum replies of nodes, got %s", ret.ToBytes())
+	}
+	ret = cluster.Exec(c, utils.ToCmdLine("MGET", remote1, "{"+local1+"}missing", local1))
+	values, ok := ret.(*reply.MultiBulkReply)
+	if !ok || len(values.Args) != 3 || string(values.Args[0]) != "b" || values.Args[1] != nil || string(values.Args[2]) != "a" {
+		t.Errorf("MGET should keep the order of keys, got %s", ret.ToBytes())
+	}
+	// 无法合并结果的命令，key 分布在多个节点时回复 CROSSSLOT
+	ret = cluster.relayByKeySpec(c, utils.ToCmdLine("MGET", local1, remote1))
+	if errReply, ok := ret.(reply.ErrorReply); !ok || !strings.HasPrefix(errReply.Error(), "CROSSSLOT") {
+		t.Errorf("expected CROSSSLOT, got %s", ret.ToBytes())
+	}
+}
@@ -2,22 +2,170 @@ package cluster
 
 import (
 	"go-redis/interface/resp"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
+	"math/rand"
+	"strconv"
 )
 
+/*
+整个集群范围的键空间命令：命令群发给所有主节点，每个节点只在本地执行，再合并各节点的结果
+	KEYS、DBSIZE  合并所有节点的结果
+	RANDOMKEY     按各节点 key 的数量加权随机选择一个节点，由该节点返回随机 key
+	SCAN          游标同时记录节点序号和节点内的游标，依次遍历每一个节点
+	FLUSHDB、FLUSHALL 群发，部分节点失败时说明每个节点的执行结果
+*/
+
 // flushDB removes all data in current database
 func flushDB(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	replies := cluster.broadcast(c, args)
-	// 广播执行模式，只要有一个节点的响应出错，则返回错误
-	var errReply reply.ErrorReply
-	for _, v := range replies {
-		if reply.IsErrorReply(v) {
-			errReply = v.(reply.ErrorReply)
-			break
+	result := cluster.broadcast(c, args)
+	// 广播执行模式，只要有一个节点的响应出错，则返回错误，并说明每个节点的执行结果
+	if errReply := result.errReply("FLUSHDB"); errReply != nil {
+		return errReply
+	}
+	return &reply.OkReply{}
+}
+
+// flushAll removes all data in all databases of all nodes
+// FLUSHALL
+func flushAll(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	result := cluster.broadcast(c, args)
+	if errReply := result.errReply("FLUSHALL"); errReply != nil {
+		return errReply
+	}
+	return &reply.OkReply{}
+}
+
+// keys returns all keys matching the pattern in cluster
+// KEYS pattern
+func keys(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("keys")
+	}
+	result := cluster.broadcast(c, args)
+	if errReply := result.errReply("KEYS"); errReply != nil {
+		return errReply
+	}
+	var keys [][]byte
+	for _, r := range result.results {
+		if multiBulk, ok := r.reply.(*reply.MultiBulkReply); ok {
+			keys = append(keys, multiBulk.Args...)
+		}
+	}
+	return reply.MakeMultiBulkReply(keys)
+}
+
+// dbSize returns the number of keys in current database of all nodes
+// DBSIZE
+func dbSize(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	sizes, errReply := cluster.getDBSizes(c)
+	if errReply != nil {
+		return errReply
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	return reply.MakeIntReply(total)
+}
+
+// getDBSizes returns the number of keys in current database of each master
+func (cluster *ClusterDatabase) getDBSizes(c resp.Connection) (map[string]int64, reply.ErrorReply) {
+	result := cluster.broadcast(c, utils.ToCmdLine("DBSIZE"))
+	if errReply := result.errReply("DBSIZE"); errReply != nil {
+		return nil, errReply
+	}
+	sizes := make(map[string]int64, len(result.results))
+	for _, r := range result.results {
+		intReply, ok := r.reply.(*reply.IntReply)
+		if !ok {
+			return nil, reply.MakeErrReply("ERR unexpected reply of DBSIZE from " + r.node)
+		}
+		sizes[r.node] = intReply.Code
+	}
+	return sizes, nil
+}
+
+// randomKey returns a random key in cluster
+// RANDOMKEY
+// 按照各节点 key 的数量加权随机选择节点，保证集群中每个 key 被选中的概率大致相同
+func randomKey(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	sizes, errReply := cluster.getDBSizes(c)
+	if errReply != nil {
+		return errReply
+	}
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+	if total == 0 {
+		return reply.MakeNullBulkReply()
+	}
+	n := rand.Int63n(total)
+	for _, node := range cluster.topology.getMasters() {
+		size, ok := sizes[node]
+		if !ok {
+			continue
+		}
+		if n < size {
+			return cluster.relayLocal(node, c, args)
 		}
+		n -= size
 	}
-	if errReply == nil {
-		return &reply.OkReply{}
+	return reply.MakeNullBulkReply()
+}
+
+// scanNodeBits is the number of bits of node index in cluster SCAN cursor
+// 集群 SCAN 的游标 = 节点内的游标 << scanNodeBits | 节点序号，最多支持 1024 个主节点
+const scanNodeBits = 10
+
+// scan iterates keys of all nodes in order
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 每次调用只遍历一个节点，节点遍历结束后游标指向下一个节点的开始；最后一个节点遍历结束时游标为 0
+// 遍历期间主节点发生变化(加入、移除)时，节点序号可能对应到其他节点
+func scan(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("scan")
+	}
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+	masters := cluster.topology.getMasters()
+	nodeIndex := int(cursor & (1<<scanNodeBits - 1))
+	nodeCursor := cursor >> scanNodeBits
+	if nodeIndex >= len(masters) {
+		return reply.MakeErrReply("ERR invalid cursor")
+	}
+
+	nodeArgs := make([][]byte, len(args))
+	copy(nodeArgs, args)
+	nodeArgs[1] = []byte(strconv.FormatUint(nodeCursor, 10))
+	result := cluster.relayLocal(masters[nodeIndex], c, nodeArgs)
+	if reply.IsErrorReply(result) {
+		return result
+	}
+	raw, ok := result.(*reply.MultiRawReply)
+	if !ok || len(raw.Replies) != 2 {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + masters[nodeIndex])
+	}
+	cursorReply, ok := raw.Replies[0].(*reply.BulkReply)
+	if !ok {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + masters[nodeIndex])
+	}
+	next, err := strconv.ParseUint(string(cursorReply.Arg), 10, 64)
+	if err != nil {
+		return reply.MakeErrReply("ERR unexpected reply of SCAN from " + masters[nodeIndex])
+	}
+
+	var nextCursor uint64
+	if next != 0 {
+		nextCursor = next<<scanNodeBits | uint64(nodeIndex)
+	} else if nodeIndex+1 < len(masters) {
+		nextCursor = uint64(nodeIndex + 1)
 	}
-	return reply.MakeErrReply("error occurs: " + errReply.Error())
+	return reply.MakeMultiRawReply([]resp.Reply{
+		reply.MakeBulkReply([]byte(strconv.FormatUint(nextCursor, 10))),
+		raw.Replies[1],
+	})
 }
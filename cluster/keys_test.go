@@ -0,0 +1,72 @@
+package cluster
+
+import (
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// startRemoteNode starts a fake node which executes commands and EXEC-LOCAL on its own database
+func startRemoteNode(t *testing.T) (string, *database.StandaloneDatabase) {
+	db := database.NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	addr := startFakePeer(t, func(args [][]byte) resp.Reply {
+		if strings.EqualFold(string(args[0]), "exec-local") {
+			args = args[1:]
+		}
+		return db.Exec(c, args)
+	})
+	return addr, db
+}
+
+func TestClusterScan(t *testing.T) {
+	peer, remote := startRemoteNode(t)
+	cluster := makeTestCluster("127.0.0.1:6399", peer)
+	c := connection.NewConn(nil)
+	for i := 0; i < 100; i++ {
+		cluster.db.Exec(c, utils.ToCmdLine("SET", "local"+strconv.Itoa(i), "v"))
+		remote.Exec(c, utils.ToCmdLine("SET", "remote"+strconv.Itoa(i), "v"))
+	}
+
+	seen := make(map[string]bool)
+	cursor := "0"
+	for calls := 0; ; calls++ {
+		ret := scan(cluster, c, utils.ToCmdLine("SCAN", cursor, "COUNT", "20"))
+		raw, ok := ret.(*reply.MultiRawReply)
+		if !ok {
+			t.Fatalf("unexpected reply %s", ret.ToBytes())
+		}
+		cursor = string(raw.Replies[0].(*reply.BulkReply).Arg)
+		// 其他节点的回复中，key 的列表被解析为 MultiRawReply
+		switch keys := raw.Replies[1].(type) {
+		case *reply.MultiBulkReply:
+			for _, key := range keys.Args {
+				seen[string(key)] = true
+			}
+		case *reply.MultiRawReply:
+			for _, key := range keys.Replies {
+				seen[string(key.(*reply.BulkReply).Arg)] = true
+			}
+		}
+		if cursor == "0" {
+			break
+		}
+		if calls > 1000 {
+			t.Fatal("SCAN does not finish")
+		}
+	}
+	if len(seen) != 200 {
+		t.Fatalf("expect 200 keys of both nodes, got %d", len(seen))
+	}
+
+	masters := cluster.topology.getMasters()
+	invalid := strconv.Itoa(len(masters))
+	if ret := scan(cluster, c, utils.ToCmdLine("SCAN", invalid)); !reply.IsErrorReply(ret) {
+		t.Error("cursor of unknown node should be rejected")
+	}
+}
@@ -0,0 +1,312 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+slot 迁移流程（与 Redis Cluster 相同）
+1. 目标节点: CLUSTER SETSLOT slot IMPORTING 源节点
+2. 源节点:   CLUSTER SETSLOT slot MIGRATING 目标节点
+3. 源节点:   循环执行 CLUSTER GETKEYSINSLOT + MIGRATE，将 key 分批迁移到目标节点
+4. 所有节点: CLUSTER SETSLOT slot NODE 目标节点
+
+迁移过程中集群照常提供服务：
+源节点上已经不存在的 key 会回复 ASK(redirect 模式) 或带 ASKING 转发(proxy 模式) 到目标节点执行
+*/
+
+// defaultMigrateTimeout is used when the timeout of MIGRATE is not positive, the same as redis
+const defaultMigrateTimeout = time.Second
+
+// migrateConn is a connection to the target instance of MIGRATE
+// 连接、每次写入和读取回复都受 MIGRATE 的 timeout 参数限制
+type migrateConn struct {
+	conn    net.Conn
+	replies <-chan *parser.Payload
+	timeout time.Duration
+}
+
+func dialMigrateTarget(addr string, timeout time.Duration) (*migrateConn, error) {
+	conn, err := dialPeer(addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &migrateConn{
+		conn:    conn,
+		replies: parser.ParseStream(conn),
+		timeout: timeout,
+	}, nil
+}
+
+// call sends commands in one write and returns their replies
+func (m *migrateConn) call(cmdLines [][][]byte) ([]resp.Reply, error) {
+	var buf []byte
+	for _, cmdLine := range cmdLines {
+		buf = append(buf, reply.MakeMultiBulkReply(cmdLine).ToBytes()...)
+	}
+	_ = m.conn.SetWriteDeadline(time.Now().Add(m.timeout))
+	if _, err := m.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	// 读取协程已经阻塞在 Read 上，修改截止时间同样生效
+	_ = m.conn.SetReadDeadline(time.Now().Add(m.timeout))
+	replies := make([]resp.Reply, 0, len(cmdLines))
+	for range cmdLines {
+		payload, ok := <-m.replies
+		if !ok {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if payload.Err != nil {
+			return nil, payload.Err
+		}
+		replies = append(replies, payload.Data)
+	}
+	return replies, nil
+}
+
+// close closes connection and waits for the parser to exit
+func (m *migrateConn) close() {
+	_ = m.conn.Close()
+	for range m.replies {
+	}
+}
+
+// execMigrate transfers keys of current node to the target node
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [KEYS key [key ...]]
+// 在本节点上 DUMP，在目标节点上 RESTORE-ASKING，成功后删除本节点上的 key(除非指定 COPY)
+func execMigrate(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 6 {
+		return reply.MakeArgNumErrReply("migrate")
+	}
+	target := net.JoinHostPort(string(args[1]), string(args[2]))
+	destDB, err := strconv.Atoi(string(args[4]))
+	if err != nil || destDB < 0 {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeoutMs, err := strconv.Atoi(string(args[5]))
+	if err != nil {
+		return reply.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	timeout := time.Duration(timeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultMigrateTimeout
+	}
+	copyKey := false
+	replace := false
+	keys := make([]string, 0)
+	if len(args[3]) > 0 {
+		keys = append(keys, string(args[3]))
+	}
+	for i := 6; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "copy":
+			copyKey = true
+		case "replace":
+			replace = true
+		case "keys":
+			if len(args[3]) > 0 {
+				return reply.MakeErrReply("ERR When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			for _, key := range args[i+1:] {
+				keys = append(keys, string(key))
+			}
+			i = len(args)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if target == cluster.self {
+		return reply.MakeErrReply("ERR Target instance is the current node")
+	}
+
+	// 从序列化到删除期间持有 key 的写锁，避免迁移期间的写入在删除时丢失
+	dbIndex := c.GetDBIndex()
+	cluster.db.RWLocks(dbIndex, keys, nil)
+	defer cluster.db.RWUnLocks(dbIndex, keys, nil)
+
+	// 序列化所有存在的 key
+	restoreCmds := make([][][]byte, 0, len(keys))
+	migrated := make([]string, 0, len(keys))
+	for _, key := range keys {
+		dumped, ok := cluster.db.ExecWithLock(c, utils.ToCmdLine("DUMP", key)).(*reply.BulkReply)
+		if !ok { // key 不存在
+			continue
+		}
+		cmdLine := utils.ToCmdLine("RESTORE-ASKING", key, "0")
+		cmdLine = append(cmdLine, dumped.Arg)
+		if replace {
+			cmdLine = append(cmdLine, []byte("REPLACE"))
+		}
+		restoreCmds = append(restoreCmds, cmdLine)
+		migrated = append(migrated, key)
+	}
+	if len(restoreCmds) == 0 {
+		return reply.MakeStatusReply("NOKEY")
+	}
+
+	// 使用单独的连接，连接池中的连接不受 timeout 参数限制
+	conn, err := dialMigrateTarget(target, timeout)
+	if err != nil {
+		return reply.MakeErrReply("IOERR error or timeout connecting to the client")
+	}
+	defer conn.close()
+	cmdLines := make([][][]byte, 0, len(restoreCmds)+2)
+	if auth := getClusterAuth(); auth != "" {
+		cmdLines = append(cmdLines, utils.ToCmdLine("AUTH", auth))
+	}
+	cmdLines = append(cmdLines, utils.ToCmdLine("SELECT", strconv.Itoa(destDB)))
+	cmdLines = append(cmdLines, restoreCmds...)
+	replies, err := conn.call(cmdLines)
+	if err != nil {
+		return reply.MakeErrReply("IOERR error or timeout reading to target instance")
+	}
+	for _, ret := range replies {
+		if reply.IsErrorReply(ret) {
+			return reply.MakeErrReply("ERR Target instance replied with error: " + errorMessage(ret))
+		}
+	}
+	if !copyKey {
+		cluster.db.ExecWithLock(c, utils.ToCmdLine(append([]string{"DEL"}, migrated...)...))
+	}
+	return reply.MakeOkReply()
+}
+
+// execRestoreAsking restores key on current node regardless of slot owner, used by MIGRATE
+// RESTORE-ASKING key ttl payload [REPLACE]
+func execRestoreAsking(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	cmdLine := make([][]byte, len(args))
+	copy(cmdLine, args)
+	cmdLine[0] = []byte("RESTORE")
+	return cluster.db.Exec(c, cmdLine)
+}
+
+// execClusterSetSlot changes the state of slot
+// CLUSTER SETSLOT slot IMPORTING|MIGRATING|NODE node
+// CLUSTER SETSLOT slot STABLE
+func execClusterSetSlot(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 4 {
+		return reply.MakeArgNumErrReply("cluster|setslot")
+	}
+	slot, errReply := parseSlot(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	action := strings.ToLower(string(args[3]))
+	if action == "stable" {
+		cluster.topology.setStable(slot)
+		return reply.MakeOkReply()
+	}
+	if len(args) != 5 {
+		return reply.MakeSyntaxErrReply()
+	}
+	node, ok := cluster.topology.findNode(string(args[4]))
+	if !ok {
+		// 使用地址指定的新节点，会在 SETSLOT NODE 时加入集群
+		if _, _, err := net.SplitHostPort(string(args[4])); err != nil {
+			return reply.MakeErrReply("ERR I don't know about node " + string(args[4]))
+		}
+		node = string(args[4])
+	}
+	owner := cluster.topology.pickNode(slot)
+	slotStr := strconv.Itoa(int(slot))
+	switch action {
+	case "importing":
+		if owner == cluster.self {
+			return reply.MakeErrReply("ERR I'm already the owner of hash slot " + slotStr)
+		}
+		cluster.topology.setImporting(slot, node)
+	case "migrating":
+		if owner != cluster.self {
+			return reply.MakeErrReply("ERR I'm not the owner of hash slot " + slotStr)
+		}
+		if node == cluster.self {
+			return reply.MakeErrReply("ERR I'm the target node of hash slot " + slotStr)
+		}
+		cluster.topology.setMigrating(slot, node)
+	case "node":
+		if owner == cluster.self && node != cluster.self && cluster.countKeysInSlot(slot) > 0 {
+			return reply.MakeErrReply("ERR Can't assign hashslot " + slotStr +
+				" to a different node while I still hold keys for this hash slot.")
+		}
+		cluster.topology.setSlot(slot, node)
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	return reply.MakeOkReply()
+}
+
+// execClusterGetKeysInSlot returns keys of the slot in current db
+// CLUSTER GETKEYSINSLOT slot count
+func execClusterGetKeysInSlot(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 4 {
+		return reply.MakeArgNumErrReply("cluster|getkeysinslot")
+	}
+	slot, errReply := parseSlot(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	count, err := strconv.Atoi(string(args[3]))
+	if err != nil || count < 0 {
+		return reply.MakeErrReply("ERR Invalid number of keys")
+	}
+	keys := cluster.getKeysInSlot(c.GetDBIndex(), slot, count)
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execClusterCountKeysInSlot returns the number of keys of the slot in current db
+// CLUSTER COUNTKEYSINSLOT slot
+func execClusterCountKeysInSlot(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("cluster|countkeysinslot")
+	}
+	slot, errReply := parseSlot(args[2])
+	if errReply != nil {
+		return errReply
+	}
+	return reply.MakeIntReply(int64(cluster.db.CountKeysInSlot(c.GetDBIndex(), slot)))
+}
+
+// getKeysInSlot returns at most count keys of the slot in the given db, count < 0 means no limit
+// 从 db 按 slot 维护的索引中读取，不需要遍历所有 key
+func (cluster *ClusterDatabase) getKeysInSlot(dbIndex int, slot uint32, count int) []string {
+	return cluster.db.GetKeysInSlot(dbIndex, slot, count)
+}
+
+// countKeysInSlot returns the number of keys of the slot in all dbs
+func (cluster *ClusterDatabase) countKeysInSlot(slot uint32) int {
+	count := 0
+	for i := 0; i < config.Properties.Databases; i++ {
+		count += cluster.db.CountKeysInSlot(i, slot)
+	}
+	return count
+}
+
+// parseSlot parses slot from argument
+func parseSlot(arg []byte) (uint32, resp.Reply) {
+	slot, err := strconv.Atoi(string(arg))
+	if err != nil || slot < 0 || slot >= slotCount {
+		return 0, reply.MakeErrReply("ERR Invalid or out of range slot")
+	}
+	return uint32(slot), nil
+}
+
+// errorMessage returns the message of error reply without '-' and CRLF
+func errorMessage(r resp.Reply) string {
+	if errReply, ok := r.(reply.ErrorReply); ok {
+		return errReply.Error()
+	}
+	return strings.TrimSuffix(strings.TrimPrefix(string(r.ToBytes()), "-"), reply.CRLF)
+}
@@ -0,0 +1,105 @@
+package cluster
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// startMigrateTarget accepts one connection and replies OK to each command after release is closed
+func startMigrateTarget(t *testing.T, release <-chan struct{}) (string, <-chan [][]byte) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	received := make(chan [][]byte, 16)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		<-release
+		for payload := range parser.ParseStream(conn) {
+			if payload.Err != nil {
+				return
+			}
+			received <- payload.Data.(*reply.MultiBulkReply).Args
+			_, _ = conn.Write(reply.MakeOkReply().ToBytes())
+		}
+	}()
+	return listener.Addr().String(), received
+}
+
+func TestMigrateLocksKeys(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399")
+	c := connection.NewConn(nil)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", "a", "1"))
+
+	release := make(chan struct{})
+	addr, received := startMigrateTarget(t, release)
+	host, port, _ := net.SplitHostPort(addr)
+	migrated := make(chan struct{})
+	go func() {
+		ret := execMigrate(cluster, c, utils.ToCmdLine("MIGRATE", host, port, "a", "0", "5000"))
+		if reply.IsErrorReply(ret) {
+			t.Errorf("migrate failed: %s", ret.ToBytes())
+		}
+		close(migrated)
+	}()
+
+	// 迁移期间写入的 key 不会被迁移完成后的 DEL 删除
+	time.Sleep(50 * time.Millisecond)
+	written := make(chan struct{})
+	go func() {
+		cluster.db.Exec(connection.NewConn(nil), utils.ToCmdLine("SET", "a", "2"))
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Fatal("write should be blocked until migration is finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	<-migrated
+	<-written
+
+	restore := <-received
+	if string(restore[0]) == "SELECT" {
+		restore = <-received
+	}
+	if string(restore[0]) != "RESTORE-ASKING" || string(restore[1]) != "a" {
+		t.Fatalf("unexpected command %q", restore)
+	}
+	value, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply)
+	if !ok || string(value.Arg) != "2" {
+		t.Fatal("value written during migration should be kept")
+	}
+}
+
+func TestMigrateTimeout(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399")
+	c := connection.NewConn(nil)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", "a", "1"))
+
+	// 目标节点不回复
+	addr, _ := startMigrateTarget(t, make(chan struct{}))
+	host, port, _ := net.SplitHostPort(addr)
+	start := time.Now()
+	ret := execMigrate(cluster, c, utils.ToCmdLine("MIGRATE", host, port, "a", "0", strconv.Itoa(200)))
+	if !reply.IsErrorReply(ret) {
+		t.Fatal("migrate should fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("migrate should time out in 200ms, took %v", elapsed)
+	}
+	if _, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply); !ok {
+		t.Error("key should be kept after migration failed")
+	}
+}
@@ -0,0 +1,100 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+)
+
+// mset atomically sets multi key-value in cluster, writeKeys can be distributed on any node
+// MSET k1 v1 k2 v2
+func mset(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("mset")
+	}
+	keys := getRelatedKeys("mset", args)
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	cmdLines := cluster.splitByNode(args)
+	if len(cmdLines) == 1 {
+		for node := range cmdLines {
+			return cluster.relay(node, c, args)
+		}
+	}
+	_, aborted := cluster.execTCC(c, cmdLines)
+	if aborted != nil {
+		return aborted
+	}
+	return reply.MakeOkReply()
+}
+
+// msetnx sets multi key-value in cluster only if none of the given keys exist
+// MSETNX k1 v1 k2 v2
+// 每个节点在 PREPARE 阶段检查各自的 key 是否存在，只要有一个节点的 key 已存在就放弃事务，回复 0
+func msetnx(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 || len(args)%2 != 1 {
+		return reply.MakeArgNumErrReply("msetnx")
+	}
+	keys := getRelatedKeys("msetnx", args)
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+	cmdLines := cluster.splitByNode(args)
+	if len(cmdLines) == 1 {
+		for node := range cmdLines {
+			return cluster.relay(node, c, args)
+		}
+	}
+	_, aborted := cluster.execTCC(c, cmdLines)
+	if aborted != nil {
+		return aborted
+	}
+	return reply.MakeIntReply(1)
+}
+
+// prepareMSetNX checks whether any of the keys exists, keys have been locked
+func prepareMSetNX(cluster *ClusterDatabase, tx *transaction, args [][]byte) (resp.Reply, bool) {
+	existed := cluster.db.ExecWithLock(tx.conn(), utils.ToCmdLine(append([]string{"EXISTS"}, tx.writeKeys...)...))
+	intReply, ok := existed.(*reply.IntReply)
+	if !ok {
+		return existed, false
+	}
+	if intReply.Code > 0 {
+		return reply.MakeIntReply(0), false
+	}
+	return reply.MakeOkReply(), true
+}
+
+// mget returns the values of keys, keys can be distributed on any node
+// MGET k1 k2 k3
+// 读命令不需要事务，按节点拆分后分别转发，再按原来的顺序组装回复
+func mget(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("mget")
+	}
+	keys := getRelatedKeys("mget", args)
+	if isSameSlot(keys) {
+		return cluster.relayByKeys(c, keys, args)
+	}
+
+	valueMap := make(map[string][]byte)
+	for node, cmdLine := range cluster.splitByNode(args) {
+		result := cluster.relay(node, c, cmdLine)
+		if reply.IsErrorReply(result) {
+			return result
+		}
+		values, ok := result.(*reply.MultiBulkReply)
+		if !ok || len(values.Args) != len(cmdLine)-1 {
+			return reply.MakeErrReply("ERR unexpected reply of MGET from " + node)
+		}
+		for i, key := range cmdLine[1:] {
+			valueMap[string(key)] = values.Args[i]
+		}
+	}
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = valueMap[key]
+	}
+	return reply.MakeMultiBulkReply(result)
+}
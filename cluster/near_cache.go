@@ -0,0 +1,429 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	stdatomic "sync/atomic"
+	"time"
+)
+
+/*
+热点 key 近端缓存(near cache)
+少数热点 key 的读请求全部转发给负责的节点，使该节点成为瓶颈。开启 cluster-near-cache 后：
+1. 发现热点：转发 GET 时按 1/cluster-hotkey-sample-rate 的概率采样，
+	估算的访问次数在一秒内达到 cluster-hotkey-threshold 的 key 为热点 key
+2. 缓存：读取热点 key 时向负责的节点发送 CACHE-GET self key，该节点登记本节点跟踪该 key，再回复 key 的值；
+	本节点将值缓存 cluster-near-cache-ttl 毫秒，期间的 GET 直接由本节点回复
+3. 失效：负责的节点修改被跟踪的 key 后，通过集群总线向跟踪该 key 的节点发送 INVALIDATE 消息，删除缓存；
+	本节点转发写命令时，也立即删除本地缓存，保证同一个客户端写后读的一致性
+跟踪登记与缓存的有效期相同，缓存过期后重新 CACHE-GET 时再次登记
+*/
+
+const (
+	defaultNearCacheTTL       = 1000 // 毫秒
+	defaultNearCacheSize      = 1024
+	defaultHotKeyThreshold    = 100 // 每秒访问次数
+	defaultHotKeySampleRate   = 10
+	hotKeyWindow              = time.Second
+	maxHotKeyCandidates       = 10000 // 一个统计窗口内最多统计的 key 数，避免大量冷 key 占用内存
+	invalidateAllDB           = -1
+	nearCacheNotOwnerErrorMsg = "TRYAGAIN key is not served by current node"
+)
+
+// cacheEntry is a cached value, or a placeholder while the value is being loaded
+type cacheEntry struct {
+	value    []byte // nil 表示 key 不存在
+	expireAt time.Time
+	loading  bool   // 正在从负责的节点读取
+	token    uint64 // 读取期间被删除(失效)后重新创建的占位不能被旧的读取结果覆盖
+}
+
+// nearCache caches values of hot keys on the coordinator
+type nearCache struct {
+	mu      sync.Mutex
+	entries map[string]*cacheEntry // dbIndex + " " + key -> entry
+	seq     uint64
+
+	// 热点 key 统计，当前窗口与上一个窗口的采样次数
+	windowStart time.Time
+	counts      map[string]int
+	prevCounts  map[string]int
+
+	hits          stdatomic.Uint64
+	misses        stdatomic.Uint64
+	invalidations stdatomic.Uint64
+}
+
+func makeNearCache() *nearCache {
+	return &nearCache{
+		entries:     make(map[string]*cacheEntry),
+		windowStart: time.Now(),
+		counts:      make(map[string]int),
+		prevCounts:  make(map[string]int),
+	}
+}
+
+func cacheKey(dbIndex int, key string) string {
+	return strconv.Itoa(dbIndex) + " " + key
+}
+
+// get returns the cached value, ok is false if not cached or expired
+func (nc *nearCache) get(dbIndex int, key string) ([]byte, bool) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	entry, ok := nc.entries[cacheKey(dbIndex, key)]
+	if !ok || entry.loading || time.Now().After(entry.expireAt) {
+		nc.misses.Add(1)
+		return nil, false
+	}
+	nc.hits.Add(1)
+	return entry.value, true
+}
+
+// isHot samples the access and returns whether the key is hot
+func (nc *nearCache) isHot(dbIndex int, key string) bool {
+	sampleRate := getHotKeySampleRate()
+	if rand.Intn(sampleRate) != 0 {
+		return false
+	}
+	k := cacheKey(dbIndex, key)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	now := time.Now()
+	if now.Sub(nc.windowStart) >= hotKeyWindow {
+		if now.Sub(nc.windowStart) >= 2*hotKeyWindow {
+			nc.prevCounts = make(map[string]int)
+		} else {
+			nc.prevCounts = nc.counts
+		}
+		nc.counts = make(map[string]int)
+		nc.windowStart = now
+	}
+	if _, ok := nc.counts[k]; ok || len(nc.counts) < maxHotKeyCandidates {
+		nc.counts[k]++
+	}
+	estimated := max(nc.counts[k], nc.prevCounts[k]) * sampleRate
+	return estimated >= getHotKeyThreshold()
+}
+
+// startLoad creates a placeholder before loading value from owner
+// returns false if the value is being loaded by another request
+func (nc *nearCache) startLoad(dbIndex int, key string) (uint64, bool) {
+	k := cacheKey(dbIndex, key)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if entry, ok := nc.entries[k]; ok && entry.loading {
+		return 0, false
+	}
+	if _, ok := nc.entries[k]; !ok && len(nc.entries) >= getNearCacheSize() {
+		nc.evict()
+	}
+	nc.seq++
+	nc.entries[k] = &cacheEntry{loading: true, token: nc.seq}
+	return nc.seq, true
+}
+
+// finishLoad stores the loaded value if the placeholder has not been invalidated
+func (nc *nearCache) finishLoad(dbIndex int, key string, token uint64, value []byte) {
+	k := cacheKey(dbIndex, key)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	entry, ok := nc.entries[k]
+	if !ok || entry.token != token {
+		return
+	}
+	entry.value = value
+	entry.loading = false
+	entry.expireAt = time.Now().Add(getNearCacheTTL())
+}
+
+// cancelLoad removes the placeholder
+func (nc *nearCache) cancelLoad(dbIndex int, key string, token uint64) {
+	k := cacheKey(dbIndex, key)
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if entry, ok := nc.entries[k]; ok && entry.token == token {
+		delete(nc.entries, k)
+	}
+}
+
+// evict removes expired entries, or a random entry if none expired, caller should hold the lock
+func (nc *nearCache) evict() {
+	now := time.Now()
+	for k, entry := range nc.entries {
+		if !entry.loading && now.After(entry.expireAt) {
+			delete(nc.entries, k)
+		}
+	}
+	if len(nc.entries) < getNearCacheSize() {
+		return
+	}
+	for k, entry := range nc.entries {
+		if !entry.loading {
+			delete(nc.entries, k)
+			return
+		}
+	}
+}
+
+// invalidate removes the given keys, removes all keys of db if keys is nil
+// dbIndex is invalidateAllDB means all dbs
+func (nc *nearCache) invalidate(dbIndex int, keys []string) {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	if keys == nil {
+		if dbIndex == invalidateAllDB {
+			nc.entries = make(map[string]*cacheEntry)
+			return
+		}
+		prefix := strconv.Itoa(dbIndex) + " "
+		for k := range nc.entries {
+			if strings.HasPrefix(k, prefix) {
+				delete(nc.entries, k)
+			}
+		}
+		return
+	}
+	for _, key := range keys {
+		k := cacheKey(dbIndex, key)
+		if _, ok := nc.entries[k]; ok {
+			delete(nc.entries, k)
+			nc.invalidations.Add(1)
+		}
+	}
+}
+
+// invalidateCommand removes the keys written by command which is relayed by current node
+func (nc *nearCache) invalidateCommand(dbIndex int, cmdName string, cmdLine [][]byte) {
+	switch cmdName {
+	case "flushdb":
+		nc.invalidate(dbIndex, nil)
+	case "flushall":
+		nc.invalidate(invalidateAllDB, nil)
+	default:
+		if keys := getRelatedKeys(cmdName, cmdLine); len(keys) > 0 {
+			nc.invalidate(dbIndex, keys)
+		}
+	}
+}
+
+func (nc *nearCache) size() int {
+	nc.mu.Lock()
+	defer nc.mu.Unlock()
+	return len(nc.entries)
+}
+
+// tracking records which nodes cached the keys served by current node
+type tracking struct {
+	mu   sync.Mutex
+	keys map[string]map[string]time.Time // dbIndex + " " + key -> 跟踪的节点 -> 跟踪的截止时间
+}
+
+func makeTracking() *tracking {
+	return &tracking{keys: make(map[string]map[string]time.Time)}
+}
+
+// track records that node caches the key until ttl
+func (tr *tracking) track(dbIndex int, key string, node string, ttl time.Duration) {
+	k := cacheKey(dbIndex, key)
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	nodes, ok := tr.keys[k]
+	if !ok {
+		nodes = make(map[string]time.Time)
+		tr.keys[k] = nodes
+	}
+	nodes[node] = time.Now().Add(ttl)
+}
+
+// collect returns the nodes which should be notified and the written keys of each node
+// keys is nil for FLUSHDB/FLUSHALL
+func (tr *tracking) collect(dbIndex int, cmdLine [][]byte) map[string][]string {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.keys) == 0 {
+		return nil
+	}
+	now := time.Now()
+	result := make(map[string][]string)
+	if cmdName == "flushdb" || cmdName == "flushall" {
+		prefix := strconv.Itoa(dbIndex) + " "
+		for k, nodes := range tr.keys {
+			if cmdName == "flushall" || strings.HasPrefix(k, prefix) {
+				for node, until := range nodes {
+					if now.Before(until) {
+						result[node] = nil
+					}
+				}
+				delete(tr.keys, k)
+			}
+		}
+		return result
+	}
+	writeKeys, _ := database.GetRelatedKeys(cmdLine)
+	for _, key := range writeKeys {
+		k := cacheKey(dbIndex, key)
+		for node, until := range tr.keys[k] {
+			if now.Before(until) {
+				result[node] = append(result[node], key)
+			} else {
+				delete(tr.keys[k], node)
+			}
+		}
+		if len(tr.keys[k]) == 0 {
+			delete(tr.keys, k)
+		}
+	}
+	return result
+}
+
+// removeExpired removes expired tracking records
+func (tr *tracking) removeExpired() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	now := time.Now()
+	for k, nodes := range tr.keys {
+		for node, until := range nodes {
+			if now.After(until) {
+				delete(nodes, node)
+			}
+		}
+		if len(nodes) == 0 {
+			delete(tr.keys, k)
+		}
+	}
+}
+
+// onWrite is the write listener of db, notifies nodes which cached the written keys
+// 在执行写命令时调用，不能阻塞，异步发送失效消息
+func (cluster *ClusterDatabase) onWrite(dbIndex int, cmdLine [][]byte) {
+	notify := cluster.tracking.collect(dbIndex, cmdLine)
+	for node, keys := range notify {
+		msg := &gossipMessage{
+			Type:   msgInvalidate,
+			Sender: cluster.self,
+			DB:     dbIndex,
+			Keys:   keys,
+		}
+		if keys == nil && strings.ToLower(string(cmdLine[0])) == "flushall" {
+			msg.DB = invalidateAllDB
+		}
+		addr := busAddr(node, cluster.topology.getBusPort(node))
+		go func() {
+			if _, err := cluster.sendMessage(addr, msg, false); err != nil {
+				logger.Warn("send invalidation to " + node + " failed: " + err.Error())
+			}
+		}()
+	}
+}
+
+// handleInvalidate removes cached keys written on the owner node
+func (cluster *ClusterDatabase) handleInvalidate(msg *gossipMessage) {
+	if cluster.nearCache != nil {
+		cluster.nearCache.invalidate(msg.DB, msg.Keys)
+	}
+}
+
+// execGet returns the value of key, hot keys are served by near cache
+// GET key
+func execGet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	nc := cluster.nearCache
+	if nc == nil || len(args) != 2 || c.IsAsking() {
+		return defaultFunc(cluster, c, args)
+	}
+	key := string(args[1])
+	owner := cluster.topology.pickNode(getSlot(key))
+	// 本节点负责的 key、本节点作为从节点可以读取的 key，直接读取
+	if owner == "" || owner == cluster.self || cluster.servedByReplica(c, "get", owner) {
+		return defaultFunc(cluster, c, args)
+	}
+	dbIndex := c.GetDBIndex()
+	if value, ok := nc.get(dbIndex, key); ok {
+		if value == nil {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply(value)
+	}
+	if !nc.isHot(dbIndex, key) {
+		return defaultFunc(cluster, c, args)
+	}
+	token, ok := nc.startLoad(dbIndex, key)
+	if !ok {
+		return defaultFunc(cluster, c, args)
+	}
+	ret := cluster.relay(owner, c, utils.ToCmdLine("Cache-Get", cluster.self, key))
+	switch r := ret.(type) {
+	case *reply.BulkReply:
+		nc.finishLoad(dbIndex, key, token, r.Arg)
+		return ret
+	case *reply.NullBulkReply:
+		nc.finishLoad(dbIndex, key, token, nil)
+		return ret
+	}
+	nc.cancelLoad(dbIndex, key, token)
+	if reply.IsErrorReply(ret) && strings.HasPrefix(string(ret.ToBytes()[1:]), nearCacheNotOwnerErrorMsg) {
+		// slot 正在迁移或者已经迁移，按照普通的 GET 处理
+		return defaultFunc(cluster, c, args)
+	}
+	return ret
+}
+
+// execCacheGet registers that the node caches the key and returns its value
+// CACHE-GET node key
+func execCacheGet(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("cache-get")
+	}
+	node := string(args[1])
+	key := string(args[2])
+	slot := getSlot(key)
+	if cluster.topology.pickNode(slot) != cluster.self {
+		return reply.MakeErrReply(nearCacheNotOwnerErrorMsg)
+	}
+	if _, migrating := cluster.topology.getMigrating(slot); migrating {
+		return reply.MakeErrReply(nearCacheNotOwnerErrorMsg)
+	}
+	// 先登记再读取，读取之后的写命令一定会通知该节点
+	cluster.tracking.track(c.GetDBIndex(), key, node, getNearCacheTTL())
+	return cluster.db.Exec(c, utils.ToCmdLine("GET", key))
+}
+
+func getNearCacheTTL() time.Duration {
+	ttl := config.Load().ClusterNearCacheTTL
+	if ttl <= 0 {
+		ttl = defaultNearCacheTTL
+	}
+	return time.Duration(ttl) * time.Millisecond
+}
+
+func getNearCacheSize() int {
+	if config.Properties.ClusterNearCacheSize <= 0 {
+		return defaultNearCacheSize
+	}
+	return config.Properties.ClusterNearCacheSize
+}
+
+func getHotKeyThreshold() int {
+	threshold := config.Load().ClusterHotKeyThreshold
+	if threshold <= 0 {
+		return defaultHotKeyThreshold
+	}
+	return threshold
+}
+
+func getHotKeySampleRate() int {
+	rate := config.Load().ClusterHotKeySampleRate
+	if rate <= 0 {
+		return defaultHotKeySampleRate
+	}
+	return rate
+}
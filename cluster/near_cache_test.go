@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNearCacheLoad(t *testing.T) {
+	nc := makeNearCache()
+	if _, ok := nc.get(0, "k"); ok {
+		t.Fatal("k should not be cached")
+	}
+	token, ok := nc.startLoad(0, "k")
+	if !ok {
+		t.Fatal("start load failed")
+	}
+	if _, ok = nc.startLoad(0, "k"); ok {
+		t.Error("k is being loaded by another request")
+	}
+	if _, ok = nc.get(0, "k"); ok {
+		t.Error("k should not be served while loading")
+	}
+	nc.finishLoad(0, "k", token, []byte("v"))
+	if value, ok := nc.get(0, "k"); !ok || string(value) != "v" {
+		t.Fatalf("k should be cached, got %q", value)
+	}
+	if _, ok = nc.get(1, "k"); ok {
+		t.Error("k of db 1 should not be cached")
+	}
+
+	// 读取期间失效的 key，旧的读取结果不能写入缓存
+	nc.invalidate(0, []string{"k"})
+	token, _ = nc.startLoad(0, "k")
+	nc.invalidate(0, []string{"k"})
+	newToken, _ := nc.startLoad(0, "k")
+	nc.finishLoad(0, "k", token, []byte("stale"))
+	if _, ok = nc.get(0, "k"); ok {
+		t.Error("stale value should not be cached")
+	}
+	nc.finishLoad(0, "k", newToken, nil)
+	if value, ok := nc.get(0, "k"); !ok || value != nil {
+		t.Error("missing key should be cached as nil")
+	}
+
+	nc.invalidateCommand(0, "flushall", utils.ToCmdLine("FLUSHALL"))
+	if nc.size() != 0 {
+		t.Error("FLUSHALL should remove all entries")
+	}
+}
+
+func TestTrackingCollect(t *testing.T) {
+	tr := makeTracking()
+	tr.track(0, "a", "node1", time.Minute)
+	tr.track(0, "a", "node2", -time.Second) // 已过期
+	tr.track(0, "b", "node2", time.Minute)
+	tr.track(1, "a", "node3", time.Minute)
+
+	if notify := tr.collect(0, utils.ToCmdLine("GET", "a")); len(notify) != 0 {
+		t.Errorf("read command should not notify, got %v", notify)
+	}
+	notify := tr.collect(0, utils.ToCmdLine("MSET", "a", "1", "b", "2"))
+	expected := map[string][]string{"node1": {"a"}, "node2": {"b"}}
+	if !reflect.DeepEqual(notify, expected) {
+		t.Errorf("got %v, want %v", notify, expected)
+	}
+	notify = tr.collect(1, utils.ToCmdLine("FLUSHDB"))
+	if !reflect.DeepEqual(notify, map[string][]string{"node3": nil}) {
+		t.Errorf("FLUSHDB should notify all nodes tracking keys of db, got %v", notify)
+	}
+}
+
+func TestExecGetNearCache(t *testing.T) {
+	sampleRate, threshold := config.Properties.ClusterHotKeySampleRate, config.Properties.ClusterHotKeyThreshold
+	config.Properties.ClusterHotKeySampleRate, config.Properties.ClusterHotKeyThreshold = 1, 2
+	defer func() {
+		config.Properties.ClusterHotKeySampleRate, config.Properties.ClusterHotKeyThreshold = sampleRate, threshold
+	}()
+
+	var gets, cacheGets atomic.Int32
+	peer := startFakePeer(t, func(args [][]byte) resp.Reply {
+		switch strings.ToLower(string(args[0])) {
+		case "get":
+			gets.Add(1)
+			return reply.MakeBulkReply([]byte("v"))
+		case "cache-get":
+			cacheGets.Add(1)
+			return reply.MakeBulkReply([]byte("v"))
+		}
+		return nil
+	})
+	cluster := makeTestCluster("127.0.0.1:6399", peer)
+	cluster.nearCache = makeNearCache()
+	c := connection.NewConn(nil)
+	key := keyOn(cluster, peer)
+
+	get := func() {
+		t.Helper()
+		value, ok := cluster.Exec(c, utils.ToCmdLine("GET", key)).(*reply.BulkReply)
+		if !ok || string(value.Arg) != "v" {
+			t.Fatal("unexpected reply of GET")
+		}
+	}
+	get() // 访问次数未达到阈值
+	get() // 成为热点 key，CACHE-GET 读取并缓存
+	get()
+	get()
+	if gets.Load() != 1 || cacheGets.Load() != 1 {
+		t.Fatalf("expected 1 GET and 1 CACHE-GET, got %d and %d", gets.Load(), cacheGets.Load())
+	}
+
+	// 本节点转发的写命令立即删除缓存
+	cluster.Exec(c, utils.ToCmdLine("SET", key, "v"))
+	get()
+	if cacheGets.Load() != 2 {
+		t.Errorf("cache should be invalidated by SET, CACHE-GET count: %d", cacheGets.Load())
+	}
+	// 负责的节点发送的失效消息
+	cluster.handleInvalidate(&gossipMessage{Type: msgInvalidate, Sender: peer, Keys: []string{key}})
+	get()
+	if cacheGets.Load() != 3 {
+		t.Errorf("cache should be invalidated by message, CACHE-GET count: %d", cacheGets.Load())
+	}
+}
+
+func TestExecCacheGet(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	cluster.tracking = makeTracking()
+	c := connection.NewConn(nil)
+	local, remote := keyOn(cluster, self), keyOn(cluster, peer)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", local, "v"))
+
+	ret := execCacheGet(cluster, c, utils.ToCmdLine("CACHE-GET", peer, local))
+	if value, ok := ret.(*reply.BulkReply); !ok || string(value.Arg) != "v" {
+		t.Fatalf("unexpected reply %s", ret.ToBytes())
+	}
+	if notify := cluster.tracking.collect(0, utils.ToCmdLine("DEL", local)); len(notify[peer]) != 1 {
+		t.Errorf("%s should be notified, got %v", peer, notify)
+	}
+	ret = execCacheGet(cluster, c, utils.ToCmdLine("CACHE-GET", peer, remote))
+	if errReply, ok := ret.(reply.ErrorReply); !ok || errReply.Error() != nearCacheNotOwnerErrorMsg {
+		t.Errorf("key of other node should be rejected, got %s", ret.ToBytes())
+	}
+}
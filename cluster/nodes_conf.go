@@ -0,0 +1,256 @@
+package cluster
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+nodes.conf 与 CLUSTER NODES 的输出格式相同，最后一行保存纪元：
+	<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+	vars currentEpoch <epoch> lastVoteEpoch <epoch>
+本节点(myself)的行中还会记录迁移中的 slot：[slot->-目标节点 id] [slot-<-源节点 id]
+节点重启时从 nodes.conf 恢复拓扑，不再依赖配置文件中的 peers
+*/
+
+// savedTopology is the topology parsed from nodes.conf or the output of CLUSTER NODES
+type savedTopology struct {
+	self          string // myself 标记的节点
+	nodes         []*nodeInfo
+	slots         [slotCount]string
+	assigned      int // 已分配的 slot 数
+	migrating     map[uint32]string
+	importing     map[uint32]string
+	currentEpoch  uint64
+	lastVoteEpoch uint64
+}
+
+// formatNodes returns all nodes in CLUSTER NODES format, caller should hold the lock
+func (t *topology) formatNodes() string {
+	ranges := t.slotRanges()
+	var builder strings.Builder
+	for _, node := range t.sortedNodes() {
+		info := t.nodes[node]
+		flags := "master"
+		master := "-"
+		if info.master != "" {
+			flags = "slave"
+			master = nodeID(info.master)
+		}
+		if node == t.self {
+			flags = "myself," + flags
+		}
+		linkState := "connected"
+		if info.flags&nodeFlagFail > 0 {
+			flags += ",fail"
+			linkState = "disconnected"
+		} else if info.flags&nodeFlagPFail > 0 {
+			flags += ",fail?"
+			linkState = "disconnected"
+		}
+		if info.flags&nodeFlagMeet > 0 {
+			flags += ",handshake"
+		}
+		var pingSent, pongReceived int64
+		if !info.pingSent.IsZero() {
+			pingSent = info.pingSent.UnixMilli()
+		}
+		if node != t.self {
+			pongReceived = info.pongReceived.UnixMilli()
+		}
+		host, port := splitAddr(node)
+		builder.WriteString(nodeID(node) + " " + host + ":" + strconv.Itoa(port) + "@" + strconv.Itoa(info.busPort) +
+			" " + flags + " " + master + " " + strconv.FormatInt(pingSent, 10) + " " + strconv.FormatInt(pongReceived, 10) +
+			" " + strconv.FormatUint(info.configEpoch, 10) + " " + linkState)
+		for _, r := range ranges {
+			if r.node != node {
+				continue
+			}
+			if r.start == r.end {
+				builder.WriteString(" " + strconv.Itoa(int(r.start)))
+			} else {
+				builder.WriteString(" " + strconv.Itoa(int(r.start)) + "-" + strconv.Itoa(int(r.end)))
+			}
+		}
+		if node == t.self {
+			for slot := uint32(0); slot < slotCount; slot++ {
+				if target, ok := t.migrating[slot]; ok {
+					builder.WriteString(" [" + strconv.Itoa(int(slot)) + "->-" + nodeID(target) + "]")
+				}
+				if source, ok := t.importing[slot]; ok {
+					builder.WriteString(" [" + strconv.Itoa(int(slot)) + "-<-" + nodeID(source) + "]")
+				}
+			}
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// parseClusterNodes parses nodes.conf or the output of CLUSTER NODES
+// 每一行格式：<id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state> <slot> ...
+func parseClusterNodes(text string) (*savedTopology, error) {
+	saved := &savedTopology{
+		migrating: make(map[uint32]string),
+		importing: make(map[uint32]string),
+	}
+	migrating := make(map[uint32]string) // slot -> 节点 id，所有节点解析完成后再转换为地址
+	importing := make(map[uint32]string)
+	masters := make(map[*nodeInfo]string) // 从节点 -> 主节点 id
+	for _, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				epoch, err := strconv.ParseUint(fields[i+1], 10, 64)
+				if err != nil {
+					return nil, errors.New("invalid vars: " + line)
+				}
+				switch fields[i] {
+				case "currentEpoch":
+					saved.currentEpoch = epoch
+				case "lastVoteEpoch":
+					saved.lastVoteEpoch = epoch
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			continue
+		}
+		addrs := strings.SplitN(fields[1], "@", 2)
+		info := &nodeInfo{addr: addrs[0]}
+		_, port := splitAddr(info.addr)
+		if port == 0 {
+			return nil, errors.New("invalid node address: " + fields[1])
+		}
+		info.busPort = port + busPortOffset
+		if len(addrs) == 2 {
+			if busPort, err := strconv.Atoi(addrs[1]); err == nil {
+				info.busPort = busPort
+			}
+		}
+		epoch, err := strconv.ParseUint(fields[6], 10, 64)
+		if err != nil {
+			return nil, errors.New("invalid config epoch: " + fields[6])
+		}
+		info.configEpoch = epoch
+		if epoch > saved.currentEpoch {
+			saved.currentEpoch = epoch
+		}
+		isSelf := strings.Contains(fields[2], "myself")
+		if isSelf {
+			saved.self = info.addr
+		}
+		saved.nodes = append(saved.nodes, info)
+		if fields[3] != "-" {
+			masters[info] = fields[3]
+		}
+		for _, field := range fields[8:] {
+			if strings.HasPrefix(field, "[") { // 迁移中的 slot: [slot->-id] [slot-<-id]
+				if !isSelf {
+					continue
+				}
+				field = strings.Trim(field, "[]")
+				if parts := strings.SplitN(field, "->-", 2); len(parts) == 2 {
+					slot, errReply := parseSlot([]byte(parts[0]))
+					if errReply != nil {
+						return nil, errors.New("invalid slot: " + field)
+					}
+					migrating[slot] = parts[1]
+				} else if parts = strings.SplitN(field, "-<-", 2); len(parts) == 2 {
+					slot, errReply := parseSlot([]byte(parts[0]))
+					if errReply != nil {
+						return nil, errors.New("invalid slot: " + field)
+					}
+					importing[slot] = parts[1]
+				}
+				continue
+			}
+			bounds := strings.SplitN(field, "-", 2)
+			start, err := strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+			end := start
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, err
+				}
+			}
+			if start < 0 || end >= slotCount || start > end {
+				return nil, errors.New("invalid slot range: " + field)
+			}
+			for slot := start; slot <= end; slot++ {
+				if saved.slots[slot] == "" {
+					saved.assigned++
+				}
+				saved.slots[slot] = info.addr
+			}
+		}
+	}
+
+	// 将主节点、迁移记录中的节点 id 转换为地址
+	ids := make(map[string]string)
+	for _, info := range saved.nodes {
+		ids[nodeID(info.addr)] = info.addr
+	}
+	for info, id := range masters {
+		info.master = ids[id]
+	}
+	for slot, id := range migrating {
+		if addr, ok := ids[id]; ok {
+			saved.migrating[slot] = addr
+		}
+	}
+	for slot, id := range importing {
+		if addr, ok := ids[id]; ok {
+			saved.importing[slot] = addr
+		}
+	}
+	return saved, nil
+}
+
+// loadNodesConf reads topology from nodes.conf, returns nil if the file not exists
+func loadNodesConf(filename string) (*savedTopology, error) {
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseClusterNodes(string(content))
+}
+
+// saveNodesConf writes topology into nodes.conf if it has been changed
+// 先写入临时文件再重命名，避免进程崩溃时留下不完整的文件
+func (t *topology) saveNodesConf(filename string) error {
+	t.mu.Lock()
+	if !t.changed {
+		t.mu.Unlock()
+		return nil
+	}
+	t.changed = false
+	content := t.formatNodes() + "vars currentEpoch " + strconv.FormatUint(t.currentEpoch, 10) +
+		" lastVoteEpoch " + strconv.FormatUint(t.lastVoteEpoch, 10) + "\n"
+	t.mu.Unlock()
+
+	tmpFile := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp-"+strconv.FormatInt(time.Now().UnixNano(), 10))
+	err := os.WriteFile(tmpFile, []byte(content), 0644)
+	if err == nil {
+		err = os.Rename(tmpFile, filename)
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile)
+		t.mu.Lock()
+		t.changed = true
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/reply"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+从节点读(read from replica)
+proxy 模式下，读命令可以转发给 key 所在主节点的从节点，由连接的读策略决定：
+	primary         只读主节点(默认)
+	prefer-replica  优先读从节点，多个从节点之间轮流读，没有可用的从节点时读主节点
+	round-robin     在主节点和从节点之间轮流读
+	lowest-latency  读集群总线 PING 往返时间最短的节点，本节点的往返时间视为 0
+从节点来源：集群拓扑中复制该主节点的从节点，以及 cluster-replicas 配置的从节点
+已下线、疑似下线的从节点不参与读
+配置了 cluster-replica-max-lag 时，复制偏移量落后主节点超过该值的从节点不参与读，所有从节点都落后时回退到主节点
+*/
+
+// read policies
+const (
+	readPrimary       = "primary"
+	readPreferReplica = "prefer-replica"
+	readRoundRobin    = "round-robin"
+	readLowestLatency = "lowest-latency"
+)
+
+func isValidReadPolicy(policy string) bool {
+	switch policy {
+	case readPrimary, readPreferReplica, readRoundRobin, readLowestLatency:
+		return true
+	}
+	return false
+}
+
+// readCandidate is a node which could serve read commands
+type readCandidate struct {
+	node string
+	rtt  time.Duration
+}
+
+// parseReplicasConfig parses cluster-replicas, returns master -> replicas
+// 格式：主节点=从节点，同一个主节点有多个从节点时重复配置
+func parseReplicasConfig(entries []string) map[string][]string {
+	result := make(map[string][]string)
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		master, replica, ok := strings.Cut(entry, "=")
+		if !ok || master == "" || replica == "" {
+			logger.Warn("invalid cluster-replicas entry: " + entry)
+			continue
+		}
+		result[master] = append(result[master], replica)
+	}
+	return result
+}
+
+// getDefaultReadPolicy returns the read policy of connections which have not executed READPOLICY
+func getDefaultReadPolicy() string {
+	policy := strings.ToLower(config.Properties.ClusterReadPolicy)
+	if !isValidReadPolicy(policy) {
+		return readPrimary
+	}
+	return policy
+}
+
+// getReadPolicy returns the read policy of connection
+func (cluster *ClusterDatabase) getReadPolicy(c resp.Connection) string {
+	if policy, ok := cluster.readPolicies.Load(c); ok {
+		return policy.(string)
+	}
+	return getDefaultReadPolicy()
+}
+
+// execReadPolicy sets or gets the read policy of current connection
+// READPOLICY [primary|prefer-replica|round-robin|lowest-latency]
+func execReadPolicy(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 1 {
+		return reply.MakeBulkReply([]byte(cluster.getReadPolicy(c)))
+	}
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("readpolicy")
+	}
+	policy := strings.ToLower(string(args[1]))
+	if !isValidReadPolicy(policy) {
+		return reply.MakeErrReply("ERR unknown read policy '" + policy + "'")
+	}
+	cluster.readPolicies.Store(c, policy)
+	return reply.MakeOkReply()
+}
+
+// getReadReplicas returns replicas of master which could serve read commands
+func (cluster *ClusterDatabase) getReadReplicas(master string) []*readCandidate {
+	maxLag := int64(config.Load().ClusterReplicaMaxLag)
+	var masterOffset int64
+	if master == cluster.self {
+		masterOffset = cluster.replication.getOffset()
+	}
+
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if info, ok := t.nodes[master]; ok && master != cluster.self {
+		masterOffset = info.replOffset
+	}
+	seen := make(map[string]struct{})
+	candidates := make([]*readCandidate, 0)
+	add := func(node string) {
+		if _, ok := seen[node]; ok {
+			return
+		}
+		seen[node] = struct{}{}
+		info, known := t.nodes[node]
+		if known && info.flags&(nodeFlagFail|nodeFlagPFail) > 0 {
+			return
+		}
+		// 复制偏移量未知的从节点无法判断延迟
+		if maxLag > 0 && (!known || masterOffset-info.replOffset > maxLag) {
+			return
+		}
+		rtt := time.Duration(math.MaxInt64)
+		if node == cluster.self {
+			rtt = 0
+		} else if known && info.rtt > 0 {
+			rtt = info.rtt
+		}
+		candidates = append(candidates, &readCandidate{node: node, rtt: rtt})
+	}
+	for node, info := range t.nodes {
+		if info.master == master {
+			add(node)
+		}
+	}
+	for _, node := range cluster.configuredReplicas[master] {
+		add(node)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].node < candidates[j].node
+	})
+	return candidates
+}
+
+// pickReadNode returns the node which executes the command according to the read policy of connection
+// returns whether the node is a replica
+func (cluster *ClusterDatabase) pickReadNode(c resp.Connection, primary string, args [][]byte) (string, bool) {
+	policy := cluster.getReadPolicy(c)
+	if policy == readPrimary || !isReadOnlyCommand(strings.ToLower(string(args[0]))) {
+		return primary, false
+	}
+	replicas := cluster.getReadReplicas(primary)
+	if len(replicas) == 0 {
+		return primary, false
+	}
+	switch policy {
+	case readPreferReplica:
+		seq := cluster.readSeq.Add(1)
+		return replicas[seq%uint64(len(replicas))].node, true
+	case readRoundRobin:
+		seq := cluster.readSeq.Add(1)
+		i := seq % uint64(len(replicas)+1)
+		if i == uint64(len(replicas)) {
+			return primary, false
+		}
+		return replicas[i].node, true
+	case readLowestLatency:
+		best := &readCandidate{node: primary, rtt: cluster.getRTT(primary)}
+		for _, candidate := range replicas {
+			if candidate.rtt < best.rtt {
+				best = candidate
+			}
+		}
+		return best.node, best.node != primary
+	}
+	return primary, false
+}
+
+// getRTT returns the round trip time of node, returns the max duration if unknown
+func (cluster *ClusterDatabase) getRTT(node string) time.Duration {
+	if node == cluster.self {
+		return 0
+	}
+	t := cluster.topology
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if info, ok := t.nodes[node]; ok && info.rtt > 0 {
+		return info.rtt
+	}
+	return time.Duration(math.MaxInt64)
+}
@@ -0,0 +1,127 @@
+package cluster
+
+import (
+	"go-redis/config"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseReplicasConfig(t *testing.T) {
+	result := parseReplicasConfig([]string{
+		"127.0.0.1:6379=127.0.0.1:6479",
+		" 127.0.0.1:6379=127.0.0.1:6579 ",
+		"127.0.0.1:6380=127.0.0.1:6480",
+		"",
+		"invalid",
+		"=127.0.0.1:6481",
+	})
+	expected := map[string][]string{
+		"127.0.0.1:6379": {"127.0.0.1:6479", "127.0.0.1:6579"},
+		"127.0.0.1:6380": {"127.0.0.1:6480"},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("got %v, want %v", result, expected)
+	}
+}
+
+// makeReadTestCluster creates a cluster in which master has replicas replica1 and replica2
+func makeReadTestCluster() (cluster *ClusterDatabase, master string, replica1 string, replica2 string) {
+	self, master := "127.0.0.1:6399", "127.0.0.1:6400"
+	replica1, replica2 = "127.0.0.1:6401", "127.0.0.1:6402"
+	cluster = makeTestCluster(self, master)
+	t := cluster.topology
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addNode(replica1).master = master
+	t.addNode(replica2).master = master
+	return cluster, master, replica1, replica2
+}
+
+func TestPickReadNode(t *testing.T) {
+	cluster, master, replica1, replica2 := makeReadTestCluster()
+	c := connection.NewConn(nil)
+	get := utils.ToCmdLine("GET", "k")
+	pick := func(args [][]byte) string {
+		node, _ := cluster.pickReadNode(c, master, args)
+		return node
+	}
+
+	if node := pick(get); node != master {
+		t.Errorf("primary policy should read master, got %s", node)
+	}
+	if ret := execReadPolicy(cluster, c, utils.ToCmdLine("READPOLICY", "nearest")); !reply.IsErrorReply(ret) {
+		t.Error("unknown read policy should be rejected")
+	}
+
+	execReadPolicy(cluster, c, utils.ToCmdLine("READPOLICY", "prefer-replica"))
+	if node := pick(utils.ToCmdLine("SET", "k", "v")); node != master {
+		t.Errorf("write command should be sent to master, got %s", node)
+	}
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		seen[pick(get)]++
+	}
+	if seen[replica1] != 2 || seen[replica2] != 2 {
+		t.Errorf("prefer-replica should read replicas in turn, got %v", seen)
+	}
+
+	execReadPolicy(cluster, c, utils.ToCmdLine("READPOLICY", "round-robin"))
+	seen = map[string]int{}
+	for i := 0; i < 6; i++ {
+		seen[pick(get)]++
+	}
+	if seen[master] != 2 || seen[replica1] != 2 || seen[replica2] != 2 {
+		t.Errorf("round-robin should read master and replicas in turn, got %v", seen)
+	}
+
+	execReadPolicy(cluster, c, utils.ToCmdLine("READPOLICY", "lowest-latency"))
+	cluster.topology.updateRTT(master, 3*time.Millisecond)
+	cluster.topology.updateRTT(replica1, 2*time.Millisecond)
+	cluster.topology.updateRTT(replica2, time.Millisecond)
+	if node := pick(get); node != replica2 {
+		t.Errorf("lowest-latency should read %s, got %s", replica2, node)
+	}
+
+	// 下线的从节点不参与读，所有从节点不可用时读主节点
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[replica2].flags |= nodeFlagPFail
+	cluster.topology.mu.Unlock()
+	if node := pick(get); node != replica1 {
+		t.Errorf("failing replica should be skipped, got %s", node)
+	}
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[replica1].flags |= nodeFlagFail
+	cluster.topology.mu.Unlock()
+	execReadPolicy(cluster, c, utils.ToCmdLine("READPOLICY", "prefer-replica"))
+	if node := pick(get); node != master {
+		t.Errorf("should read master if no replica is available, got %s", node)
+	}
+}
+
+func TestReadReplicaMaxLag(t *testing.T) {
+	maxLag := config.Properties.ClusterReplicaMaxLag
+	config.Properties.ClusterReplicaMaxLag = 100
+	defer func() { config.Properties.ClusterReplicaMaxLag = maxLag }()
+
+	cluster, master, replica1, replica2 := makeReadTestCluster()
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[master].replOffset = 1000
+	cluster.topology.nodes[replica1].replOffset = 950
+	cluster.topology.nodes[replica2].replOffset = 800
+	cluster.topology.mu.Unlock()
+
+	candidates := cluster.getReadReplicas(master)
+	if len(candidates) != 1 || candidates[0].node != replica1 {
+		t.Fatalf("only %s should serve reads, got %v", replica1, candidates)
+	}
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[replica1].replOffset = 0
+	cluster.topology.mu.Unlock()
+	if candidates = cluster.getReadReplicas(master); len(candidates) != 0 {
+		t.Errorf("lagging replicas should not serve reads, got %d", len(candidates))
+	}
+}
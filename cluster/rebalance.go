@@ -0,0 +1,197 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+)
+
+const (
+	migrateBatchSize = 100  // 每次 MIGRATE 迁移的 key 个数
+	migrateTimeout   = 5000 // MIGRATE 的超时时间，单位毫秒
+)
+
+// slotMove describes moving a slot from one node to another
+type slotMove struct {
+	slot uint32
+	from string // 源节点，为空表示该 slot 尚未分配
+	to   string // 目标节点
+}
+
+// execClusterRebalance moves slots so that every node serves the same number of slots
+// CLUSTER REBALANCE [node ...]
+// 参数中的节点会作为新节点加入集群，迁移在后台进行，期间集群正常提供服务
+// 例如：新节点 D 启动后(peers 配置为已有节点)，在任一节点上执行 CLUSTER REBALANCE D 即可将部分 slot 迁移到 D
+func execClusterRebalance(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	nodes := cluster.topology.getNodes()
+	for _, arg := range args[2:] {
+		node := string(arg)
+		if _, ok := cluster.topology.findNode(node); !ok {
+			nodes = append(nodes, node)
+		}
+	}
+	if cluster.rebalancing.Get() {
+		return reply.MakeErrReply("ERR rebalance is already in progress")
+	}
+	moves := planRebalance(cluster.topology.getSlots(), nodes)
+	if len(moves) == 0 {
+		return reply.MakeStatusReply("OK")
+	}
+	cluster.rebalancing.Set(true)
+	go cluster.rebalance(moves)
+	return reply.MakeStatusReply("OK")
+}
+
+// planRebalance returns slots to move so that each node serves almost the same number of slots
+// 每个节点的目标 slot 数为 slotCount/len(nodes)，多出的 slot 从节点 slot 列表的尾部取出，分配给不足的节点
+func planRebalance(slots [slotCount]string, nodes []string) []*slotMove {
+	sort.Strings(nodes)
+	target := make(map[string]int)
+	for i, node := range nodes {
+		target[node] = slotCount / len(nodes)
+		if i < slotCount%len(nodes) {
+			target[node]++
+		}
+	}
+	owned := make(map[string][]uint32)
+	owners := make([]string, 0)
+	for i, node := range slots {
+		if _, ok := owned[node]; !ok {
+			owners = append(owners, node)
+		}
+		owned[node] = append(owned[node], uint32(i))
+	}
+	sort.Strings(owners)
+
+	// 收集多出来的 slot，不在节点列表中的节点(以及未分配的 slot)需要交出全部 slot
+	spare := make([]*slotMove, 0)
+	for _, owner := range owners {
+		ownedSlots := owned[owner]
+		keep, ok := target[owner]
+		if !ok || owner == "" {
+			keep = 0
+		}
+		if len(ownedSlots) <= keep {
+			continue
+		}
+		for _, slot := range ownedSlots[keep:] {
+			spare = append(spare, &slotMove{slot: slot, from: owner})
+		}
+	}
+
+	// 分配给 slot 不足的节点
+	moves := make([]*slotMove, 0, len(spare))
+	for _, node := range nodes {
+		need := target[node] - len(owned[node])
+		for ; need > 0 && len(spare) > 0; need-- {
+			move := spare[0]
+			spare = spare[1:]
+			move.to = node
+			moves = append(moves, move)
+		}
+	}
+	return moves
+}
+
+// rebalance executes the slot moves one by one
+func (cluster *ClusterDatabase) rebalance(moves []*slotMove) {
+	defer cluster.rebalancing.Set(false)
+	logger.Info(fmt.Sprintf("rebalance started, %d slots to move", len(moves)))
+	for i, move := range moves {
+		err := cluster.migrateSlot(move)
+		if err != nil {
+			logger.Error(fmt.Sprintf("rebalance stopped, migrate slot %d from %s to %s failed: %v",
+				move.slot, move.from, move.to, err))
+			return
+		}
+		if (i+1)%100 == 0 {
+			logger.Info(fmt.Sprintf("rebalance progress: %d/%d slots", i+1, len(moves)))
+		}
+	}
+	logger.Info("rebalance finished")
+}
+
+// migrateSlot moves all keys of the slot to the target node, then assigns the slot to the target node
+func (cluster *ClusterDatabase) migrateSlot(move *slotMove) error {
+	slot := strconv.Itoa(int(move.slot))
+	if move.from != "" {
+		err := replyError(cluster.callNode(move.to, 0, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "IMPORTING", move.from)))
+		if err != nil {
+			return err
+		}
+		err = replyError(cluster.callNode(move.from, 0, utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "MIGRATING", move.to)))
+		if err != nil {
+			return err
+		}
+		host, port := splitAddr(move.to)
+		for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+			db := strconv.Itoa(dbIndex)
+			for {
+				ret := cluster.callNode(move.from, dbIndex,
+					utils.ToCmdLine("CLUSTER", "GETKEYSINSLOT", slot, strconv.Itoa(migrateBatchSize)))
+				if err = replyError(ret); err != nil {
+					return err
+				}
+				keysReply, ok := ret.(*reply.MultiBulkReply)
+				if !ok || len(keysReply.Args) == 0 {
+					break
+				}
+				cmdLine := utils.ToCmdLine("MIGRATE", host, strconv.Itoa(port), "", db,
+					strconv.Itoa(migrateTimeout), "REPLACE", "KEYS")
+				cmdLine = append(cmdLine, keysReply.Args...)
+				if err = replyError(cluster.callNode(move.from, dbIndex, cmdLine)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// 通知所有节点 slot 的新归属，目标节点和源节点必须成功
+	setNode := utils.ToCmdLine("CLUSTER", "SETSLOT", slot, "NODE", move.to)
+	if err := replyError(cluster.callNode(move.to, 0, setNode)); err != nil {
+		return err
+	}
+	if move.from != "" {
+		if err := replyError(cluster.callNode(move.from, 0, setNode)); err != nil {
+			return err
+		}
+	}
+	for _, node := range cluster.topology.getNodes() {
+		if node == move.to || node == move.from {
+			continue
+		}
+		if err := replyError(cluster.callNode(node, 0, setNode)); err != nil {
+			logger.Warn(fmt.Sprintf("notify %s the owner of slot %d failed: %v", node, move.slot, err))
+		}
+	}
+	return nil
+}
+
+// callNode executes command on the given node in the given db
+// 与 relay 不同，在自身节点上执行时会经过集群路由，可以执行 CLUSTER SETSLOT 等集群命令
+func (cluster *ClusterDatabase) callNode(node string, dbIndex int, args [][]byte) resp.Reply {
+	conn := &connection.Connection{}
+	conn.SelectDB(dbIndex)
+	if node == cluster.self {
+		return cluster.exec(conn, args, true)
+	}
+	return cluster.relay(node, conn, args)
+}
+
+// replyError converts error reply to error
+func replyError(r resp.Reply) error {
+	if r == nil {
+		return errors.New("no reply")
+	}
+	if reply.IsErrorReply(r) {
+		return errors.New(errorMessage(r))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"strconv"
+)
+
+/*
+集群的两种工作模式
+1. proxy（默认）
+	key 不属于本节点时，由本节点将命令转发给负责的节点，再把回复返回给客户端。
+	客户端无需感知集群，但每条命令多一次节点间的网络往返。
+
+2. redirect
+	key 不属于本节点时，回复 -MOVED slot host:port，由支持集群协议的客户端直接访问目标节点。
+	slot 迁移过程中，key 已经不在源节点时回复 -ASK slot host:port，
+	客户端需要先向目标节点发送 ASKING，再发送原命令。
+*/
+
+// isNodeCommand returns whether the command is executed by current node regardless of keys
+func isNodeCommand(cmdName string) bool {
+	switch cmdName {
+	case "ping", "select", "readpolicy", "cluster", "asking", "readonly", "readwrite", "migrate", "restore-asking",
+		"psync", "replconf", "prepare", "commit", "rollback", "exec-local", "cache-get":
+		return true
+	}
+	return false
+}
+
+// servedByReplica returns whether current node, as a replica of the owner, could execute the command
+func (cluster *ClusterDatabase) servedByReplica(c resp.Connection, cmdName string, owner string) bool {
+	return c.IsReadOnly() && isReadOnlyCommand(cmdName) && cluster.topology.isReplicaOf(owner)
+}
+
+// locate returns the node which should execute command of the given keys
+// asking is true if the keys have been migrated to the importing node
+// 找到负责给定 key 的节点
+// 1. slot 属于本节点，且没有在迁出，由本节点执行
+// 2. slot 属于本节点，但正在迁出：key 都还在本节点则由本节点执行；key 都不在本节点，则需要到目标节点执行(ASK)
+// 3. slot 不属于本节点：客户端执行过 ASKING 且本节点正在迁入该 slot，由本节点执行；否则由 slot 的负责节点执行(MOVED)
+// slot 未分配或负责的节点已下线(FAIL)时回复 CLUSTERDOWN
+func (cluster *ClusterDatabase) locate(c resp.Connection, keys []string) (node string, asking bool, errReply resp.Reply) {
+	slot := getSlot(keys[0])
+	for _, key := range keys[1:] {
+		if getSlot(key) != slot {
+			return "", false, reply.MakeErrReply("CROSSSLOT Keys in request don't hash to the same slot")
+		}
+	}
+
+	owner := cluster.topology.pickNode(slot)
+	if owner == "" {
+		return "", false, reply.MakeErrReply("CLUSTERDOWN Hash slot not served")
+	}
+	if cluster.topology.isFailed(owner) {
+		return "", false, reply.MakeErrReply("CLUSTERDOWN The cluster is down")
+	}
+	if owner == cluster.self {
+		target, migrating := cluster.topology.getMigrating(slot)
+		if !migrating {
+			return cluster.self, false, nil
+		}
+		existed := cluster.db.Exec(c, utils.ToCmdLine(append([]string{"EXISTS"}, keys...)...))
+		intReply, ok := existed.(*reply.IntReply)
+		if !ok {
+			return "", false, existed
+		}
+		if intReply.Code == int64(len(keys)) {
+			return cluster.self, false, nil
+		}
+		if intReply.Code == 0 {
+			return target, true, nil
+		}
+		// 部分 key 已经迁出，无法在一个节点上执行
+		return "", false, reply.MakeErrReply("TRYAGAIN Multiple keys request during rehashing of slot")
+	}
+	if _, importing := cluster.topology.getImporting(slot); importing && c.IsAsking() {
+		return cluster.self, false, nil
+	}
+	return owner, false, nil
+}
+
+// execRedirect executes command in redirect mode
+// key 属于本节点时在本地执行，否则回复 MOVED/ASK
+func (cluster *ClusterDatabase) execRedirect(c resp.Connection, cmdName string, cmdLine [][]byte) resp.Reply {
+	keys := getRelatedKeys(cmdName, cmdLine)
+	if len(keys) == 0 {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	node, asking, errReply := cluster.locate(c, keys)
+	if errReply != nil {
+		return errReply
+	}
+	if node == cluster.self || (!asking && cluster.servedByReplica(c, cmdName, node)) {
+		return cluster.db.Exec(c, cmdLine)
+	}
+	slot := strconv.Itoa(int(getSlot(keys[0])))
+	if asking {
+		return reply.MakeErrReply("ASK " + slot + " " + node)
+	}
+	return reply.MakeErrReply("MOVED " + slot + " " + node)
+}
+
+// execAsking allows the next command to access the slot which is importing into current node
+// ASKING
+func execAsking(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	c.SetAsking(true)
+	return reply.MakeOkReply()
+}
+
+// execReadOnly allows read commands to be served by replicas
+// READONLY
+func execReadOnly(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	c.SetReadOnly(true)
+	return reply.MakeOkReply()
+}
+
+// execReadWrite resets READONLY
+// READWRITE
+func execReadWrite(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	c.SetReadOnly(false)
+	return reply.MakeOkReply()
+}
@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"github.com/jolestar/go-commons-pool/v2"
+	"go-redis/database"
+	"go-redis/datastruct/dict"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/client"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// makeTestCluster creates a node whose slots are assigned evenly to self and peers
+func makeTestCluster(self string, peers ...string) *ClusterDatabase {
+	return &ClusterDatabase{
+		self:           self,
+		topology:       newTopology(self, peers),
+		db:             database.NewStandaloneDatabase(),
+		peerConnection: make(map[string]*pool.ObjectPool),
+		peerMux:        make(map[string]*client.Client),
+		peerBreakers:   make(map[string]*breaker),
+		replication:    makeReplication(),
+		transactions:   dict.MakeSyncDict(),
+	}
+}
+
+// keyOn returns a key served by node
+func keyOn(cluster *ClusterDatabase, node string) string {
+	for i := 0; ; i++ {
+		key := "key" + strconv.Itoa(i)
+		if cluster.topology.pickNode(getSlot(key)) == node {
+			return key
+		}
+	}
+}
+
+func execAndCheck(t *testing.T, cluster *ClusterDatabase, c resp.Connection, expected string, cmd ...string) {
+	t.Helper()
+	result := cluster.Exec(c, utils.ToCmdLine(cmd...))
+	if errReply, ok := result.(reply.ErrorReply); ok {
+		if errReply.Error() != expected {
+			t.Errorf("%v: expected %q, got %q", cmd, expected, errReply.Error())
+		}
+		return
+	}
+	if expected != "" {
+		t.Errorf("%v: expected %q, got %s", cmd, expected, result.ToBytes())
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	cluster.redirect = true
+	c := connection.NewConn(nil)
+
+	local, remote := keyOn(cluster, self), keyOn(cluster, peer)
+	remoteSlot := strconv.Itoa(int(getSlot(remote)))
+	execAndCheck(t, cluster, c, "", "SET", local, "1")
+	execAndCheck(t, cluster, c, "MOVED "+remoteSlot+" "+peer, "GET", remote)
+	execAndCheck(t, cluster, c, "CROSSSLOT Keys in request don't hash to the same slot", "DEL", local, remote)
+	// 不涉及 key 的命令在本节点执行
+	execAndCheck(t, cluster, c, "", "PING")
+}
+
+func TestRedirectDuringMigration(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	cluster.redirect = true
+	c := connection.NewConn(nil)
+
+	tag := keyOn(cluster, self)
+	slot := getSlot(tag)
+	existing, migrated := "{"+tag+"}existing", "{"+tag+"}migrated"
+	execAndCheck(t, cluster, c, "", "SET", existing, "1")
+	cluster.topology.mu.Lock()
+	cluster.topology.migrating[slot] = peer
+	cluster.topology.mu.Unlock()
+	slotStr := strconv.Itoa(int(slot))
+	// 迁出中的 slot：key 还在本节点时在本地执行，已经迁出时回复 ASK
+	execAndCheck(t, cluster, c, "", "GET", existing)
+	execAndCheck(t, cluster, c, "ASK "+slotStr+" "+peer, "GET", migrated)
+	execAndCheck(t, cluster, c, "TRYAGAIN Multiple keys request during rehashing of slot", "DEL", existing, migrated)
+
+	// 迁入中的 slot：只有紧跟在 ASKING 之后的命令在本地执行
+	remote := keyOn(cluster, peer)
+	remoteSlot := getSlot(remote)
+	moved := "MOVED " + strconv.Itoa(int(remoteSlot)) + " " + peer
+	cluster.topology.mu.Lock()
+	cluster.topology.importing[remoteSlot] = peer
+	cluster.topology.mu.Unlock()
+	execAndCheck(t, cluster, c, moved, "GET", remote)
+	execAndCheck(t, cluster, c, "", "ASKING")
+	execAndCheck(t, cluster, c, "", "GET", remote)
+	execAndCheck(t, cluster, c, moved, "GET", remote)
+}
+
+func TestRedirectClusterDown(t *testing.T) {
+	self, peer := "127.0.0.1:6399", "127.0.0.1:6400"
+	cluster := makeTestCluster(self, peer)
+	cluster.redirect = true
+	c := connection.NewConn(nil)
+
+	remote := keyOn(cluster, peer)
+	cluster.topology.mu.Lock()
+	cluster.topology.nodes[peer].flags |= nodeFlagFail
+	cluster.topology.mu.Unlock()
+	execAndCheck(t, cluster, c, "CLUSTERDOWN The cluster is down", "GET", remote)
+
+	cluster.topology.mu.Lock()
+	cluster.topology.slots[getSlot(remote)] = ""
+	cluster.topology.mu.Unlock()
+	execAndCheck(t, cluster, c, "CLUSTERDOWN Hash slot not served", "GET", remote)
+}
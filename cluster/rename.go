@@ -2,27 +2,117 @@ package cluster
 
 import (
 	"go-redis/interface/resp"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
+	"strings"
 )
 
-// rename renames a key, the origin and the destination must within the same node
+/*
+跨节点的 RENAME/RENAMENX 通过 TCC 实现：
+	源节点   PREPARE txid RENAMEFROM src            锁住 src，回复 src 序列化后的值(DUMP)；提交时执行 DEL src
+	目标节点 PREPARE txid RENAMETO dest payload [NX] 锁住 dest，NX 时检查 dest 不存在；提交时执行 RESTORE dest 0 payload REPLACE
+*/
+
+// rename renames a key, the origin and the destination could be on different nodes
 // eg: rename k1 k2
 func rename(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
 	// 一定是 3个 参数
 	if len(args) != 3 {
 		return reply.MakeErrReply("ERR wrong number of arguments for 'rename' command")
 	}
+	return cluster.doRename(c, args, false)
+}
+
+// renameNx renames a key only if the destination does not exist
+// eg: renamenx k1 k2
+func renameNx(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeErrReply("ERR wrong number of arguments for 'renamenx' command")
+	}
+	return cluster.doRename(c, args, true)
+}
+
+func (cluster *ClusterDatabase) doRename(c resp.Connection, args [][]byte, nx bool) resp.Reply {
 	src := string(args[1])  // 修改前的 key
 	dest := string(args[2]) // 修改后的 key
+	if getSlot(src) == getSlot(dest) {
+		return cluster.relayByKeys(c, []string{src, dest}, args)
+	}
+
+	srcPeer := cluster.topology.pickNode(getSlot(src))   // 拿到 原节点 key，通过 slot 找到对应的节点
+	destPeer := cluster.topology.pickNode(getSlot(dest)) // 拿到 目标 key，通过 slot 找到对应的节点
+	if srcPeer == destPeer {
+		return cluster.relay(srcPeer, c, args)
+	}
 
-	srcPeer := cluster.peerPicker.PickNode(src)   // 拿到 原节点 key，通过一致性哈希找到对应的节点
-	destPeer := cluster.peerPicker.PickNode(dest) // 拿到 目标 key，通过一致性哈希找到对应的节点
+	// 先在源节点上 PREPARE 获取 src 的值，再在目标节点上 PREPARE
+	// 任意一步没有成功都回滚已经发送过 PREPARE 的节点，PREPARE 超时时参与者可能已经加锁
+	return cluster.retryTCC(func(txID string) (resp.Reply, bool) {
+		srcResult := cluster.requestPrepare(c, txID, srcPeer, utils.ToCmdLine("RenameFrom", src))
+		payload, ok := srcResult.(*reply.BulkReply)
+		if !ok {
+			cluster.requestRollback(c, txID, []string{srcPeer})
+			if reply.IsErrorReply(srcResult) {
+				return srcResult, isLockConflict(srcResult)
+			}
+			return reply.MakeErrReply("ERR unexpected reply of RENAMEFROM from " + srcPeer), false
+		}
+		destCmdLine := utils.ToCmdLine("RenameTo", dest)
+		destCmdLine = append(destCmdLine, payload.Arg)
+		if nx {
+			destCmdLine = append(destCmdLine, []byte("NX"))
+		}
+		destResult := cluster.requestPrepare(c, txID, destPeer, destCmdLine)
+		if !reply.IsOKReply(destResult) {
+			// dest 已存在时(RENAMENX)回复 0
+			cluster.requestRollback(c, txID, []string{srcPeer, destPeer})
+			return destResult, isLockConflict(destResult)
+		}
+		if _, errReply := cluster.requestCommit(c, txID, []string{srcPeer, destPeer}); errReply != nil {
+			return errReply, false
+		}
+		if nx {
+			return reply.MakeIntReply(1), false
+		}
+		return reply.MakeOkReply(), false
+	})
+}
+
+// translateRenameFrom translates RENAMEFROM src into DEL src
+func translateRenameFrom(args [][]byte) CmdLine {
+	if len(args) != 2 {
+		return nil
+	}
+	return utils.ToCmdLine2("DEL", args[1])
+}
+
+// prepareRenameFrom returns the serialized value of src, src has been locked
+func prepareRenameFrom(cluster *ClusterDatabase, tx *transaction, args [][]byte) (resp.Reply, bool) {
+	result := cluster.db.ExecWithLock(tx.conn(), utils.ToCmdLine2("DUMP", args[1]))
+	if _, ok := result.(*reply.BulkReply); !ok {
+		return reply.MakeErrReply("ERR no such key"), false
+	}
+	return result, true
+}
+
+// translateRenameTo translates RENAMETO dest payload [NX] into RESTORE dest 0 payload REPLACE
+func translateRenameTo(args [][]byte) CmdLine {
+	if len(args) != 3 && len(args) != 4 {
+		return nil
+	}
+	return [][]byte{[]byte("RESTORE"), args[1], []byte("0"), args[2], []byte("REPLACE")}
+}
 
-	// 如果 原节点和 目标节点 不同，简单处理，直接报错
-	// 也可以实现另一套逻辑，将原节点数据删除，再去新节点数据覆盖
-	if srcPeer != destPeer {
-		return reply.MakeErrReply("ERR rename must within one slot in cluster mode")
+// prepareRenameTo checks whether dest exists if NX is given, dest has been locked
+func prepareRenameTo(cluster *ClusterDatabase, tx *transaction, args [][]byte) (resp.Reply, bool) {
+	if len(args) == 4 {
+		if strings.ToLower(string(args[3])) != "nx" {
+			return reply.MakeSyntaxErrReply(), false
+		}
+		existed := cluster.db.ExecWithLock(tx.conn(), utils.ToCmdLine2("EXISTS", args[1]))
+		if intReply, ok := existed.(*reply.IntReply); !ok || intReply.Code > 0 {
+			return reply.MakeIntReply(0), false
+		}
 	}
-	// 调用转发
-	return cluster.relay(srcPeer, c, args)
+	return reply.MakeOkReply(), true
 }
@@ -0,0 +1,54 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRenameRollback(t *testing.T) {
+	var prepares, rollbacks atomic.Int32
+	var conflicts atomic.Int32
+	conflicts.Store(2)
+	peer := startFakePeer(t, func(args [][]byte) resp.Reply {
+		switch strings.ToLower(string(args[0])) {
+		case "prepare":
+			prepares.Add(1)
+			// 前两次锁冲突，之后目标节点的检查未通过
+			if conflicts.Add(-1) >= 0 {
+				return lockConflictReply
+			}
+			return reply.MakeIntReply(0)
+		case "rollback":
+			rollbacks.Add(1)
+		}
+		return nil
+	})
+	cluster := makeTestCluster("127.0.0.1:6399", peer)
+	c := connection.NewConn(nil)
+	src, dest := keyOn(cluster, cluster.self), keyOn(cluster, peer)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", src, "1"))
+
+	ret := renameNx(cluster, c, utils.ToCmdLine("RENAMENX", src, dest))
+	if intReply, ok := ret.(*reply.IntReply); !ok || intReply.Code != 0 {
+		t.Fatalf("RENAMENX should reply 0, got %s", ret.ToBytes())
+	}
+	if prepares.Load() != 3 {
+		t.Errorf("transaction should be retried after lock conflict, prepares: %d", prepares.Load())
+	}
+	if rollbacks.Load() != 3 {
+		t.Errorf("destination should be rolled back in each attempt, rollbacks: %d", rollbacks.Load())
+	}
+	// 源节点已经回滚，src 没有被删除并且锁已经释放
+	value, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", src)).(*reply.BulkReply)
+	if !ok || string(value.Arg) != "1" {
+		t.Fatal("src should be kept")
+	}
+	if ret = execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx", "DEL", src)); !reply.IsOKReply(ret) {
+		t.Errorf("src should be unlocked, got %s", ret.ToBytes())
+	}
+}
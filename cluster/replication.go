@@ -0,0 +1,478 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+主从复制
+从节点连接主节点的服务端口并发送 PSYNC，主节点依次回复：
+	+FULLRESYNC <主节点 id> <offset>
+	快照：SELECT db、RESTORE key 0 payload REPLACE
+	+CONTINUE
+	之后执行的所有写命令，与写入 AOF 的命令相同
+从节点每秒发送 REPLCONF ACK <offset>，主节点不回复
+
+复制偏移量(offset)是 +CONTINUE 之后命令流的字节数(不含 SELECT)，主从两端分别累加。
+从节点晋升为主节点后偏移量继续累加，故障转移时偏移量最大的从节点优先发起选举。
+快照期间阻塞所有命令，快照对应的正是 +FULLRESYNC 中的 offset，之后的写命令只出现在命令流中
+*/
+
+const (
+	replQueueLimit    = 256 << 20   // 每个从节点待发送命令的最大字节数，超过时断开从节点，由其重新全量同步
+	replAckInterval   = time.Second // 从节点发送 REPLCONF ACK 的周期
+	replRetryInterval = time.Second // 从节点与主节点断开后重连的间隔
+)
+
+// replPayload is a write command in replication stream
+type replPayload struct {
+	dbIndex int
+	data    []byte
+}
+
+// replicaLink is the connection from a replica, used by master
+type replicaLink struct {
+	conn      resp.Connection
+	dbIndex   int   // 从节点当前选择的 db
+	ackOffset int64 // 从节点确认的复制偏移量
+
+	// 待发送的命令，发送快照期间同样会积累
+	mu          sync.Mutex
+	cond        *sync.Cond
+	queue       []*replPayload
+	queuedBytes int
+	closed      bool
+}
+
+func makeReplicaLink(c resp.Connection) *replicaLink {
+	link := &replicaLink{conn: c}
+	link.cond = sync.NewCond(&link.mu)
+	return link
+}
+
+// push appends command to queue, returns false if queued bytes exceed replQueueLimit
+func (link *replicaLink) push(p *replPayload) bool {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	if link.queuedBytes+len(p.data) > replQueueLimit {
+		return false
+	}
+	link.queue = append(link.queue, p)
+	link.queuedBytes += len(p.data)
+	link.cond.Signal()
+	return true
+}
+
+// pop waits for commands in queue, returns false after link is closed
+func (link *replicaLink) pop() ([]*replPayload, bool) {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	for len(link.queue) == 0 && !link.closed {
+		link.cond.Wait()
+	}
+	if link.closed {
+		return nil, false
+	}
+	queue := link.queue
+	link.queue = nil
+	link.queuedBytes = 0
+	return queue, true
+}
+
+func (link *replicaLink) close() {
+	link.mu.Lock()
+	defer link.mu.Unlock()
+	link.closed = true
+	link.queue = nil
+	link.queuedBytes = 0
+	link.cond.Broadcast()
+}
+
+// replication stores the replication state of current node
+type replication struct {
+	mu       sync.Mutex
+	offset   int64                            // 主节点：写入命令流的字节数；从节点：已执行的命令流字节数
+	replicas map[resp.Connection]*replicaLink // 主节点的从节点连接
+	master   string                           // 正在复制的主节点，为空表示本节点没有复制任何节点
+	linkUp   bool                             // 是否已完成全量同步
+	stop     chan struct{}                    // 停止复制当前的主节点
+	conn     net.Conn                         // 与主节点的连接
+}
+
+func makeReplication() *replication {
+	return &replication{
+		replicas: make(map[resp.Connection]*replicaLink),
+	}
+}
+
+// getOffset returns replication offset of current node
+func (r *replication) getOffset() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.offset
+}
+
+// feed sends write command to all replicas
+// 从节点执行主节点发来的命令时也会调用，此时不再向下级复制
+func (r *replication) feed(dbIndex int, cmdLine [][]byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != "" {
+		return
+	}
+	data := reply.MakeMultiBulkReply(cmdLine).ToBytes()
+	r.offset += int64(len(data))
+	for conn, link := range r.replicas {
+		if !link.push(&replPayload{dbIndex: dbIndex, data: data}) {
+			// 从节点跟不上写入速度，断开连接，由从节点重新全量同步
+			delete(r.replicas, conn)
+			link.close()
+			go closeConnection(conn)
+			logger.Warn("replication queue of replica is full, disconnect it")
+		}
+	}
+}
+
+// addReplica registers connection of replica, returns the offset which replication stream starts from
+func (r *replication) addReplica(c resp.Connection) (*replicaLink, int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != "" {
+		return nil, 0, errors.New("ERR chained replication is not supported")
+	}
+	link := makeReplicaLink(c)
+	r.replicas[c] = link
+	return link, r.offset, nil
+}
+
+// removeReplica unregisters connection of replica
+func (r *replication) removeReplica(c resp.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if link, ok := r.replicas[c]; ok {
+		delete(r.replicas, c)
+		link.close()
+	}
+}
+
+// serve writes commands in queue to replica
+func (link *replicaLink) serve() {
+	defer closeConnection(link.conn)
+	for {
+		queue, ok := link.pop()
+		if !ok {
+			return
+		}
+		for _, p := range queue {
+			if p.dbIndex != link.dbIndex {
+				selectCmd := reply.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
+				if err := link.conn.Write(selectCmd); err != nil {
+					return
+				}
+				link.dbIndex = p.dbIndex
+			}
+			if err := link.conn.Write(p.data); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// closeConnection closes client connection, the handler will clean it up
+func closeConnection(c resp.Connection) {
+	if closer, ok := c.(io.Closer); ok {
+		_ = closer.Close()
+	}
+}
+
+// blockingWriter is implemented by connection.Connection, see WriteBlocking
+type blockingWriter interface {
+	WriteBlocking([]byte) error
+}
+
+// replicaMarker is implemented by connection.Connection, see SetReplica
+type replicaMarker interface {
+	SetReplica(bool)
+}
+
+// writeSnapshot writes data of snapshot to replica, waits if replica reads slowly
+// 快照可能远大于 client-output-buffer-limit，等待从节点读取，不受输出缓冲区限制
+func writeSnapshot(c resp.Connection, b []byte) error {
+	if writer, ok := c.(blockingWriter); ok {
+		return writer.WriteBlocking(b)
+	}
+	return c.Write(b)
+}
+
+// execPSync sends snapshot and subsequent write commands to replica
+// PSYNC replicationid offset
+// 只支持全量同步，参数会被忽略
+func execPSync(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	// 在快照的同时注册从节点，快照之后的写命令进入从节点的队列
+	var link *replicaLink
+	var offset int64
+	var err error
+	snapshot := cluster.db.Snapshot(func() {
+		link, offset, err = cluster.replication.addReplica(c)
+	})
+	if err != nil {
+		return reply.MakeErrReply(err.Error())
+	}
+	// 已认证的从节点的连接按 replica 类型限制输出缓冲区
+	if marker, ok := c.(replicaMarker); ok {
+		marker.SetReplica(true)
+	}
+	header := "+FULLRESYNC " + nodeID(cluster.self) + " " + strconv.FormatInt(offset, 10) + reply.CRLF
+	if err = writeSnapshot(c, []byte(header)); err != nil {
+		return &reply.NoReply{}
+	}
+
+	// 发送快照
+	for dbIndex, entries := range snapshot {
+		if len(entries) == 0 {
+			continue
+		}
+		if dbIndex != link.dbIndex {
+			selectCmd := utils.ToCmdLine("SELECT", strconv.Itoa(dbIndex))
+			if err = writeSnapshot(c, reply.MakeMultiBulkReply(selectCmd).ToBytes()); err != nil {
+				return &reply.NoReply{}
+			}
+			link.dbIndex = dbIndex
+		}
+		for i, entry := range entries {
+			restoreCmd := [][]byte{[]byte("RESTORE"), []byte(entry.Key), []byte("0"), entry.Payload, []byte("REPLACE")}
+			if err = writeSnapshot(c, reply.MakeMultiBulkReply(restoreCmd).ToBytes()); err != nil {
+				return &reply.NoReply{}
+			}
+			// 已发送的数据不再占用内存
+			entries[i] = database.SnapshotEntry{}
+		}
+	}
+	if err = writeSnapshot(c, []byte("+CONTINUE"+reply.CRLF)); err != nil {
+		return &reply.NoReply{}
+	}
+	logger.Info(fmt.Sprintf("full resync with replica finished, offset %d", offset))
+	go link.serve()
+	return &reply.NoReply{}
+}
+
+// info returns the fields of INFO replication
+// 从节点的 ip、port 为复制连接的地址
+func (r *replication) info() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master != "" {
+		host, port, _ := net.SplitHostPort(r.master)
+		status := "down"
+		if r.linkUp {
+			status = "up"
+		}
+		return []string{
+			"role:slave",
+			"master_host:" + host,
+			"master_port:" + port,
+			"master_link_status:" + status,
+			"slave_repl_offset:" + strconv.FormatInt(r.offset, 10),
+			"connected_slaves:0",
+			"master_repl_offset:" + strconv.FormatInt(r.offset, 10),
+		}
+	}
+	fields := []string{
+		"role:master",
+		"connected_slaves:" + strconv.Itoa(len(r.replicas)),
+	}
+	addrs := make([]string, 0, len(r.replicas))
+	offsets := make(map[string]int64, len(r.replicas))
+	for conn, link := range r.replicas {
+		addr := conn.RemoteAddr().String()
+		addrs = append(addrs, addr)
+		offsets[addr] = link.ackOffset
+	}
+	sort.Strings(addrs)
+	for i, addr := range addrs {
+		host, port, _ := net.SplitHostPort(addr)
+		fields = append(fields, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=%d", i, host, port, offsets[addr]))
+	}
+	return append(fields, "master_repl_offset:"+strconv.FormatInt(r.offset, 10))
+}
+
+// execReplConf handles REPLCONF from replica
+// REPLCONF ACK offset
+func execReplConf(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) == 3 && strings.ToLower(string(args[1])) == "ack" {
+		offset, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err == nil {
+			cluster.replication.mu.Lock()
+			if link, ok := cluster.replication.replicas[c]; ok {
+				link.ackOffset = offset
+			}
+			cluster.replication.mu.Unlock()
+		}
+		return &reply.NoReply{}
+	}
+	return reply.MakeOkReply()
+}
+
+// reconcileReplication starts or stops replication according to the master of current node in topology
+// 节点角色通过 CLUSTER REPLICATE、故障转移、gossip 改变，由集群总线的定时任务调用
+func (cluster *ClusterDatabase) reconcileReplication() {
+	cluster.topology.mu.RLock()
+	master := cluster.topology.nodes[cluster.self].master
+	cluster.topology.mu.RUnlock()
+
+	r := cluster.replication
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.master == master {
+		return
+	}
+	if r.master != "" {
+		logger.Info("stop replicating " + r.master)
+		close(r.stop)
+		if r.conn != nil {
+			_ = r.conn.Close()
+			r.conn = nil
+		}
+		r.linkUp = false
+	}
+	r.master = master
+	if master != "" {
+		logger.Info("start replicating " + master)
+		r.stop = make(chan struct{})
+		go cluster.replicate(master, r.stop)
+	}
+}
+
+// replicate keeps replicating the master until stopped
+func (cluster *ClusterDatabase) replicate(master string, stop chan struct{}) {
+	for {
+		err := cluster.syncWithMaster(master, stop)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if err != nil {
+			logger.Warn(fmt.Sprintf("replication with %s broken: %v", master, err))
+		}
+		time.Sleep(replRetryInterval)
+	}
+}
+
+// syncWithMaster receives snapshot and write commands from master
+func (cluster *ClusterDatabase) syncWithMaster(master string, stop chan struct{}) error {
+	conn, err := dialPeer(master, getNodeTimeout())
+	if err != nil {
+		return err
+	}
+	r := cluster.replication
+	r.mu.Lock()
+	if r.stop != stop {
+		r.mu.Unlock()
+		_ = conn.Close()
+		return nil
+	}
+	r.conn = conn
+	r.linkUp = false
+	r.mu.Unlock()
+	defer conn.Close()
+
+	// 主节点配置了 cluster-auth 时，认证后才能执行 PSYNC
+	if auth := getClusterAuth(); auth != "" {
+		if _, err = conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("AUTH", auth)).ToBytes()); err != nil {
+			return err
+		}
+	}
+	if _, err = conn.Write(reply.MakeMultiBulkReply(utils.ToCmdLine("PSYNC", "?", "-1")).ToBytes()); err != nil {
+		return err
+	}
+	ackDone := make(chan struct{})
+	defer close(ackDone)
+
+	fakeConn := &connection.Connection{}
+	synced := false
+	for payload := range parser.ParseStream(conn) {
+		if payload.Err != nil {
+			return payload.Err
+		}
+		switch data := payload.Data.(type) {
+		case *reply.StatusReply:
+			fields := strings.Fields(data.Status)
+			if len(fields) == 3 && fields[0] == "FULLRESYNC" {
+				offset, err := strconv.ParseInt(fields[2], 10, 64)
+				if err != nil {
+					return errors.New("invalid FULLRESYNC offset: " + fields[2])
+				}
+				// 全量同步前清空本节点的数据
+				for dbIndex := 0; dbIndex < config.Properties.Databases; dbIndex++ {
+					fakeConn.SelectDB(dbIndex)
+					cluster.db.Exec(fakeConn, utils.ToCmdLine("FLUSHDB"))
+				}
+				fakeConn.SelectDB(0)
+				r.mu.Lock()
+				r.offset = offset
+				r.mu.Unlock()
+			} else if data.Status == "CONTINUE" {
+				synced = true
+				r.mu.Lock()
+				r.linkUp = true
+				r.mu.Unlock()
+				logger.Info("full resync with " + master + " finished")
+				go sendReplAck(conn, r, ackDone)
+			}
+		case *reply.MultiBulkReply:
+			if len(data.Args) == 0 {
+				continue
+			}
+			if strings.ToLower(string(data.Args[0])) == "select" && len(data.Args) == 2 {
+				dbIndex, err := strconv.Atoi(string(data.Args[1]))
+				if err == nil && dbIndex >= 0 && dbIndex < config.Properties.Databases {
+					fakeConn.SelectDB(dbIndex)
+				}
+				continue
+			}
+			cluster.db.Exec(fakeConn, data.Args)
+			if synced {
+				r.mu.Lock()
+				r.offset += int64(len(data.ToBytes()))
+				r.mu.Unlock()
+			}
+		case reply.ErrorReply:
+			return errors.New(data.Error())
+		}
+	}
+	return io.EOF
+}
+
+// sendReplAck sends REPLCONF ACK to master periodically
+func sendReplAck(conn net.Conn, r *replication, done chan struct{}) {
+	ticker := time.NewTicker(replAckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		ack := utils.ToCmdLine("REPLCONF", "ACK", strconv.FormatInt(r.getOffset(), 10))
+		if _, err := conn.Write(reply.MakeMultiBulkReply(ack).ToBytes()); err != nil {
+			return
+		}
+	}
+}
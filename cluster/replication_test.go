@@ -0,0 +1,120 @@
+package cluster
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplicaLinkQueueLimit(t *testing.T) {
+	link := makeReplicaLink(nil)
+	payload := &replPayload{data: make([]byte, replQueueLimit/4)}
+	for i := 0; i < 4; i++ {
+		if !link.push(payload) {
+			t.Fatalf("push %d should succeed", i)
+		}
+	}
+	if link.push(&replPayload{data: []byte("x")}) {
+		t.Fatal("push should fail when queued bytes exceed limit")
+	}
+	queue, ok := link.pop()
+	if !ok || len(queue) != 4 {
+		t.Fatalf("pop should return all queued commands, got %d", len(queue))
+	}
+	if !link.push(&replPayload{data: []byte("x")}) {
+		t.Fatal("push should succeed after queue is drained")
+	}
+	link.close()
+	if _, ok = link.pop(); ok {
+		t.Fatal("pop should fail after link is closed")
+	}
+}
+
+// readCommand reads a command or status from replication stream
+func readCommand(t *testing.T, ch <-chan *parser.Payload) string {
+	select {
+	case payload := <-ch:
+		if payload.Err != nil {
+			t.Fatal(payload.Err)
+		}
+		switch r := payload.Data.(type) {
+		case *reply.StatusReply:
+			return r.Status
+		case *reply.MultiBulkReply:
+			args := make([]string, len(r.Args))
+			for i, arg := range r.Args {
+				args[i] = string(arg)
+			}
+			return strings.Join(args, " ")
+		}
+		t.Fatalf("unexpected reply %q", payload.Data.ToBytes())
+	case <-time.After(time.Second):
+		t.Fatal("read replication stream timeout")
+	}
+	return ""
+}
+
+func TestPSyncSnapshot(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399")
+	cluster.db.AddWriteListener(cluster.replication.feed)
+	c := connection.NewConn(nil)
+	c.SelectDB(3)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", "a", "1"))
+
+	offset := cluster.replication.getOffset()
+	local, remote := net.Pipe()
+	replica := connection.NewConn(local)
+	defer replica.Close()
+	defer remote.Close()
+	go execPSync(cluster, replica, utils.ToCmdLine("PSYNC", "?", "-1"))
+	ch := parser.ParseStream(remote)
+
+	if header := readCommand(t, ch); !strings.HasPrefix(header, "FULLRESYNC ") || !strings.HasSuffix(header, " "+strconv.FormatInt(offset, 10)) {
+		t.Fatalf("unexpected header %q", header)
+	}
+	if cmd := readCommand(t, ch); cmd != "SELECT 3" {
+		t.Fatalf("expect SELECT 3, got %q", cmd)
+	}
+	if cmd := readCommand(t, ch); !strings.HasPrefix(cmd, "RESTORE a 0 ") {
+		t.Fatalf("expect RESTORE a, got %q", cmd)
+	}
+	if cmd := readCommand(t, ch); cmd != "CONTINUE" {
+		t.Fatalf("expect CONTINUE, got %q", cmd)
+	}
+	if !replica.IsReplica() {
+		t.Error("connection should be marked as replica after PSYNC is accepted")
+	}
+
+	// 快照之后的写命令只出现在命令流中
+	c.SelectDB(0)
+	cluster.db.Exec(c, utils.ToCmdLine("SET", "b", "2"))
+	if cmd := readCommand(t, ch); cmd != "SELECT 0" {
+		t.Fatalf("expect SELECT 0, got %q", cmd)
+	}
+	if cmd := readCommand(t, ch); !strings.EqualFold(cmd, "SET b 2") {
+		t.Fatalf("expect SET b 2, got %q", cmd)
+	}
+	offset += int64(len(reply.MakeMultiBulkReply(utils.ToCmdLine("SET", "b", "2")).ToBytes()))
+	if got := cluster.replication.getOffset(); got != offset {
+		t.Errorf("expect offset %d, got %d", offset, got)
+	}
+}
+
+func TestPSyncRequiresAuth(t *testing.T) {
+	setupClusterAuth(t, "secret")
+	cluster := makeTestCluster("127.0.0.1:6399")
+	c := connection.NewConn(nil)
+	ret := cluster.Exec(c, utils.ToCmdLine("PSYNC", "?", "-1"))
+	if !reply.IsErrorReply(ret) || !strings.HasPrefix(errorMessage(ret), "NOAUTH") {
+		t.Fatalf("PSYNC should require authentication, got %s", ret.ToBytes())
+	}
+	if c.IsReplica() {
+		t.Error("unauthenticated connection should not be marked as replica")
+	}
+}
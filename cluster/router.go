@@ -1,6 +1,9 @@
 package cluster
 
-import "go-redis/interface/resp"
+import (
+	"go-redis/interface/resp"
+	"strings"
+)
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
@@ -10,19 +13,52 @@ func makeRouter() map[string]CmdFunc {
 	routerMap["ping"] = ping         // PING
 	routerMap["select"] = execSelect // SELECT 1
 
-	routerMap["del"] = del // DEL k1 k2 k3
+	routerMap["readpolicy"] = execReadPolicy // READPOLICY [primary|prefer-replica|round-robin|lowest-latency]
 
-	routerMap["exists"] = defaultFunc // EXISTS k1
-	routerMap["type"] = defaultFunc   // TYPE k1
-	routerMap["set"] = defaultFunc    // SET k1 v1
-	routerMap["setnx"] = defaultFunc  // SETNX k1 v1
-	routerMap["get"] = defaultFunc    // GET k1
-	routerMap["getset"] = defaultFunc // GETSET k1 v1
+	routerMap["del"] = del       // DEL k1 k2 k3
+	routerMap["unlink"] = del    // UNLINK k1 k2 k3
+	routerMap["mset"] = mset     // MSET k1 v1 k2 v2
+	routerMap["msetnx"] = msetnx // MSETNX k1 v1 k2 v2
+	routerMap["mget"] = mget     // MGET k1 k2
 
-	routerMap["rename"] = rename   // RENAME k1 k2
-	routerMap["renamenx"] = rename // RENAMENX k1 k2 这个只负责转发，不需要做任何处理
+	routerMap["exists"] = sumByNode    // EXISTS k1 k2 k3
+	routerMap["touch"] = sumByNode     // TOUCH k1 k2 k3
+	routerMap["strlen"] = defaultFunc  // STRLEN k1
+	routerMap["type"] = defaultFunc    // TYPE k1
+	routerMap["set"] = defaultFunc     // SET k1 v1
+	routerMap["setnx"] = defaultFunc   // SETNX k1 v1
+	routerMap["get"] = execGet         // GET k1, 开启 cluster-near-cache 时热点 key 由本节点缓存
+	routerMap["getset"] = defaultFunc  // GETSET k1 v1
+	routerMap["dump"] = defaultFunc    // DUMP k1
+	routerMap["restore"] = defaultFunc // RESTORE k1 0 payload
 
-	routerMap["flushdb"] = flushDB // FLUSHDB
+	routerMap["rename"] = rename     // RENAME k1 k2
+	routerMap["renamenx"] = renameNx // RENAMENX k1 k2
+
+	routerMap["flushdb"] = flushDB     // FLUSHDB
+	routerMap["flushall"] = flushAll   // FLUSHALL
+	routerMap["keys"] = keys           // KEYS pattern
+	routerMap["dbsize"] = dbSize       // DBSIZE
+	routerMap["randomkey"] = randomKey // RANDOMKEY
+	routerMap["scan"] = scan           // SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+
+	routerMap["cluster"] = execCluster     // CLUSTER SLOTS
+	routerMap["asking"] = execAsking       // ASKING
+	routerMap["readonly"] = execReadOnly   // READONLY
+	routerMap["readwrite"] = execReadWrite // READWRITE
+
+	routerMap["migrate"] = execMigrate              // MIGRATE host port "" 0 5000 KEYS k1 k2
+	routerMap["restore-asking"] = execRestoreAsking // RESTORE-ASKING k1 0 payload, 由 MIGRATE 发送
+	routerMap["psync"] = execPSync                  // PSYNC ? -1, 从节点全量同步
+	routerMap["replconf"] = execReplConf            // REPLCONF ACK offset
+	routerMap["cache-get"] = execCacheGet           // CACHE-GET node k1, 读取并跟踪热点 key
+
+	// 分布式事务(TCC)的内部命令，由协调者节点发送
+	routerMap["prepare"] = execPrepare   // PREPARE txid cmd args...
+	routerMap["commit"] = execCommit     // COMMIT txid
+	routerMap["rollback"] = execRollback // ROLLBACK txid
+
+	routerMap["exec-local"] = execLocal // EXEC-LOCAL cmd args..., 群发的命令只在目标节点本地执行
 
 	return routerMap
 }
@@ -31,8 +67,22 @@ func makeRouter() map[string]CmdFunc {
 // 默认的转发方法，大多数的命令可能都需要转发
 // GET Key / SET K1 V1
 func defaultFunc(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
-	// 只需要拿到 key，通过一致性哈希就可以找到对应的节点
-	key := string(args[1])
-	peer := cluster.peerPicker.PickNode(key)
+	// 通过命令的 key 规格拿到 key，通过 key 所在的 slot 就可以找到对应的节点
+	return cluster.relayByKeySpec(c, args)
+}
+
+// relayByKeys relays command to the node which serves the given keys, keys must be in the same slot
+func (cluster *ClusterDatabase) relayByKeys(c resp.Connection, keys []string, args [][]byte) resp.Reply {
+	peer, asking, errReply := cluster.locate(c, keys)
+	if errReply != nil {
+		return errReply
+	}
+	// 从节点上执行过 READONLY 的连接，读命令直接在本节点执行
+	if !asking && cluster.servedByReplica(c, strings.ToLower(string(args[0])), peer) {
+		return cluster.db.Exec(c, args)
+	}
+	if asking {
+		return cluster.relayAsking(peer, c, args)
+	}
 	return cluster.relay(peer, c, args)
 }
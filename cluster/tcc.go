@@ -0,0 +1,440 @@
+package cluster
+
+import (
+	"fmt"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+分布式事务 TCC(Try-Confirm-Cancel)
+涉及多个节点的写命令(如 DEL k1 k2、MSET k1 v1 k2 v2)由收到命令的节点作为协调者，
+按 key 所在的节点拆分命令，再通过以下内部命令保证所有节点要么全部执行、要么全部不执行：
+	PREPARE txid cmd args...  对命令相关的 key 加锁，检查命令能否执行，记录回滚日志
+	                          key 被其他事务锁住时不等待，直接失败，避免不同节点上的事务相互等待
+	COMMIT txid               执行命令并释放锁，已提交时回复提交时的结果
+	ROLLBACK txid             未提交时直接释放锁；已提交时执行回滚日志，恢复 key 原来的值
+任意节点 PREPARE 失败时，协调者向所有节点发送 ROLLBACK。
+COMMIT 失败的节点最多重试 maxCommitAttempts 次，已提交的节点不回滚：提交后锁已经释放，回滚日志会覆盖其他客户端之后的写入。
+重试后仍然失败时事务只在部分节点上生效(如参与者已超时回滚或者无法连接)，协调者向客户端回复错误，
+未提交的参与者在 maxLockTime 后自动回滚并释放锁。
+PREPARE 因为锁冲突失败时，协调者回滚后随机等待一段时间，使用新的事务 id 重试，最多 maxPrepareAttempts 次。
+参与者在 PREPARE 之后超过 maxLockTime 仍未收到 COMMIT，自动回滚并释放锁，避免协调者宕机时 key 被永久锁住。
+*/
+
+const (
+	maxLockTime        = 3 * time.Second       // PREPARE 之后持有锁的最长时间，超时自动回滚
+	maxPrepareAttempts = 5                     // PREPARE 因锁冲突失败时最多尝试的次数
+	prepareRetryDelay  = 5 * time.Millisecond  // 重试前随机等待的时间上限，随尝试次数增加
+	maxCommitAttempts  = 3                     // COMMIT 失败时最多尝试的次数
+	commitRetryDelay   = 50 * time.Millisecond // COMMIT 失败后重试前等待的时间
+	waitBeforeCleanTx  = 2 * maxLockTime       // 事务结束后保留一段时间，协调者重试 COMMIT 时回复提交的结果
+)
+
+// lockConflictReply is replied by PREPARE if keys are locked by another transaction
+var lockConflictReply = reply.MakeErrReply("ERR keys are locked by another transaction, try again later")
+
+// transaction status
+const (
+	createdStatus    = 0
+	preparedStatus   = 1
+	committedStatus  = 2
+	rolledBackStatus = 3
+)
+
+// transaction stores state of a transaction on participant node
+// 参与者节点上的事务状态
+type transaction struct {
+	id        string   // 事务 id，由协调者生成
+	cmdLine   [][]byte // 提交时执行的命令
+	cluster   *ClusterDatabase
+	dbIndex   int        // 命令所在的 db
+	writeKeys []string   // 加写锁的 key
+	readKeys  []string   // 加读锁的 key
+	undoLog   []CmdLine  // 回滚日志，提交后回滚时依次执行
+	result    resp.Reply // 提交时执行命令的结果，重复提交时回复
+	status    int8       // 事务状态
+	locked    bool       // 是否持有 key 的锁
+	mu        sync.Mutex // 提交、回滚、超时回滚可能同时发生
+	timer     *time.Timer
+}
+
+// prepareFunc validates command in PREPARE, the keys of tx.cmdLine are locked when it is called
+// returns reply to coordinator and whether the transaction could be committed
+// 部分命令在 PREPARE 阶段需要检查能否执行或者返回数据，检查时 key 已经加锁
+type prepareFunc func(cluster *ClusterDatabase, tx *transaction, args [][]byte) (resp.Reply, bool)
+
+// preparer describes how to prepare a command which needs special handling
+type preparer struct {
+	translate func(args [][]byte) CmdLine // 将 PREPARE 中的命令转换为提交时执行的命令，为空表示直接执行原命令
+	check     prepareFunc
+}
+
+// preparers of commands, other commands are executed as they are without checking
+var preparers = map[string]*preparer{
+	"msetnx":     {check: prepareMSetNX},
+	"renamefrom": {translate: translateRenameFrom, check: prepareRenameFrom},
+	"renameto":   {translate: translateRenameTo, check: prepareRenameTo},
+}
+
+// makeTransaction creates a transaction on participant node
+func makeTransaction(cluster *ClusterDatabase, id string, dbIndex int, cmdLine [][]byte) *transaction {
+	return &transaction{
+		id:      id,
+		cmdLine: cmdLine,
+		cluster: cluster,
+		dbIndex: dbIndex,
+		status:  createdStatus,
+	}
+}
+
+// lockKeys locks the related keys of command
+func (tx *transaction) lockKeys() {
+	if !tx.locked {
+		tx.cluster.db.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.locked = true
+	}
+}
+
+// tryLockKeys locks the related keys of command without blocking, returns false if any key is locked by others
+// PREPARE 阻塞等待锁时，持有锁的事务可能也在其他节点上等待本事务持有的锁，只能等到超时回滚
+func (tx *transaction) tryLockKeys() bool {
+	if !tx.cluster.db.TryRWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys) {
+		return false
+	}
+	tx.locked = true
+	return true
+}
+
+// unLockKeys unlocks the related keys of command
+func (tx *transaction) unLockKeys() {
+	if tx.locked {
+		tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+		tx.locked = false
+	}
+}
+
+// conn returns a fake connection which selects the db of transaction
+// PREPARE、COMMIT、ROLLBACK 通过连接池中不同的连接发送，使用事务记录的 db
+func (tx *transaction) conn() resp.Connection {
+	conn := &connection.Connection{}
+	conn.SelectDB(tx.dbIndex)
+	return conn
+}
+
+// prepare locks keys, validates command and records undo logs
+// returns reply to coordinator and whether the transaction is prepared
+func (tx *transaction) prepare(check prepareFunc, args [][]byte) (resp.Reply, bool) {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.writeKeys, tx.readKeys = database.GetRelatedKeys(tx.cmdLine)
+	if !tx.tryLockKeys() {
+		tx.status = rolledBackStatus
+		return lockConflictReply, false
+	}
+	var result resp.Reply = reply.MakeOkReply()
+	if check != nil {
+		var ok bool
+		result, ok = check(tx.cluster, tx, args)
+		if !ok {
+			tx.unLockKeys()
+			tx.status = rolledBackStatus
+			return result, false
+		}
+	}
+	tx.prepared()
+	return result, true
+}
+
+// prepared records undo logs and starts the timer which rolls back transaction if it won't be committed
+// caller should hold tx.mu
+func (tx *transaction) prepared() {
+	tx.undoLog = tx.cluster.db.GetUndoLogs(tx.dbIndex, tx.cmdLine)
+	tx.status = preparedStatus
+	tx.timer = time.AfterFunc(maxLockTime, func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status == preparedStatus {
+			logger.Warn(fmt.Sprintf("transaction %s is not committed in time, roll back", tx.id))
+			tx.rollbackWithLock()
+		}
+	})
+}
+
+// commit executes command and releases locks
+func (tx *transaction) commit() resp.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status == committedStatus {
+		// 协调者没有收到上次提交的回复，重试提交
+		return tx.result
+	}
+	if tx.status != preparedStatus {
+		return reply.MakeErrReply("ERR transaction " + tx.id + " is not prepared")
+	}
+	tx.timer.Stop()
+	tx.result = tx.cluster.db.ExecWithLock(tx.conn(), tx.cmdLine)
+	tx.unLockKeys()
+	tx.status = committedStatus
+	tx.clean()
+	return tx.result
+}
+
+// rollbackWithLock undoes the transaction, caller should hold tx.mu
+func (tx *transaction) rollbackWithLock() {
+	switch tx.status {
+	case preparedStatus:
+		// 尚未提交，数据没有被修改，释放锁即可
+		tx.timer.Stop()
+		tx.unLockKeys()
+	case committedStatus:
+		// 已经提交，重新加锁并执行回滚日志
+		tx.lockKeys()
+		conn := tx.conn()
+		for _, cmdLine := range tx.undoLog {
+			tx.cluster.db.ExecWithLock(conn, cmdLine)
+		}
+		tx.unLockKeys()
+	default:
+		return
+	}
+	tx.status = rolledBackStatus
+	tx.clean()
+}
+
+// clean removes the finished transaction after a while
+func (tx *transaction) clean() {
+	time.AfterFunc(waitBeforeCleanTx, func() {
+		tx.cluster.transactions.Remove(tx.id)
+	})
+}
+
+// execPrepare executes prepare phase of TCC
+// PREPARE txid cmd args...
+func execPrepare(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("prepare")
+	}
+	txID := string(args[1])
+	cmdName := strings.ToLower(string(args[2]))
+	cmdLine := args[2:]
+	var check prepareFunc
+	if p, ok := preparers[cmdName]; ok {
+		if p.translate != nil {
+			cmdLine = p.translate(args[2:])
+			if cmdLine == nil {
+				return reply.MakeArgNumErrReply(cmdName)
+			}
+		}
+		check = p.check
+	}
+	if _, ok := cluster.transactions.Get(txID); ok {
+		return reply.MakeErrReply("ERR transaction " + txID + " already exists")
+	}
+	tx := makeTransaction(cluster, txID, c.GetDBIndex(), cmdLine)
+	cluster.transactions.Put(txID, tx)
+	result, ok := tx.prepare(check, args[2:])
+	if !ok {
+		// 检查未通过，锁已经释放，协调者会回滚其他节点
+		cluster.transactions.Remove(txID)
+	}
+	return result
+}
+
+// execCommit executes commit phase of TCC
+// COMMIT txid
+func execCommit(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("commit")
+	}
+	txID := string(args[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return reply.MakeErrReply("ERR transaction " + txID + " not found")
+	}
+	tx, _ := raw.(*transaction)
+	return tx.commit()
+}
+
+// execRollback executes rollback phase of TCC
+// ROLLBACK txid
+// 事务不存在时(PREPARE 失败或尚未收到 PREPARE)直接回复 OK
+func execRollback(cluster *ClusterDatabase, c resp.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 {
+		return reply.MakeArgNumErrReply("rollback")
+	}
+	txID := string(args[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return reply.MakeOkReply()
+	}
+	tx, _ := raw.(*transaction)
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	tx.rollbackWithLock()
+	return reply.MakeOkReply()
+}
+
+/* ---- coordinator ---- */
+
+// nextTxID generates an unique transaction id
+func (cluster *ClusterDatabase) nextTxID() string {
+	return cluster.self + "-" + strconv.FormatUint(cluster.txSeq.Add(1), 10)
+}
+
+// relayTCC sends PREPARE/COMMIT/ROLLBACK to node
+// relay 到本节点时会直接执行单机数据库的命令，TCC 内部命令需要调用集群的处理函数
+// PREPARE 在参与者上等待 key 的锁，如果通过多路复用连接发送，持有锁的事务的 COMMIT/ROLLBACK 会排在它后面无法执行，
+// 因此 TCC 命令使用连接池中独占的连接发送
+func (cluster *ClusterDatabase) relayTCC(node string, c resp.Connection, args [][]byte) resp.Reply {
+	if node == cluster.self {
+		return router[strings.ToLower(string(args[0]))](cluster, c, args)
+	}
+	peerClient, err := cluster.getPeerClient(node)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	defer func() {
+		_ = cluster.returnPeerClient(node, peerClient)
+	}()
+	ret := peerClient.SendInDB(c.GetDBIndex(), args)
+	cluster.getBreaker(node).record(ret)
+	return ret
+}
+
+// requestPrepare sends PREPARE to node
+func (cluster *ClusterDatabase) requestPrepare(c resp.Connection, txID string, node string, cmdLine CmdLine) resp.Reply {
+	return cluster.relayTCC(node, c, utils.ToCmdLine2("Prepare", append([][]byte{[]byte(txID)}, cmdLine...)...))
+}
+
+// requestCommit commits transaction on all nodes concurrently, and retries the failed nodes
+// returns the reply of each node
+// 已提交的节点不会回滚，重试后仍有节点失败时事务只在部分节点上生效，回复错误
+func (cluster *ClusterDatabase) requestCommit(c resp.Connection, txID string, nodes []string) (map[string]resp.Reply, reply.ErrorReply) {
+	replies := make(map[string]resp.Reply, len(nodes))
+	pending := nodes
+	for attempt := 1; ; attempt++ {
+		result := cluster.multicastTCC(c, pending, utils.ToCmdLine("Commit", txID))
+		pending = pending[:0:0]
+		for _, r := range result.results {
+			if reply.IsErrorReply(r.reply) {
+				pending = append(pending, r.node)
+			} else {
+				replies[r.node] = r.reply
+			}
+		}
+		if len(pending) == 0 {
+			return replies, nil
+		}
+		if attempt >= maxCommitAttempts {
+			logger.Warn(fmt.Sprintf("transaction %s is partially committed, failed nodes: %s", txID, strings.Join(pending, ", ")))
+			// 回复中同时列出已经提交的节点
+			for node, r := range replies {
+				result.results = append(result.results, &nodeResult{node: node, reply: r})
+			}
+			sort.Slice(result.results, func(i, j int) bool {
+				return result.results[i].node < result.results[j].node
+			})
+			return nil, result.errReply("commit of transaction " + txID)
+		}
+		time.Sleep(commitRetryDelay)
+	}
+}
+
+// requestRollback rolls back transaction on all nodes concurrently
+func (cluster *ClusterDatabase) requestRollback(c resp.Connection, txID string, nodes []string) {
+	result := cluster.multicastTCC(c, nodes, utils.ToCmdLine("Rollback", txID))
+	for _, r := range result.failed() {
+		logger.Warn(fmt.Sprintf("rollback transaction %s on %s failed: %s", txID, r.node, errorMessage(r.reply)))
+	}
+}
+
+// multicastTCC sends the same TCC command to nodes concurrently
+func (cluster *ClusterDatabase) multicastTCC(c resp.Connection, nodes []string, cmdLine CmdLine) *multicastResult {
+	groupMap := make(map[string]CmdLine, len(nodes))
+	for _, node := range nodes {
+		groupMap[node] = cmdLine
+	}
+	return cluster.multicast(groupMap, func(node string, cmdLine CmdLine) resp.Reply {
+		return cluster.relayTCC(node, c, cmdLine)
+	})
+}
+
+// execTCC executes command on multiple nodes atomically
+// groupMap: node -> command line executed on the node
+// returns the commit reply of each node, or the reply to client if the transaction is aborted
+// 所有节点并发 PREPARE，任意节点回复错误，或者检查未通过(如 MSETNX 的 key 已存在，回复 0)时回滚所有节点，将该回复返回给客户端
+// 只因为锁冲突失败时，等待随机的时间后重试
+func (cluster *ClusterDatabase) execTCC(c resp.Connection, groupMap map[string]CmdLine) (map[string]resp.Reply, resp.Reply) {
+	nodes := make([]string, 0, len(groupMap))
+	for node := range groupMap {
+		nodes = append(nodes, node)
+	}
+
+	var replies map[string]resp.Reply
+	aborted := cluster.retryTCC(func(txID string) (resp.Reply, bool) {
+		prepareResult := cluster.multicast(groupMap, func(node string, cmdLine CmdLine) resp.Reply {
+			return cluster.requestPrepare(c, txID, node, cmdLine)
+		})
+		if errReply := prepareResult.errReply("prepare of transaction " + txID); errReply != nil {
+			cluster.requestRollback(c, txID, nodes)
+			return errReply, prepareResult.lockConflict()
+		}
+		for _, r := range prepareResult.results {
+			if !reply.IsOKReply(r.reply) {
+				cluster.requestRollback(c, txID, nodes)
+				return r.reply, false
+			}
+		}
+		var errReply reply.ErrorReply
+		replies, errReply = cluster.requestCommit(c, txID, nodes)
+		if errReply != nil {
+			return errReply, false
+		}
+		return nil, false
+	})
+	if aborted != nil {
+		return nil, aborted
+	}
+	return replies, nil
+}
+
+// retryTCC runs transaction with a new id each time, until it is not aborted by lock conflict
+// run returns the reply to client and whether the transaction is aborted by lock conflict
+// 锁冲突时等待随机的时间后重试，最多 maxPrepareAttempts 次
+func (cluster *ClusterDatabase) retryTCC(run func(txID string) (resp.Reply, bool)) resp.Reply {
+	for attempt := 1; ; attempt++ {
+		result, conflict := run(cluster.nextTxID())
+		if !conflict || attempt >= maxPrepareAttempts {
+			return result
+		}
+		time.Sleep(time.Duration(rand.Int63n(int64(prepareRetryDelay) * int64(attempt))))
+	}
+}
+
+// isLockConflict returns whether PREPARE failed because keys are locked by another transaction
+func isLockConflict(r resp.Reply) bool {
+	return reply.IsErrorReply(r) && errorMessage(r) == lockConflictReply.Error()
+}
+
+// lockConflict returns whether all failed nodes replied lock conflict in PREPARE
+func (r *multicastResult) lockConflict() bool {
+	failed := r.failed()
+	for _, result := range failed {
+		if !isLockConflict(result.reply) {
+			return false
+		}
+	}
+	return len(failed) > 0
+}
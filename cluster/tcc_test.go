@@ -0,0 +1,157 @@
+package cluster
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// startFakePeer starts a node which replies commands by handle, PING is replied by PONG
+func startFakePeer(t *testing.T, handle func(args [][]byte) resp.Reply) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					args := payload.Data.(*reply.MultiBulkReply).Args
+					var result resp.Reply = reply.MakeOkReply()
+					if strings.EqualFold(string(args[0]), "ping") {
+						result = reply.MakePongReply()
+					} else if ret := handle(args); ret != nil {
+						result = ret
+					}
+					if _, err = conn.Write(result.ToBytes()); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestTransactionCommit(t *testing.T) {
+	cluster := makeTestCluster("127.0.0.1:6399")
+	c := connection.NewConn(nil)
+	ret := execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx1", "SET", "a", "1"))
+	if !reply.IsOKReply(ret) {
+		t.Fatalf("prepare failed: %s", ret.ToBytes())
+	}
+	// key 被锁住时，其他事务 PREPARE 失败
+	ret = execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx2", "SET", "a", "2"))
+	if !isLockConflict(ret) {
+		t.Fatalf("prepare should fail by lock conflict, got %s", ret.ToBytes())
+	}
+	if ret = execCommit(cluster, c, utils.ToCmdLine("COMMIT", "tx1")); !reply.IsOKReply(ret) {
+		t.Fatalf("commit failed: %s", ret.ToBytes())
+	}
+	// 协调者没有收到回复时重试提交
+	if ret = execCommit(cluster, c, utils.ToCmdLine("COMMIT", "tx1")); !reply.IsOKReply(ret) {
+		t.Fatalf("commit again should reply the result of commit, got %s", ret.ToBytes())
+	}
+	value, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply)
+	if !ok || string(value.Arg) != "1" {
+		t.Fatal("a should be committed")
+	}
+
+	// 未提交的事务回滚后释放锁，数据没有被修改
+	execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx3", "SET", "a", "3"))
+	execRollback(cluster, c, utils.ToCmdLine("ROLLBACK", "tx3"))
+	if ret = execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx4", "SET", "a", "4")); !reply.IsOKReply(ret) {
+		t.Fatalf("lock should be released after rollback, got %s", ret.ToBytes())
+	}
+	execRollback(cluster, c, utils.ToCmdLine("ROLLBACK", "tx4"))
+	value, _ = cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply)
+	if string(value.Arg) != "1" {
+		t.Fatalf("rolled back transaction should not modify a, got %s", value.Arg)
+	}
+}
+
+func TestRollbackOnPartialPrepare(t *testing.T) {
+	var rollbacks atomic.Int32
+	peer := startFakePeer(t, func(args [][]byte) resp.Reply {
+		switch strings.ToLower(string(args[0])) {
+		case "prepare":
+			return reply.MakeErrReply("ERR wrong type")
+		case "rollback":
+			rollbacks.Add(1)
+		}
+		return nil
+	})
+	cluster := makeTestCluster("127.0.0.1:6399", peer)
+	c := connection.NewConn(nil)
+	_, aborted := cluster.execTCC(c, map[string]CmdLine{
+		cluster.self: utils.ToCmdLine("SET", "a", "1"),
+		peer:         utils.ToCmdLine("SET", "b", "1"),
+	})
+	if !reply.IsErrorReply(aborted) {
+		t.Fatal("transaction should be aborted")
+	}
+	if rollbacks.Load() != 1 {
+		t.Errorf("peer should be rolled back once, got %d", rollbacks.Load())
+	}
+	if _, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply); ok {
+		t.Error("a should not be written")
+	}
+	// 回滚后锁已经释放
+	ret := execPrepare(cluster, c, utils.ToCmdLine("PREPARE", "tx", "SET", "a", "2"))
+	if !reply.IsOKReply(ret) {
+		t.Errorf("lock should be released after rollback, got %s", ret.ToBytes())
+	}
+}
+
+func TestRequestCommitKeepsCommittedNodes(t *testing.T) {
+	var commits, rollbacks atomic.Int32
+	peer := startFakePeer(t, func(args [][]byte) resp.Reply {
+		switch strings.ToLower(string(args[0])) {
+		case "commit":
+			commits.Add(1)
+			return reply.MakeErrReply("ERR transaction is not prepared")
+		case "rollback":
+			rollbacks.Add(1)
+		}
+		return nil
+	})
+	cluster := makeTestCluster("127.0.0.1:6399", peer)
+	c := connection.NewConn(nil)
+	txID := cluster.nextTxID()
+	if ret := cluster.requestPrepare(c, txID, cluster.self, utils.ToCmdLine("SET", "a", "1")); !reply.IsOKReply(ret) {
+		t.Fatalf("prepare failed: %s", ret.ToBytes())
+	}
+	_, errReply := cluster.requestCommit(c, txID, []string{cluster.self, peer})
+	if errReply == nil {
+		t.Fatal("commit should fail")
+	}
+	if !strings.Contains(errReply.Error(), "succeeded on "+cluster.self) {
+		t.Errorf("error should list committed nodes: %s", errReply.Error())
+	}
+	if commits.Load() != maxCommitAttempts {
+		t.Errorf("commit should be retried %d times, got %d", maxCommitAttempts, commits.Load())
+	}
+	if rollbacks.Load() != 0 {
+		t.Error("transaction should not be rolled back after commit")
+	}
+	// 已提交的节点不回滚
+	value, ok := cluster.db.Exec(c, utils.ToCmdLine("GET", "a")).(*reply.BulkReply)
+	if !ok || string(value.Arg) != "1" {
+		t.Fatal("committed value should be kept")
+	}
+}
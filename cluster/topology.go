@@ -0,0 +1,370 @@
+package cluster
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+)
+
+// node flags
+const (
+	nodeFlagPFail = 1 << iota // 疑似下线：超过 cluster-node-timeout 没有收到该节点的消息
+	nodeFlagFail              // 已下线：多数主节点都认为该节点疑似下线
+	nodeFlagMeet              // 执行 CLUSTER MEET 后尚未收到对方回复，需要继续发送 MEET
+)
+
+// nodeInfo stores the state of a node in cluster
+// 集群中一个节点的状态，通过集群总线上的 PING/PONG 消息更新
+type nodeInfo struct {
+	addr         string               // 节点地址 host:port，同时也是节点的唯一标识
+	busPort      int                  // 集群总线端口
+	configEpoch  uint64               // 配置纪元，多个节点声明负责同一个 slot 时，纪元大的节点胜出
+	flags        int                  // 节点状态
+	pingSent     time.Time            // 已发送 PING 但尚未收到 PONG 时，记录发送时间
+	pongReceived time.Time            // 最近一次收到该节点消息的时间
+	failReports  map[string]time.Time // 其他主节点报告该节点疑似下线的时间
+	master       string               // 从节点复制的主节点地址，为空表示该节点是主节点
+	replOffset   int64                // 复制偏移量，故障转移时偏移量最大的从节点优先发起选举
+	votedAt      time.Time            // 最近一次为该节点的从节点投票的时间，避免短时间内重复投票
+	rtt          time.Duration        // 集群总线 PING 的往返时间(平滑后)，用于 lowest-latency 读策略
+}
+
+// topology stores which node serves each slot of the cluster
+// 集群拓扑：记录集群中的节点、每个 slot 由哪一个节点负责，以及 slot 的迁移状态
+// 节点之间通过集群总线交换拓扑，并持久化到 nodes.conf
+type topology struct {
+	mu            sync.RWMutex
+	self          string               // 本节点地址
+	nodes         map[string]*nodeInfo // 集群中的所有节点，包括本节点
+	slots         [slotCount]string    // slot -> 负责该 slot 的节点地址
+	migrating     map[uint32]string    // slot -> 目标节点。本节点负责的 slot 正在迁出到目标节点
+	importing     map[uint32]string    // slot -> 源节点。源节点负责的 slot 正在迁入本节点
+	currentEpoch  uint64               // 集群当前纪元，即见过的最大纪元
+	lastVoteEpoch uint64               // 最近一次投票的纪元
+	forgotten     map[string]time.Time // 被 CLUSTER FORGET 的节点 -> 解禁时间，在此之前不会通过 gossip 重新加入
+	changed       bool                 // 拓扑发生变化，需要保存到 nodes.conf
+}
+
+// slotRange is a continuous range of slots served by the same node
+type slotRange struct {
+	start uint32
+	end   uint32 // 包含 end
+	node  string
+}
+
+// newTopology creates topology and assigns slots evenly to the given nodes
+// 将节点排序后，把 slot 按连续区间平均分配给每个节点
+// 每个节点的 self、peers 顺序不同，但排序后结果一致，保证各节点计算出的拓扑相同
+// 没有配置 peers 的节点不负责任何 slot，通过 CLUSTER MEET 加入集群后再使用 CLUSTER REBALANCE 分配
+func newTopology(self string, peers []string) *topology {
+	t := &topology{
+		self:      self,
+		nodes:     make(map[string]*nodeInfo),
+		migrating: make(map[uint32]string),
+		importing: make(map[uint32]string),
+		forgotten: make(map[string]time.Time),
+	}
+	t.addNode(self)
+	for _, peer := range peers {
+		if peer != "" {
+			t.addNode(peer)
+		}
+	}
+	if len(t.nodes) < 2 {
+		return t
+	}
+	nodes := t.sortedNodes()
+	for i := range t.slots {
+		t.slots[i] = nodes[i*len(nodes)/slotCount]
+	}
+	return t
+}
+
+// pickNode returns the node which serves the given slot
+func (t *topology) pickNode(slot uint32) string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slots[slot]
+}
+
+// getNodes returns all nodes in cluster
+func (t *topology) getNodes() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.sortedNodes()
+}
+
+// getMasters returns all masters in cluster, including masters which serve no slot
+func (t *topology) getMasters() []string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	masters := make([]string, 0, len(t.nodes))
+	for _, node := range t.sortedNodes() {
+		if t.nodes[node].master == "" {
+			masters = append(masters, node)
+		}
+	}
+	return masters
+}
+
+// sortedNodes returns all nodes in order, caller should hold the lock
+func (t *topology) sortedNodes() []string {
+	nodes := make([]string, 0, len(t.nodes))
+	for node := range t.nodes {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// getSlots returns a copy of the slot assignment
+func (t *topology) getSlots() [slotCount]string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slots
+}
+
+// getMigrating returns the target node if the slot is migrating out of current node
+func (t *topology) getMigrating(slot uint32) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	target, ok := t.migrating[slot]
+	return target, ok
+}
+
+// getImporting returns the source node if the slot is importing into current node
+func (t *topology) getImporting(slot uint32) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	source, ok := t.importing[slot]
+	return source, ok
+}
+
+// isFailed returns whether the node has been marked as FAIL
+func (t *topology) isFailed(node string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	info, ok := t.nodes[node]
+	return ok && info.flags&nodeFlagFail > 0
+}
+
+// addNode adds node into cluster, caller should hold the lock
+func (t *topology) addNode(node string) *nodeInfo {
+	if info, ok := t.nodes[node]; ok {
+		return info
+	}
+	_, port := splitAddr(node)
+	info := &nodeInfo{
+		addr:         node,
+		busPort:      port + busPortOffset,
+		pongReceived: time.Now(),
+		failReports:  make(map[string]time.Time),
+	}
+	t.nodes[node] = info
+	t.changed = true
+	return info
+}
+
+// setBusPort sets the cluster bus port of the node
+func (t *topology) setBusPort(node string, busPort int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addNode(node).busPort = busPort
+}
+
+// removeNode removes node from cluster, the node won't be added back by gossip until ban time passed
+func (t *topology) removeNode(node string, banTime time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.nodes, node)
+	t.forgotten[node] = time.Now().Add(banTime)
+	for _, info := range t.nodes {
+		delete(info.failReports, node)
+	}
+	t.changed = true
+}
+
+// isForgotten returns whether the node is forgotten recently, caller should hold the lock
+func (t *topology) isForgotten(node string) bool {
+	until, ok := t.forgotten[node]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(t.forgotten, node)
+		return false
+	}
+	return true
+}
+
+// updateRTT records the round trip time of PING to the node
+// 使用指数加权移动平均，避免一次网络抖动影响读策略
+func (t *topology) updateRTT(node string, sample time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	info, ok := t.nodes[node]
+	if !ok {
+		return
+	}
+	if info.rtt == 0 {
+		info.rtt = sample
+	} else {
+		info.rtt = (info.rtt*7 + sample) / 8
+	}
+}
+
+// isReplicaOf returns whether current node is a replica of the given node
+func (t *topology) isReplicaOf(node string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.nodes[t.self].master == node
+}
+
+// countSlots returns the number of slots served by the node, caller should hold the lock
+func (t *topology) countSlots(node string) int {
+	count := 0
+	for _, owner := range t.slots {
+		if owner == node {
+			count++
+		}
+	}
+	return count
+}
+
+// setSlot assigns the slot to node and finishes the migration of slot
+// 新节点会被加入集群
+// 本节点得到新的 slot 时需要增大配置纪元，其他节点通过集群总线得知 slot 的新归属
+func (t *topology) setSlot(slot uint32, node string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addNode(node)
+	if node == t.self && t.slots[slot] != t.self {
+		t.bumpEpoch()
+	}
+	t.slots[slot] = node
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+	t.changed = true
+}
+
+// bumpEpoch makes the config epoch of current node the greatest in cluster, caller should hold the lock
+func (t *topology) bumpEpoch() {
+	self := t.nodes[t.self]
+	greatest := self.configEpoch > 0
+	for node, info := range t.nodes {
+		if node != t.self && info.configEpoch >= self.configEpoch {
+			greatest = false
+			break
+		}
+	}
+	if greatest {
+		return
+	}
+	t.currentEpoch++
+	self.configEpoch = t.currentEpoch
+	t.changed = true
+}
+
+// setMigrating marks the slot is migrating out of current node
+func (t *topology) setMigrating(slot uint32, target string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addNode(target)
+	t.migrating[slot] = target
+	t.changed = true
+}
+
+// setImporting marks the slot is importing into current node
+func (t *topology) setImporting(slot uint32, source string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.importing[slot] = source
+	t.changed = true
+}
+
+// setStable clears the migrating and importing state of slot
+func (t *topology) setStable(slot uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.migrating, slot)
+	delete(t.importing, slot)
+	t.changed = true
+}
+
+// load replaces nodes and slot assignment, used when restarting or joining a running cluster
+func (t *topology) load(saved *savedTopology) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, node := range saved.nodes {
+		info := t.addNode(node.addr)
+		info.busPort = node.busPort
+		info.configEpoch = node.configEpoch
+		info.master = node.master
+	}
+	t.slots = saved.slots
+	for slot, target := range saved.migrating {
+		t.migrating[slot] = target
+	}
+	for slot, source := range saved.importing {
+		t.importing[slot] = source
+	}
+	if saved.currentEpoch > t.currentEpoch {
+		t.currentEpoch = saved.currentEpoch
+	}
+	if saved.lastVoteEpoch > t.lastVoteEpoch {
+		t.lastVoteEpoch = saved.lastVoteEpoch
+	}
+	t.changed = true
+}
+
+// findNode returns the address of node by address or id
+func (t *topology) findNode(nodeOrID string) (string, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if _, ok := t.nodes[nodeOrID]; ok {
+		return nodeOrID, true
+	}
+	for node := range t.nodes {
+		if nodeID(node) == nodeOrID {
+			return node, true
+		}
+	}
+	return "", false
+}
+
+// getSlotRanges merges continuous slots served by the same node into ranges
+// 用于 CLUSTER SLOTS、CLUSTER NODES 的输出
+func (t *topology) getSlotRanges() []*slotRange {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.slotRanges()
+}
+
+// slotRanges merges continuous slots into ranges, caller should hold the lock
+func (t *topology) slotRanges() []*slotRange {
+	ranges := make([]*slotRange, 0)
+	var current *slotRange
+	for i, node := range t.slots {
+		slot := uint32(i)
+		if node == "" {
+			current = nil
+			continue
+		}
+		if current != nil && current.node == node {
+			current.end = slot
+			continue
+		}
+		current = &slotRange{start: slot, end: slot, node: node}
+		ranges = append(ranges, current)
+	}
+	return ranges
+}
+
+// nodeID returns a 40 characters id of the node, smart clients use it to identify nodes
+// 使用地址的 sha1 作为节点 ID，保证同一个地址在所有节点上的 ID 一致
+func nodeID(addr string) string {
+	sum := sha1.Sum([]byte(addr))
+	return hex.EncodeToString(sum[:])
+}
@@ -5,28 +5,83 @@ import (
 	"go-redis/lib/logger"
 	"io"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strconv"
 	"strings"
 )
 
 // ServerProperties defines global config properties
+// 带有 mutable:"yes" 标签的参数可以通过 CONFIG SET 在运行时修改，见 runtime.go
 type ServerProperties struct {
 	Bind           string `cfg:"bind"`
 	Port           int    `cfg:"port"`
-	AppendOnly     bool   `cfg:"appendOnly"`
+	AppendOnly     bool   `cfg:"appendOnly" mutable:"yes"`
 	AppendFilename string `cfg:"appendFilename"`
-	MaxClients     int    `cfg:"maxclients"`
-	RequirePass    string `cfg:"requirepass"`
+	MaxClients     int    `cfg:"maxclients" mutable:"yes"` // 最大客户端连接数，默认 10000
+	Timeout        int    `cfg:"timeout" mutable:"yes"`    // 客户端空闲超过该秒数后关闭连接，0 表示不关闭
+	TCPKeepAlive   int    `cfg:"tcp-keepalive"`            // TCP keepalive 探测间隔(秒)，0 表示关闭 SO_KEEPALIVE
+	RequirePass    string `cfg:"requirepass" mutable:"yes"`
+	AclFile        string `cfg:"aclfile"`                      // 保存 ACL 用户的文件，启动时加载
+	AclLogMaxLen   int    `cfg:"acllog-max-len" mutable:"yes"` // ACL LOG 最多保留的记录数，默认 128
 	Databases      int    `cfg:"databases"`
 
-	Peers []string `cfg:"peers"` // 多个节点，以逗号分隔
-	Self  string   `cfg:"self"`
+	Peers       []string `cfg:"peers"` // 多个节点，以逗号分隔
+	Self        string   `cfg:"self"`
+	ClusterMode string   `cfg:"cluster-mode"` // proxy: 由节点转发命令(默认)；redirect: 回复 MOVED/ASK，由客户端重定向
+
+	ClusterEnabled     bool   `cfg:"cluster-enabled"`      // 没有配置 peers 时也以集群模式启动，通过 CLUSTER MEET 加入集群
+	ClusterPort        int    `cfg:"cluster-port"`         // 集群总线端口，默认为 port + 10000
+	ClusterNodeTimeout int    `cfg:"cluster-node-timeout"` // 节点超时时间(毫秒)，超时未响应的节点被标记为疑似下线，默认 15000
+	ClusterConfigFile  string `cfg:"cluster-config-file"`  // 保存集群拓扑的文件，默认 nodes.conf
+	ClusterReplicaOf   string `cfg:"cluster-replicaof"`    // 作为从节点复制该主节点(host:port)，主节点下线后自动故障转移
+
+	ClusterBroadcastTimeout int `cfg:"cluster-broadcast-timeout"` // 群发命令(如 FLUSHDB)等待所有节点回复的最长时间(毫秒)，默认 5000
+
+	ClusterPoolSize        int `cfg:"cluster-pool-size"`         // 每个节点连接池的最大连接数，默认 8
+	ClusterPoolMaxIdle     int `cfg:"cluster-pool-max-idle"`     // 每个节点连接池的最大空闲连接数，默认 8
+	ClusterPoolIdleTimeout int `cfg:"cluster-pool-idle-timeout"` // 空闲超过该时间(毫秒)的连接被关闭，默认 60000
+	ClusterPeerTimeout     int `cfg:"cluster-peer-timeout"`      // 等待其他节点回复的最长时间(毫秒)，默认 3000
+	ClusterBreakerFailures int `cfg:"cluster-breaker-failures"`  // 连续失败多少次后熔断，快速失败，默认 5
+	ClusterBreakerCooldown int `cfg:"cluster-breaker-cooldown"`  // 熔断后经过该时间(毫秒)放行一个探测请求，默认 5000
+
+	ClusterReplicas         []string `cfg:"cluster-replicas"`                         // 各主节点的从节点，格式为 主节点=从节点，多个以逗号分隔
+	ClusterReadPolicy       string   `cfg:"cluster-read-policy"`                      // 连接默认的读策略：primary(默认)、prefer-replica、round-robin、lowest-latency
+	ClusterReplicaMaxLag    int      `cfg:"cluster-replica-max-lag" mutable:"yes"`    // 从节点落后主节点的复制偏移量超过该值时不参与读，0 表示不限制
+	ClusterNearCache        bool     `cfg:"cluster-near-cache"`                       // proxy 模式下是否在本节点缓存其他节点的热点 key
+	ClusterNearCacheTTL     int      `cfg:"cluster-near-cache-ttl" mutable:"yes"`     // 热点 key 的缓存时间(毫秒)，默认 1000
+	ClusterNearCacheSize    int      `cfg:"cluster-near-cache-size"`                  // 最多缓存的 key 数，默认 1024
+	ClusterHotKeyThreshold  int      `cfg:"cluster-hotkey-threshold" mutable:"yes"`   // 每秒访问次数达到该值的 key 视为热点 key，默认 100
+	ClusterHotKeySampleRate int      `cfg:"cluster-hotkey-sample-rate" mutable:"yes"` // 每 N 次访问采样一次用于统计访问频率，默认 10
+
+	ClusterAuth string `cfg:"cluster-auth"` // 节点之间认证的密码，节点连接其他节点后发送 AUTH，集群内部命令需要认证后才能执行
+	MasterAuth  string `cfg:"masterauth"`   // 未配置 cluster-auth 时使用该密码
+
+	TLSCluster    bool   `cfg:"tls-cluster"`      // 节点之间的连接(命令转发、主从复制、集群总线)使用 TLS
+	TLSCertFile   string `cfg:"tls-cert-file"`    // 证书，同时作为服务端证书和连接其他节点的客户端证书
+	TLSKeyFile    string `cfg:"tls-key-file"`     // 证书私钥
+	TLSCACertFile string `cfg:"tls-ca-cert-file"` // 用于验证对方证书的 CA 证书
+
+	TLSPort            int    `cfg:"tls-port"`              // 客户端 TLS 端口，0 表示不监听，普通端口同时继续服务
+	TLSAuthClients     string `cfg:"tls-auth-clients"`      // 是否要求客户端证书：yes(默认)、optional、no
+	TLSAuthClientsUser string `cfg:"tls-auth-clients-user"` // CN: 以客户端证书的 CN 作为 ACL 用户认证；off(默认)
+
+	ProtoMaxBulkLen         string `cfg:"proto-max-bulk-len" mutable:"yes"`         // 客户端发送的单个字符串的最大长度，默认 512mb
+	ClientQueryBufferLimit  string `cfg:"client-query-buffer-limit" mutable:"yes"`  // 客户端一条命令的最大长度，默认 1gb
+	ClientOutputBufferLimit string `cfg:"client-output-buffer-limit" mutable:"yes"` // 各类客户端的输出缓冲区限制，格式为 类型 硬限制 软限制 秒数，多个类型写在同一行
+	TrackingTableMaxKeys    int    `cfg:"tracking-table-max-keys" mutable:"yes"`    // 客户端缓存最多记录的 key 数，超过后淘汰 key 并通知客户端失效，默认 1000000
+
+	UnixSocket     string `cfg:"unixsocket"`     // Unix socket 文件路径，为空时不监听，TCP 端口同时继续服务
+	UnixSocketPerm string `cfg:"unixsocketperm"` // Unix socket 文件的权限，八进制，如 700
 }
 
 // Properties holds global config properties
+// 启动之后不再修改，可以被 CONFIG SET 修改的参数通过 Load 读取
 var Properties *ServerProperties
 
+// ConfigFile is the absolute path of config file, empty if started without config file
+var ConfigFile string
+
 func init() {
 	// default config
 	Properties = &ServerProperties{
@@ -102,4 +157,7 @@ func SetupConfig(configFilename string) {
 	}
 	defer file.Close()
 	Properties = parse(file)
+	if ConfigFile, err = filepath.Abs(configFilename); err != nil {
+		ConfigFile = configFilename
+	}
 }
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"errors"
+	"go-redis/lib/wildcard"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+运行时修改配置
+1. CONFIG GET pattern [pattern ...]  按通配符返回参数的当前值
+2. CONFIG SET parameter value [parameter value ...]  只能修改带有 mutable:"yes" 标签的参数
+	值先按字段类型解析并写入当前配置的副本，再依次用副本调用参数注册的 apply 函数校验并使修改生效(如开启、关闭 AOF)
+	全部成功后才用副本整体替换当前配置，任意一个参数失败时已经生效的参数按原配置重新生效
+	其他协程通过 Load 读取配置，不会读到修改了一半或者校验失败的值
+3. CONFIG REWRITE  将 CONFIG SET 修改过的参数写回配置文件，保留注释和其他行
+*/
+
+// rewriteHeader is the line before parameters appended by CONFIG REWRITE
+const rewriteHeader = "# Generated by CONFIG REWRITE"
+
+var (
+	runtimeMu  sync.Mutex                       // 串行执行 CONFIG SET、CONFIG REWRITE
+	applyFuncs = make(map[string][]ApplyFunc)   // 参数名 -> 修改后调用的函数
+	modified   = make(map[string]struct{})      // 运行期间被 CONFIG SET 修改过的参数
+	current    atomic.Pointer[ServerProperties] // CONFIG SET 之后的配置，为空时使用 Properties
+)
+
+// ApplyFunc validates and applies the properties which will replace the current ones
+type ApplyFunc func(next *ServerProperties) error
+
+// Load returns the current properties, the returned value should not be modified
+// 运行期间读取带有 mutable:"yes" 标签的参数必须使用 Load，Properties 中保存的是启动时的值
+func Load() *ServerProperties {
+	if p := current.Load(); p != nil {
+		return p
+	}
+	return Properties
+}
+
+// OnSet registers function which is called before the properties changed by CONFIG SET take effect
+// the change is discarded if the function returns error, the function reads new value from next
+func OnSet(name string, apply ApplyFunc) {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	name = strings.ToLower(name)
+	applyFuncs[name] = append(applyFuncs[name], apply)
+}
+
+// param is a field of ServerProperties
+type param struct {
+	name    string
+	field   reflect.StructField
+	value   reflect.Value
+	mutable bool
+}
+
+// params returns all parameters of props in the order of declaration
+func params(props *ServerProperties) []*param {
+	t := reflect.TypeOf(props).Elem()
+	v := reflect.ValueOf(props).Elem()
+	result := make([]*param, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, ok := field.Tag.Lookup("cfg")
+		if !ok {
+			name = field.Name
+		}
+		result = append(result, &param{
+			name:    strings.ToLower(name),
+			field:   field,
+			value:   v.Field(i),
+			mutable: field.Tag.Get("mutable") == "yes",
+		})
+	}
+	return result
+}
+
+func lookupParam(props *ServerProperties, name string) *param {
+	name = strings.ToLower(name)
+	for _, p := range params(props) {
+		if p.name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// formatValue formats value in the format of config file
+func formatValue(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Bool:
+		if v.Bool() {
+			return "yes"
+		}
+		return "no"
+	case reflect.Slice:
+		return strings.Join(v.Interface().([]string), ",")
+	}
+	return v.String()
+}
+
+// parseValue parses value according to the type of field, returns a new value
+func parseValue(t reflect.Type, value string) (reflect.Value, error) {
+	result := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.String:
+		result.SetString(value)
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return result, errors.New("argument couldn't be parsed into an integer")
+		}
+		result.SetInt(n)
+	case reflect.Bool:
+		switch strings.ToLower(value) {
+		case "yes":
+			result.SetBool(true)
+		case "no":
+		default:
+			return result, errors.New("argument must be 'yes' or 'no'")
+		}
+	case reflect.Slice:
+		if value != "" {
+			result.Set(reflect.ValueOf(strings.Split(value, ",")))
+		}
+	}
+	return result, nil
+}
+
+// Get returns parameters and their values matching any of the patterns, sorted by name
+func Get(patterns []string) [][2]string {
+	compiled := make([]*wildcard.Pattern, len(patterns))
+	for i, pattern := range patterns {
+		compiled[i] = wildcard.CompilePattern(strings.ToLower(pattern))
+	}
+	var result [][2]string
+	for _, p := range params(Load()) {
+		for _, pattern := range compiled {
+			if pattern.IsMatch(p.name) {
+				result = append(result, [2]string{p.name, formatValue(p.value)})
+				break
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i][0] < result[j][0]
+	})
+	return result
+}
+
+func setFailed(name string, reason string) error {
+	return errors.New("ERR CONFIG SET failed (possibly related to argument '" + name + "') - " + reason)
+}
+
+// Set changes parameters, pairs are parameter names and values
+// 所有参数都修改成功才返回 nil，否则保持原配置，返回的错误可以直接回复给客户端
+func Set(pairs [][2]string) error {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	old := Load()
+	next := new(ServerProperties)
+	*next = *old
+	targets := make([]*param, len(pairs))
+	seen := make(map[string]struct{}, len(pairs))
+	for i, pair := range pairs {
+		p := lookupParam(next, pair[0])
+		if p == nil {
+			return errors.New("ERR Unknown option or number of arguments for CONFIG SET - '" + pair[0] + "'")
+		}
+		if !p.mutable {
+			return setFailed(p.name, "can't set immutable config")
+		}
+		if _, ok := seen[p.name]; ok {
+			return setFailed(p.name, "duplicate parameter")
+		}
+		seen[p.name] = struct{}{}
+		value, err := parseValue(p.field.Type, pair[1])
+		if err != nil {
+			return setFailed(p.name, err.Error())
+		}
+		// 只修改副本，校验通过之前其他协程读取不到新的值
+		p.value.Set(value)
+		targets[i] = p
+	}
+
+	for i, p := range targets {
+		if err := apply(p.name, next); err != nil {
+			// 已经生效的参数重新按原配置生效
+			for _, q := range targets[:i] {
+				_ = apply(q.name, old)
+			}
+			return setFailed(p.name, err.Error())
+		}
+	}
+	current.Store(next)
+	for _, p := range targets {
+		modified[p.name] = struct{}{}
+	}
+	return nil
+}
+
+// apply calls functions registered by OnSet with props, caller should hold lock
+func apply(name string, props *ServerProperties) error {
+	for _, fn := range applyFuncs[name] {
+		if err := fn(props); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewrite writes parameters changed by CONFIG SET into config file
+// 修改过的参数替换配置文件中的第一行，删除重复的行，配置文件中没有的参数追加到文件末尾
+// 注释、未修改的参数和未知的行保持不变
+func Rewrite() error {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	if ConfigFile == "" {
+		return errors.New("ERR The server is running without a config file")
+	}
+	content, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	current := make(map[string]string, len(modified))
+	for _, p := range params(Load()) {
+		if _, ok := modified[p.name]; ok {
+			current[p.name] = formatValue(p.value)
+		}
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	result := make([]string, 0, len(lines)+len(current)+1)
+	written := make(map[string]struct{}, len(current))
+	hasHeader := false
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == rewriteHeader {
+			hasHeader = true
+		}
+		if trimmed == "" || trimmed[0] == '#' {
+			result = append(result, line)
+			continue
+		}
+		name := strings.ToLower(strings.Fields(trimmed)[0])
+		value, ok := current[name]
+		if !ok {
+			result = append(result, line)
+			continue
+		}
+		if _, ok := written[name]; ok {
+			continue
+		}
+		written[name] = struct{}{}
+		// 值为空的参数不写入，重新启动后同样为空
+		if value != "" {
+			result = append(result, name+" "+value)
+		}
+	}
+
+	var appended []string
+	for name, value := range current {
+		if _, ok := written[name]; !ok && value != "" {
+			appended = append(appended, name+" "+value)
+		}
+	}
+	if len(appended) > 0 {
+		sort.Strings(appended)
+		if !hasHeader {
+			result = append(result, rewriteHeader)
+		}
+		result = append(result, appended...)
+	}
+	return writeFileAtomic(ConfigFile, []byte(strings.Join(result, "\n")+"\n"))
+}
+
+// writeFileAtomic writes a temporary file and renames it to path, the file keeps its mode
+func writeFileAtomic(path string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return errors.New("ERR Rewriting config file: " + err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resetRuntime replaces the config with props and clears the changes made by CONFIG SET
+func resetRuntime(t *testing.T, props *ServerProperties) {
+	oldProps, oldFile := Properties, ConfigFile
+	reset := func() {
+		current.Store(nil)
+		modified = make(map[string]struct{})
+	}
+	Properties, ConfigFile = props, ""
+	reset()
+	t.Cleanup(func() {
+		Properties, ConfigFile = oldProps, oldFile
+		reset()
+	})
+}
+
+func TestGet(t *testing.T) {
+	resetRuntime(t, &ServerProperties{
+		Port:       6399,
+		AppendOnly: true,
+		MaxClients: 100,
+		Peers:      []string{"a:1", "b:2"},
+	})
+	tests := []struct {
+		patterns []string
+		want     [][2]string
+	}{
+		{patterns: []string{"PORT"}, want: [][2]string{{"port", "6399"}}},
+		{patterns: []string{"appendonly"}, want: [][2]string{{"appendonly", "yes"}}},
+		{patterns: []string{"peers", "bind"}, want: [][2]string{{"bind", ""}, {"peers", "a:1,b:2"}}},
+		// 按参数名排序，多个模式匹配同一参数时只返回一次
+		{patterns: []string{"max*", "*clients"}, want: [][2]string{{"maxclients", "100"}, {"tls-auth-clients", ""}}},
+		{patterns: []string{"nosuch"}, want: nil},
+	}
+	for _, tt := range tests {
+		if got := Get(tt.patterns); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: got %v, want %v", tt.patterns, got, tt.want)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	resetRuntime(t, &ServerProperties{MaxClients: 100, Timeout: 10})
+	tests := []struct {
+		pairs [][2]string
+		err   string
+	}{
+		{pairs: [][2]string{{"nosuch", "1"}}, err: "ERR Unknown option or number of arguments for CONFIG SET - 'nosuch'"},
+		{pairs: [][2]string{{"port", "6380"}}, err: "ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config"},
+		{pairs: [][2]string{{"maxclients", "abc"}}, err: "argument couldn't be parsed into an integer"},
+		{pairs: [][2]string{{"appendonly", "true"}}, err: "argument must be 'yes' or 'no'"},
+		{pairs: [][2]string{{"timeout", "1"}, {"TIMEOUT", "2"}}, err: "duplicate parameter"},
+		{pairs: [][2]string{{"proto-max-bulk-len", "1xb"}}, err: "invalid memory size: 1xb"},
+		// 任意一个参数失败时其他参数同样不生效
+		{pairs: [][2]string{{"timeout", "20"}, {"maxclients", "x"}}, err: "argument couldn't be parsed into an integer"},
+	}
+	for _, tt := range tests {
+		err := Set(tt.pairs)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%v: got error %v, want %q", tt.pairs, err, tt.err)
+		}
+	}
+	if Load() != Properties || Properties.Timeout != 10 || Properties.MaxClients != 100 {
+		t.Fatal("failed CONFIG SET should not change config")
+	}
+
+	if err := Set([][2]string{{"maxclients", "200"}, {"proto-max-bulk-len", "1mb"}}); err != nil {
+		t.Fatal(err)
+	}
+	if Load().MaxClients != 200 || Load().ProtoMaxBulkLen != "1mb" {
+		t.Errorf("config is not changed: %+v", Load())
+	}
+	// 启动时的配置不被修改
+	if Properties.MaxClients != 100 {
+		t.Errorf("Properties should keep the value at startup, got %d", Properties.MaxClients)
+	}
+}
+
+func TestSetApply(t *testing.T) {
+	resetRuntime(t, &ServerProperties{AclLogMaxLen: 10, ClusterNearCacheTTL: 100})
+	var applied []int
+	OnSet("acllog-max-len", func(next *ServerProperties) error {
+		applied = append(applied, next.AclLogMaxLen)
+		return nil
+	})
+	OnSet("cluster-near-cache-ttl", func(next *ServerProperties) error {
+		if next.ClusterNearCacheTTL < 0 {
+			return errors.New("must not be negative")
+		}
+		return nil
+	})
+
+	err := Set([][2]string{{"acllog-max-len", "20"}, {"cluster-near-cache-ttl", "-1"}})
+	if err == nil || err.Error() != "ERR CONFIG SET failed (possibly related to argument 'cluster-near-cache-ttl') - must not be negative" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// 已经生效的参数按原配置重新生效
+	if !reflect.DeepEqual(applied, []int{20, 10}) {
+		t.Errorf("applied values should be rolled back, got %v", applied)
+	}
+	if Load().AclLogMaxLen != 10 {
+		t.Errorf("config should not be changed, got %d", Load().AclLogMaxLen)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	resetRuntime(t, &ServerProperties{Port: 6399, MaxClients: 100})
+	if err := Rewrite(); err == nil {
+		t.Fatal("rewrite without config file should fail")
+	}
+
+	ConfigFile = filepath.Join(t.TempDir(), "redis.conf")
+	content := strings.Join([]string{
+		"# comment",
+		"port 6399",
+		"maxclients 100",
+		"unknown-option value",
+		"",
+		"MAXCLIENTS 50",
+		"requirepass old",
+	}, "\n") + "\n"
+	if err := os.WriteFile(ConfigFile, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := Set([][2]string{
+		{"maxclients", "200"},
+		{"timeout", "30"},
+		{"appendonly", "yes"},
+		{"requirepass", ""},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	// 修改过的参数替换第一行并删除重复的行，值为空的参数被删除，文件中没有的参数追加到末尾
+	want := strings.Join([]string{
+		"# comment",
+		"port 6399",
+		"maxclients 200",
+		"unknown-option value",
+		"",
+		rewriteHeader,
+		"appendonly yes",
+		"timeout 30",
+	}, "\n") + "\n"
+	got, err := os.ReadFile(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", got, want)
+	}
+	info, err := os.Stat(ConfigFile)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file mode should be kept, got %v", info.Mode())
+	}
+
+	// 再次重写时不重复追加
+	if err := Rewrite(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := os.ReadFile(ConfigFile); string(again) != want {
+		t.Errorf("rewrite should be idempotent, got:\n%s", again)
+	}
+
+	// 重写后的文件可以被重新加载
+	file, err := os.Open(ConfigFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	props := parse(file)
+	if props.MaxClients != 200 || props.Timeout != 30 || !props.AppendOnly || props.RequirePass != "" {
+		t.Errorf("unexpected properties after reload: %+v", props)
+	}
+}
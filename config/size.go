@@ -0,0 +1,57 @@
+package config
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// sizeUnits are the units of memory size, the same as redis
+// k、m、g 以 1000 为单位，kb、mb、gb 以 1024 为单位
+var sizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"kb", 1 << 10},
+	{"mb", 1 << 20},
+	{"gb", 1 << 30},
+	{"k", 1000},
+	{"m", 1000 * 1000},
+	{"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseSize parses memory size such as 512mb, 1gb or 1024
+func ParseSize(value string) (int64, error) {
+	raw := value
+	value = strings.ToLower(strings.TrimSpace(value))
+	factor := int64(1)
+	for _, unit := range sizeUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			factor = unit.factor
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid memory size: " + raw)
+	}
+	return n * factor, nil
+}
+
+// memory size parameters are validated before taking effect
+func init() {
+	OnSet("proto-max-bulk-len", validateSize(func(p *ServerProperties) string { return p.ProtoMaxBulkLen }))
+	OnSet("client-query-buffer-limit", validateSize(func(p *ServerProperties) string { return p.ClientQueryBufferLimit }))
+}
+
+func validateSize(get func(p *ServerProperties) string) ApplyFunc {
+	return func(next *ServerProperties) error {
+		if value := get(next); value != "" {
+			_, err := ParseSize(value)
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"go-redis/config"
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// enableAof turns on AOF with a temporary file, returns the file name
+func enableAof(t *testing.T) string {
+	old := *config.Properties
+	filename := filepath.Join(t.TempDir(), "appendonly.aof")
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = filename
+	t.Cleanup(func() {
+		*config.Properties = old
+	})
+	return filename
+}
+
+// waitAof waits until the AOF file contains s, AOF is written asynchronously
+func waitAof(t *testing.T, filename string, s string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		content, _ := os.ReadFile(filename)
+		if strings.Contains(string(content), s) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%q is not written to AOF, content: %q", s, content)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAofReplay(t *testing.T) {
+	filename := enableAof(t)
+	mdb := NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	for _, cmdLine := range [][]string{
+		{"SET", "a", "1"},
+		{"SET", "b", "2"},
+		{"SET", "e", "3"},
+		{"SET", "c", "4"},
+		// 覆盖已经存在的 dest
+		{"RENAME", "a", "c"},
+		{"RENAMENX", "b", "d"},
+		// dest 已经存在，不会执行
+		{"RENAMENX", "e", "d"},
+		{"SELECT", "1"},
+		{"SET", "x", "1"},
+		{"FLUSHDB"},
+		{"SET", "y", "2"},
+		{"SELECT", "0"},
+		{"SET", "done", "1"},
+	} {
+		mdb.Exec(c, utils.ToCmdLine(cmdLine...))
+	}
+	waitAof(t, filename, "done")
+
+	// 重新加载 AOF 后数据与加载之前一致
+	loaded := NewStandaloneDatabase()
+	c = connection.NewConn(nil)
+	tests := []struct {
+		db    string
+		key   string
+		value string
+	}{
+		{db: "0", key: "a"},
+		{db: "0", key: "b"},
+		{db: "0", key: "c", value: "1"},
+		{db: "0", key: "d", value: "2"},
+		{db: "0", key: "e", value: "3"},
+		{db: "1", key: "x"},
+		{db: "1", key: "y", value: "2"},
+	}
+	for _, tt := range tests {
+		loaded.Exec(c, utils.ToCmdLine("SELECT", tt.db))
+		ret := loaded.Exec(c, utils.ToCmdLine("GET", tt.key))
+		bulk, ok := ret.(*reply.BulkReply)
+		if tt.value == "" {
+			if ok && bulk.Arg != nil {
+				t.Errorf("db%s %s should not exist, got %q", tt.db, tt.key, bulk.Arg)
+			}
+			continue
+		}
+		if !ok || string(bulk.Arg) != tt.value {
+			t.Errorf("db%s %s: got %v, want %q", tt.db, tt.key, ret, tt.value)
+		}
+	}
+}
+
+func TestApplyAppendOnly(t *testing.T) {
+	filename := enableAof(t)
+	config.Properties.AppendOnly = false
+	mdb := NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	mdb.Exec(c, utils.ToCmdLine("SET", "key-a", "1"))
+
+	// 开启时写入当前所有的 key，之后的写命令照常追加
+	if err := mdb.applyAppendOnly(&config.ServerProperties{AppendOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if mdb.aofHandler.Load() == nil {
+		t.Fatal("aof should be started")
+	}
+	mdb.Exec(c, utils.ToCmdLine("SET", "key-b", "2"))
+	waitAof(t, filename, "key-b")
+	if content, _ := os.ReadFile(filename); !strings.Contains(string(content), "key-a") {
+		t.Fatalf("existing keys should be written to AOF, content: %q", content)
+	}
+
+	if err := mdb.applyAppendOnly(&config.ServerProperties{}); err != nil {
+		t.Fatal(err)
+	}
+	if mdb.aofHandler.Load() != nil {
+		t.Fatal("aof should be stopped")
+	}
+	mdb.Exec(c, utils.ToCmdLine("SET", "key-c", "3"))
+	if content, _ := os.ReadFile(filename); strings.Contains(string(content), "key-c") {
+		t.Fatal("writes after AOF stopped should not be written")
+	}
+
+	config.Properties.AppendOnly = true
+	loaded := NewStandaloneDatabase()
+	for key, exists := range map[string]bool{"key-a": true, "key-b": true, "key-c": false} {
+		ret := loaded.Exec(c, utils.ToCmdLine("EXISTS", key))
+		if got := ret.(*reply.IntReply).Code == 1; got != exists {
+			t.Errorf("%s exists: got %v, want %v", key, got, exists)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"sort"
 	"strings"
 )
 
@@ -13,17 +14,54 @@ var cmdTable = make(map[string]*command)
 // command 命令的执行函数和参数个数
 type command struct {
 	executor ExecFunc
-	arity    int // allow number of args, arity < 0 means len(args) >= -arity
+	prepare  PreFunc  // return related keys command
+	undo     UndoFunc // return undo logs of command, nil means the command doesn't modify data
+	arity    int      // allow number of args, arity < 0 means len(args) >= -arity
+	// ACL categories, such as @read, @write, @fast
+	// ACL 按照分类授权，如 +@read 允许用户执行所有读命令
+	categories []string
 }
 
+// PreFunc analyses command line and returns related write keys and read keys
+// 命令执行前对相关的 key 加锁，写 key 加写锁、读 key 加读锁
+type PreFunc func(args [][]byte) ([]string, []string)
+
+// UndoFunc returns undo logs for the given command line
+// execute from head to tail when undo
+// 分布式事务回滚时，依次执行回滚日志，将 key 恢复到命令执行前的状态
+type UndoFunc func(db *DB, args [][]byte) []CmdLine
+
 // RegisterCommand registers a new command
 // arity means allowed number of cmdArgs, arity < 0 means len(args) >= -arity.
 // for example: the arity of `get` is 2, `mget` is -2
+// categories are ACL categories of the command, for example: "@read", "@string", "@fast"
 // 命令注册
-func RegisterCommand(name string, executor ExecFunc, arity int) {
+func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback UndoFunc, arity int, categories ...string) {
 	name = strings.ToLower(name)
 	cmdTable[name] = &command{
-		executor: executor,
-		arity:    arity,
+		executor:   executor,
+		prepare:    prepare,
+		undo:       rollback,
+		arity:      arity,
+		categories: categories,
+	}
+}
+
+// GetCommandCategories returns the ACL categories of command, ok is false if the command doesn't exist
+func GetCommandCategories(name string) ([]string, bool) {
+	cmd, ok := cmdTable[strings.ToLower(name)]
+	if !ok {
+		return nil, false
+	}
+	return cmd.categories, true
+}
+
+// GetCommandNames returns the names of all registered commands in order
+func GetCommandNames() []string {
+	names := make([]string, 0, len(cmdTable))
+	for name := range cmdTable {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
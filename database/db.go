@@ -2,11 +2,16 @@
 package database
 
 import (
+	"go-redis/aof"
 	"go-redis/datastruct/dict"
 	"go-redis/interface/database"
 	"go-redis/interface/resp"
+	"go-redis/lib/lock"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 // DB stores data and execute user's commands
@@ -16,6 +21,16 @@ type DB struct {
 	// 直接将 AddAof 方法作为 DB 的成员变量
 	// 这样不用将 database 的 aofHandler 传递给 DB，里面过多多于的字段，这样写封装性更好
 	addAof func(CmdLine)
+	// 对 key 加锁，保证 key 迁移和集群分布式事务执行期间，其他命令不会修改相关的 key
+	locker *lock.Locks
+	// 读命令查找 key 的命中和未命中次数，INFO stats 中的 keyspace_hits、keyspace_misses
+	hits   atomic.Int64
+	misses atomic.Int64
+	// 按 slot 记录 key，集群模式下使用集群的 hash slot
+	slots *slotIndex
+	// 命令执行期间持有读锁，Snapshot 持有写锁，保证快照与之后的写命令之间没有重叠或遗漏
+	// 在 key 加锁之后获取，持有 key 锁等待提交的事务不会阻塞快照
+	barrier sync.RWMutex
 }
 
 // ExecFunc is interface for command executor
@@ -26,6 +41,8 @@ type DB struct {
 // 如: SET KEY VALUE
 type ExecFunc func(db *DB, args [][]byte) resp.Reply
 
+const lockerSize = 1024
+
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
 
@@ -40,6 +57,8 @@ func makeDB() *DB {
 		// 那么就会调用到 db.addAof() 这个函数
 		// 所以，这里的 addAof 需要一个空实现，而非不赋初值，则为 nil，调用的话将报错
 		addAof: func(line CmdLine) {},
+		locker: lock.Make(lockerSize),
+		slots:  makeSlotIndex(defaultSlotCount, defaultSlotOf),
 	}
 	return db
 }
@@ -58,8 +77,33 @@ func (db *DB) Exec(c resp.Connection, cmdLine CmdLine) resp.Reply {
 	}
 	fun := cmd.executor
 
+	// 执行前对相关的 key 加锁
 	// SET K V -> K V
-	return fun(db, cmdLine[1:])
+	args := cmdLine[1:]
+	if cmd.prepare != nil {
+		writeKeys, readKeys := cmd.prepare(args)
+		db.RWLocks(writeKeys, readKeys)
+		defer db.RWUnLocks(writeKeys, readKeys)
+	}
+	db.barrier.RLock()
+	defer db.barrier.RUnlock()
+	return fun(db, args)
+}
+
+// execWithLock executes command without locking keys, the caller should have locked related keys
+// 用于集群分布式事务：prepare 阶段已经对 key 加锁，commit 和 rollback 时不能重复加锁
+func (db *DB) execWithLock(cmdLine CmdLine) resp.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return reply.MakeArgNumErrReply(cmdName)
+	}
+	db.barrier.RLock()
+	defer db.barrier.RUnlock()
+	return cmd.executor(db, cmdLine[1:])
 }
 
 // validateArity 校验参数个数
@@ -87,9 +131,30 @@ func (db *DB) GetEntity(key string) (*database.DataEntity, bool) {
 	return entity, true
 }
 
+// GetEntityForRead returns DataEntity bind to given key, and counts keyspace hits and misses
+// 读命令(GET、MGET、EXISTS 等)通过该方法查找 key
+func (db *DB) GetEntityForRead(key string) (*database.DataEntity, bool) {
+	entity, ok := db.GetEntity(key)
+	if ok {
+		db.hits.Add(1)
+	} else {
+		db.misses.Add(1)
+	}
+	return entity, ok
+}
+
+// Len returns the number of keys in db
+func (db *DB) Len() int {
+	return db.data.Len()
+}
+
 // PutEntity a DataEntity into DB
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
-	return db.data.Put(key, entity)
+	result := db.data.Put(key, entity)
+	if result > 0 {
+		db.slots.add(key)
+	}
+	return result
 }
 
 // PutIfExists edit an existing DataEntity
@@ -99,12 +164,24 @@ func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
 
 // PutIfAbsent insert an DataEntity only if the key not exists
 func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
-	return db.data.PutIfAbsent(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.slots.add(key)
+	}
+	return result
 }
 
 // Remove the given key from db
 func (db *DB) Remove(key string) {
-	db.data.Remove(key)
+	if db.data.Remove(key) > 0 {
+		db.slots.remove(key)
+	}
+}
+
+// exists returns whether the key exists, without counting hits and misses
+func (db *DB) exists(key string) bool {
+	_, ok := db.data.Get(key)
+	return ok
 }
 
 // Removes the given keys from db
@@ -120,7 +197,39 @@ func (db *DB) Removes(keys ...string) int {
 	return deleted
 }
 
+// RWLocks lock keys for writing and reading
+func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWLocks(writeKeys, readKeys)
+}
+
+// TryRWLocks tries to lock keys for writing and reading without blocking
+func (db *DB) TryRWLocks(writeKeys []string, readKeys []string) bool {
+	return db.locker.TryRWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlock keys for writing and reading
+func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
+	db.locker.RWUnLocks(writeKeys, readKeys)
+}
+
+// writeSnapshot writes all keys into AOF, a key is locked while writing to keep the order with write commands
+// 字符串写入 SET，其他类型写入 RESTORE
+func (db *DB) writeSnapshot(handler *aof.AofHandler) {
+	for _, key := range db.data.Keys() {
+		readKeys := []string{key}
+		db.RWLocks(nil, readKeys)
+		if entity, ok := db.GetEntity(key); ok {
+			if bytes, isString := entity.Data.([]byte); isString {
+				handler.AddAof(db.index, utils.ToCmdLine2("Set", []byte(key), bytes))
+			} else if payload, err := SerializeEntity(entity); err == nil {
+				handler.AddAof(db.index, [][]byte{[]byte("Restore"), []byte(key), []byte("0"), payload, []byte("REPLACE")})
+			}
+		}
+		db.RWUnLocks(nil, readKeys)
+	}
+}
+
 // Flush clean database
 func (db *DB) Flush() {
-	db.data.Clear()
+	db.slots.clear(db.data.Clear)
 }
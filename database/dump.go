@@ -0,0 +1,121 @@
+package database
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/lib/utils"
+	"go-redis/resp/reply"
+	"hash/crc64"
+	"strconv"
+	"strings"
+)
+
+/*
+DUMP/RESTORE 使用的序列化格式：
+	[数据类型 1 字节][数据...][版本号 2 字节][CRC64 校验和 8 字节]
+校验和覆盖前面所有的字节，RESTORE 时先校验版本号和校验和，防止写入损坏的数据
+*/
+
+const (
+	dumpVersion uint16 = 1
+
+	// 数据类型
+	dumpTypeString byte = 0
+)
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// SerializeEntity serializes DataEntity into DUMP payload
+func SerializeEntity(entity *database.DataEntity) ([]byte, error) {
+	var buf bytes.Buffer
+	switch data := entity.Data.(type) {
+	case []byte:
+		buf.WriteByte(dumpTypeString)
+		buf.Write(data)
+	default:
+		return nil, errors.New("unsupported data type")
+	}
+	_ = binary.Write(&buf, binary.LittleEndian, dumpVersion)
+	_ = binary.Write(&buf, binary.LittleEndian, crc64.Checksum(buf.Bytes(), crc64Table))
+	return buf.Bytes(), nil
+}
+
+// DeserializeEntity parses DUMP payload into DataEntity
+func DeserializeEntity(payload []byte) (*database.DataEntity, error) {
+	// 至少包含 类型 + 版本号 + 校验和
+	if len(payload) < 1+2+8 {
+		return nil, errors.New("payload is too short")
+	}
+	body := payload[:len(payload)-8]
+	checksum := binary.LittleEndian.Uint64(payload[len(payload)-8:])
+	if crc64.Checksum(body, crc64Table) != checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+	version := binary.LittleEndian.Uint16(body[len(body)-2:])
+	if version != dumpVersion {
+		return nil, errors.New("version mismatch")
+	}
+	data := body[1 : len(body)-2]
+	switch body[0] {
+	case dumpTypeString:
+		value := make([]byte, len(data))
+		copy(value, data)
+		return &database.DataEntity{Data: value}, nil
+	}
+	return nil, errors.New("unsupported data type")
+}
+
+// execDump returns the serialized value of key
+// DUMP k1
+func execDump(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return reply.MakeNullBulkReply()
+	}
+	payload, err := SerializeEntity(entity)
+	if err != nil {
+		return reply.MakeErrReply("ERR " + err.Error())
+	}
+	return reply.MakeBulkReply(payload)
+}
+
+// execRestore creates a key using the payload of DUMP
+// RESTORE k1 ttl payload [REPLACE]
+// 暂不支持过期时间，ttl 必须为 0
+func execRestore(db *DB, args [][]byte) resp.Reply {
+	key := string(args[0])
+	ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || ttl < 0 {
+		return reply.MakeErrReply("ERR Invalid TTL value, must be >= 0")
+	}
+	if ttl != 0 {
+		return reply.MakeErrReply("ERR TTL is not supported")
+	}
+	replace := false
+	for _, arg := range args[3:] {
+		if strings.ToLower(string(arg)) == "replace" {
+			replace = true
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	if _, exists := db.GetEntity(key); exists && !replace {
+		return reply.MakeErrReply("BUSYKEY Target key name already exists.")
+	}
+	entity, err := DeserializeEntity(args[2])
+	if err != nil {
+		return reply.MakeErrReply("ERR DUMP payload version or checksum are wrong")
+	}
+	db.PutEntity(key, entity)
+	db.addAof(utils.ToCmdLine2("Restore", args...))
+	return reply.MakeOkReply()
+}
+
+func init() {
+	RegisterCommand("dump", execDump, readFirstKey, nil, 2, "@keyspace", "@read", "@slow")                                     // DUMP k1
+	RegisterCommand("restore", execRestore, writeFirstKey, rollbackFirstKey, -4, "@keyspace", "@write", "@slow", "@dangerous") // RESTORE k1 0 payload [REPLACE]
+}
@@ -0,0 +1,82 @@
+package database
+
+import (
+	"go-redis/config"
+	"strconv"
+)
+
+// InfoFields returns the fields of persistence, stats, replication, cluster and keyspace in INFO
+// 单机模式没有主从复制，集群模式下由 ClusterDatabase 补充 replication、cluster 等 section
+func (mdb *StandaloneDatabase) InfoFields(section string) []string {
+	switch section {
+	case "persistence":
+		return mdb.persistenceInfo()
+	case "stats":
+		var hits, misses int64
+		for _, db := range mdb.dbSet {
+			hits += db.hits.Load()
+			misses += db.misses.Load()
+		}
+		return []string{
+			"keyspace_hits:" + strconv.FormatInt(hits, 10),
+			"keyspace_misses:" + strconv.FormatInt(misses, 10),
+		}
+	case "replication":
+		return []string{
+			"role:master",
+			"connected_slaves:0",
+			"master_repl_offset:0",
+		}
+	case "cluster":
+		return []string{"cluster_enabled:0"}
+	case "keyspace":
+		// 只输出有 key 的 db，暂不支持过期时间，expires 和 avg_ttl 固定为 0
+		var fields []string
+		for _, db := range mdb.dbSet {
+			if keys := db.Len(); keys > 0 {
+				fields = append(fields, "db"+strconv.Itoa(db.index)+":keys="+strconv.Itoa(keys)+",expires=0,avg_ttl=0")
+			}
+		}
+		return fields
+	}
+	return nil
+}
+
+// ResetStats resets keyspace hits and misses
+func (mdb *StandaloneDatabase) ResetStats() {
+	for _, db := range mdb.dbSet {
+		db.hits.Store(0)
+		db.misses.Store(0)
+	}
+}
+
+func (mdb *StandaloneDatabase) persistenceInfo() []string {
+	handler := mdb.aofHandler.Load()
+	fields := []string{
+		"loading:0",
+		"rdb_changes_since_last_save:0",
+		"aof_enabled:" + boolToInfo(handler != nil),
+		"aof_rewrite_in_progress:0",
+	}
+	if handler == nil {
+		return fields
+	}
+	status := "ok"
+	if !handler.LastWriteOK() {
+		status = "err"
+	}
+	return append(fields,
+		"aof_filename:"+config.Properties.AppendFilename,
+		"aof_last_write_status:"+status,
+		"aof_current_size:"+strconv.FormatInt(handler.CurrentSize(), 10),
+		"aof_buffer_length:"+strconv.Itoa(handler.PendingCount()),
+	)
+}
+
+// boolToInfo formats bool as 1 or 0 in INFO
+func boolToInfo(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
@@ -5,6 +5,9 @@ import (
     "go-redis/lib/utils"
     "go-redis/lib/wildcard"
     "go-redis/resp/reply"
+    "hash/fnv"
+    "strconv"
+    "strings"
 )
 
 // execDel removes a key from db
@@ -28,13 +31,22 @@ func execDel(db *DB, args [][]byte) resp.Reply {
     return reply.MakeIntReply(int64(deleted))
 }
 
+// undoDel returns undo logs of DEL
+func undoDel(db *DB, args [][]byte) []CmdLine {
+    keys := make([]string, len(args))
+    for i, v := range args {
+        keys[i] = string(v)
+    }
+    return rollbackGivenKeys(db, keys...)
+}
+
 // execExists checks if a is existed in db
 // EXISTS K1 K2 K3
 func execExists(db *DB, args [][]byte) resp.Reply {
     result := int64(0)
     for _, arg := range args {
         key := string(arg)
-        _, exists := db.GetEntity(key)
+        _, exists := db.GetEntityForRead(key)
         if exists {
             result++
         }
@@ -48,6 +60,7 @@ func execExists(db *DB, args [][]byte) resp.Reply {
 // FLUSHDB
 func execFlushDB(db *DB, args [][]byte) resp.Reply {
     db.Flush()
+    db.addAof(utils.ToCmdLine2("FlushDB", args...))
     // 包装成 RESP 协议的 OK 返回形式
     return &reply.OkReply{}
 }
@@ -58,17 +71,26 @@ func execFlushDB(db *DB, args [][]byte) resp.Reply {
 // 这里 args 实际上只有 K1, 因为 TYPE 在前面已经被切掉了
 func execType(db *DB, args [][]byte) resp.Reply {
     key := string(args[0])
-    entity, exists := db.GetEntity(key)
+    entity, exists := db.GetEntityForRead(key)
     if !exists {
         return reply.MakeStatusReply("none")
     }
 
+    typeName := entityType(entity.Data)
+    if typeName == "" {
+        return &reply.UnknownErrReply{}
+    }
+    return reply.MakeStatusReply(typeName)
+}
+
+// entityType returns the type name of data, returns empty string if the type is unknown
+func entityType(data interface{}) string {
     // 后续可实现其他类型
-    switch entity.Data.(type) {
+    switch data.(type) {
     case []byte: // string 类型就是按照 []byte 来存储的
-        return reply.MakeStatusReply("string")
+        return "string"
     }
-    return &reply.UnknownErrReply{}
+    return ""
 }
 
 // execRename a key
@@ -88,10 +110,24 @@ func execRename(db *DB, args [][]byte) resp.Reply {
     // 更新 dest、删除 src
     db.PutEntity(dest, entity)
     db.Remove(src)
-    db.addAof(utils.ToCmdLine2("RenameNx", args...))
+    db.addAof(utils.ToCmdLine2("Rename", args...))
     return &reply.OkReply{}
 }
 
+// prepareRename returns related keys of RENAME and RENAMENX, both keys will be modified
+func prepareRename(args [][]byte) ([]string, []string) {
+    src := string(args[0])
+    dest := string(args[1])
+    return []string{src, dest}, nil
+}
+
+// undoRename returns undo logs of RENAME and RENAMENX
+func undoRename(db *DB, args [][]byte) []CmdLine {
+    src := string(args[0])
+    dest := string(args[1])
+    return rollbackGivenKeys(db, src, dest)
+}
+
 // execRenameNx a key, only if the new key does not exist
 // RENAMENX K1 K2
 // 检查 K2 是否存在, 如果存在则返回 0, 什么也不操作
@@ -115,7 +151,7 @@ func execRenameNx(db *DB, args [][]byte) resp.Reply {
     db.Removes(src)
     // 插入 K2
     db.PutEntity(dest, entity)
-    db.addAof(utils.ToCmdLine2("Rename", args...))
+    db.addAof(utils.ToCmdLine2("RenameNx", args...))
     // 返回操作数：1
     return reply.MakeIntReply(1)
 }
@@ -136,12 +172,106 @@ func execKeys(db *DB, args [][]byte) resp.Reply {
     return reply.MakeMultiBulkReply(result)
 }
 
+// execDBSize returns the number of keys in db
+// DBSIZE
+func execDBSize(db *DB, args [][]byte) resp.Reply {
+    return reply.MakeIntReply(int64(db.data.Len()))
+}
+
+// execRandomKey returns a random key in db
+// RANDOMKEY
+func execRandomKey(db *DB, args [][]byte) resp.Reply {
+    if db.data.Len() == 0 {
+        return reply.MakeNullBulkReply()
+    }
+    keys := db.data.RandomKeys(1)
+    if len(keys) == 0 || keys[0] == "" {
+        return reply.MakeNullBulkReply()
+    }
+    return reply.MakeBulkReply([]byte(keys[0]))
+}
+
+// keyHash 计算 key 的哈希值，单机模式下按哈希值为 key 分配 slot
+func keyHash(key string) uint32 {
+    h := fnv.New32a()
+    _, _ = h.Write([]byte(key))
+    return h.Sum32()
+}
+
+// execScan iterates keys in db
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 底层的 dict 没有稳定的遍历顺序，这里按照 slot 索引的顺序遍历，游标是下一个待遍历的 slot
+// 遍历期间一直存在的 key 至少会被返回一次；同一个 slot 的 key 总是在同一次调用中返回
+// 游标为 0 表示遍历结束
+func execScan(db *DB, args [][]byte) resp.Reply {
+    cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+    if err != nil {
+        return reply.MakeErrReply("ERR invalid cursor")
+    }
+    count := 10
+    var pattern *wildcard.Pattern
+    typeName := ""
+    for i := 1; i < len(args); i += 2 {
+        if i+1 >= len(args) {
+            return reply.MakeSyntaxErrReply()
+        }
+        value := string(args[i+1])
+        switch strings.ToLower(string(args[i])) {
+        case "match":
+            pattern = wildcard.CompilePattern(value)
+        case "count":
+            count, err = strconv.Atoi(value)
+            if err != nil {
+                return reply.MakeErrReply("ERR value is not an integer or out of range")
+            }
+            if count < 1 {
+                return reply.MakeSyntaxErrReply()
+            }
+        case "type":
+            typeName = strings.ToLower(value)
+        default:
+            return reply.MakeSyntaxErrReply()
+        }
+    }
+
+    if cursor > uint64(db.slots.size) {
+        cursor = uint64(db.slots.size)
+    }
+    keys, next := db.slots.scan(uint32(cursor), count)
+    nextCursor := uint64(next)
+    result := make([][]byte, 0, len(keys))
+    for _, key := range keys {
+        if pattern != nil && !pattern.IsMatch(key) {
+            continue
+        }
+        if typeName != "" {
+            entity, ok := db.GetEntity(key)
+            if !ok || entityType(entity.Data) != typeName {
+                continue
+            }
+        } else if !db.exists(key) {
+            // 索引与数据不是原子地修改，跳过已经删除的 key
+            continue
+        }
+        result = append(result, []byte(key))
+    }
+    return reply.MakeMultiRawReply([]resp.Reply{
+        reply.MakeBulkReply([]byte(strconv.FormatUint(nextCursor, 10))),
+        reply.MakeMultiBulkReply(result),
+    })
+}
+
 func init() {
-    RegisterCommand("del", execDel, -2)          // DEL K1... 至少两个参数、变长
-    RegisterCommand("exists", execExists, -2)    // EXISTS K1... 至少两个参数、变长
-    RegisterCommand("flushDB", execFlushDB, -1)  // FLUSHDB 命令, 其实是固定参数 1 个。但是这里为了兼容性, 允许变长, 如 FLUSHDB a b c, 但也只执行 FLUSHDB 命令, 这也是 -1 的作用
-    RegisterCommand("type", execType, 2)         // TYPE K1 固定两个参数
-    RegisterCommand("rename", execRename, 3)     // RENAME K1 K2 固定三个参数
-    RegisterCommand("renameNx", execRenameNx, 3) // RENAMENX K1 K2 固定三个参数
-    RegisterCommand("keys", execKeys, 2)         // KEYS PATTERN 固定两个参数
+    RegisterCommand("del", execDel, writeAllKeys, undoDel, -2, "@keyspace", "@write", "@slow")                // DEL K1... 至少两个参数、变长
+    RegisterCommand("exists", execExists, readAllKeys, nil, -2, "@keyspace", "@read", "@fast")                // EXISTS K1... 至少两个参数、变长
+    RegisterCommand("unlink", execDel, writeAllKeys, undoDel, -2, "@keyspace", "@write", "@fast")             // UNLINK K1... 与 DEL 相同，同步删除
+    RegisterCommand("touch", execExists, readAllKeys, nil, -2, "@keyspace", "@read", "@fast")                 // TOUCH K1... 没有记录访问时间，返回存在的 key 的个数
+    RegisterCommand("flushDB", execFlushDB, noPrepare, nil, -1, "@keyspace", "@write", "@slow", "@dangerous") // FLUSHDB 命令, 其实是固定参数 1 个。但是这里为了兼容性, 允许变长, 如 FLUSHDB a b c, 但也只执行 FLUSHDB 命令, 这也是 -1 的作用
+    RegisterCommand("type", execType, readFirstKey, nil, 2, "@keyspace", "@read", "@fast")                    // TYPE K1 固定两个参数
+    RegisterCommand("rename", execRename, prepareRename, undoRename, 3, "@keyspace", "@write", "@slow")       // RENAME K1 K2 固定三个参数
+    RegisterCommand("renameNx", execRenameNx, prepareRename, undoRename, 3, "@keyspace", "@write", "@fast")   // RENAMENX K1 K2 固定三个参数
+    RegisterCommand("keys", execKeys, noPrepare, nil, 2, "@keyspace", "@read", "@slow", "@dangerous")         // KEYS PATTERN 固定两个参数
+    RegisterCommand("dbSize", execDBSize, noPrepare, nil, 1, "@keyspace", "@read", "@fast")                   // DBSIZE
+    RegisterCommand("randomKey", execRandomKey, noPrepare, nil, 1, "@keyspace", "@read", "@slow")             // RANDOMKEY
+    RegisterCommand("scan", execScan, noPrepare, nil, -2, "@keyspace", "@read", "@slow")                      // SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
 }
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"testing"
+)
+
+// scanAll iterates db by SCAN until cursor is 0, returns the times each key is returned and the number of calls
+func scanAll(t *testing.T, mdb *StandaloneDatabase, args ...string) (map[string]int, int) {
+	c := connection.NewConn(nil)
+	seen := make(map[string]int)
+	cursor := "0"
+	for calls := 1; ; calls++ {
+		ret, ok := mdb.Exec(c, utils.ToCmdLine(append([]string{"SCAN", cursor}, args...)...)).(*reply.MultiRawReply)
+		if !ok || len(ret.Replies) != 2 {
+			t.Fatalf("unexpected reply of SCAN")
+		}
+		cursor = string(ret.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range ret.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(key)]++
+		}
+		if cursor == "0" {
+			return seen, calls
+		}
+		if calls > defaultSlotCount {
+			t.Fatal("SCAN does not finish")
+		}
+	}
+}
+
+func TestScan(t *testing.T) {
+	mdb := NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	for i := 0; i < 1000; i++ {
+		mdb.Exec(c, utils.ToCmdLine("SET", "key"+strconv.Itoa(i), "v"))
+	}
+
+	seen, calls := scanAll(t, mdb, "COUNT", "100")
+	if len(seen) != 1000 {
+		t.Fatalf("expect 1000 keys, got %d", len(seen))
+	}
+	for key, times := range seen {
+		if times != 1 {
+			t.Errorf("%s is returned %d times", key, times)
+		}
+	}
+	// 每次调用返回的 key 不少于 count，或者遍历了 count * 10 个空的 slot
+	if calls < 2 || calls > 1000/100+defaultSlotCount/(100*10)+1 {
+		t.Errorf("unexpected number of calls: %d", calls)
+	}
+
+	seen, _ = scanAll(t, mdb, "MATCH", "key1?", "COUNT", "1000")
+	if len(seen) != 10 {
+		t.Errorf("expect 10 keys matching key1?, got %d", len(seen))
+	}
+	seen, _ = scanAll(t, mdb, "TYPE", "list")
+	if len(seen) != 0 {
+		t.Errorf("expect no list, got %d", len(seen))
+	}
+}
+
+func TestScanDuringWrites(t *testing.T) {
+	mdb := NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	for i := 0; i < 500; i++ {
+		mdb.Exec(c, utils.ToCmdLine("SET", "old"+strconv.Itoa(i), "v"))
+	}
+	seen := make(map[string]int)
+	cursor := "0"
+	for i := 0; ; i++ {
+		// 遍历期间写入和删除其他 key
+		mdb.Exec(c, utils.ToCmdLine("SET", "new"+strconv.Itoa(i), "v"))
+		mdb.Exec(c, utils.ToCmdLine("DEL", "new"+strconv.Itoa(i-1)))
+		ret := mdb.Exec(c, utils.ToCmdLine("SCAN", cursor, "COUNT", "10")).(*reply.MultiRawReply)
+		cursor = string(ret.Replies[0].(*reply.BulkReply).Arg)
+		for _, key := range ret.Replies[1].(*reply.MultiBulkReply).Args {
+			seen[string(key)]++
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	for i := 0; i < 500; i++ {
+		if seen["old"+strconv.Itoa(i)] == 0 {
+			t.Errorf("old%d is not returned", i)
+		}
+	}
+}
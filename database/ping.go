@@ -17,5 +17,5 @@ func Ping(db *DB, args [][]byte) resp.Reply {
 }
 
 func init() {
-    RegisterCommand("ping", Ping, -1)
+    RegisterCommand("ping", Ping, noPrepare, nil, -1, "@fast", "@connection")
 }
@@ -0,0 +1,46 @@
+package database
+
+/*
+命令执行前需要加锁的 key
+写 key 加写锁，读 key 加读锁，没有相关 key 的命令不加锁
+*/
+
+// noPrepare is PreFunc of commands which have no related keys
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
+// writeFirstKey returns the first key as write key
+// SET k1 v1
+func writeFirstKey(args [][]byte) ([]string, []string) {
+	key := string(args[0])
+	return []string{key}, nil
+}
+
+// writeAllKeys returns all arguments as write keys
+// DEL k1 k2 k3
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return keys, nil
+}
+
+// readFirstKey returns the first key as read key
+// GET k1
+func readFirstKey(args [][]byte) ([]string, []string) {
+	// assert len(args) > 0
+	key := string(args[0])
+	return nil, []string{key}
+}
+
+// readAllKeys returns all arguments as read keys
+// EXISTS k1 k2 k3
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+	return nil, keys
+}
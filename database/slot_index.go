@@ -0,0 +1,167 @@
+package database
+
+import "sync"
+
+// defaultSlotCount is the number of slots of index in standalone mode
+const defaultSlotCount = 16384
+
+// slotIndex records keys of each hash slot
+// 集群模式下 slot 与集群的 hash slot 相同，CLUSTER GETKEYSINSLOT、COUNTKEYSINSLOT 以及迁移 slot 时不需要遍历 db 中的所有 key；
+// 单机模式下按 key 的哈希值分配 slot，SCAN 按 slot 的顺序遍历
+type slotIndex struct {
+	size   uint32 // slot 的个数，slotOf 的返回值小于该值
+	slotOf func(key string) uint32
+	mu     sync.Mutex
+	slots  map[uint32]map[string]struct{} // slot -> 属于该 slot 的 key
+}
+
+func makeSlotIndex(size uint32, slotOf func(key string) uint32) *slotIndex {
+	return &slotIndex{
+		size:   size,
+		slotOf: slotOf,
+		slots:  make(map[uint32]map[string]struct{}),
+	}
+}
+
+// defaultSlotOf returns the slot of key in standalone mode
+func defaultSlotOf(key string) uint32 {
+	return keyHash(key) % defaultSlotCount
+}
+
+func (idx *slotIndex) add(key string) {
+	slot := idx.slotOf(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys, ok := idx.slots[slot]
+	if !ok {
+		keys = make(map[string]struct{})
+		idx.slots[slot] = keys
+	}
+	keys[key] = struct{}{}
+}
+
+func (idx *slotIndex) remove(key string) {
+	slot := idx.slotOf(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if keys, ok := idx.slots[slot]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx.slots, slot)
+		}
+	}
+}
+
+// removeIfAbsent removes key from index if it does not exist in db, returns whether the key is removed
+// 持有锁时检查，写入 key 之后才加入索引，不会删除并发写入的 key
+func (idx *slotIndex) removeIfAbsent(key string, exists func(key string) bool) bool {
+	slot := idx.slotOf(key)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	if exists(key) {
+		return false
+	}
+	if keys, ok := idx.slots[slot]; ok {
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(idx.slots, slot)
+		}
+	}
+	return true
+}
+
+// clear calls clearData and clears index while holding lock, keys written concurrently are kept in index
+func (idx *slotIndex) clear(clearData func()) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	clearData()
+	idx.slots = make(map[uint32]map[string]struct{})
+}
+
+// keys returns at most count keys of the slot, count < 0 means no limit
+func (idx *slotIndex) keys(slot uint32, count int) []string {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	keys := idx.slots[slot]
+	size := len(keys)
+	if count >= 0 && count < size {
+		size = count
+	}
+	result := make([]string, 0, size)
+	for key := range keys {
+		if len(result) >= size {
+			break
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+func (idx *slotIndex) count(slot uint32) int {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return len(idx.slots[slot])
+}
+
+// scan returns keys of slots starting from cursor, and the next cursor which is 0 if all slots are visited
+// 遍历到 key 的个数不少于 count，或者连续遍历了 count * 10 个空的 slot 时结束，每次调用的代价与 count 成正比
+func (idx *slotIndex) scan(cursor uint32, count int) ([]string, uint32) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	result := make([]string, 0, count)
+	emptyVisited := 0
+	for slot := cursor; slot < idx.size; slot++ {
+		keys := idx.slots[slot]
+		if len(keys) == 0 {
+			emptyVisited++
+		}
+		for key := range keys {
+			result = append(result, key)
+		}
+		if len(result) >= count || emptyVisited >= count*10 {
+			if slot+1 == idx.size {
+				return result, 0
+			}
+			return result, slot + 1
+		}
+	}
+	return result, 0
+}
+
+// EnableSlotIndex records keys of each slot in all dbs, slotOf returns the slot of key which is less than size
+// 需要在处理客户端请求之前调用，已经存在的 key(如从 AOF 加载的 key)同样加入索引
+func (mdb *StandaloneDatabase) EnableSlotIndex(size uint32, slotOf func(key string) uint32) {
+	for _, db := range mdb.dbSet {
+		idx := makeSlotIndex(size, slotOf)
+		for _, key := range db.data.Keys() {
+			idx.add(key)
+		}
+		db.slots = idx
+	}
+}
+
+// GetKeysInSlot returns at most count keys of the slot in the given db, count < 0 means no limit
+func (mdb *StandaloneDatabase) GetKeysInSlot(dbIndex int, slot uint32, count int) []string {
+	if dbIndex < 0 || dbIndex >= len(mdb.dbSet) {
+		return []string{}
+	}
+	db := mdb.dbSet[dbIndex]
+	keys := db.slots.keys(slot, count)
+	// 索引与数据不是原子地修改，FLUSHDB 与写命令并发时可能残留已经不存在的 key
+	result := keys[:0]
+	for _, key := range keys {
+		if db.slots.removeIfAbsent(key, db.exists) {
+			continue
+		}
+		result = append(result, key)
+	}
+	return result
+}
+
+// CountKeysInSlot returns the number of keys of the slot in the given db
+func (mdb *StandaloneDatabase) CountKeysInSlot(dbIndex int, slot uint32) int {
+	if dbIndex < 0 || dbIndex >= len(mdb.dbSet) {
+		return 0
+	}
+	return mdb.dbSet[dbIndex].slots.count(slot)
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"go-redis/lib/logger"
+)
+
+// SnapshotEntry is a key and its DUMP payload in snapshot
+type SnapshotEntry struct {
+	Key     string
+	Payload []byte
+}
+
+// Snapshot serializes all keys of each db at the same point, and calls onSnapshot before commands are resumed
+// 快照期间阻塞所有 db 的命令，onSnapshot 之后执行的写命令都不在快照中，用于主从复制的全量同步
+// 快照保存在内存中，需要与数据相同大小的内存
+func (mdb *StandaloneDatabase) Snapshot(onSnapshot func()) [][]SnapshotEntry {
+	for _, db := range mdb.dbSet {
+		db.barrier.Lock()
+	}
+	defer func() {
+		for _, db := range mdb.dbSet {
+			db.barrier.Unlock()
+		}
+	}()
+	result := make([][]SnapshotEntry, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		entries := make([]SnapshotEntry, 0, db.data.Len())
+		for _, key := range db.data.Keys() {
+			entity, ok := db.GetEntity(key)
+			if !ok {
+				continue
+			}
+			payload, err := SerializeEntity(entity)
+			if err != nil {
+				logger.Warn("serialize " + key + " failed: " + err.Error())
+				continue
+			}
+			entries = append(entries, SnapshotEntry{Key: key, Payload: payload})
+		}
+		result[i] = entries
+	}
+	onSnapshot()
+	return result
+}
@@ -0,0 +1,47 @@
+package database
+
+import (
+	"go-redis/lib/utils"
+	"go-redis/resp/connection"
+	"testing"
+	"time"
+)
+
+func TestSnapshotBlocksCommands(t *testing.T) {
+	mdb := NewStandaloneDatabase()
+	c := connection.NewConn(nil)
+	mdb.Exec(c, utils.ToCmdLine("SET", "a", "1"))
+
+	done := make(chan struct{})
+	release := make(chan struct{})
+	result := make(chan [][]SnapshotEntry, 1)
+	go func() {
+		result <- mdb.Snapshot(func() {
+			go func() {
+				mdb.Exec(c, utils.ToCmdLine("SET", "b", "2"))
+				close(done)
+			}()
+			<-release
+		})
+	}()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("write should be blocked during snapshot")
+	default:
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write should be resumed after snapshot")
+	}
+	snapshot := <-result
+	if len(snapshot[0]) != 1 || snapshot[0][0].Key != "a" {
+		t.Fatalf("snapshot should only contain a, got %v", snapshot[0])
+	}
+	entity, err := DeserializeEntity(snapshot[0][0].Payload)
+	if err != nil || string(entity.Data.([]byte)) != "1" {
+		t.Fatalf("unexpected payload of a: %v", err)
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"go-redis/config"
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
+	"go-redis/lib/utils"
 	"go-redis/resp/reply"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // StandaloneDatabase is a set of multiple database set
@@ -17,8 +19,10 @@ import (
 type StandaloneDatabase struct {
 	dbSet []*DB
 	// handle aof persistence
-	// 创建一个 aofHandler，用于执行 aof 相关业务
-	aofHandler *aof.AofHandler
+	// 创建一个 aofHandler，用于执行 aof 相关业务，CONFIG SET appendonly 时替换，没有开启 AOF 时为 nil
+	aofHandler atomic.Pointer[aof.AofHandler]
+	// 写命令监听器，写命令执行成功后与 AOF 一同调用，用于集群的主从复制
+	writeListeners []func(dbIndex int, cmdLine CmdLine)
 }
 
 // NewStandaloneDatabase creates a standaloneDatabase redis database,
@@ -42,19 +46,58 @@ func NewStandaloneDatabase() *StandaloneDatabase {
 		if err != nil {
 			panic(err)
 		}
-		mdb.aofHandler = handler
+		mdb.aofHandler.Store(handler)
+	}
+	config.OnSet("appendonly", mdb.applyAppendOnly)
 
-		// 给每个 db 添加 addAof 方法
-		for _, db := range mdb.dbSet {
-			// go1.22 版本后，db 不会再产生闭包问题
-			db.addAof = func(line CmdLine) {
-				mdb.aofHandler.AddAof(db.index, line)
+	// 给每个 db 添加 addAof 方法
+	// 加载 AOF 文件时 addAof 仍为空实现，避免恢复的数据重复写入 AOF
+	for _, db := range mdb.dbSet {
+		// go1.22 版本后，db 不会再产生闭包问题
+		db.addAof = func(line CmdLine) {
+			if handler := mdb.aofHandler.Load(); handler != nil {
+				handler.AddAof(db.index, line)
+			}
+			for _, listener := range mdb.writeListeners {
+				listener(db.index, line)
 			}
 		}
 	}
 	return mdb
 }
 
+// applyAppendOnly starts or stops AOF after appendonly is changed by CONFIG SET
+// 开启时清空 AOF 文件并写入当前所有的 key，之后的写命令照常追加
+func (mdb *StandaloneDatabase) applyAppendOnly(next *config.ServerProperties) error {
+	current := mdb.aofHandler.Load()
+	if next.AppendOnly == (current != nil) {
+		return nil
+	}
+	if !next.AppendOnly {
+		mdb.aofHandler.Store(nil)
+		current.Close()
+		logger.Info("aof stopped")
+		return nil
+	}
+	handler, err := aof.CreateAOFHandler(mdb)
+	if err != nil {
+		return err
+	}
+	// 先替换 handler 再写入快照，快照期间的写命令同样写入新文件
+	mdb.aofHandler.Store(handler)
+	for _, db := range mdb.dbSet {
+		db.writeSnapshot(handler)
+	}
+	logger.Info("aof started")
+	return nil
+}
+
+// AddWriteListener registers a function which will be called after each write command
+// 需要在处理客户端请求之前注册，监听器中不能再执行写命令
+func (mdb *StandaloneDatabase) AddWriteListener(listener func(dbIndex int, cmdLine CmdLine)) {
+	mdb.writeListeners = append(mdb.writeListeners, listener)
+}
+
 // Exec executes command
 // parameter `cmdLine` contains command and its arguments, for example: "set key value"
 // 执行数据库相关的核心业务方法
@@ -76,12 +119,51 @@ func (mdb *StandaloneDatabase) Exec(c resp.Connection, cmdLine [][]byte) (result
 		}
 		return execSelect(c, mdb, cmdLine[1:])
 	}
+	if cmdName == "flushall" {
+		return mdb.flushAll()
+	}
 	// normal commands
 	dbIndex := c.GetDBIndex()
 	selectedDB := mdb.dbSet[dbIndex]
 	return selectedDB.Exec(c, cmdLine)
 }
 
+// flushAll removes all data in all db
+// FLUSHALL [ASYNC|SYNC]
+// 只写入一条 FlushAll 到 AOF，重新加载时同样清空所有 db
+func (mdb *StandaloneDatabase) flushAll() resp.Reply {
+	for _, db := range mdb.dbSet {
+		db.barrier.RLock()
+		defer db.barrier.RUnlock()
+	}
+	for _, db := range mdb.dbSet {
+		db.Flush()
+	}
+	mdb.dbSet[0].addAof(utils.ToCmdLine("FlushAll"))
+	return reply.MakeOkReply()
+}
+
+// ExecWithLock executes normal commands, invoker should provide locks
+// 调用方已经通过 RWLocks 对命令相关的 key 加锁
+func (mdb *StandaloneDatabase) ExecWithLock(c resp.Connection, cmdLine [][]byte) resp.Reply {
+	return mdb.dbSet[c.GetDBIndex()].execWithLock(cmdLine)
+}
+
+// RWLocks locks keys in the given db for writing and reading
+func (mdb *StandaloneDatabase) RWLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	mdb.dbSet[dbIndex].RWLocks(writeKeys, readKeys)
+}
+
+// TryRWLocks tries to lock keys in the given db without blocking, returns whether the keys are locked
+func (mdb *StandaloneDatabase) TryRWLocks(dbIndex int, writeKeys []string, readKeys []string) bool {
+	return mdb.dbSet[dbIndex].TryRWLocks(writeKeys, readKeys)
+}
+
+// RWUnLocks unlocks keys in the given db for writing and reading
+func (mdb *StandaloneDatabase) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
+	mdb.dbSet[dbIndex].RWUnLocks(writeKeys, readKeys)
+}
+
 // Close graceful shutdown database
 // 不需要实现
 func (mdb *StandaloneDatabase) Close() {}
@@ -12,7 +12,7 @@ import (
 // GET k1
 func execGet(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, ok := db.GetEntity(key)
+	entity, ok := db.GetEntityForRead(key)
 	if !ok {
 		return reply.MakeNullBulkReply()
 	}
@@ -70,7 +70,7 @@ func execGetSet(db *DB, args [][]byte) resp.Reply {
 // STRLEN k -> 'value' -> 5
 func execStrLen(db *DB, args [][]byte) resp.Reply {
 	key := string(args[0])
-	entity, exists := db.GetEntity(key)
+	entity, exists := db.GetEntityForRead(key)
 	if !exists {
 		return reply.MakeNullBulkReply()
 	}
@@ -78,10 +78,102 @@ func execStrLen(db *DB, args [][]byte) resp.Reply {
 	return reply.MakeIntReply(int64(len(old)))
 }
 
+// execMSet sets multi key-value in database
+// MSET k1 v1 k2 v2
+func execMSet(db *DB, args [][]byte) resp.Reply {
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	size := len(args) / 2
+	keys := make([]string, size)
+	values := make([][]byte, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+		values[i] = args[2*i+1]
+	}
+
+	for i, key := range keys {
+		value := values[i]
+		db.PutEntity(key, &database.DataEntity{Data: value})
+	}
+	db.addAof(utils.ToCmdLine2("MSet", args...))
+	return &reply.OkReply{}
+}
+
+// prepareMSet returns the keys of MSET and MSETNX as write keys
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
+// undoMSet returns undo logs of MSET and MSETNX
+func undoMSet(db *DB, args [][]byte) []CmdLine {
+	writeKeys, _ := prepareMSet(args)
+	return rollbackGivenKeys(db, writeKeys...)
+}
+
+// execMGet get multi key-value from database
+// MGET k1 k2 k3
+// 不存在的 key 返回 nil
+func execMGet(db *DB, args [][]byte) resp.Reply {
+	keys := make([]string, len(args))
+	for i, v := range args {
+		keys[i] = string(v)
+	}
+
+	result := make([][]byte, len(args))
+	for i, key := range keys {
+		entity, exists := db.GetEntityForRead(key)
+		if !exists {
+			result[i] = nil
+			continue
+		}
+		bytes, ok := entity.Data.([]byte)
+		if !ok {
+			result[i] = nil
+			continue
+		}
+		result[i] = bytes
+	}
+	return reply.MakeMultiBulkReply(result)
+}
+
+// execMSetNX sets multi key-value in database, only if none of the given keys exist
+// MSETNX k1 v1 k2 v2
+// 只要有一个 key 已经存在，所有的 key 都不会被设置，返回 0
+func execMSetNX(db *DB, args [][]byte) resp.Reply {
+	// parse args
+	if len(args)%2 != 0 {
+		return reply.MakeSyntaxErrReply()
+	}
+	size := len(args) / 2
+	for i := 0; i < size; i++ {
+		key := string(args[2*i])
+		if _, exists := db.GetEntity(key); exists {
+			return reply.MakeIntReply(0)
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		key := string(args[2*i])
+		value := args[2*i+1]
+		db.PutEntity(key, &database.DataEntity{Data: value})
+	}
+	db.addAof(utils.ToCmdLine2("MSetNX", args...))
+	return reply.MakeIntReply(1)
+}
+
 func init() {
-	RegisterCommand("get", execGet, 2)  // get k1
-	RegisterCommand("set", execSet, -3) // set k1 v1 k2 v2...
-	RegisterCommand("setNx", execSetNX, 3)
-	RegisterCommand("getSet", execGetSet, 3)
-	RegisterCommand("strLen", execStrLen, 2)
+	RegisterCommand("get", execGet, readFirstKey, nil, 2, "@read", "@string", "@fast")                      // get k1
+	RegisterCommand("set", execSet, writeFirstKey, rollbackFirstKey, -3, "@write", "@string", "@slow")      // set k1 v1 k2 v2...
+	RegisterCommand("setNx", execSetNX, writeFirstKey, rollbackFirstKey, 3, "@write", "@string", "@fast")   // setnx k1 v1
+	RegisterCommand("getSet", execGetSet, writeFirstKey, rollbackFirstKey, 3, "@write", "@string", "@fast") // getset k1 v1
+	RegisterCommand("strLen", execStrLen, readFirstKey, nil, 2, "@read", "@string", "@fast")                // strlen k1
+	RegisterCommand("mSet", execMSet, prepareMSet, undoMSet, -3, "@write", "@string", "@slow")              // mset k1 v1 k2 v2
+	RegisterCommand("mGet", execMGet, readAllKeys, nil, -2, "@read", "@string", "@fast")                    // mget k1 k2
+	RegisterCommand("mSetNX", execMSetNX, prepareMSet, undoMSet, -3, "@write", "@string", "@slow")          // msetnx k1 v1 k2 v2
 }
@@ -0,0 +1,57 @@
+package database
+
+import (
+	"go-redis/lib/utils"
+	"strings"
+)
+
+/*
+回滚日志(undo log)
+集群的分布式事务(TCC)在提交前记录命令涉及的 key 的原始状态，
+某个节点提交失败时，其他已提交的节点依次执行回滚日志，将 key 恢复原状：
+	key 原本存在：RESTORE key 0 payload REPLACE
+	key 原本不存在：DEL key
+*/
+
+// rollbackFirstKey returns undo logs of the first key
+func rollbackFirstKey(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	return rollbackGivenKeys(db, key)
+}
+
+// rollbackGivenKeys returns undo logs which restore the given keys to current state
+func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
+	var undoCmdLines []CmdLine
+	for _, key := range keys {
+		entity, ok := db.GetEntity(key)
+		if !ok {
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+			continue
+		}
+		payload, err := SerializeEntity(entity)
+		if err != nil {
+			continue
+		}
+		undoCmdLines = append(undoCmdLines, [][]byte{[]byte("RESTORE"), []byte(key), []byte("0"), payload, []byte("REPLACE")})
+	}
+	return undoCmdLines
+}
+
+// GetRelatedKeys analyses the related keys of the command line
+// 返回命令需要加写锁和读锁的 key，未知的命令没有相关的 key
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string) {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.prepare == nil || !validateArity(cmd.arity, cmdLine) {
+		return nil, nil
+	}
+	return cmd.prepare(cmdLine[1:])
+}
+
+// GetUndoLogs returns the undo logs of the command line in the given db
+func (mdb *StandaloneDatabase) GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine {
+	cmd, ok := cmdTable[strings.ToLower(string(cmdLine[0]))]
+	if !ok || cmd.undo == nil || !validateArity(cmd.arity, cmdLine) {
+		return nil
+	}
+	return cmd.undo(mdb.dbSet[dbIndex], cmdLine[1:])
+}
@@ -21,3 +21,15 @@ type Database interface {
 type DataEntity struct {
 	Data interface{}
 }
+
+// InfoProvider is implemented by databases which report fields in INFO
+// 协议层输出 INFO 的各个 section，数据库补充与自身相关的字段
+type InfoProvider interface {
+	// InfoFields returns the `field:value` lines of the given section, nil if the database has no fields in it
+	InfoFields(section string) []string
+}
+
+// StatsResetter is implemented by databases which reset statistics reported in INFO by CONFIG RESETSTAT
+type StatsResetter interface {
+	ResetStats()
+}
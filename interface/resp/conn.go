@@ -1,9 +1,37 @@
 package resp
 
+import "net"
+
 // Connection represents a connection with redis client
 // Redis 协议层代表一个连接
 type Connection interface {
-	Write([]byte) error // 客户端回复消息
-	GetDBIndex() int    // 1-16个 db，返回当前使用的 db
-	SelectDB(int)       // 选择 db，切换数据库
+	Write([]byte) error   // 客户端回复消息
+	RemoteAddr() net.Addr // 客户端地址
+	GetDBIndex() int      // 1-16个 db，返回当前使用的 db
+	SelectDB(int)         // 选择 db，切换数据库
+
+	// 集群模式下的连接状态
+	SetAsking(bool)   // ASKING 命令设置，仅对下一条命令有效
+	IsAsking() bool   // 是否允许访问正在迁入本节点的 slot
+	SetReadOnly(bool) // READONLY/READWRITE 命令设置
+	IsReadOnly() bool // 是否允许在从节点上执行读命令
+
+	SetPassword(string)    // AUTH 命令认证成功后记录密码
+	GetPassword() string   // 连接认证使用的密码
+	SetAuthenticated(bool) // AUTH 认证成功后设置
+	IsAuthenticated() bool // 是否通过认证，配置了 requirepass 时未认证的连接只能执行 AUTH、HELLO、QUIT
+	SetUser(string)        // AUTH 认证成功后设置 ACL 用户
+	GetUser() string       // 执行命令的 ACL 用户，节点之间的连接为空
+
+	GetID() int64     // 连接 id
+	SetProtocol(int)  // HELLO 协商的协议版本
+	GetProtocol() int // 回复使用的协议版本，2 或 3
+	SetName(string)   // 设置连接名称
+	GetName() string  // 连接名称
+
+	// 发布订阅
+	Subscribe(channel string)   // 订阅频道
+	UnSubscribe(channel string) // 取消订阅频道
+	SubsCount() int             // 订阅的频道数量
+	GetChannels() []string      // 订阅的频道
 }
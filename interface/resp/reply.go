@@ -5,3 +5,10 @@ package resp
 type Reply interface {
 	ToBytes() []byte
 }
+
+// RESP3Reply is a reply which is encoded differently when the connection uses RESP3
+// 在 RESP3 下有不同编码的回复，ToBytes 返回 RESP2 编码，RESP2 没有的类型降级为相近的类型
+type RESP3Reply interface {
+	Reply
+	ToRESP3() []byte
+}
@@ -0,0 +1,175 @@
+package lock
+
+import (
+	"sort"
+	"sync"
+)
+
+const (
+	prime32 = uint32(16777619)
+)
+
+// Locks provides rw locks for key
+// 对 key 加锁。key 的数量不确定，不能为每个 key 创建一把锁，
+// 这里使用固定数量的锁，通过 key 的哈希值找到对应的锁，不同的 key 可能共用同一把锁
+type Locks struct {
+	table []*sync.RWMutex
+}
+
+// Make creates a new lock map
+func Make(tableSize int) *Locks {
+	table := make([]*sync.RWMutex, tableSize)
+	for i := 0; i < tableSize; i++ {
+		table[i] = &sync.RWMutex{}
+	}
+	return &Locks{
+		table: table,
+	}
+}
+
+// fnv32 FNV-1 哈希算法
+func fnv32(key string) uint32 {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash *= prime32
+		hash ^= uint32(key[i])
+	}
+	return hash
+}
+
+// spread returns the index of lock for the given hash code
+func (locks *Locks) spread(hashCode uint32) uint32 {
+	if locks == nil {
+		panic("dict is nil")
+	}
+	tableSize := uint32(len(locks.table))
+	return hashCode % tableSize
+}
+
+// Lock obtains exclusive lock for writing
+func (locks *Locks) Lock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Lock()
+}
+
+// RLock obtains shared lock for reading
+func (locks *Locks) RLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RLock()
+}
+
+// UnLock release exclusive lock
+func (locks *Locks) UnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].Unlock()
+}
+
+// RUnLock release shared lock
+func (locks *Locks) RUnLock(key string) {
+	index := locks.spread(fnv32(key))
+	locks.table[index].RUnlock()
+}
+
+// toLockIndices returns the sorted and deduplicated indices of locks for the given keys
+// 多个 key 按照相同的顺序加锁，避免死锁；多个 key 共用同一把锁时只加一次
+func (locks *Locks) toLockIndices(keys []string, reverse bool) []uint32 {
+	indexMap := make(map[uint32]struct{})
+	for _, key := range keys {
+		indexMap[locks.spread(fnv32(key))] = struct{}{}
+	}
+	indices := make([]uint32, 0, len(indexMap))
+	for index := range indexMap {
+		indices = append(indices, index)
+	}
+	sort.Slice(indices, func(i, j int) bool {
+		if !reverse {
+			return indices[i] < indices[j]
+		}
+		return indices[i] > indices[j]
+	})
+	return indices
+}
+
+// Locks obtains multiple exclusive locks for writing
+// invoking Lock in loop may cause dead lock, please use Locks
+func (locks *Locks) Locks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, false) {
+		locks.table[index].Lock()
+	}
+}
+
+// UnLocks releases multiple exclusive locks
+func (locks *Locks) UnLocks(keys ...string) {
+	for _, index := range locks.toLockIndices(keys, true) {
+		locks.table[index].Unlock()
+	}
+}
+
+// RWLocks locks write keys and read keys together. allow duplicate keys
+// 同一把锁既对应写 key 又对应读 key 时，加写锁
+func (locks *Locks) RWLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(append(keys, writeKeys...), readKeys...)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		writeIndexSet[locks.spread(fnv32(wKey))] = struct{}{}
+	}
+	for _, index := range locks.toLockIndices(keys, false) {
+		if _, w := writeIndexSet[index]; w {
+			locks.table[index].Lock()
+		} else {
+			locks.table[index].RLock()
+		}
+	}
+}
+
+// TryRWLocks tries to lock write keys and read keys together without blocking
+// returns false and releases locks already obtained if any of the locks is held by others
+func (locks *Locks) TryRWLocks(writeKeys []string, readKeys []string) bool {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(append(keys, writeKeys...), readKeys...)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		writeIndexSet[locks.spread(fnv32(wKey))] = struct{}{}
+	}
+	indices := locks.toLockIndices(keys, false)
+	for i, index := range indices {
+		_, w := writeIndexSet[index]
+		var ok bool
+		if w {
+			ok = locks.table[index].TryLock()
+		} else {
+			ok = locks.table[index].TryRLock()
+		}
+		if ok {
+			continue
+		}
+		// 释放已经获得的锁
+		for j := i - 1; j >= 0; j-- {
+			if _, w := writeIndexSet[indices[j]]; w {
+				locks.table[indices[j]].Unlock()
+			} else {
+				locks.table[indices[j]].RUnlock()
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// RWUnLocks unlocks write keys and read keys together. allow duplicate keys
+func (locks *Locks) RWUnLocks(writeKeys []string, readKeys []string) {
+	keys := make([]string, 0, len(writeKeys)+len(readKeys))
+	keys = append(append(keys, writeKeys...), readKeys...)
+	writeIndexSet := make(map[uint32]struct{})
+	for _, wKey := range writeKeys {
+		writeIndexSet[locks.spread(fnv32(wKey))] = struct{}{}
+	}
+	for _, index := range locks.toLockIndices(keys, true) {
+		if _, w := writeIndexSet[index]; w {
+			locks.table[index].Unlock()
+		} else {
+			locks.table[index].RUnlock()
+		}
+	}
+}
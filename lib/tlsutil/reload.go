@@ -0,0 +1,70 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// ReloadableConfig is a server tls config whose certificates could be reloaded from files
+// 重新加载只影响之后的握手，已经建立的连接不受影响
+type ReloadableConfig struct {
+	certFile   string
+	keyFile    string
+	caFile     string
+	clientAuth tls.ClientAuthType
+	current    atomic.Pointer[tls.Config]
+}
+
+// NewReloadableConfig loads certificates and creates ReloadableConfig
+func NewReloadableConfig(certFile, keyFile, caFile string, clientAuth tls.ClientAuthType) (*ReloadableConfig, error) {
+	r := &ReloadableConfig{
+		certFile:   certFile,
+		keyFile:    keyFile,
+		caFile:     caFile,
+		clientAuth: clientAuth,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads certificates from files again, the current config is kept if failed
+func (r *ReloadableConfig) Reload() error {
+	config, err := ServerConfig(r.certFile, r.keyFile, r.caFile)
+	if err != nil {
+		return err
+	}
+	if config.ClientCAs != nil {
+		config.ClientAuth = r.clientAuth
+	} else if r.clientAuth == tls.RequireAndVerifyClientCert {
+		return errors.New("tls-ca-cert-file is required to verify client certificates")
+	}
+	r.current.Store(config)
+	return nil
+}
+
+// Config returns the tls config used by listener, each handshake uses the latest loaded config
+func (r *ReloadableConfig) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return r.current.Load(), nil
+		},
+	}
+}
+
+// ParseClientAuth parses tls-auth-clients: yes(default), optional, no
+func ParseClientAuth(value string) (tls.ClientAuthType, error) {
+	switch strings.ToLower(value) {
+	case "", "yes":
+		return tls.RequireAndVerifyClientCert, nil
+	case "optional":
+		return tls.VerifyClientCertIfGiven, nil
+	case "no":
+		return tls.NoClientCert, nil
+	}
+	return tls.NoClientCert, errors.New("invalid tls-auth-clients: " + value)
+}
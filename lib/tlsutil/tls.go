@@ -0,0 +1,156 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+)
+
+// recordTypeHandshake is the first byte of TLS ClientHello
+// TLS 连接的第一个字节，普通的 RESP 协议不会以该字节开头
+const recordTypeHandshake = 0x16
+
+// loadCertPool loads CA certificates from file
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, errors.New("no certificate found in " + caFile)
+	}
+	return pool, nil
+}
+
+// ServerConfig creates tls config for server
+// 配置了 CA 时验证对方提供的客户端证书
+func ServerConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return config, nil
+}
+
+// ClientConfig creates tls config for client
+// 使用 CA 验证服务端证书，证书和私钥作为客户端证书(可选)
+func ClientConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// SniffListener accepts both plain and TLS connections on the same port
+// 根据连接的第一个字节判断是否为 TLS 连接，普通客户端和使用 TLS 的节点可以共用一个端口
+func SniffListener(listener net.Listener, config *tls.Config) net.Listener {
+	return &sniffListener{Listener: listener, config: config}
+}
+
+type sniffListener struct {
+	net.Listener
+	config *tls.Config
+}
+
+// Accept returns connection without reading, the first byte is read on the first Read or Write
+// 不能在 Accept 中读取数据，否则一个不发送数据的连接会阻塞所有新连接
+func (l *sniffListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &sniffConn{Conn: conn, config: l.config}, nil
+}
+
+// sniffConn is plain or TLS connection decided by the first byte
+type sniffConn struct {
+	net.Conn
+	config *tls.Config
+	once   sync.Once
+	conn   net.Conn // 判断之后实际读写的连接
+	isTLS  bool
+	err    error
+}
+
+func (c *sniffConn) sniff() error {
+	c.once.Do(func() {
+		first := make([]byte, 1)
+		if _, err := io.ReadFull(c.Conn, first); err != nil {
+			c.err = err
+			return
+		}
+		raw := &prefixConn{Conn: c.Conn, prefix: first}
+		if first[0] == recordTypeHandshake {
+			c.conn = tls.Server(raw, c.config)
+			c.isTLS = true
+		} else {
+			c.conn = raw
+		}
+	})
+	return c.err
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	if err := c.sniff(); err != nil {
+		return 0, err
+	}
+	return c.conn.Read(b)
+}
+
+func (c *sniffConn) Write(b []byte) (int, error) {
+	if err := c.sniff(); err != nil {
+		return 0, err
+	}
+	return c.conn.Write(b)
+}
+
+// IsTLS returns whether the connection uses TLS, only valid after the first Read
+func (c *sniffConn) IsTLS() bool {
+	return c.isTLS
+}
+
+// prefixConn returns the bytes already read before reading from connection
+type prefixConn struct {
+	net.Conn
+	prefix []byte
+}
+
+func (c *prefixConn) Read(b []byte) (int, error) {
+	if len(c.prefix) > 0 {
+		n := copy(b, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(b)
+}
@@ -1,12 +1,16 @@
 package main
 
 import (
+	"crypto/tls"
 	"fmt"
 	"go-redis/config"
 	"go-redis/lib/logger"
+	"go-redis/lib/tlsutil"
 	"go-redis/resp/handler"
 	"go-redis/tcp"
 	"os"
+	"strconv"
+	"time"
 )
 
 const configFile string = "redis.conf"
@@ -35,13 +39,61 @@ func main() {
 		config.Properties = defaultProperties
 	}
 
-	err := tcp.ListenAndServeWithSignal(
-		&tcp.Config{
-			Address: fmt.Sprintf("%s:%d",
-				config.Properties.Bind,
-				config.Properties.Port),
-		},
-		handler.MakeHandler())
+	cfg := &tcp.Config{
+		Address: fmt.Sprintf("%s:%d",
+			config.Properties.Bind,
+			config.Properties.Port),
+		KeepAlive: time.Duration(config.Properties.TCPKeepAlive) * time.Second,
+	}
+	// 证书在收到 SIGHUP 时重新加载
+	var reloadable []*tlsutil.ReloadableConfig
+	// 节点之间使用 TLS 时，其他节点通过 TLS 连接本节点的端口
+	if config.Properties.TLSCluster {
+		clusterTLS, err := tlsutil.NewReloadableConfig(config.Properties.TLSCertFile, config.Properties.TLSKeyFile,
+			config.Properties.TLSCACertFile, tls.VerifyClientCertIfGiven)
+		if err != nil {
+			logger.Fatal("load tls certificate failed: " + err.Error())
+		}
+		cfg.TLSConfig = clusterTLS.Config()
+		reloadable = append(reloadable, clusterTLS)
+	}
+	// 客户端通过 tls-port 使用 TLS 连接
+	if config.Properties.TLSPort > 0 {
+		clientAuth, err := tlsutil.ParseClientAuth(config.Properties.TLSAuthClients)
+		if err != nil {
+			logger.Fatal(err.Error())
+		}
+		clientTLS, err := tlsutil.NewReloadableConfig(config.Properties.TLSCertFile, config.Properties.TLSKeyFile,
+			config.Properties.TLSCACertFile, clientAuth)
+		if err != nil {
+			logger.Fatal("load tls certificate failed: " + err.Error())
+		}
+		cfg.TLSAddress = fmt.Sprintf("%s:%d", config.Properties.Bind, config.Properties.TLSPort)
+		cfg.ClientTLSConfig = clientTLS.Config()
+		reloadable = append(reloadable, clientTLS)
+	}
+	// 同一台机器上的客户端可以通过 Unix socket 连接
+	if config.Properties.UnixSocket != "" {
+		cfg.UnixSocket = config.Properties.UnixSocket
+		if config.Properties.UnixSocketPerm != "" {
+			perm, err := strconv.ParseUint(config.Properties.UnixSocketPerm, 8, 32)
+			if err != nil {
+				logger.Fatal("invalid unixsocketperm: " + config.Properties.UnixSocketPerm)
+			}
+			cfg.UnixSocketPerm = os.FileMode(perm)
+		}
+	}
+	cfg.OnReload = func() {
+		for _, r := range reloadable {
+			if err := r.Reload(); err != nil {
+				logger.Error("reload tls certificate failed: " + err.Error())
+				return
+			}
+		}
+		logger.Info("tls certificates reloaded")
+	}
+
+	err := tcp.ListenAndServeWithSignal(cfg, handler.MakeHandler())
 	if err != nil {
 		logger.Error(err)
 	}
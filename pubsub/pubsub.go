@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"sort"
+	"sync"
+)
+
+/*
+发布订阅
+只在本节点内发布，集群模式下不会转发给其他节点的订阅者
+订阅确认和消息在 RESP2 中是数组，在 RESP3 中是推送数据(push)
+*/
+
+var (
+	subscribeBytes   = []byte("subscribe")
+	unsubscribeBytes = []byte("unsubscribe")
+	messageBytes     = []byte("message")
+)
+
+// Hub stores the subscribers of channels
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[string]map[resp.Connection]struct{} // channel -> subscribers
+}
+
+// MakeHub creates Hub
+func MakeHub() *Hub {
+	return &Hub{
+		subs: make(map[string]map[resp.Connection]struct{}),
+	}
+}
+
+// writeReply writes reply encoded by the protocol of connection
+func writeReply(c resp.Connection, r resp.Reply) {
+	_ = c.Write(reply.Encode(r, c.GetProtocol()))
+}
+
+func makeSubscribeReply(kind []byte, channel string, count int) resp.Reply {
+	var channelReply resp.Reply = reply.MakeBulkReply([]byte(channel))
+	if channel == "" {
+		channelReply = reply.MakeNullBulkReply()
+	}
+	return reply.MakePushReply([]resp.Reply{
+		reply.MakeBulkReply(kind),
+		channelReply,
+		reply.MakeIntReply(int64(count)),
+	})
+}
+
+// Subscribe subscribes channels, a reply is sent for each channel
+// SUBSCRIBE channel [channel ...]
+func (hub *Hub) Subscribe(c resp.Connection, channels []string) resp.Reply {
+	// 每个频道回复一次，回复中是当时订阅的频道数量
+	for _, channel := range channels {
+		hub.mu.Lock()
+		subscribers, ok := hub.subs[channel]
+		if !ok {
+			subscribers = make(map[resp.Connection]struct{})
+			hub.subs[channel] = subscribers
+		}
+		subscribers[c] = struct{}{}
+		c.Subscribe(channel)
+		hub.mu.Unlock()
+		writeReply(c, makeSubscribeReply(subscribeBytes, channel, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// UnSubscribe unsubscribes channels, all channels are unsubscribed if channels is empty
+// UNSUBSCRIBE [channel ...]
+func (hub *Hub) UnSubscribe(c resp.Connection, channels []string) resp.Reply {
+	if len(channels) == 0 {
+		channels = c.GetChannels()
+		sort.Strings(channels)
+	}
+	if len(channels) == 0 {
+		// 没有订阅任何频道时也需要回复
+		writeReply(c, makeSubscribeReply(unsubscribeBytes, "", 0))
+		return &reply.NoReply{}
+	}
+	for _, channel := range channels {
+		hub.mu.Lock()
+		hub.remove(c, channel)
+		hub.mu.Unlock()
+		writeReply(c, makeSubscribeReply(unsubscribeBytes, channel, c.SubsCount()))
+	}
+	return &reply.NoReply{}
+}
+
+// UnsubscribeAll removes connection from all channels without reply, called when connection closed
+func (hub *Hub) UnsubscribeAll(c resp.Connection) {
+	channels := c.GetChannels()
+	if len(channels) == 0 {
+		return
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	for _, channel := range channels {
+		hub.remove(c, channel)
+	}
+}
+
+// remove removes connection from channel, caller should hold lock
+func (hub *Hub) remove(c resp.Connection, channel string) {
+	c.UnSubscribe(channel)
+	subscribers, ok := hub.subs[channel]
+	if !ok {
+		return
+	}
+	delete(subscribers, c)
+	if len(subscribers) == 0 {
+		delete(hub.subs, channel)
+	}
+}
+
+// Publish sends message to all subscribers of channel, returns the number of subscribers
+// 消息内容可以是数组，如 __redis__:invalidate 频道的失效通知
+func (hub *Hub) Publish(channel string, message resp.Reply) int {
+	hub.mu.RLock()
+	subscribers := make([]resp.Connection, 0, len(hub.subs[channel]))
+	for c := range hub.subs[channel] {
+		subscribers = append(subscribers, c)
+	}
+	hub.mu.RUnlock()
+	msg := reply.MakePushReply([]resp.Reply{
+		reply.MakeBulkReply(messageBytes),
+		reply.MakeBulkReply([]byte(channel)),
+		message,
+	})
+	for _, c := range subscribers {
+		writeReply(c, msg)
+	}
+	return len(subscribers)
+}
+
+// IsSubscribed returns whether the connection subscribes the channel
+func (hub *Hub) IsSubscribed(c resp.Connection, channel string) bool {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	_, ok := hub.subs[channel][c]
+	return ok
+}
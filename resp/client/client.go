@@ -1,6 +1,8 @@
 package client
 
 import (
+	"crypto/tls"
+	"errors"
 	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/sync/wait"
@@ -8,21 +10,38 @@ import (
 	"go-redis/resp/reply"
 	"net"
 	"runtime/debug"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Client is a pipeline mode redis client
 // Redis 的客户端结构
+// 多个协程可以同时使用同一个 Client：发送协程把排队的请求合并为一次写入，回复按照发送的顺序依次对应到请求
 type Client struct {
 	conn        net.Conn
 	pendingReqs chan *request // wait to send。发送缓冲区
-	waitingReqs chan *request // waiting response。等待回复缓冲区
+	waitingReqs chan *request // waiting response。当前连接的等待回复缓冲区，重新连接后替换为新的缓冲区，只由发送协程访问
 	ticker      *time.Ticker
 	addr        string
+	timeout     time.Duration // 等待回复的最长时间
+	tlsConfig   *tls.Config   // 非空时使用 TLS 连接
+	handshake   [][][]byte    // 建立连接后需要先发送的命令，如 AUTH、READONLY，重新连接后再次发送
+	handshakeMu sync.Mutex    // 保护 handshake
+	reconnected bool          // 重新连接后还没有发送 handshake，只由发送协程访问
+
+	// 连接当前选择的 DB，只由发送协程修改；SELECT 失败时置为 unknownDB，下次请求重新发送 SELECT
+	dbIndex atomic.Int64
 
 	// 正在处理的请求数
 	working *sync.WaitGroup // its counter presents unfinished requests(pending and waiting)
+	// 发送协程退出后关闭，之后才能关闭 waitingReqs
+	writeDone chan struct{}
+
+	// 关闭后不再接受新的请求，避免向已关闭的 pendingReqs 发送
+	mu     sync.RWMutex
+	closed bool
 }
 
 // request is a message sends to redis server
@@ -34,27 +53,101 @@ type request struct {
 	heartbeat bool       // 是否为心跳包
 	waiting   *wait.Wait // 等待回复（包含超时时间）
 	err       error      // 错误记录
+	dbIndex   int        // 需要在哪个 DB 执行，anyDB 表示在连接当前的 DB 执行
+	asking    bool       // 是否需要在命令之前发送 ASKING
+	selectDB  bool       // 是否为发送协程自动插入的 SELECT
+	handshake bool       // 是否为重新连接后自动插入的 handshake 命令
 }
 
 const (
-	chanSize = 256
-	maxWait  = 3 * time.Second
+	chanSize     = 256
+	maxWait      = 3 * time.Second
+	maxBatchSize = 64 // 合并为一次写入的最大请求数
+)
+
+const (
+	anyDB     = -1 // 请求不关心 DB
+	unknownDB = -1 // 不确定连接当前选择的 DB
 )
 
 // MakeClient creates a new client
 // 新建一个 Redis 客户端，与服务端建立连接，返回一个 Client 对象
 func MakeClient(addr string) (*Client, error) {
-	conn, err := net.Dial("tcp", addr)
+	return MakeTLSClient(addr, nil)
+}
+
+// MakeTLSClient creates a new client connected by TLS, plain tcp is used if tlsConfig is nil
+func MakeTLSClient(addr string, tlsConfig *tls.Config) (*Client, error) {
+	conn, err := dial(addr, tlsConfig)
 	if err != nil {
 		return nil, err
 	}
 	return &Client{
 		addr:        addr,
 		conn:        conn,
+		tlsConfig:   tlsConfig,
 		pendingReqs: make(chan *request, chanSize),
 		waitingReqs: make(chan *request, chanSize),
 		working:     &sync.WaitGroup{},
-	}, nil
+		writeDone:   make(chan struct{}),
+		timeout:     maxWait,
+	}, nil // 新连接默认选择 0 号 DB
+}
+
+func dial(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	if tlsConfig == nil {
+		return net.Dial("tcp", addr)
+	}
+	return tls.Dial("tcp", addr, tlsConfig)
+}
+
+// Handshake sends the command which should be sent again after reconnecting, such as AUTH and READONLY
+// should be called after Start
+func (client *Client) Handshake(cmdLine [][]byte) resp.Reply {
+	client.handshakeMu.Lock()
+	client.handshake = append(client.handshake, cmdLine)
+	client.handshakeMu.Unlock()
+	return client.Send(cmdLine)
+}
+
+// Auth authenticates the connection, the password is sent again after reconnecting
+// should be called after Start
+func (client *Client) Auth(password string) error {
+	ret := client.Handshake([][]byte{[]byte("AUTH"), []byte(password)})
+	if bytes := ret.ToBytes(); len(bytes) > 0 && bytes[0] == '-' {
+		return errors.New("auth failed: " + string(bytes[1:len(bytes)-2]))
+	}
+	return nil
+}
+
+// SetTimeout sets the max time to wait for reply, should be called before Start
+func (client *Client) SetTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		client.timeout = timeout
+	}
+}
+
+// ConnErrReply means the request failed because of the connection rather than the command
+// the command may or may not have been executed by server
+// 连接错误：超时、发送失败、连接断开等，与服务端回复的错误区分，用于判断节点是否可用
+type ConnErrReply struct {
+	Msg string
+}
+
+// ToBytes marshals redis.Reply
+func (r *ConnErrReply) ToBytes() []byte {
+	return []byte("-" + r.Msg + "\r\n")
+}
+
+// Error implements error
+func (r *ConnErrReply) Error() string {
+	return r.Msg
+}
+
+// IsConnErr returns whether the reply is caused by connection error
+func IsConnErr(r resp.Reply) bool {
+	_, ok := r.(*ConnErrReply)
+	return ok
 }
 
 // Start starts asynchronous goroutines
@@ -65,12 +158,7 @@ func (client *Client) Start() {
 	go client.handleWrite()
 
 	// 协程异步处理响应，处理服务端的数据回复
-	go func() {
-		err := client.handleRead()
-		if err != nil {
-			logger.Error(err)
-		}
-	}()
+	go client.handleRead(client.conn, client.waitingReqs)
 
 	// 客户端心跳保活
 	go client.heartbeat()
@@ -81,10 +169,14 @@ func (client *Client) Start() {
 func (client *Client) Close() {
 	client.ticker.Stop()
 	// stop new request
+	client.mu.Lock()
+	client.closed = true
 	close(client.pendingReqs)
+	client.mu.Unlock()
 
 	// wait stop process
 	client.working.Wait()
+	<-client.writeDone
 
 	// clean
 	_ = client.conn.Close()
@@ -93,6 +185,7 @@ func (client *Client) Close() {
 
 // handleConnectionError 处理连接错误
 // 关闭已有连接，尝试进行重新连接
+// 旧连接上等待回复的请求全部失败，新连接使用新的等待回复缓冲区，避免旧的请求与新连接的回复错位
 func (client *Client) handleConnectionError(err error) error {
 	err1 := client.conn.Close()
 	if err1 != nil {
@@ -104,15 +197,19 @@ func (client *Client) handleConnectionError(err error) error {
 			return err1
 		}
 	}
-	conn, err1 := net.Dial("tcp", client.addr)
+	// 关闭旧的等待回复缓冲区后，旧连接的读取协程使剩余的请求失败并退出
+	close(client.waitingReqs)
+	client.waitingReqs = make(chan *request, chanSize)
+	conn, err1 := dial(client.addr, client.tlsConfig)
 	if err1 != nil {
 		logger.Error(err1)
 		return err1
 	}
 	client.conn = conn
-	go func() {
-		_ = client.handleRead()
-	}()
+	// 新连接默认选择 0 号 DB，且需要重新认证
+	client.dbIndex.Store(0)
+	client.reconnected = true
+	go client.handleRead(conn, client.waitingReqs)
 	return nil
 }
 
@@ -125,36 +222,69 @@ func (client *Client) heartbeat() {
 
 // handleWrite 批量处理请求
 func (client *Client) handleWrite() {
-	// 从等待发送的 Channel 中取出待发送的 req，并将其发送到服务端
+	defer close(client.writeDone)
+	batch := make([]*request, 0, maxBatchSize)
+	// 从等待发送的 Channel 中取出待发送的 req，连同已经在排队的 req 一起发送到服务端
 	for req := range client.pendingReqs {
-		client.doRequest(req)
+		batch = append(batch[:0], req)
+	drain:
+		for len(batch) < maxBatchSize {
+			select {
+			case next, ok := <-client.pendingReqs:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+		client.doRequest(batch)
 	}
 }
 
-// Send sends a request to redis server
+// Send sends a request to redis server, the command is executed in the db currently selected by the connection
 // 发送命令给 Redis 服务端
 func (client *Client) Send(args [][]byte) resp.Reply {
-	// 构建请求结构
-	request := &request{
-		args:      args,
-		heartbeat: false,
-		waiting:   &wait.Wait{},
-	}
+	return client.send(&request{args: args, dbIndex: anyDB})
+}
+
+// SendInDB sends a request which is executed in the given db
+// SELECT is sent before the command only if the connection selected another db
+// 连接记住当前选择的 DB，只有 DB 变化时才需要多发送一次 SELECT
+func (client *Client) SendInDB(dbIndex int, args [][]byte) resp.Reply {
+	return client.send(&request{args: args, dbIndex: dbIndex})
+}
+
+// SendAsking sends ASKING and the command in the given db
+// ASKING 只对紧随其后的一条命令有效，发送协程保证两者之间不会插入其他请求
+func (client *Client) SendAsking(dbIndex int, args [][]byte) resp.Reply {
+	return client.send(&request{args: args, dbIndex: dbIndex, asking: true})
+}
+
+func (client *Client) send(request *request) resp.Reply {
+	request.waiting = &wait.Wait{}
 	// 添加到等待队列
 	request.waiting.Add(1)
 	// 添加到工作队列
 	client.working.Add(1)
 	defer client.working.Done()
 	// 将发送请求，添加到等待发送的 Channel
+	client.mu.RLock()
+	if client.closed {
+		client.mu.RUnlock()
+		return &ConnErrReply{Msg: "client closed"}
+	}
 	client.pendingReqs <- request
+	client.mu.RUnlock()
 
-	// 阻塞等待，且包含 3s 超时
-	timeout := request.waiting.WaitWithTimeout(maxWait)
+	// 阻塞等待，默认 3s 超时
+	timeout := request.waiting.WaitWithTimeout(client.timeout)
 	if timeout {
-		return reply.MakeErrReply("server time out")
+		return &ConnErrReply{Msg: "server time out"}
 	}
 	if request.err != nil {
-		return reply.MakeErrReply("request failed")
+		return &ConnErrReply{Msg: "request failed"}
 	}
 	return request.reply
 }
@@ -165,22 +295,73 @@ func (client *Client) doHeartbeat() {
 		args:      [][]byte{[]byte("PING")},
 		heartbeat: true,
 		waiting:   &wait.Wait{},
+		dbIndex:   anyDB,
 	}
 	request.waiting.Add(1)
 	client.working.Add(1)
 	defer client.working.Done()
+	client.mu.RLock()
+	if client.closed {
+		client.mu.RUnlock()
+		return
+	}
 	client.pendingReqs <- request
-	request.waiting.WaitWithTimeout(maxWait)
+	client.mu.RUnlock()
+	request.waiting.WaitWithTimeout(client.timeout)
+}
+
+// encode converts requests into RESP bytes
+// 按需在请求之前插入 SELECT、ASKING，返回需要等待回复的全部请求(包括插入的请求)
+func (client *Client) encode(batch []*request) ([]byte, []*request) {
+	var buf []byte
+	sent := make([]*request, 0, len(batch)+1)
+	if client.reconnected {
+		client.handshakeMu.Lock()
+		for _, cmdLine := range client.handshake {
+			handshakeReq := &request{args: cmdLine, handshake: true}
+			buf = append(buf, reply.MakeMultiBulkReply(handshakeReq.args).ToBytes()...)
+			sent = append(sent, handshakeReq)
+		}
+		client.handshakeMu.Unlock()
+	}
+	dbIndex := int(client.dbIndex.Load())
+	for _, req := range batch {
+		if req.dbIndex != anyDB && req.dbIndex != dbIndex {
+			selectReq := &request{
+				args:     [][]byte{[]byte("SELECT"), []byte(strconv.Itoa(req.dbIndex))},
+				dbIndex:  req.dbIndex,
+				selectDB: true,
+			}
+			buf = append(buf, reply.MakeMultiBulkReply(selectReq.args).ToBytes()...)
+			sent = append(sent, selectReq)
+			dbIndex = req.dbIndex
+		}
+		if req.asking {
+			askingReq := &request{args: [][]byte{[]byte("ASKING")}}
+			buf = append(buf, reply.MakeMultiBulkReply(askingReq.args).ToBytes()...)
+			sent = append(sent, askingReq)
+		}
+		buf = append(buf, reply.MakeMultiBulkReply(req.args).ToBytes()...)
+		sent = append(sent, req)
+	}
+	client.dbIndex.Store(int64(dbIndex))
+	return buf, sent
 }
 
 // doRequest 发送请求
-func (client *Client) doRequest(req *request) {
-	if req == nil || len(req.args) == 0 {
+// 一批请求合并为一次写入，减少系统调用和网络往返
+func (client *Client) doRequest(batch []*request) {
+	reqs := make([]*request, 0, len(batch))
+	for _, req := range batch {
+		if req != nil && len(req.args) > 0 {
+			reqs = append(reqs, req)
+		}
+	}
+	if len(reqs) == 0 {
 		return
 	}
 	// 将发送命令转化为 RESP 协议格式
-	re := reply.MakeMultiBulkReply(req.args)
-	bytes := re.ToBytes()
+	bytes, sent := client.encode(reqs)
 	_, err := client.conn.Write(bytes)
 	// 如果出现错误，则重试 3 次
 	i := 0
@@ -189,22 +370,31 @@ func (client *Client) doRequest(req *request) {
 		// 可能是连接错误，进行重新连接
 		err = client.handleConnectionError(err)
 		if err == nil {
+			// 重新连接后选择的 DB 变为 0，需要重新编码
+			bytes, sent = client.encode(reqs)
 			_, err = client.conn.Write(bytes)
 		}
 		i++
 	}
 	if err == nil {
+		client.reconnected = false
 		// 命令成功发送，将请求对象，添加到等待回复缓冲区
-		client.waitingReqs <- req
+		for _, req := range sent {
+			client.waitingReqs <- req
+		}
 	} else {
 		// 发送报错，记录错误，等待缓冲区 -1
-		req.err = err
-		req.waiting.Done()
+		client.dbIndex.Store(unknownDB)
+		for _, req := range reqs {
+			req.err = err
+			req.waiting.Done()
+		}
 	}
 }
 
 // finishRequest 请求处理完毕
-func (client *Client) finishRequest(reply resp.Reply) {
+// waiting 为回复所在连接的等待回复缓冲区
+func (client *Client) finishRequest(waiting <-chan *request, reply resp.Reply) {
 	defer func() {
 		if err := recover(); err != nil {
 			debug.PrintStack()
@@ -212,10 +402,24 @@ func (client *Client) finishRequest(reply resp.Reply) {
 		}
 	}()
 	// 从等待回复缓冲区中取出回复对象
-	request := <-client.waitingReqs
+	request := <-waiting
 	if request == nil {
 		return
 	}
+	if request.selectDB {
+		// SELECT 失败时，无法确定连接当前的 DB
+		if bytes := reply.ToBytes(); len(bytes) > 0 && bytes[0] == '-' {
+			logger.Error("select db failed: " + string(bytes))
+			client.dbIndex.Store(unknownDB)
+		}
+		return
+	}
+	if request.handshake {
+		if bytes := reply.ToBytes(); len(bytes) > 0 && bytes[0] == '-' {
+			logger.Error("handshake " + string(request.args[0]) + " failed: " + string(bytes))
+		}
+		return
+	}
 	// 添加回复数据
 	// 这里并没有再回复给服务端，仅是拿到服务端的回复数据后，做记录，并没有其余业务处理
 	request.reply = reply
@@ -226,18 +430,30 @@ func (client *Client) finishRequest(reply resp.Reply) {
 }
 
 // handleRead 批量处理响应
-func (client *Client) handleRead() error {
+// 读取 conn 的回复，依次对应到 waiting 中的请求，直到连接断开
+func (client *Client) handleRead(conn net.Conn, waiting chan *request) {
 	// 异步解析服务端回复的数据流，返回 Channel
-	ch := parser.ParseStream(client.conn)
+	ch := parser.ParseStream(conn)
 	// 循环处理数据
+	var err error
 	for payload := range ch {
 		if payload.Err != nil {
 			// 请求报错，返回错误信息，完成本次请求
-			client.finishRequest(reply.MakeErrReply(payload.Err.Error()))
+			err = payload.Err
+			client.finishRequest(waiting, &ConnErrReply{Msg: payload.Err.Error()})
 			continue
 		}
 		// 正常返回数据，完成本次响应请求
-		client.finishRequest(payload.Data)
+		client.finishRequest(waiting, payload.Data)
+	}
+	if err == nil {
+		err = errors.New("connection closed")
+	}
+	// 连接已经断开，已发送的请求不会再收到回复，直到重新连接或关闭客户端时缓冲区被关闭
+	for req := range waiting {
+		req.err = err
+		if req.waiting != nil {
+			req.waiting.Done()
+		}
 	}
-	return nil
 }
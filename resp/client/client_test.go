@@ -0,0 +1,93 @@
+package client
+
+import (
+	"go-redis/resp/parser"
+	"net"
+	"testing"
+)
+
+/*
+比较两种转发方式
+1. 每条命令之前发送 SELECT，等待回复后再发送命令，每条命令两次网络往返，并发的转发各自使用一个连接
+2. 并发的转发共用一个多路复用连接，连接记住当前的 DB，请求合并为批量写入
+go test -bench Relay -benchmem ./resp/client
+*/
+
+// startServer starts a server which replies OK to every command, returns its address
+func startServer(b *testing.B) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		_ = listener.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				for payload := range parser.ParseStream(conn) {
+					if payload.Err != nil {
+						return
+					}
+					if _, err := conn.Write([]byte("+OK\r\n")); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func makeStartedClient(b *testing.B, addr string) *Client {
+	c, err := MakeClient(addr)
+	if err != nil {
+		b.Fatal(err)
+	}
+	c.Start()
+	return c
+}
+
+// BenchmarkRelaySelectPerCommand sends SELECT before every command and waits for its reply
+func BenchmarkRelaySelectPerCommand(b *testing.B) {
+	addr := startServer(b)
+	selectCmd := [][]byte{[]byte("SELECT"), []byte("1")}
+	getCmd := [][]byte{[]byte("GET"), []byte("key")}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		c := makeStartedClient(b, addr)
+		defer c.Close()
+		for pb.Next() {
+			if ret := c.Send(selectCmd); IsConnErr(ret) {
+				b.Error(ret)
+				return
+			}
+			if ret := c.Send(getCmd); IsConnErr(ret) {
+				b.Error(ret)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkRelayPipelined shares a multiplexed client, SELECT is sent only when db changes
+func BenchmarkRelayPipelined(b *testing.B) {
+	addr := startServer(b)
+	c := makeStartedClient(b, addr)
+	defer c.Close()
+	getCmd := [][]byte{[]byte("GET"), []byte("key")}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if ret := c.SendInDB(1, getCmd); IsConnErr(ret) {
+				b.Error(ret)
+				return
+			}
+		}
+	})
+}
@@ -4,70 +4,337 @@ import (
 	"go-redis/lib/sync/wait"
 	"net"
 	"sync"
+	stdatomic "sync/atomic"
 	"time"
 )
 
+// connSeq generates the id of connections
+var connSeq stdatomic.Int64
+
 // Connection represents a connection with a redis-cli
 // 对每一个客户端连接的描述
 type Connection struct {
+	id   int64    // 连接 id，递增
 	conn net.Conn // 一个连接
 	// waiting until reply finished
-	waitingReply wait.Wait // 一个自定义实现的具备超时退出的等待组，用于同步并发访问连接
-	// lock while handler sending response
-	mu sync.Mutex // 互斥锁
+	waitingReply wait.Wait // 一个自定义实现的具备超时退出的等待组，等待输出缓冲区中的数据发送完毕
+	// output buffer, see output.go
+	mu             sync.Mutex    // 保护输出缓冲区
+	outCond        *sync.Cond    // 输出缓冲区有数据或连接关闭时唤醒发送协程
+	drainCond      *sync.Cond    // 数据发送完毕或连接关闭时唤醒 WriteBlocking
+	outQueue       [][]byte      // 等待发送的数据
+	outPending     int64         // 等待发送的字节数
+	outErr         error         // 发送失败或连接关闭后不再接收数据
+	outputLimiter  OutputLimiter // 检查输出缓冲区是否超过限制
+	softLimitSince time.Time     // 输出缓冲区开始超过软限制的时间
+	// CLIENT LIST、CLIENT KILL、tracking 的失效通知等会在其他协程中读取连接的状态，
+	// 这些字段使用原子变量或者由 attrMu 保护
 	// selected db
-	selectedDB int // 存储当前数据库的索引
+	selectedDB stdatomic.Int64 // 存储当前数据库的索引
+	// cluster flags
+	asking   bool           // 执行过 ASKING，下一条命令可以访问正在迁入的 slot
+	readOnly stdatomic.Bool // 执行过 READONLY，允许在从节点上执行读命令
+	// password may be changed by CONFIG command during runtime, so store the password
+	password      string // AUTH 认证成功的密码
+	authenticated bool   // 是否通过了 requirepass 或节点之间的认证
+	// protocol version negotiated by HELLO
+	protocol    stdatomic.Int32 // 2 或 3，默认为 2
+	attrMu      sync.RWMutex    // 保护 user、name、lastCommand
+	user        string          // 执行命令的 ACL 用户，默认为 default；节点之间的连接为空，不受 ACL 限制
+	name        string          // HELLO SETNAME 设置的连接名称
+	lastCommand string          // 最后执行的命令，CLIENT LIST 的 cmd 字段
+	createTime  time.Time       // 连接建立的时间
+	noEvict     stdatomic.Bool  // CLIENT NO-EVICT 设置
+	replica     stdatomic.Bool  // 执行了 PSYNC 的从节点连接
+	// CLIENT REPLY 设置的回复模式
+	replyOff        bool // 不回复
+	skipNextReply   bool // CLIENT REPLY SKIP 之后的下一条命令不回复
+	skippingReply   bool // 当前命令不回复
+	closeAfterReply bool // 回复当前命令后关闭连接，如 CLIENT KILL 自身
+	// idle timeout
+	lastInteraction stdatomic.Int64 // 最后一次收到命令的时间(UnixNano)，用于关闭空闲连接
+	blocked         stdatomic.Bool  // 正在执行阻塞命令，不会因为空闲被关闭
+	// subscribing channels
+	subsMu sync.Mutex
+	subs   map[string]struct{} // 订阅的频道，订阅了频道的连接不会因为空闲被关闭
 }
 
 func NewConn(conn net.Conn) *Connection {
-	return &Connection{
+	c := &Connection{
+		id:   connSeq.Add(1),
 		conn: conn,
+		user: "default",
 	}
+	c.protocol.Store(2)
+	c.createTime = time.Now()
+	c.Touch()
+	return c
+}
+
+// GetID returns the unique id of connection
+func (c *Connection) GetID() int64 {
+	return c.id
 }
 
 // RemoteAddr returns the remote network address
 // 获取到远端客户端的连接地址
+// Unix socket 的客户端没有地址，与 redis 一样使用 socket 文件路径:0
 func (c *Connection) RemoteAddr() net.Addr {
+	if _, ok := c.conn.RemoteAddr().(*net.UnixAddr); ok {
+		if local, ok := c.conn.LocalAddr().(*net.UnixAddr); ok {
+			return &net.UnixAddr{Name: local.Name + ":0", Net: local.Net}
+		}
+	}
 	return c.conn.RemoteAddr()
 }
 
+// LocalAddr returns the local network address
+// Unix socket 的连接为 socket 文件路径:0
+func (c *Connection) LocalAddr() net.Addr {
+	if local, ok := c.conn.LocalAddr().(*net.UnixAddr); ok {
+		return &net.UnixAddr{Name: local.Name + ":0", Net: local.Net}
+	}
+	return c.conn.LocalAddr()
+}
+
 // Close disconnect with the client
 // 等待 10s 关闭
 func (c *Connection) Close() error {
 	// 等待回复完毕后，关闭连接
 	// 或者10s内没有回复完毕，则关闭
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
+	c.stopOutput(errConnClosed)
 	_ = c.conn.Close()
 	return nil
 }
 
-// Write sends response to client over tcp connection
-// 并发安全的写入数据
-func (c *Connection) Write(b []byte) error {
-	if len(b) == 0 {
-		return nil
-	}
-	// 加锁，防止并发写
-	c.mu.Lock()
-	// 添加一个等待回复的任务, 表明有协程再给客户端回写数据
-	// 给 waitingReply 加1，表示正在回复
-	c.waitingReply.Add(1)
-	defer func() {
-		// 解锁，复原
-		c.waitingReply.Done()
-		c.mu.Unlock()
-	}()
-
-	_, err := c.conn.Write(b)
-	return err
+// Kill disconnects with the client immediately, replies not sent are dropped
+// 用于 CLIENT KILL、空闲超时等在其他协程中关闭连接的场景，不会阻塞调用方
+func (c *Connection) Kill() error {
+	c.stopOutput(errConnClosed)
+	return c.conn.Close()
 }
 
 // GetDBIndex returns selected db
 func (c *Connection) GetDBIndex() int {
-	return c.selectedDB
+	return int(c.selectedDB.Load())
 }
 
 // SelectDB selects a database
 func (c *Connection) SelectDB(dbNum int) {
-	c.selectedDB = dbNum
+	c.selectedDB.Store(int64(dbNum))
+}
+
+// SetAsking sets whether the next command may access an importing slot
+func (c *Connection) SetAsking(asking bool) {
+	c.asking = asking
+}
+
+// IsAsking returns whether the connection has sent ASKING
+func (c *Connection) IsAsking() bool {
+	return c.asking
+}
+
+// SetReadOnly sets whether read commands could be served by replicas
+func (c *Connection) SetReadOnly(readOnly bool) {
+	c.readOnly.Store(readOnly)
+}
+
+// IsReadOnly returns whether the connection is in READONLY mode
+func (c *Connection) IsReadOnly() bool {
+	return c.readOnly.Load()
+}
+
+// SetPassword stores password for authentication
+func (c *Connection) SetPassword(password string) {
+	c.password = password
+}
+
+// GetPassword gets password for authentication
+func (c *Connection) GetPassword() string {
+	return c.password
+}
+
+// SetProtocol sets the protocol version used to encode replies
+func (c *Connection) SetProtocol(protocol int) {
+	c.protocol.Store(int32(protocol))
+}
+
+// GetProtocol returns the protocol version, 2 or 3
+func (c *Connection) GetProtocol() int {
+	return int(c.protocol.Load())
+}
+
+// SetName sets the name of connection
+func (c *Connection) SetName(name string) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+	c.name = name
+}
+
+// GetName returns the name of connection
+func (c *Connection) GetName() string {
+	c.attrMu.RLock()
+	defer c.attrMu.RUnlock()
+	return c.name
+}
+
+// SetAuthenticated sets whether the connection has been authenticated
+func (c *Connection) SetAuthenticated(authenticated bool) {
+	c.authenticated = authenticated
+}
+
+// IsAuthenticated returns whether the connection has been authenticated
+func (c *Connection) IsAuthenticated() bool {
+	return c.authenticated
+}
+
+// SetUser sets the ACL user of connection
+func (c *Connection) SetUser(user string) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+	c.user = user
+}
+
+// GetUser returns the ACL user of connection
+func (c *Connection) GetUser() string {
+	c.attrMu.RLock()
+	defer c.attrMu.RUnlock()
+	return c.user
+}
+
+// Touch records the time of last interaction
+// 每收到一条命令调用一次
+func (c *Connection) Touch() {
+	c.lastInteraction.Store(time.Now().UnixNano())
+}
+
+// GetIdleTime returns the duration since last interaction
+func (c *Connection) GetIdleTime() time.Duration {
+	return time.Since(time.Unix(0, c.lastInteraction.Load()))
+}
+
+// SetBlocked sets whether the connection is blocked by command
+func (c *Connection) SetBlocked(blocked bool) {
+	c.blocked.Store(blocked)
+}
+
+// IsBlocked returns whether the connection is blocked by command
+func (c *Connection) IsBlocked() bool {
+	return c.blocked.Load()
+}
+
+// Subscribe adds channel into subscriptions
+func (c *Connection) Subscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.subs == nil {
+		c.subs = make(map[string]struct{})
+	}
+	c.subs[channel] = struct{}{}
+}
+
+// UnSubscribe removes channel from subscriptions
+func (c *Connection) UnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.subs, channel)
+}
+
+// SubsCount returns the number of subscribing channels
+func (c *Connection) SubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.subs)
+}
+
+// GetChannels returns all subscribing channels
+func (c *Connection) GetChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	channels := make([]string, 0, len(c.subs))
+	for channel := range c.subs {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// IsSubscribed returns whether the connection has subscribed channels
+func (c *Connection) IsSubscribed() bool {
+	return c.SubsCount() > 0
+}
+
+// GetCreateTime returns the time when connection established
+func (c *Connection) GetCreateTime() time.Time {
+	return c.createTime
+}
+
+// SetLastCommand records the last command executed by connection
+func (c *Connection) SetLastCommand(cmd string) {
+	c.attrMu.Lock()
+	defer c.attrMu.Unlock()
+	c.lastCommand = cmd
+}
+
+// GetLastCommand returns the last command executed by connection
+func (c *Connection) GetLastCommand() string {
+	c.attrMu.RLock()
+	defer c.attrMu.RUnlock()
+	return c.lastCommand
+}
+
+// SetNoEvict sets whether keys accessed by the connection could be evicted
+func (c *Connection) SetNoEvict(noEvict bool) {
+	c.noEvict.Store(noEvict)
+}
+
+// IsNoEvict returns whether the connection is in no-evict mode
+func (c *Connection) IsNoEvict() bool {
+	return c.noEvict.Load()
+}
+
+// SetReplica marks the connection as a replica which has sent PSYNC
+func (c *Connection) SetReplica(replica bool) {
+	c.replica.Store(replica)
+}
+
+// IsReplica returns whether the connection is a replica
+func (c *Connection) IsReplica() bool {
+	return c.replica.Load()
+}
+
+// SetReplyMode sets the reply mode by CLIENT REPLY, mode is on, off or skip
+func (c *Connection) SetReplyMode(mode string) {
+	switch mode {
+	case "on":
+		c.replyOff = false
+		c.skipNextReply = false
+	case "off":
+		c.replyOff = true
+	case "skip":
+		c.skipNextReply = true
+	}
+}
+
+// TakeReply returns whether the reply of current command should be sent, called once after each command
+// CLIENT REPLY SKIP 本身和下一条命令都不回复
+func (c *Connection) TakeReply() bool {
+	send := !c.replyOff && !c.skippingReply
+	c.skippingReply = c.skipNextReply
+	c.skipNextReply = false
+	if c.skippingReply {
+		// CLIENT REPLY SKIP 本身不回复
+		send = false
+	}
+	return send
+}
+
+// SetCloseAfterReply closes connection after replying current command
+func (c *Connection) SetCloseAfterReply() {
+	c.closeAfterReply = true
+}
+
+// IsCloseAfterReply returns whether connection should be closed after replying
+func (c *Connection) IsCloseAfterReply() bool {
+	return c.closeAfterReply
 }
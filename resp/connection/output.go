@@ -0,0 +1,170 @@
+package connection
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+/*
+输出缓冲区
+Write 只把数据放入输出缓冲区，由每个连接的发送协程写入 socket，读取缓慢的客户端不会阻塞执行命令的协程
+WriteBlocking 在缓冲区超过水位时等待发送协程写出数据，用于全量同步等需要背压的场景
+每次写入后由 OutputLimiter 检查缓冲区大小，超过 client-output-buffer-limit 时立即关闭连接，丢弃未发送的数据
+*/
+
+var (
+	errConnClosed = errors.New("use of closed network connection")
+	// ErrOutputBufferLimit is returned by Write when the output buffer exceeds limit
+	ErrOutputBufferLimit = errors.New("output buffer limit exceeded")
+)
+
+// OutputLimiter returns whether the connection should be closed, pending is the bytes waiting to be sent
+type OutputLimiter func(c *Connection, pending int64) bool
+
+// SetOutputLimiter sets the function which checks output buffer after each write
+func (c *Connection) SetOutputLimiter(limiter OutputLimiter) {
+	c.outputLimiter = limiter
+}
+
+// Write sends response to client over tcp connection
+// 并发安全的写入数据，数据放入输出缓冲区后立即返回
+func (c *Connection) Write(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	pending, err := c.enqueue(b)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if c.outputLimiter != nil && c.outputLimiter(c, pending) {
+		c.stopOutput(ErrOutputBufferLimit)
+		_ = c.conn.Close()
+		return ErrOutputBufferLimit
+	}
+	return nil
+}
+
+// blockingWriteWatermark is the max bytes in output buffer before WriteBlocking puts data
+const blockingWriteWatermark = 1024 * 1024
+
+// WriteBlocking waits until output buffer is drained below watermark, then puts data into it
+// 用于向从节点发送快照等大量数据，发送速度受限于对端的读取速度，不受 client-output-buffer-limit 限制
+func (c *Connection) WriteBlocking(b []byte) error {
+	if len(b) == 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.outErr == nil && c.drainCond != nil && c.outPending >= blockingWriteWatermark {
+		c.drainCond.Wait()
+	}
+	_, err := c.enqueue(b)
+	return err
+}
+
+// enqueue puts data into output buffer, returns the bytes waiting to be sent, caller should hold lock
+func (c *Connection) enqueue(b []byte) (int64, error) {
+	if c.outErr != nil {
+		return 0, c.outErr
+	}
+	if c.outCond == nil {
+		// 第一次写入时启动发送协程
+		c.outCond = sync.NewCond(&c.mu)
+		c.drainCond = sync.NewCond(&c.mu)
+		go c.writeLoop()
+	}
+	// 添加一个等待回复的任务，发送完毕或丢弃后 Done
+	c.waitingReply.Add(1)
+	c.outQueue = append(c.outQueue, b)
+	c.outPending += int64(len(b))
+	c.outCond.Signal()
+	return c.outPending, nil
+}
+
+// writeLoop writes data in output buffer to socket until connection closed
+func (c *Connection) writeLoop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		for len(c.outQueue) == 0 && c.outErr == nil {
+			c.outCond.Wait()
+		}
+		if c.outErr != nil {
+			c.dropOutput()
+			return
+		}
+		queue := c.outQueue
+		c.outQueue = nil
+		var size int64
+		for _, b := range queue {
+			size += int64(len(b))
+		}
+		c.mu.Unlock()
+		buffers := net.Buffers(queue)
+		_, err := buffers.WriteTo(c.conn)
+		c.mu.Lock()
+		c.outPending -= size
+		for range queue {
+			c.waitingReply.Done()
+		}
+		if err != nil {
+			if c.outErr == nil {
+				c.outErr = err
+			}
+			c.dropOutput()
+		}
+		c.drainCond.Broadcast()
+		if err != nil {
+			return
+		}
+	}
+}
+
+// stopOutput stops receiving data, data not sent is dropped
+func (c *Connection) stopOutput(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.outErr == nil {
+		c.outErr = err
+	}
+	c.dropOutput()
+	if c.outCond != nil {
+		c.outCond.Broadcast()
+		c.drainCond.Broadcast()
+	}
+}
+
+// dropOutput drops data not sent, caller should hold lock
+func (c *Connection) dropOutput() {
+	for _, b := range c.outQueue {
+		c.outPending -= int64(len(b))
+		c.waitingReply.Done()
+	}
+	c.outQueue = nil
+}
+
+// GetOutputBufferSize returns the bytes and the number of replies waiting to be sent
+func (c *Connection) GetOutputBufferSize() (int64, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.outPending, len(c.outQueue)
+}
+
+// SoftLimitExceededFor records whether output buffer exceeds soft limit, returns how long it has exceeded
+func (c *Connection) SoftLimitExceededFor(exceeded bool) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !exceeded {
+		c.softLimitSince = time.Time{}
+		return 0
+	}
+	if c.softLimitSince.IsZero() {
+		c.softLimitSince = time.Now()
+	}
+	return time.Since(c.softLimitSince)
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"crypto/tls"
+	"go-redis/acl"
+	"go-redis/cluster"
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strings"
+	"time"
+)
+
+// tlsHandshakeTimeout is the max duration of TLS handshake
+const tlsHandshakeTimeout = 10 * time.Second
+
+// authFailureDelay is the delay before replying failed AUTH, slows down brute-force attacks
+// 认证失败后延迟回复，降低暴力破解密码的速度
+const authFailureDelay = 500 * time.Millisecond
+
+var noAuthReply = reply.MakeErrReply("NOAUTH Authentication required.")
+
+// execAuth authenticates the connection
+// AUTH [username] password
+// 集群模式下使用 cluster-auth 或 masterauth 认证的是其他节点，不受 ACL 限制
+func (h *RespHandler) execAuth(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 2 && len(args) != 3 {
+		return reply.MakeArgNumErrReply("auth")
+	}
+	password := args[len(args)-1]
+	username := acl.DefaultUser
+	if len(args) == 3 {
+		username = string(args[1])
+	}
+	if _, ok := h.db.(*cluster.ClusterDatabase); ok && username == acl.DefaultUser && cluster.IsPeerPassword(password) {
+		client.SetPassword(string(password))
+		client.SetAuthenticated(true)
+		client.SetUser("")
+		return reply.MakeOkReply()
+	}
+	if len(args) == 2 && h.acl.IsDefaultNoPass() {
+		return reply.MakeErrReply("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+	if h.acl.Authenticate(username, string(password)) {
+		client.SetPassword(string(password))
+		client.SetAuthenticated(true)
+		client.SetUser(username)
+		return reply.MakeOkReply()
+	}
+	h.acl.LogAuthFailure(client, username)
+	logger.Warn("AUTH failed from " + client.RemoteAddr().String())
+	time.Sleep(authFailureDelay)
+	return reply.MakeErrReply("WRONGPASS invalid username-password pair or user is disabled.")
+}
+
+// handshakeTLS completes TLS handshake, and authenticates the client by its certificate
+// tls-auth-clients-user 为 CN 时，客户端证书的 CN 是已启用的 ACL 用户则以该用户认证，否则仍为 default 用户
+func (h *RespHandler) handshakeTLS(client *connection.Connection, conn *tls.Conn) error {
+	_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if !strings.EqualFold(config.Properties.TLSAuthClientsUser, "cn") {
+		return nil
+	}
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil
+	}
+	username := certs[0].Subject.CommonName
+	if h.acl.IsUserEnabled(username) {
+		client.SetUser(username)
+		client.SetAuthenticated(true)
+		logger.Info("client " + conn.RemoteAddr().String() + " authenticated as " + username + " by certificate")
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"go-redis/config"
+	"strings"
+	"testing"
+)
+
+// setConfig changes parameter by CONFIG SET, and restores it after test
+func setConfig(t *testing.T, name string, value string) {
+	t.Helper()
+	old := config.Get([]string{name})
+	if len(old) != 1 {
+		t.Fatalf("unknown parameter %s", name)
+	}
+	if err := config.Set([][2]string{{name, value}}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := config.Set([][2]string{old[0]}); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestRequirePass(t *testing.T) {
+	setConfig(t, "requirepass", "secret")
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		args   []string
+		prefix string
+	}{
+		{args: []string{"PING"}, prefix: "-NOAUTH Authentication required."},
+		{args: []string{"SET", "k", "v"}, prefix: "-NOAUTH Authentication required."},
+		{args: []string{"HELLO", "3"}, prefix: "-NOAUTH HELLO must be called with the client already authenticated"},
+		{args: []string{"AUTH"}, prefix: "-ERR wrong number of arguments for 'auth' command"},
+		{args: []string{"AUTH", "wrong"}, prefix: "-WRONGPASS invalid username-password pair or user is disabled."},
+		{args: []string{"AUTH", "nobody", "secret"}, prefix: "-WRONGPASS"},
+		{args: []string{"PING"}, prefix: "-NOAUTH"},
+		{args: []string{"AUTH", "secret"}, prefix: "+OK"},
+		{args: []string{"PING"}, prefix: "+PONG"},
+		{args: []string{"AUTH", "default", "secret"}, prefix: "+OK"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); !strings.HasPrefix(got, tt.prefix) {
+			t.Errorf("%v: got %q, want prefix %q", tt.args, got, tt.prefix)
+		}
+	}
+
+	// HELLO 同时认证并切换协议，认证失败时不切换
+	c = dial(t, addr)
+	if got := c.do("HELLO", "3", "AUTH", "default", "wrong"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Errorf("got %q", got)
+	}
+	if got := c.do("HELLO", "3", "AUTH", "default", "secret"); !strings.HasPrefix(got, "%7\r\n") {
+		t.Errorf("got %q", got)
+	}
+	if got := c.do("GET", "missing"); got != "_\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestAuthWithoutPassword(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Errorf("connection should not need AUTH, got %q", got)
+	}
+	if got := c.do("AUTH", "secret"); !strings.HasPrefix(got, "-ERR AUTH <password> called without any password configured") {
+		t.Errorf("got %q", got)
+	}
+}
@@ -0,0 +1,448 @@
+package handler
+
+import (
+	"go-redis/config"
+	"go-redis/interface/resp"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+客户端连接数量与空闲连接
+1. maxclients 限制同时连接的客户端数量(包括其他节点的连接)，超过后回复错误并关闭新连接
+2. timeout 秒内没有发送命令的客户端连接被关闭，正在执行阻塞命令、订阅了频道的连接以及节点之间的连接除外
+3. CLIENT 命令查看和管理连接：ID、SETNAME、GETNAME、LIST、INFO、KILL、PAUSE、UNPAUSE、NO-EVICT、REPLY，
+   以及客户端缓存相关的 TRACKING、CACHING、GETREDIR(见 tracking.go)
+*/
+
+// defaultMaxClients is used when maxclients is not configured
+const defaultMaxClients = 10000
+
+// clientsCronInterval is the interval of checking idle clients
+const clientsCronInterval = time.Second
+
+var maxClientsReply = reply.MakeErrReply("ERR max number of clients reached")
+
+func getMaxClients() int64 {
+	maxClients := config.Load().MaxClients
+	if maxClients <= 0 {
+		return defaultMaxClients
+	}
+	return int64(maxClients)
+}
+
+// getIdleTimeout returns the max idle duration of clients, 0 means never close idle clients
+func getIdleTimeout() time.Duration {
+	timeout := config.Load().Timeout
+	if timeout <= 0 {
+		return 0
+	}
+	return time.Duration(timeout) * time.Second
+}
+
+// clientsCron closes idle clients and records peak memory periodically until handler closed
+func (h *RespHandler) clientsCron() {
+	ticker := time.NewTicker(clientsCronInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if h.closing.Get() {
+			return
+		}
+		h.updateMemoryPeak()
+		timeout := getIdleTimeout()
+		if timeout == 0 {
+			continue
+		}
+		h.activeConn.Range(func(key, value interface{}) bool {
+			client := key.(*connection.Connection)
+			if isIdleExempt(client) || client.GetIdleTime() <= timeout {
+				return true
+			}
+			logger.Info("closing idle client: " + client.RemoteAddr().String())
+			// 关闭后解析协程读取失败，由 Handle 释放连接
+			// 不等待未发送的回复，避免阻塞定时任务
+			_ = client.Kill()
+			return true
+		})
+	}
+}
+
+// isIdleExempt returns whether the client would not be closed by idle timeout
+func isIdleExempt(client *connection.Connection) bool {
+	return client.IsBlocked() || client.IsSubscribed() || client.GetUser() == ""
+}
+
+// containerCommands are the commands whose subcommand is recorded in the cmd field of CLIENT LIST
+var containerCommands = map[string]struct{}{
+	"client":  {},
+	"acl":     {},
+	"cluster": {},
+	"config":  {},
+}
+
+// commandName returns the name of command shown in CLIENT LIST, such as get or client|list
+func commandName(args [][]byte) string {
+	name := strings.ToLower(string(args[0]))
+	if _, ok := containerCommands[name]; ok && len(args) > 1 {
+		name += "|" + strings.ToLower(string(args[1]))
+	}
+	return name
+}
+
+// execClient executes CLIENT subcommands
+func (h *RespHandler) execClient(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("client")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "id":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|id")
+		}
+		return reply.MakeIntReply(client.GetID())
+	case "setname":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("client|setname")
+		}
+		name := string(args[2])
+		if strings.ContainsAny(name, " \n") {
+			return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+		}
+		client.SetName(name)
+		return reply.MakeOkReply()
+	case "getname":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|getname")
+		}
+		if client.GetName() == "" {
+			return reply.MakeNullBulkReply()
+		}
+		return reply.MakeBulkReply([]byte(client.GetName()))
+	case "info":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|info")
+		}
+		return reply.MakeVerbatimReply("txt", []byte(h.describeClient(client)+"\n"))
+	case "list":
+		return h.execClientList(args)
+	case "kill":
+		return h.execClientKill(client, args)
+	case "pause":
+		if len(args) != 3 && len(args) != 4 {
+			return reply.MakeArgNumErrReply("client|pause")
+		}
+		timeout, err := strconv.ParseInt(string(args[2]), 10, 64)
+		if err != nil || timeout < 0 {
+			return reply.MakeErrReply("ERR timeout is not an integer or out of range")
+		}
+		all := true
+		if len(args) == 4 {
+			var ok bool
+			if all, ok = parsePauseMode(string(args[3])); !ok {
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+		h.pause.pause(time.Duration(timeout)*time.Millisecond, all)
+		return reply.MakeOkReply()
+	case "unpause":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|unpause")
+		}
+		h.pause.unpause()
+		return reply.MakeOkReply()
+	case "no-evict":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("client|no-evict")
+		}
+		switch strings.ToLower(string(args[2])) {
+		case "on":
+			client.SetNoEvict(true)
+		case "off":
+			client.SetNoEvict(false)
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+		return reply.MakeOkReply()
+	case "tracking":
+		return h.execClientTracking(client, args)
+	case "caching":
+		return h.execClientCaching(client, args)
+	case "getredir":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("client|getredir")
+		}
+		return reply.MakeIntReply(h.getTrackingRedirect(client))
+	case "reply":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply("client|reply")
+		}
+		mode := strings.ToLower(string(args[2]))
+		if mode != "on" && mode != "off" && mode != "skip" {
+			return reply.MakeSyntaxErrReply()
+		}
+		client.SetReplyMode(mode)
+		if mode != "on" {
+			return &reply.NoReply{}
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[1]) + "'. Try CLIENT HELP.")
+}
+
+// execClientList lists connections
+// CLIENT LIST [TYPE normal|replica|pubsub] [ID client-id ...]
+func (h *RespHandler) execClientList(args [][]byte) resp.Reply {
+	var clientType string
+	var ids map[int64]struct{}
+	for i := 2; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		if option == "type" && i+1 < len(args) {
+			clientType = strings.ToLower(string(args[i+1]))
+			if !isValidClientType(clientType) {
+				return reply.MakeErrReply("ERR Unknown client type '" + string(args[i+1]) + "'")
+			}
+			i++
+		} else if option == "id" && i+1 < len(args) {
+			ids = make(map[int64]struct{})
+			for i++; i < len(args); i++ {
+				id, err := strconv.ParseInt(string(args[i]), 10, 64)
+				if err != nil || id <= 0 {
+					return reply.MakeErrReply("ERR Invalid client ID")
+				}
+				ids[id] = struct{}{}
+			}
+		} else {
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+	var sb strings.Builder
+	for _, c := range h.sortedClients() {
+		if clientType != "" && getClientType(c) != clientType {
+			continue
+		}
+		if ids != nil {
+			if _, ok := ids[c.GetID()]; !ok {
+				continue
+			}
+		}
+		sb.WriteString(h.describeClient(c))
+		sb.WriteByte('\n')
+	}
+	return reply.MakeVerbatimReply("txt", []byte(sb.String()))
+}
+
+// execClientKill closes connections
+// CLIENT KILL ip:port
+// CLIENT KILL [ID client-id] [ADDR ip:port] [LADDR ip:port] [USER username] [TYPE normal|replica|pubsub] [SKIPME yes|no]
+// 旧格式只关闭一个连接并回复 OK，新格式回复关闭的连接数；关闭自身时回复后再关闭
+func (h *RespHandler) execClientKill(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("client|kill")
+	}
+	filter := &killFilter{skipMe: true}
+	oldStyle := len(args) == 3
+	if oldStyle {
+		filter.addr = string(args[2])
+		filter.skipMe = false
+	} else {
+		if len(args)%2 != 0 {
+			return reply.MakeSyntaxErrReply()
+		}
+		for i := 2; i < len(args); i += 2 {
+			value := string(args[i+1])
+			switch strings.ToLower(string(args[i])) {
+			case "id":
+				id, err := strconv.ParseInt(value, 10, 64)
+				if err != nil || id <= 0 {
+					return reply.MakeErrReply("ERR client-id should be greater than 0")
+				}
+				filter.id = id
+			case "addr":
+				filter.addr = value
+			case "laddr":
+				filter.laddr = value
+			case "user":
+				filter.user = value
+			case "type":
+				filter.clientType = strings.ToLower(value)
+				if !isValidClientType(filter.clientType) {
+					return reply.MakeErrReply("ERR Unknown client type '" + value + "'")
+				}
+			case "skipme":
+				switch strings.ToLower(value) {
+				case "yes":
+					filter.skipMe = true
+				case "no":
+					filter.skipMe = false
+				default:
+					return reply.MakeSyntaxErrReply()
+				}
+			default:
+				return reply.MakeSyntaxErrReply()
+			}
+		}
+	}
+
+	var killed int64
+	for _, c := range h.sortedClients() {
+		if !filter.match(client, c) {
+			continue
+		}
+		killed++
+		if c == client {
+			client.SetCloseAfterReply()
+			continue
+		}
+		logger.Info("client killed: " + c.RemoteAddr().String())
+		// 关闭后解析协程读取失败，由 Handle 释放连接
+		// 丢弃未发送的回复，不等待输出缓冲区发送完毕
+		_ = c.Kill()
+	}
+	if oldStyle {
+		if killed == 0 {
+			return reply.MakeErrReply("ERR No such client")
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeIntReply(killed)
+}
+
+// killFilter is the filter of CLIENT KILL, zero value fields match all connections
+type killFilter struct {
+	id         int64
+	addr       string
+	laddr      string
+	user       string
+	clientType string
+	skipMe     bool
+}
+
+func (f *killFilter) match(self, c *connection.Connection) bool {
+	if f.skipMe && c == self {
+		return false
+	}
+	if f.id != 0 && c.GetID() != f.id {
+		return false
+	}
+	if f.addr != "" && c.RemoteAddr().String() != f.addr {
+		return false
+	}
+	if f.laddr != "" && c.LocalAddr().String() != f.laddr {
+		return false
+	}
+	if f.user != "" && c.GetUser() != f.user {
+		return false
+	}
+	if f.clientType != "" && getClientType(c) != f.clientType {
+		return false
+	}
+	return true
+}
+
+// sortedClients returns active connections ordered by id
+func (h *RespHandler) sortedClients() []*connection.Connection {
+	clients := make([]*connection.Connection, 0)
+	h.activeConn.Range(func(key, value interface{}) bool {
+		clients = append(clients, key.(*connection.Connection))
+		return true
+	})
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].GetID() < clients[j].GetID()
+	})
+	return clients
+}
+
+func isValidClientType(clientType string) bool {
+	return clientType == "normal" || clientType == "replica" || clientType == "pubsub"
+}
+
+// getClientType returns normal, replica or pubsub
+func getClientType(c *connection.Connection) string {
+	if c.IsReplica() {
+		return "replica"
+	}
+	if c.IsSubscribed() {
+		return "pubsub"
+	}
+	return "normal"
+}
+
+// getClientFlags returns the flags field of CLIENT LIST
+// N: 没有特殊标志 S: 从节点 r: READONLY P: 订阅了频道 b: 正在执行阻塞命令 e: NO-EVICT
+// t: 开启了 tracking B: 广播模式 R: 重定向的连接已关闭
+func (h *RespHandler) getClientFlags(c *connection.Connection) string {
+	flags := ""
+	if state := h.tracking.getState(c.GetID()); state != nil {
+		flags += "t"
+		if state.bcast {
+			flags += "B"
+		}
+		if state.redirect != 0 && h.getClient(state.redirect) == nil {
+			flags += "R"
+		}
+	}
+	if c.IsReplica() {
+		flags += "S"
+	}
+	if c.IsReadOnly() {
+		flags += "r"
+	}
+	if c.IsSubscribed() {
+		flags += "P"
+	}
+	if c.IsBlocked() {
+		flags += "b"
+	}
+	if c.IsNoEvict() {
+		flags += "e"
+	}
+	if flags == "" {
+		flags = "N"
+	}
+	return flags
+}
+
+// describeClient returns the line of connection in CLIENT LIST
+// qbuf 为正在读取的命令的长度，omem、oll 为输出缓冲区中等待发送的字节数和回复数
+func (h *RespHandler) describeClient(c *connection.Connection) string {
+	omem, oll := c.GetOutputBufferSize()
+	fields := []string{
+		"id=" + strconv.FormatInt(c.GetID(), 10),
+		"addr=" + c.RemoteAddr().String(),
+		"laddr=" + c.LocalAddr().String(),
+		"name=" + c.GetName(),
+		"age=" + strconv.FormatInt(int64(time.Since(c.GetCreateTime())/time.Second), 10),
+		"idle=" + strconv.FormatInt(int64(c.GetIdleTime()/time.Second), 10),
+		"flags=" + h.getClientFlags(c),
+		"db=" + strconv.Itoa(c.GetDBIndex()),
+		"sub=" + strconv.Itoa(c.SubsCount()),
+		"psub=0",
+		"multi=-1",
+		"qbuf=" + strconv.FormatInt(h.getQueryBufferSize(c), 10),
+		"qbuf-free=0",
+		"obl=0",
+		"oll=" + strconv.Itoa(oll),
+		"omem=" + strconv.FormatInt(omem, 10),
+		"events=r",
+		"cmd=" + c.GetLastCommand(),
+		"user=" + c.GetUser(),
+		"redir=" + strconv.FormatInt(h.getTrackingRedirect(c), 10),
+		"resp=" + strconv.Itoa(c.GetProtocol()),
+	}
+	return strings.Join(fields, " ")
+}
+
+// getQueryBufferSize returns the bytes of the command being read
+func (h *RespHandler) getQueryBufferSize(c *connection.Connection) int64 {
+	if limits, ok := h.activeConn.Load(c); ok {
+		return limits.(*parser.Limits).QueryBufferSize()
+	}
+	return 0
+}
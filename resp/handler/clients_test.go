@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"go-redis/config"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxClients(t *testing.T) {
+	// 未配置时为 0，不能通过 CONFIG SET 恢复，测试结束后恢复为相同效果的默认值
+	if err := config.Set([][2]string{{"maxclients", "2"}}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = config.Set([][2]string{{"maxclients", strconv.Itoa(defaultMaxClients)}}) }()
+	h, addr := startTestServer(t)
+	c1, c2 := dial(t, addr), dial(t, addr)
+	c1.do("PING")
+	c2.do("PING")
+
+	rejected := dial(t, addr)
+	if got := rejected.expectClosed(); got != "-ERR max number of clients reached\r\n" {
+		t.Errorf("got %q", got)
+	}
+	if h.stats.rejectedConnections.Load() != 1 {
+		t.Errorf("rejected connections should be 1, got %d", h.stats.rejectedConnections.Load())
+	}
+
+	// 连接关闭后释放名额
+	_ = c1.conn.Close()
+	deadline := time.Now().Add(readTimeout)
+	for h.clients.Load() > 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("closed connection is not released")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := dial(t, addr).do("PING"); got != "+PONG\r\n" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	setConfig(t, "timeout", "1")
+	_, addr := startTestServer(t)
+	idle, active := dial(t, addr), dial(t, addr)
+	idle.do("PING")
+
+	// 持续发送命令的连接不会被关闭
+	done := make(chan error, 1)
+	go func() {
+		_ = idle.conn.SetReadDeadline(time.Now().Add(readTimeout))
+		_, err := idle.reader.ReadByte() // 等待连接被关闭
+		done <- err
+	}()
+	for {
+		select {
+		case err := <-done:
+			if err != io.EOF {
+				t.Fatalf("idle connection should be closed, got %v", err)
+			}
+			if got := active.do("PING"); got != "+PONG\r\n" {
+				t.Errorf("active connection should not be closed, got %q", got)
+			}
+			return
+		case <-time.After(200 * time.Millisecond):
+			active.do("PING")
+		}
+	}
+}
+
+func TestClientInfo(t *testing.T) {
+	_, addr := startTestServer(t)
+	c, other := dial(t, addr), dial(t, addr)
+	other.do("PING")
+
+	id := strings.TrimSuffix(strings.TrimPrefix(c.do("CLIENT", "ID"), ":"), "\r\n")
+	tests := []struct {
+		args   []string
+		prefix string
+	}{
+		{args: []string{"CLIENT", "GETNAME"}, prefix: "$-1\r\n"},
+		{args: []string{"CLIENT", "SETNAME", "a b"}, prefix: "-ERR Client names cannot contain spaces"},
+		{args: []string{"CLIENT", "SETNAME", "conn1"}, prefix: "+OK\r\n"},
+		{args: []string{"CLIENT", "GETNAME"}, prefix: "$5\r\nconn1\r\n"},
+		{args: []string{"CLIENT", "NO-EVICT", "maybe"}, prefix: "-Err syntax error"},
+		{args: []string{"CLIENT", "NO-EVICT", "on"}, prefix: "+OK\r\n"},
+		{args: []string{"CLIENT", "LIST", "TYPE", "master"}, prefix: "-ERR Unknown client type 'master'"},
+		{args: []string{"CLIENT", "LIST", "ID", "x"}, prefix: "-ERR Invalid client ID"},
+		{args: []string{"CLIENT", "NOSUCH"}, prefix: "-ERR unknown subcommand 'NOSUCH'"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); !strings.HasPrefix(got, tt.prefix) {
+			t.Errorf("%v: got %q, want prefix %q", tt.args, got, tt.prefix)
+		}
+	}
+
+	info := c.do("CLIENT", "INFO")
+	for _, field := range []string{"id=" + id + " ", " addr=" + c.conn.LocalAddr().String() + " ", " name=conn1 ",
+		" flags=e ", " cmd=client|info ", " user=default ", " resp=2"} {
+		if !strings.Contains(info, field) {
+			t.Errorf("CLIENT INFO should contain %q, got %q", field, info)
+		}
+	}
+	list := c.do("CLIENT", "LIST", "TYPE", "normal")
+	if strings.Count(list, "id=") != 2 {
+		t.Errorf("CLIENT LIST should contain 2 clients, got %q", list)
+	}
+	list = c.do("CLIENT", "LIST", "ID", id)
+	if strings.Count(list, "id=") != 1 || !strings.Contains(list, "id="+id+" ") {
+		t.Errorf("CLIENT LIST ID should only contain %s, got %q", id, list)
+	}
+}
+
+func TestClientKill(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	victim1, victim2, victim3 := dial(t, addr), dial(t, addr), dial(t, addr)
+	for _, v := range []*testClient{victim1, victim2, victim3} {
+		v.do("PING")
+	}
+
+	if got := c.do("CLIENT", "KILL", "127.0.0.1:1"); got != "-ERR No such client\r\n" {
+		t.Errorf("got %q", got)
+	}
+	if got := c.do("CLIENT", "KILL", victim1.conn.LocalAddr().String()); got != "+OK\r\n" {
+		t.Errorf("got %q", got)
+	}
+	victim1.expectClosed()
+
+	id := strings.TrimSuffix(strings.TrimPrefix(victim2.do("CLIENT", "ID"), ":"), "\r\n")
+	if got := c.do("CLIENT", "KILL", "ID", id); got != ":1\r\n" {
+		t.Errorf("got %q", got)
+	}
+	victim2.expectClosed()
+	if got := c.do("CLIENT", "KILL", "ID", "0"); !strings.HasPrefix(got, "-ERR client-id should be greater than 0") {
+		t.Errorf("got %q", got)
+	}
+
+	// 默认跳过自身
+	if got := c.do("CLIENT", "KILL", "USER", "default"); got != ":1\r\n" {
+		t.Errorf("got %q", got)
+	}
+	victim3.expectClosed()
+	if got := c.do("CLIENT", "KILL", "USER", "default", "SKIPME", "no"); got != ":1\r\n" {
+		t.Errorf("got %q", got)
+	}
+	c.expectClosed()
+}
+
+func TestClientPause(t *testing.T) {
+	_, addr := startTestServer(t)
+	admin, c := dial(t, addr), dial(t, addr)
+	if got := admin.do("CLIENT", "PAUSE", "10000", "WRITE"); got != "+OK\r\n" {
+		t.Fatalf("got %q", got)
+	}
+	// 只暂停写命令
+	if got := c.do("GET", "k"); got != "$-1\r\n" {
+		t.Errorf("read command should not be paused, got %q", got)
+	}
+	c.send(encodeCommand("SET", "k", "v"))
+	_ = c.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := c.reader.ReadByte(); err == nil {
+		t.Fatal("write command should be paused")
+	}
+	_ = c.conn.SetReadDeadline(time.Time{})
+	if got := admin.do("CLIENT", "UNPAUSE"); got != "+OK\r\n" {
+		t.Fatalf("got %q", got)
+	}
+	if got := c.read(); got != "+OK\r\n" {
+		t.Errorf("paused command should be executed after UNPAUSE, got %q", got)
+	}
+
+	// 超时后自动恢复
+	admin.do("CLIENT", "PAUSE", "200")
+	start := time.Now()
+	if got := c.do("GET", "k"); got != "$1\r\nv\r\n" {
+		t.Errorf("got %q", got)
+	}
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("command should be paused until timeout, took %s", elapsed)
+	}
+	if got := admin.do("CLIENT", "PAUSE", "-1"); !strings.HasPrefix(got, "-ERR timeout is not an integer or out of range") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestClientReply(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.send(encodeCommand("CLIENT", "REPLY", "OFF") + encodeCommand("SET", "k", "1") +
+		encodeCommand("CLIENT", "REPLY", "ON"))
+	if got := c.read(); got != "+OK\r\n" {
+		t.Fatalf("only CLIENT REPLY ON should be replied, got %q", got)
+	}
+	c.send(encodeCommand("CLIENT", "REPLY", "SKIP") + encodeCommand("SET", "k", "2") + encodeCommand("GET", "k"))
+	if got := c.read(); got != "$1\r\n2\r\n" {
+		t.Errorf("only the command after SKIP should not be replied, got %q", got)
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"errors"
+	"go-redis/config"
+	databaseface "go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/reply"
+	"strings"
+)
+
+/*
+CONFIG GET pattern [pattern ...]
+CONFIG SET parameter value [parameter value ...]
+CONFIG RESETSTAT
+CONFIG REWRITE
+只修改本节点的配置，集群模式下不会同步到其他节点
+*/
+
+// registerConfigHooks registers functions which apply parameters changed by CONFIG SET
+// maxclients、timeout 等参数每次使用时通过 config.Load 读取，只需要校验，新的配置替换后生效
+func (h *RespHandler) registerConfigHooks() {
+	config.OnSet("maxclients", func(next *config.ServerProperties) error {
+		if next.MaxClients < 1 {
+			return errors.New("argument must be between 1 and 2147483647 inclusive")
+		}
+		return nil
+	})
+	config.OnSet("timeout", func(next *config.ServerProperties) error {
+		if next.Timeout < 0 {
+			return errors.New("argument must be between 0 and 2147483647 inclusive")
+		}
+		return nil
+	})
+	config.OnSet("acllog-max-len", func(next *config.ServerProperties) error {
+		if next.AclLogMaxLen < 0 {
+			return errors.New("argument must be between 0 and 2147483647 inclusive")
+		}
+		return nil
+	})
+	config.OnSet("tracking-table-max-keys", func(next *config.ServerProperties) error {
+		if next.TrackingTableMaxKeys < 0 {
+			return errors.New("argument must be between 0 and 2147483647 inclusive")
+		}
+		return nil
+	})
+	// requirepass 修改 default 用户的密码
+	config.OnSet("requirepass", func(next *config.ServerProperties) error {
+		h.acl.SetDefaultPassword(next.RequirePass)
+		return nil
+	})
+	config.OnSet("client-output-buffer-limit", func(next *config.ServerProperties) error {
+		limits, err := parseOutputLimits(next.ClientOutputBufferLimit)
+		if err != nil {
+			return err
+		}
+		h.outputLimits.Store(&limits)
+		return nil
+	})
+}
+
+// execConfig executes CONFIG subcommands
+func (h *RespHandler) execConfig(client resp.Connection, args [][]byte) resp.Reply {
+	if len(args) < 2 {
+		return reply.MakeArgNumErrReply("config")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	switch subCmd {
+	case "get":
+		if len(args) < 3 {
+			return reply.MakeArgNumErrReply("config|get")
+		}
+		pairs := config.Get(toStrings(args[2:]))
+		replies := make([]resp.Reply, 0, len(pairs)*2)
+		for _, pair := range pairs {
+			replies = append(replies, reply.MakeBulkReply([]byte(pair[0])), reply.MakeBulkReply([]byte(pair[1])))
+		}
+		return reply.MakeMapReply(replies)
+	case "set":
+		if len(args) < 4 || len(args)%2 != 0 {
+			return reply.MakeArgNumErrReply("config|set")
+		}
+		pairs := make([][2]string, 0, (len(args)-2)/2)
+		for i := 2; i < len(args); i += 2 {
+			pairs = append(pairs, [2]string{string(args[i]), string(args[i+1])})
+		}
+		if err := config.Set(pairs); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	case "resetstat":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("config|resetstat")
+		}
+		h.resetStats()
+		return reply.MakeOkReply()
+	case "rewrite":
+		if len(args) != 2 {
+			return reply.MakeArgNumErrReply("config|rewrite")
+		}
+		if err := config.Rewrite(); err != nil {
+			return reply.MakeErrReply(err.Error())
+		}
+		return reply.MakeOkReply()
+	}
+	return reply.MakeErrReply("ERR unknown subcommand '" + string(args[1]) + "'. Try CONFIG HELP.")
+}
+
+// resetStats resets the counters in INFO stats
+func (h *RespHandler) resetStats() {
+	h.stats.totalConnections.Store(0)
+	h.stats.rejectedConnections.Store(0)
+	h.stats.totalCommands.Store(0)
+	h.stats.queryBufferLimitDisconnections.Store(0)
+	h.stats.outputBufferLimitDisconnections.Store(0)
+	h.stats.usedMemoryPeak.Store(0)
+	if resetter, ok := h.db.(databaseface.StatsResetter); ok {
+		resetter.ResetStats()
+	}
+}
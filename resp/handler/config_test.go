@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"go-redis/config"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestConfigCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	// 测试结束后恢复被修改的参数，maxclients 不能设置为 0，恢复为默认值
+	old := config.Get([]string{"client-output-buffer-limit"})
+	t.Cleanup(func() {
+		if err := config.Set(append(old, [2]string{"maxclients", strconv.Itoa(defaultMaxClients)})); err != nil {
+			t.Error(err)
+		}
+	})
+
+	tests := []struct {
+		args  []string
+		reply string
+	}{
+		{args: []string{"CONFIG", "GET", "port"}, reply: "*2\r\n$4\r\nport\r\n$4\r\n6379\r\n"},
+		{args: []string{"CONFIG", "GET", "nosuch*"}, reply: "*0\r\n"},
+		{args: []string{"CONFIG", "GET"}, reply: "-ERR wrong number of arguments for 'config|get' command\r\n"},
+		{args: []string{"CONFIG", "SET", "maxclients"}, reply: "-ERR wrong number of arguments for 'config|set' command\r\n"},
+		{args: []string{"CONFIG", "SET", "maxclients", "0"}, reply: "-ERR CONFIG SET failed (possibly related to argument 'maxclients') - argument must be between 1 and 2147483647 inclusive\r\n"},
+		{args: []string{"CONFIG", "SET", "client-output-buffer-limit", "normal 0 0"}, reply: "-ERR CONFIG SET failed (possibly related to argument 'client-output-buffer-limit') - wrong number of arguments in client-output-buffer-limit\r\n"},
+		{args: []string{"CONFIG", "SET", "maxclients", "100", "client-output-buffer-limit", "pubsub 1mb 0 0"}, reply: "+OK\r\n"},
+		{args: []string{"CONFIG", "GET", "maxclients"}, reply: "*2\r\n$10\r\nmaxclients\r\n$3\r\n100\r\n"},
+		{args: []string{"CONFIG", "NOSUCH"}, reply: "-ERR unknown subcommand 'NOSUCH'. Try CONFIG HELP.\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); got != tt.reply {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.reply)
+		}
+	}
+
+	// RESP3 下回复 map
+	c.do("HELLO", "3")
+	if got := c.do("CONFIG", "GET", "maxclients"); got != "%1\r\n$10\r\nmaxclients\r\n$3\r\n100\r\n" {
+		t.Errorf("CONFIG GET in RESP3: got %q", got)
+	}
+}
+
+func TestConfigResetStat(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+	c.do("GET", "k")
+	c.do("GET", "missing")
+	if got := c.do("CONFIG", "RESETSTAT"); got != "+OK\r\n" {
+		t.Fatalf("CONFIG RESETSTAT: %q", got)
+	}
+	info := c.do("INFO", "stats")
+	for _, field := range []string{
+		"total_connections_received:0\r\n",
+		"total_commands_processed:1\r\n",
+		"keyspace_hits:0\r\n",
+		"keyspace_misses:0\r\n",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO stats should contain %q after reset, got %q", field, info)
+		}
+	}
+}
+
+func TestConfigSetRequirePass(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	t.Cleanup(func() {
+		if err := config.Set([][2]string{{"requirepass", ""}}); err != nil {
+			t.Error(err)
+		}
+	})
+	if got := c.do("CONFIG", "SET", "requirepass", "secret"); got != "+OK\r\n" {
+		t.Fatalf("CONFIG SET requirepass: %q", got)
+	}
+	// 修改立即对新连接生效，已经认证的连接不受影响
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		t.Errorf("authenticated connection: got %q", got)
+	}
+	other := dial(t, addr)
+	if got := other.do("AUTH", "secret"); got != "+OK\r\n" {
+		t.Errorf("AUTH with new password: got %q", got)
+	}
+	if got := dial(t, addr).do("AUTH", "wrong"); !strings.HasPrefix(got, "-WRONGPASS") {
+		t.Errorf("AUTH with wrong password: got %q", got)
+	}
+}
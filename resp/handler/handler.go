@@ -6,13 +6,17 @@ package handler
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"go-redis/acl"
 	"go-redis/cluster"
 	"go-redis/config"
 	"go-redis/database"
 	databaseface "go-redis/interface/database"
+	"go-redis/interface/resp"
 	"go-redis/lib/logger"
 	"go-redis/lib/sync/atomic"
+	"go-redis/pubsub"
 	"go-redis/resp/connection"
 	"go-redis/resp/parser"
 	"go-redis/resp/reply"
@@ -20,6 +24,8 @@ import (
 	"net"
 	"strings"
 	"sync"
+	stdatomic "sync/atomic"
+	"time"
 )
 
 var (
@@ -30,29 +36,59 @@ var (
 // RespHandler implements tcp.Handler and serves as a redis handler
 // 处理客户端请求
 type RespHandler struct {
-	activeConn sync.Map              // *client -> placeholder 存储当前活跃的连接
+	activeConn sync.Map              // *client -> *parser.Limits 存储当前活跃的连接
 	db         databaseface.Database // 抽象接口对象，表示与此响应处理程序关联的数据库
+	acl        *acl.ACL              // ACL 用户，执行命令前检查权限
 	closing    atomic.Boolean        // 表示服务器是否正在关闭，拒绝其他 goroutine 的请求
+	clients    stdatomic.Int64       // 当前连接数，不超过 maxclients
+	pause      clientPause           // CLIENT PAUSE 暂停处理客户端命令
+	clientIDs  sync.Map              // id -> *client，用于 CLIENT TRACKING REDIRECT 查找连接
+	tracking   *tracking             // CLIENT TRACKING 记录的连接和 key
+	hub        *pubsub.Hub           // 发布订阅
+	// client-output-buffer-limit，修改配置时整体替换
+	outputLimits stdatomic.Pointer[outputLimits]
+	stats        stats     // INFO stats 中的计数
+	startTime    time.Time // 启动时间，INFO server 中的 uptime
+	runID        string    // 每次启动随机生成的 id
 }
 
 // MakeHandler creates a RespHandler instance
 func MakeHandler() *RespHandler {
 	var db databaseface.Database
-	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
+	if config.Properties.Self != "" && (len(config.Properties.Peers) > 0 || config.Properties.ClusterEnabled) {
 		// 使用集群版本的实现
 		db = cluster.MakeClusterDatabase()
 	} else {
 		db = database.NewStandaloneDatabase()
 	}
-	return &RespHandler{
-		db: db,
+	users, err := acl.MakeACL()
+	if err != nil {
+		logger.Fatal("load aclfile failed: " + err.Error())
 	}
+	h := &RespHandler{
+		db:        db,
+		acl:       users,
+		tracking:  makeTracking(),
+		hub:       pubsub.MakeHub(),
+		startTime: time.Now(),
+		runID:     makeRunID(),
+	}
+	limits := loadOutputLimits()
+	h.outputLimits.Store(&limits)
+	h.registerConfigHooks()
+	go h.clientsCron()
+	return h
 }
 
 func (h *RespHandler) closeClient(client *connection.Connection) {
 	_ = client.Close()
 	h.db.AfterClientClose(client)
-	h.activeConn.Delete(client)
+	if _, ok := h.activeConn.LoadAndDelete(client); ok {
+		h.clients.Add(-1)
+	}
+	h.clientIDs.Delete(client.GetID())
+	h.tracking.disable(client.GetID())
+	h.hub.UnsubscribeAll(client)
 }
 
 // Handle receives and executes redis commands
@@ -62,17 +98,56 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 	if h.closing.Get() {
 		// closing handler refuse new connection
 		_ = conn.Close()
+		return
 	}
 
 	// 到来一个新的客户端连接，并添加到活跃连接列表中
 	client := connection.NewConn(conn)
-	h.activeConn.Store(client, struct{}{})
+	// default 用户为 nopass 时新连接不需要认证
+	client.SetAuthenticated(h.acl.IsDefaultNoPass())
+	// 超过 maxclients 时回复错误并关闭连接
+	// 在 TLS 握手之前占用名额，避免大量未完成握手的连接绕过 maxclients
+	if h.clients.Add(1) > getMaxClients() {
+		h.clients.Add(-1)
+		h.stats.rejectedConnections.Add(1)
+		// tls 连接在发送回复时才握手，限制握手和发送的时间
+		_ = conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+		_ = client.Write(maxClientsReply.ToBytes())
+		_ = client.Close()
+		logger.Warn("max number of clients reached, refuse " + client.RemoteAddr().String())
+		return
+	}
+	// tls-port 的连接先完成握手，根据客户端证书认证
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := h.handshakeTLS(client, tlsConn); err != nil {
+			h.clients.Add(-1)
+			logger.Warn("tls handshake failed from " + conn.RemoteAddr().String() + ": " + err.Error())
+			_ = client.Kill()
+			return
+		}
+	}
+	h.stats.totalConnections.Add(1)
+	client.SetOutputLimiter(h.checkOutputLimit)
+	limits := makeQueryLimits()
+	h.activeConn.Store(client, limits)
+	h.clientIDs.Store(client.GetID(), client)
 
 	// 异步流式解析客户端请求
-	ch := parser.ParseStream(conn)
+	ch := parser.ParseStreamWithLimits(conn, limits)
 	for payload := range ch {
 		// error 逻辑
 		if payload.Err != nil {
+			// 超过 proto-max-bulk-len、client-query-buffer-limit 时回复错误并关闭连接
+			if isQueryLimitErr(payload.Err) {
+				if errors.Is(payload.Err, parser.ErrQueryBufferLimit) {
+					h.stats.queryBufferLimitDisconnections.Add(1)
+					logger.Warn("client " + client.RemoteAddr().String() + " closed for exceeding query buffer limit")
+				}
+				_ = client.Write(reply.MakeErrReply(payload.Err.Error()).ToBytes())
+				h.closeClient(client)
+				logger.Info("connection closed: " + client.RemoteAddr().String())
+				return
+			}
 			if payload.Err == io.EOF || // io.EOF 代表客户端关闭连接
 				errors.Is(payload.Err, io.ErrUnexpectedEOF) || // io.ErrUnexpectedEOF 代表客户端发送了一个不完整的请求
 				// 使用了被关闭的连接
@@ -99,22 +174,97 @@ func (h *RespHandler) Handle(ctx context.Context, conn net.Conn) {
 			continue
 		}
 
-		// 如果数据不是 MultiBulkReply，则忽略
+		// 空数组 *0 与 redis 相同直接忽略，其他不是 MultiBulkReply 的数据回复错误，避免客户端一直等待
 		r, ok := payload.Data.(*reply.MultiBulkReply)
 		if !ok {
-			logger.Error("require multi bulk reply")
+			if _, empty := payload.Data.(*reply.EmptyMultiBulkReply); !empty {
+				_ = client.Write(reply.MakeErrReply("ERR Protocol error: expected multi bulk").ToBytes())
+			}
 			continue
 		}
 
+		client.Touch()
+		h.stats.totalCommands.Add(1)
+
+		// QUIT 回复 OK 后关闭连接
+		if len(r.Args) > 0 && strings.EqualFold(string(r.Args[0]), "quit") {
+			_ = client.Write(reply.MakeOkReply().ToBytes())
+			h.closeClient(client)
+			logger.Info("connection closed: " + client.RemoteAddr().String())
+			return
+		}
+
 		// exec 逻辑
 		// 执行命令
-		result := h.db.Exec(client, r.Args)
-		if result != nil {
-			_ = client.Write(result.ToBytes())
-		} else {
-			_ = client.Write(unknownErrReplyBytes)
+		result := h.exec(client, r.Args)
+		// CLIENT REPLY OFF/SKIP 时不回复
+		if client.TakeReply() {
+			if result != nil {
+				// 按照连接协商的协议版本编码回复
+				_ = client.Write(reply.Encode(result, client.GetProtocol()))
+			} else {
+				_ = client.Write(unknownErrReplyBytes)
+			}
+		}
+		if client.IsCloseAfterReply() {
+			h.closeClient(client)
+			logger.Info("connection closed: " + client.RemoteAddr().String())
+			return
+		}
+	}
+	// 解析器遇到无法恢复的错误(如过长的 inline 请求)后停止解析，关闭连接
+	h.closeClient(client)
+	logger.Info("connection closed: " + client.RemoteAddr().String())
+}
+
+// exec executes connection commands, and checks authentication before executing database commands
+// AUTH、HELLO 修改连接的认证状态和协议版本，由协议层处理
+func (h *RespHandler) exec(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) == 0 {
+		return reply.MakeErrReply("ERR empty command")
+	}
+	client.SetLastCommand(commandName(args))
+	switch strings.ToLower(string(args[0])) {
+	case "auth":
+		return h.execAuth(client, args)
+	case "hello":
+		return h.execHello(client, args)
+	}
+	if !client.IsAuthenticated() {
+		return noAuthReply
+	}
+	// 检查 ACL 用户的命令和 key 权限，节点之间的连接不检查
+	if client.GetUser() != "" {
+		if denied := h.acl.Check(client, args); denied != nil {
+			return denied
+		}
+	}
+	cmdName := strings.ToLower(string(args[0]))
+	// RESP2 连接订阅了频道后只能执行订阅相关的命令
+	if client.IsSubscribed() && client.GetProtocol() == reply.RESP2 && !isSubscribeModeCommand(cmdName) {
+		return reply.MakeErrReply("ERR Can't execute '" + cmdName + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+	}
+	// CLIENT PAUSE 期间等待暂停结束
+	h.pause.wait(client, cmdName)
+	switch cmdName {
+	case "acl":
+		return h.acl.Exec(client, args)
+	case "client":
+		return h.execClient(client, args)
+	case "info":
+		return h.execInfo(args)
+	case "config":
+		return h.execConfig(client, args)
+	case "subscribe", "unsubscribe", "publish":
+		return h.execPubSub(client, cmdName, args)
+	case "ping":
+		if client.IsSubscribed() && client.GetProtocol() == reply.RESP2 {
+			return execSubscribedPing(args)
 		}
 	}
+	result := h.db.Exec(client, args)
+	h.trackAfterExec(client, cmdName, args, result)
+	return result
 }
 
 // Close stops handler
@@ -0,0 +1,198 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readTimeout is the max duration of waiting for a reply in tests
+const readTimeout = 5 * time.Second
+
+// startTestServer starts a handler listening on a random port
+func startTestServer(t *testing.T) (*RespHandler, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := MakeHandler()
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = h.Close()
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(context.Background(), conn)
+		}
+	}()
+	return h, listener.Addr().String()
+}
+
+// testClient sends commands and reads raw replies
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *testClient {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// send writes raw bytes
+func (c *testClient) send(raw string) {
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(raw)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// do sends command and returns the raw reply
+func (c *testClient) do(args ...string) string {
+	c.t.Helper()
+	c.send(encodeCommand(args...))
+	return c.read()
+}
+
+// read returns the next raw reply
+func (c *testClient) read() string {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	raw, err := readRawReply(c.reader)
+	if err != nil {
+		c.t.Fatalf("read reply failed: %v", err)
+	}
+	return raw
+}
+
+// expectClosed waits until the server closes connection, replies before closing are returned
+func (c *testClient) expectClosed() string {
+	c.t.Helper()
+	_ = c.conn.SetReadDeadline(time.Now().Add(readTimeout))
+	var sb strings.Builder
+	for {
+		raw, err := readRawReply(c.reader)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				c.t.Fatal("connection is not closed")
+			}
+			return sb.String()
+		}
+		sb.WriteString(raw)
+	}
+}
+
+func encodeCommand(args ...string) string {
+	var sb strings.Builder
+	sb.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		sb.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return sb.String()
+}
+
+// readRawReply reads a RESP2 or RESP3 value, returns the bytes as received
+func readRawReply(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 3 {
+		return "", errors.New("invalid line " + strconv.Quote(line))
+	}
+	switch line[0] {
+	case '$', '=', '!':
+		size, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil || size < 0 {
+			return line, err
+		}
+		body := make([]byte, size+2)
+		if _, err = io.ReadFull(reader, body); err != nil {
+			return "", err
+		}
+		return line + string(body), nil
+	case '*', '~', '>', '%', '|':
+		count, err := strconv.Atoi(line[1 : len(line)-2])
+		if err != nil {
+			return "", err
+		}
+		if line[0] == '%' || line[0] == '|' {
+			count *= 2
+		}
+		if line[0] == '|' {
+			count++ // 属性之后的数据
+		}
+		var sb strings.Builder
+		sb.WriteString(line)
+		for i := 0; i < count; i++ {
+			element, err := readRawReply(reader)
+			if err != nil {
+				return "", err
+			}
+			sb.WriteString(element)
+		}
+		return sb.String(), nil
+	}
+	return line, nil
+}
+
+func TestHandle(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	tests := []struct {
+		request string
+		reply   string
+	}{
+		{request: encodeCommand("SET", "k", "v"), reply: "+OK\r\n"},
+		{request: encodeCommand("GET", "k"), reply: "$1\r\nv\r\n"},
+		{request: encodeCommand("GET", "missing"), reply: "$-1\r\n"},
+		{request: encodeCommand("NOSUCHCOMMAND"), reply: "-ERR unknown command 'nosuchcommand'\r\n"},
+		// 空数组被忽略，不回复
+		{request: "*0\r\n" + encodeCommand("PING"), reply: "+PONG\r\n"},
+	}
+	for _, tt := range tests {
+		c.send(tt.request)
+		if got := c.read(); got != tt.reply {
+			t.Errorf("%q: got %q, want %q", tt.request, got, tt.reply)
+		}
+	}
+	if got := c.do("QUIT"); got != "+OK\r\n" {
+		t.Errorf("QUIT: got %q", got)
+	}
+	c.expectClosed()
+}
+
+func TestInlineCommand(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.send("set k \"hello world\"\r\n\r\nget k\n")
+	if got := c.read(); got != "+OK\r\n" {
+		t.Errorf("got %q", got)
+	}
+	if got := c.read(); got != "$11\r\nhello world\r\n" {
+		t.Errorf("got %q", got)
+	}
+	c.send("get \"k\r\n")
+	if got := c.read(); got != "-protocol error: unbalanced quotes in request\r\n" {
+		t.Errorf("got %q", got)
+	}
+	// 过长的 inline 请求关闭连接
+	c.send(strings.Repeat("a", 2*65536))
+	c.expectClosed()
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"go-redis/cluster"
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+)
+
+// serverVersion is the redis version reported by HELLO
+// 客户端根据版本号判断支持的特性
+const serverVersion = "7.0.0"
+
+// execHello switches protocol version and returns the information of server
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+// 不指定协议版本时只回复服务器信息；认证失败时不切换协议版本
+func (h *RespHandler) execHello(client *connection.Connection, args [][]byte) resp.Reply {
+	protocol := client.GetProtocol()
+	var name string
+	var setName bool
+	if len(args) > 1 {
+		version, err := strconv.Atoi(string(args[1]))
+		if err != nil {
+			return reply.MakeErrReply("ERR Protocol version is not an integer or out of range")
+		}
+		if version != reply.RESP2 && version != reply.RESP3 {
+			return reply.MakeErrReply("NOPROTO unsupported protocol version")
+		}
+		protocol = version
+		for i := 2; i < len(args); i++ {
+			option := strings.ToLower(string(args[i]))
+			if option == "auth" && i+2 < len(args) {
+				ret := h.execAuth(client, [][]byte{[]byte("AUTH"), args[i+1], args[i+2]})
+				if reply.IsErrorReply(ret) {
+					return ret
+				}
+				i += 2
+			} else if option == "setname" && i+1 < len(args) {
+				name = string(args[i+1])
+				if strings.ContainsAny(name, " \n") {
+					return reply.MakeErrReply("ERR Client names cannot contain spaces, newlines or special characters.")
+				}
+				setName = true
+				i++
+			} else {
+				return reply.MakeErrReply("ERR Syntax error in HELLO option '" + string(args[i]) + "'")
+			}
+		}
+	}
+	if !client.IsAuthenticated() {
+		return reply.MakeErrReply("NOAUTH HELLO must be called with the client already authenticated, " +
+			"otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time")
+	}
+	client.SetProtocol(protocol)
+	if setName {
+		client.SetName(name)
+	}
+	mode := "standalone"
+	if _, ok := h.db.(*cluster.ClusterDatabase); ok {
+		mode = "cluster"
+	}
+	return reply.MakeMapReply([]resp.Reply{
+		reply.MakeBulkReply([]byte("server")), reply.MakeBulkReply([]byte("redis")),
+		reply.MakeBulkReply([]byte("version")), reply.MakeBulkReply([]byte(serverVersion)),
+		reply.MakeBulkReply([]byte("proto")), reply.MakeIntReply(int64(protocol)),
+		reply.MakeBulkReply([]byte("id")), reply.MakeIntReply(client.GetID()),
+		reply.MakeBulkReply([]byte("mode")), reply.MakeBulkReply([]byte(mode)),
+		reply.MakeBulkReply([]byte("role")), reply.MakeBulkReply([]byte("master")),
+		reply.MakeBulkReply([]byte("modules")), &reply.EmptyMultiBulkReply{},
+	})
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHello(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.do("SET", "k", "v")
+
+	tests := []struct {
+		args   []string
+		prefix string
+	}{
+		{args: []string{"HELLO", "4"}, prefix: "-NOPROTO unsupported protocol version"},
+		{args: []string{"HELLO", "x"}, prefix: "-ERR Protocol version is not an integer or out of range"},
+		{args: []string{"HELLO", "3", "SETNAME"}, prefix: "-ERR Syntax error in HELLO option 'SETNAME'"},
+		{args: []string{"HELLO", "3", "SETNAME", "a b"}, prefix: "-ERR Client names cannot contain spaces"},
+		// 不指定协议版本时按当前协议回复服务器信息
+		{args: []string{"HELLO"}, prefix: "*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n"},
+	}
+	for _, tt := range tests {
+		if got := c.do(tt.args...); !strings.HasPrefix(got, tt.prefix) {
+			t.Errorf("%v: got %q, want prefix %q", tt.args, got, tt.prefix)
+		}
+	}
+	// 参数错误时不切换协议
+	if got := c.do("GET", "missing"); got != "$-1\r\n" {
+		t.Fatalf("protocol should not be changed, got %q", got)
+	}
+
+	got := c.do("HELLO", "3", "SETNAME", "conn1")
+	if !strings.HasPrefix(got, "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n") || !strings.Contains(got, "$5\r\nproto\r\n:3\r\n") {
+		t.Fatalf("unexpected reply of HELLO 3: %q", got)
+	}
+	tests2 := []struct {
+		args  []string
+		reply string
+	}{
+		{args: []string{"GET", "missing"}, reply: "_\r\n"},
+		{args: []string{"MGET", "k", "missing"}, reply: "*2\r\n$1\r\nv\r\n_\r\n"},
+	}
+	for _, tt := range tests2 {
+		if got := c.do(tt.args...); got != tt.reply {
+			t.Errorf("%v: got %q, want %q", tt.args, got, tt.reply)
+		}
+	}
+	if got := c.do("HELLO", "2"); !strings.HasPrefix(got, "*14\r\n") {
+		t.Fatalf("unexpected reply of HELLO 2: %q", got)
+	}
+	if got := c.do("GET", "missing"); got != "$-1\r\n" {
+		t.Errorf("RESP2 null should be $-1, got %q", got)
+	}
+	if got := c.do("HELLO"); !strings.Contains(got, "$5\r\nproto\r\n:2\r\n$2\r\nid\r\n:") {
+		t.Errorf("HELLO should reply proto 2 and id, got %q", got)
+	}
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"go-redis/cluster"
+	"go-redis/config"
+	databaseface "go-redis/interface/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"go-redis/resp/reply"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	stdatomic "sync/atomic"
+	"time"
+)
+
+/*
+INFO [section ...]
+按 `# Section\r\nfield:value\r\n` 格式输出，section 之间以空行分隔
+协议层输出 server、clients、memory、stats、cpu，数据库补充 persistence、stats、replication、cluster、keyspace 等
+不指定 section 或指定 default、all、everything 时输出所有 section，未知的 section 被忽略
+*/
+
+// infoSections are all sections of INFO in order
+var infoSections = []struct {
+	name  string
+	title string
+}{
+	{"server", "Server"},
+	{"clients", "Clients"},
+	{"memory", "Memory"},
+	{"persistence", "Persistence"},
+	{"stats", "Stats"},
+	{"replication", "Replication"},
+	{"cpu", "CPU"},
+	{"cluster", "Cluster"},
+	{"peers", "Peers"},
+	{"nearcache", "NearCache"},
+	{"keyspace", "Keyspace"},
+}
+
+// stats are the counters reported in INFO stats
+type stats struct {
+	totalConnections                stdatomic.Int64 // 接受的连接数
+	rejectedConnections             stdatomic.Int64 // 超过 maxclients 被拒绝的连接数
+	totalCommands                   stdatomic.Int64 // 执行的命令数
+	queryBufferLimitDisconnections  stdatomic.Int64 // 超过 client-query-buffer-limit 被关闭的连接数
+	outputBufferLimitDisconnections stdatomic.Int64 // 超过 client-output-buffer-limit 被关闭的连接数
+	usedMemoryPeak                  stdatomic.Uint64
+}
+
+// makeRunID returns a random id which identifies the running server
+func makeRunID() string {
+	buf := make([]byte, 20)
+	_, _ = rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// execInfo returns the sections of INFO
+func (h *RespHandler) execInfo(args [][]byte) resp.Reply {
+	selected := make(map[string]bool)
+	for _, arg := range args[1:] {
+		selected[strings.ToLower(string(arg))] = true
+	}
+	all := len(selected) == 0 || selected["default"] || selected["all"] || selected["everything"]
+	provider, _ := h.db.(databaseface.InfoProvider)
+	var builder strings.Builder
+	for _, section := range infoSections {
+		if !all && !selected[section.name] {
+			continue
+		}
+		fields := h.infoFields(section.name)
+		if provider != nil {
+			fields = append(fields, provider.InfoFields(section.name)...)
+		}
+		// keyspace 没有 key 时也输出标题
+		if len(fields) == 0 && section.name != "keyspace" {
+			continue
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + section.title + "\r\n")
+		for _, field := range fields {
+			builder.WriteString(field + "\r\n")
+		}
+	}
+	return reply.MakeVerbatimReply("txt", []byte(builder.String()))
+}
+
+// infoFields returns the fields of section reported by handler
+func (h *RespHandler) infoFields(section string) []string {
+	switch section {
+	case "server":
+		return h.serverInfo()
+	case "clients":
+		return h.clientsInfo()
+	case "memory":
+		return h.memoryInfo()
+	case "stats":
+		return h.statsInfo()
+	case "cpu":
+		return cpuInfo()
+	}
+	return nil
+}
+
+func (h *RespHandler) serverInfo() []string {
+	mode := "standalone"
+	if _, ok := h.db.(*cluster.ClusterDatabase); ok {
+		mode = "cluster"
+	}
+	executable, _ := os.Executable()
+	uptime := int64(time.Since(h.startTime) / time.Second)
+	return []string{
+		"redis_version:" + serverVersion,
+		"redis_mode:" + mode,
+		"os:" + runtime.GOOS + " " + runtime.GOARCH,
+		"arch_bits:" + strconv.Itoa(strconv.IntSize),
+		"go_version:" + runtime.Version(),
+		"process_id:" + strconv.Itoa(os.Getpid()),
+		"run_id:" + h.runID,
+		"tcp_port:" + strconv.Itoa(config.Properties.Port),
+		"server_time_usec:" + strconv.FormatInt(time.Now().UnixMicro(), 10),
+		"uptime_in_seconds:" + strconv.FormatInt(uptime, 10),
+		"uptime_in_days:" + strconv.FormatInt(uptime/(24*3600), 10),
+		"executable:" + executable,
+		"config_file:" + config.ConfigFile,
+	}
+}
+
+func (h *RespHandler) clientsInfo() []string {
+	var maxInput, maxOutput int64
+	var blocked, tracking, pubsub int
+	h.activeConn.Range(func(key, value interface{}) bool {
+		c := key.(*connection.Connection)
+		if size := value.(*parser.Limits).QueryBufferSize(); size > maxInput {
+			maxInput = size
+		}
+		if size, _ := c.GetOutputBufferSize(); size > maxOutput {
+			maxOutput = size
+		}
+		if c.IsBlocked() {
+			blocked++
+		}
+		if h.tracking.getState(c.GetID()) != nil {
+			tracking++
+		}
+		if c.IsSubscribed() {
+			pubsub++
+		}
+		return true
+	})
+	return []string{
+		"connected_clients:" + strconv.FormatInt(h.clients.Load(), 10),
+		"maxclients:" + strconv.FormatInt(getMaxClients(), 10),
+		"client_recent_max_input_buffer:" + strconv.FormatInt(maxInput, 10),
+		"client_recent_max_output_buffer:" + strconv.FormatInt(maxOutput, 10),
+		"blocked_clients:" + strconv.Itoa(blocked),
+		"tracking_clients:" + strconv.Itoa(tracking),
+		"pubsub_clients:" + strconv.Itoa(pubsub),
+	}
+}
+
+// updateMemoryPeak records the peak of used memory, returns current memory stats
+func (h *RespHandler) updateMemoryPeak() *runtime.MemStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	for {
+		peak := h.stats.usedMemoryPeak.Load()
+		if m.HeapAlloc <= peak || h.stats.usedMemoryPeak.CompareAndSwap(peak, m.HeapAlloc) {
+			return &m
+		}
+	}
+}
+
+// memoryInfo reports memory allocated by go runtime
+// used_memory 为堆上正在使用的内存，used_memory_rss 为向操作系统申请的内存
+func (h *RespHandler) memoryInfo() []string {
+	m := h.updateMemoryPeak()
+	peak := h.stats.usedMemoryPeak.Load()
+	return []string{
+		"used_memory:" + strconv.FormatUint(m.HeapAlloc, 10),
+		"used_memory_human:" + bytesToHuman(m.HeapAlloc),
+		"used_memory_rss:" + strconv.FormatUint(m.Sys, 10),
+		"used_memory_rss_human:" + bytesToHuman(m.Sys),
+		"used_memory_peak:" + strconv.FormatUint(peak, 10),
+		"used_memory_peak_human:" + bytesToHuman(peak),
+		"mem_allocator:go",
+		"gc_count:" + strconv.FormatUint(uint64(m.NumGC), 10),
+		"gc_pause_total_ms:" + strconv.FormatUint(m.PauseTotalNs/uint64(time.Millisecond), 10),
+	}
+}
+
+// bytesToHuman formats bytes like 1.50M, the same as redis
+func bytesToHuman(n uint64) string {
+	units := []string{"B", "K", "M", "G", "T"}
+	value := float64(n)
+	i := 0
+	for value >= 1024 && i < len(units)-1 {
+		value /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.FormatUint(n, 10) + "B"
+	}
+	return strconv.FormatFloat(value, 'f', 2, 64) + units[i]
+}
+
+func (h *RespHandler) statsInfo() []string {
+	return []string{
+		"total_connections_received:" + strconv.FormatInt(h.stats.totalConnections.Load(), 10),
+		"total_commands_processed:" + strconv.FormatInt(h.stats.totalCommands.Load(), 10),
+		"rejected_connections:" + strconv.FormatInt(h.stats.rejectedConnections.Load(), 10),
+		"client_query_buffer_limit_disconnections:" + strconv.FormatInt(h.stats.queryBufferLimitDisconnections.Load(), 10),
+		"client_output_buffer_limit_disconnections:" + strconv.FormatInt(h.stats.outputBufferLimitDisconnections.Load(), 10),
+		"tracking_total_keys:" + strconv.Itoa(h.tracking.keysCount()),
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package handler
+
+// cpuInfo reports cpu time used by the process
+// getrusage 只在 unix 系统上可用，其他系统不输出 cpu section
+func cpuInfo() []string {
+	return nil
+}
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"strings"
+	"testing"
+)
+
+// infoSectionsOf returns the titles of sections in the reply of INFO
+func infoSectionsOf(raw string) []string {
+	var titles []string
+	for _, line := range strings.Split(raw, "\r\n") {
+		if strings.HasPrefix(line, "# ") {
+			titles = append(titles, strings.TrimPrefix(line, "# "))
+		}
+	}
+	return titles
+}
+
+func TestInfoSections(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{args: nil, want: "Server Clients Memory Persistence Stats Replication CPU Cluster Keyspace"},
+		{args: []string{"everything"}, want: "Server Clients Memory Persistence Stats Replication CPU Cluster Keyspace"},
+		{args: []string{"SERVER"}, want: "Server"},
+		// 按固定顺序输出，与参数顺序无关
+		{args: []string{"keyspace", "memory"}, want: "Memory Keyspace"},
+		{args: []string{"nosuch"}, want: ""},
+	}
+	for _, tt := range tests {
+		got := strings.Join(infoSectionsOf(c.do(append([]string{"INFO"}, tt.args...)...)), " ")
+		if got != tt.want {
+			t.Errorf("INFO %v: got sections %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestInfoFields(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.do("SET", "a", "1")
+	c.do("SELECT", "2")
+	c.do("SET", "b", "1")
+	c.do("SET", "c", "1")
+	c.do("GET", "b")
+	c.do("GET", "missing")
+	dial(t, addr).do("PING")
+
+	info := c.do("INFO")
+	for _, field := range []string{
+		"redis_mode:standalone\r\n",
+		"connected_clients:2\r\n",
+		"aof_enabled:0\r\n",
+		"total_connections_received:2\r\n",
+		"keyspace_hits:1\r\n",
+		"keyspace_misses:1\r\n",
+		"role:master\r\n",
+		"cluster_enabled:0\r\n",
+		"db0:keys=1,expires=0,avg_ttl=0\r\n",
+		"db2:keys=2,expires=0,avg_ttl=0\r\n",
+	} {
+		if !strings.Contains(info, field) {
+			t.Errorf("INFO should contain %q", field)
+		}
+	}
+	if strings.Contains(info, "db1:") {
+		t.Error("empty db should not be reported in keyspace")
+	}
+	for _, field := range []string{"uptime_in_seconds:", "used_memory:", "used_cpu_sys:", "total_commands_processed:"} {
+		if !strings.Contains(info, "\r\n"+field) {
+			t.Errorf("INFO should contain field %q", field)
+		}
+	}
+
+	// INFO 本身也计入执行的命令数
+	before := infoField(c.do("INFO", "stats"), "total_commands_processed")
+	c.do("PING")
+	after := infoField(c.do("INFO", "stats"), "total_commands_processed")
+	if before == "" || after == before {
+		t.Errorf("total_commands_processed should increase, before %q, after %q", before, after)
+	}
+}
+
+// infoField returns the value of field in the reply of INFO
+func infoField(raw, name string) string {
+	for _, line := range strings.Split(raw, "\r\n") {
+		if value, ok := strings.CutPrefix(line, name+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package handler
+
+import (
+	"strconv"
+	"syscall"
+)
+
+// cpuInfo reports cpu time used by the process
+func cpuInfo() []string {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return nil
+	}
+	return []string{
+		"used_cpu_sys:" + formatTimeval(usage.Stime),
+		"used_cpu_user:" + formatTimeval(usage.Utime),
+	}
+}
+
+func formatTimeval(tv syscall.Timeval) string {
+	seconds := float64(tv.Sec) + float64(tv.Usec)/1e6
+	return strconv.FormatFloat(seconds, 'f', 6, 64)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"errors"
+	"go-redis/config"
+	"go-redis/lib/logger"
+	"go-redis/resp/connection"
+	"go-redis/resp/parser"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+客户端缓冲区限制
+1. proto-max-bulk-len          单个字符串的最大长度，超过后回复协议错误并关闭连接
+2. client-query-buffer-limit   一条命令的最大长度，超过后关闭连接
+3. client-output-buffer-limit  输出缓冲区限制，按客户端类型配置，格式为 类型 硬限制 软限制 秒数
+	超过硬限制，或者持续超过软限制达到指定秒数后关闭连接，0 表示不限制
+	类型为 normal、replica(slave)、pubsub，多个类型写在同一行，如
+	client-output-buffer-limit normal 0 0 0 replica 256mb 64mb 60 pubsub 32mb 8mb 60
+*/
+
+const (
+	defaultProtoMaxBulkLen        = 512 * 1024 * 1024
+	defaultClientQueryBufferLimit = 1024 * 1024 * 1024
+)
+
+// outputLimit is the output buffer limit of a client class
+type outputLimit struct {
+	hard        int64         // 硬限制
+	soft        int64         // 软限制
+	softSeconds time.Duration // 超过软限制的持续时间
+}
+
+// outputLimits maps client class to its limit
+type outputLimits map[string]outputLimit
+
+// defaultOutputLimits are the same as redis
+var defaultOutputLimits = outputLimits{
+	"normal":  {},
+	"replica": {hard: 256 * 1024 * 1024, soft: 64 * 1024 * 1024, softSeconds: 60 * time.Second},
+	"pubsub":  {hard: 32 * 1024 * 1024, soft: 8 * 1024 * 1024, softSeconds: 60 * time.Second},
+}
+
+// getSizeConfig parses memory size in config, returns defaultValue if not configured or invalid
+func getSizeConfig(name, value string, defaultValue int64) int64 {
+	if value == "" {
+		return defaultValue
+	}
+	size, err := config.ParseSize(value)
+	if err != nil {
+		logger.Warn(name + ": " + err.Error())
+		return defaultValue
+	}
+	return size
+}
+
+// makeQueryLimits creates the limits of requests of a new connection
+func makeQueryLimits() *parser.Limits {
+	props := config.Load()
+	return &parser.Limits{
+		MaxBulkLen:     getSizeConfig("proto-max-bulk-len", props.ProtoMaxBulkLen, defaultProtoMaxBulkLen),
+		MaxQueryBuffer: getSizeConfig("client-query-buffer-limit", props.ClientQueryBufferLimit, defaultClientQueryBufferLimit),
+	}
+}
+
+// parseOutputLimits parses client-output-buffer-limit, classes not configured use default limits
+func parseOutputLimits(value string) (outputLimits, error) {
+	limits := make(outputLimits, len(defaultOutputLimits))
+	for class, limit := range defaultOutputLimits {
+		limits[class] = limit
+	}
+	fields := strings.Fields(value)
+	if len(fields)%4 != 0 {
+		return nil, errors.New("wrong number of arguments in client-output-buffer-limit")
+	}
+	for i := 0; i < len(fields); i += 4 {
+		class := strings.ToLower(fields[i])
+		if class == "slave" {
+			class = "replica"
+		}
+		if _, ok := defaultOutputLimits[class]; !ok {
+			return nil, errors.New("invalid client class in client-output-buffer-limit: " + fields[i])
+		}
+		hard, err := config.ParseSize(fields[i+1])
+		if err != nil {
+			return nil, err
+		}
+		soft, err := config.ParseSize(fields[i+2])
+		if err != nil {
+			return nil, err
+		}
+		seconds, err := strconv.ParseInt(fields[i+3], 10, 64)
+		if err != nil || seconds < 0 {
+			return nil, errors.New("invalid soft limit seconds in client-output-buffer-limit: " + fields[i+3])
+		}
+		limits[class] = outputLimit{hard: hard, soft: soft, softSeconds: time.Duration(seconds) * time.Second}
+	}
+	return limits, nil
+}
+
+// loadOutputLimits parses client-output-buffer-limit in config, returns default limits if invalid
+func loadOutputLimits() outputLimits {
+	limits, err := parseOutputLimits(config.Load().ClientOutputBufferLimit)
+	if err != nil {
+		logger.Warn(err.Error())
+		return defaultOutputLimits
+	}
+	return limits
+}
+
+// checkOutputLimit is called after each write, returns true if the connection should be closed
+func (h *RespHandler) checkOutputLimit(c *connection.Connection, pending int64) bool {
+	// 节点之间的连接不限制
+	if c.GetUser() == "" && !c.IsReplica() {
+		return false
+	}
+	limit := (*h.outputLimits.Load())[getClientType(c)]
+	exceeded := limit.hard > 0 && pending >= limit.hard
+	if !exceeded && limit.soft > 0 && pending >= limit.soft {
+		exceeded = c.SoftLimitExceededFor(true) >= limit.softSeconds
+	} else if !exceeded {
+		c.SoftLimitExceededFor(false)
+	}
+	if exceeded {
+		h.stats.outputBufferLimitDisconnections.Add(1)
+		logger.Warn("client " + c.RemoteAddr().String() + " closed for overcoming of output buffer limits")
+	}
+	return exceeded
+}
+
+// isQueryLimitErr returns whether the error is returned by parser because of limits
+// 包括数组中出现非字符串元素，这些错误之后解析器停止解析，需要关闭连接
+func isQueryLimitErr(err error) bool {
+	return errors.Is(err, parser.ErrInvalidBulkLength) ||
+		errors.Is(err, parser.ErrExpectedBulk) ||
+		errors.Is(err, parser.ErrInvalidMultiBulkLength) ||
+		errors.Is(err, parser.ErrQueryBufferLimit)
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"go-redis/resp/connection"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOutputLimits(t *testing.T) {
+	tests := []struct {
+		value string
+		want  outputLimits
+		err   bool
+	}{
+		{value: "", want: defaultOutputLimits},
+		{
+			value: "normal 1mb 512kb 10 slave 0 0 0",
+			want: outputLimits{
+				"normal":  {hard: 1 << 20, soft: 512 << 10, softSeconds: 10 * time.Second},
+				"replica": {},
+				"pubsub":  defaultOutputLimits["pubsub"],
+			},
+		},
+		{value: "normal 1mb 512kb", err: true},
+		{value: "master 0 0 0", err: true},
+		{value: "pubsub 1x 0 0", err: true},
+		{value: "pubsub 0 0 -1", err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseOutputLimits(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q should be rejected", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestQueryLimits(t *testing.T) {
+	setConfig(t, "proto-max-bulk-len", "16")
+	setConfig(t, "client-query-buffer-limit", "64")
+	_, addr := startTestServer(t)
+
+	c := dial(t, addr)
+	if got := c.do("SET", "k", "0123456789"); got != "+OK\r\n" {
+		t.Fatalf("SET within limits: %q", got)
+	}
+	c.send("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$100\r\n")
+	if got := c.expectClosed(); got != "-ERR Protocol error: invalid bulk length\r\n" {
+		t.Fatalf("proto-max-bulk-len: got %q", got)
+	}
+
+	c = dial(t, addr)
+	c.send(encodeCommand("MSET", "a", "0123456789", "b", "0123456789", "c", "0123456789"))
+	if got := c.expectClosed(); got != "-ERR Protocol error: query buffer limit exceeded\r\n" {
+		t.Fatalf("client-query-buffer-limit: got %q", got)
+	}
+
+	c = dial(t, addr)
+	if got := c.do("INFO", "stats"); !strings.Contains(got, "client_query_buffer_limit_disconnections:1\r\n") {
+		t.Errorf("INFO stats should count disconnections, got %q", got)
+	}
+}
+
+func TestOutputLimit(t *testing.T) {
+	setConfig(t, "client-output-buffer-limit", "pubsub 64 0 0")
+	_, addr := startTestServer(t)
+
+	sub := dial(t, addr)
+	sub.do("SUBSCRIBE", "ch")
+	publisher := dial(t, addr)
+	if got := publisher.do("PUBLISH", "ch", "small"); got != ":1\r\n" {
+		t.Fatalf("PUBLISH: %q", got)
+	}
+	sub.read()
+	// 超过硬限制时立即关闭连接，未发送的消息被丢弃
+	publisher.do("PUBLISH", "ch", strings.Repeat("x", 100))
+	if got := sub.expectClosed(); got != "" {
+		t.Fatalf("message over hard limit should be dropped, got %q", got)
+	}
+	if got := publisher.do("INFO", "stats"); !strings.Contains(got, "client_output_buffer_limit_disconnections:1\r\n") {
+		t.Errorf("INFO stats should count disconnections, got %q", got)
+	}
+	// 普通客户端不受 pubsub 的限制
+	publisher.do("SET", "k", strings.Repeat("x", 100))
+	if got := publisher.do("GET", "k"); !strings.HasPrefix(got, "$100\r\n") {
+		t.Errorf("normal client should not be limited, got %q", got)
+	}
+}
+
+func TestOutputSoftLimit(t *testing.T) {
+	h, _ := startTestServer(t)
+	server, client := net.Pipe()
+	t.Cleanup(func() {
+		_ = server.Close()
+		_ = client.Close()
+	})
+	c := connection.NewConn(server)
+	c.SetUser("default")
+
+	limits := outputLimits{"normal": {soft: 64, softSeconds: time.Hour}}
+	h.outputLimits.Store(&limits)
+	if h.checkOutputLimit(c, 100) {
+		t.Fatal("should not be closed before soft limit seconds")
+	}
+	limits = outputLimits{"normal": {soft: 64}}
+	h.outputLimits.Store(&limits)
+	if !h.checkOutputLimit(c, 100) {
+		t.Fatal("should be closed after soft limit seconds")
+	}
+	// 低于软限制后重新计时
+	limits = outputLimits{"normal": {soft: 64, softSeconds: time.Hour}}
+	h.outputLimits.Store(&limits)
+	h.checkOutputLimit(c, 10)
+	if d := c.SoftLimitExceededFor(true); d > time.Second {
+		t.Fatalf("soft limit timer should be reset, got %v", d)
+	}
+
+	// 节点之间的连接不限制
+	c.SetUser("")
+	limits = outputLimits{"normal": {hard: 1}}
+	h.outputLimits.Store(&limits)
+	if h.checkOutputLimit(c, 100) {
+		t.Fatal("connections between nodes should not be limited")
+	}
+}
@@ -0,0 +1,93 @@
+package handler
+
+import (
+	"go-redis/acl"
+	"go-redis/resp/connection"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+CLIENT PAUSE timeout [WRITE|ALL]
+暂停处理客户端的命令，直到超时或 CLIENT UNPAUSE，用于故障转移前停止写入
+	ALL    暂停所有命令(默认)
+	WRITE  只暂停写命令，读命令正常执行
+节点之间的连接不受影响；CLIENT 命令不受影响，以便执行 CLIENT UNPAUSE
+*/
+
+// clientPause is the pause state of all clients
+type clientPause struct {
+	mu       sync.Mutex
+	end      time.Time     // 暂停结束的时间，零值表示没有暂停
+	all      bool          // 暂停所有命令，否则只暂停写命令
+	unpaused chan struct{} // CLIENT UNPAUSE 时关闭，唤醒等待的连接
+}
+
+// pause pauses clients until timeout, a longer or stricter pause overrides the current one
+func (p *clientPause) pause(timeout time.Duration, all bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	end := time.Now().Add(timeout)
+	if !p.isPaused() {
+		p.end = end
+		p.all = all
+		p.unpaused = make(chan struct{})
+		return
+	}
+	if end.After(p.end) {
+		p.end = end
+	}
+	p.all = p.all || all
+}
+
+// unpause resumes all paused clients
+func (p *clientPause) unpause() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unpaused != nil {
+		close(p.unpaused)
+		p.unpaused = nil
+	}
+	p.end = time.Time{}
+}
+
+// isPaused returns whether clients are paused, caller should hold lock
+func (p *clientPause) isPaused() bool {
+	return !p.end.IsZero() && time.Now().Before(p.end)
+}
+
+// wait blocks until the command could be executed
+func (p *clientPause) wait(client *connection.Connection, cmdName string) {
+	if client.GetUser() == "" || cmdName == "client" {
+		return
+	}
+	for {
+		p.mu.Lock()
+		if !p.isPaused() || (!p.all && !acl.IsWriteCommand(cmdName)) {
+			p.mu.Unlock()
+			return
+		}
+		unpaused := p.unpaused
+		remaining := time.Until(p.end)
+		p.mu.Unlock()
+
+		timer := time.NewTimer(remaining)
+		select {
+		case <-unpaused:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// parsePauseMode parses the mode of CLIENT PAUSE, returns whether all commands are paused
+func parsePauseMode(mode string) (bool, bool) {
+	switch strings.ToLower(mode) {
+	case "all":
+		return true, true
+	case "write":
+		return false, true
+	}
+	return false, false
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+)
+
+var pongBytes = []byte("pong")
+
+// execPubSub executes SUBSCRIBE, UNSUBSCRIBE and PUBLISH
+// SUBSCRIBE、PUBLISH 检查 ACL 用户的频道权限，节点之间的连接不检查
+func (h *RespHandler) execPubSub(client *connection.Connection, cmdName string, args [][]byte) resp.Reply {
+	switch cmdName {
+	case "subscribe":
+		if len(args) < 2 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		channels := toStrings(args[1:])
+		for _, channel := range channels {
+			if denied := h.checkChannel(client, channel); denied != nil {
+				return denied
+			}
+		}
+		return h.hub.Subscribe(client, channels)
+	case "unsubscribe":
+		return h.hub.UnSubscribe(client, toStrings(args[1:]))
+	case "publish":
+		if len(args) != 3 {
+			return reply.MakeArgNumErrReply(cmdName)
+		}
+		channel := string(args[1])
+		if denied := h.checkChannel(client, channel); denied != nil {
+			return denied
+		}
+		return reply.MakeIntReply(int64(h.hub.Publish(channel, reply.MakeBulkReply(args[2]))))
+	}
+	return reply.MakeErrReply("ERR unknown command '" + cmdName + "'")
+}
+
+func (h *RespHandler) checkChannel(client *connection.Connection, channel string) resp.Reply {
+	if client.GetUser() == "" {
+		return nil
+	}
+	return h.acl.CheckChannel(client, channel)
+}
+
+// isSubscribeModeCommand returns whether the command could be executed by RESP2 connection which has subscribed channels
+func isSubscribeModeCommand(cmdName string) bool {
+	switch cmdName {
+	case "subscribe", "unsubscribe", "psubscribe", "punsubscribe", "ssubscribe", "sunsubscribe", "ping", "quit", "reset":
+		return true
+	}
+	return false
+}
+
+// execSubscribedPing replies PING of RESP2 connection which has subscribed channels
+// 订阅模式下 PING 回复数组 [pong, message]
+func execSubscribedPing(args [][]byte) resp.Reply {
+	if len(args) > 2 {
+		return reply.MakeArgNumErrReply("ping")
+	}
+	message := []byte("")
+	if len(args) == 2 {
+		message = args[1]
+	}
+	return reply.MakeMultiBulkReply([][]byte{pongBytes, message})
+}
+
+func toStrings(args [][]byte) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = string(arg)
+	}
+	return result
+}
@@ -0,0 +1,257 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"go-redis/config"
+	"go-redis/lib/tlsutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// certAuthority is a self-signed CA generated for tests
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string // CA 证书的 PEM 文件
+}
+
+var serialNumber int64
+
+func newCertTemplate(cn string) *x509.Certificate {
+	serialNumber++
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serialNumber),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func writePEM(t *testing.T, file string, blockType string, der []byte) {
+	data := pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// makeCA generates a self-signed CA and writes its certificate into dir
+func makeCA(t *testing.T, dir string, name string) *certAuthority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := newCertTemplate(name)
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, name+".crt")
+	writePEM(t, file, "CERTIFICATE", der)
+	return &certAuthority{cert: cert, key: key, file: file}
+}
+
+// issue generates a certificate for 127.0.0.1 signed by ca, returns the certificate file and key file
+func (ca *certAuthority) issue(t *testing.T, dir string, name string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := newCertTemplate(name)
+	template.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+// startTLSServer serves h on a tls listener with the given config
+func startTLSServer(t *testing.T, h *RespHandler, tlsConfig *tls.Config) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	listener = tls.NewListener(listener, tlsConfig)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go h.Handle(context.Background(), conn)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// dialTLS connects to server with client certificate, certFile and keyFile are optional
+func dialTLS(t *testing.T, addr string, caFile string, certFile string, keyFile string) (*testClient, error) {
+	clientConfig, err := tlsutil.ClientConfig(certFile, keyFile, caFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: readTimeout}, "tcp", addr, clientConfig)
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func TestTLSClientCertUser(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCA(t, dir, "ca")
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	aliceCert, aliceKey := ca.issue(t, dir, "alice")
+	bobCert, bobKey := ca.issue(t, dir, "bob")
+
+	user := config.Properties.TLSAuthClientsUser
+	config.Properties.TLSAuthClientsUser = "CN"
+	defer func() { config.Properties.TLSAuthClientsUser = user }()
+	setConfig(t, "requirepass", "secret")
+	h, addr := startTestServer(t)
+	c := dial(t, addr)
+	c.do("AUTH", "secret")
+	if got := c.do("ACL", "SETUSER", "alice", "on", "nopass", "allkeys", "+@all"); got != "+OK\r\n" {
+		t.Fatalf("setuser failed: %q", got)
+	}
+
+	reloadable, err := tlsutil.NewReloadableConfig(serverCert, serverKey, ca.file, tls.VerifyClientCertIfGiven)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsAddr := startTLSServer(t, h, reloadable.Config())
+
+	tests := []struct {
+		name     string
+		certFile string
+		keyFile  string
+		reply    string
+	}{
+		{name: "certificate of acl user", certFile: aliceCert, keyFile: aliceKey, reply: "$5\r\nalice\r\n"},
+		{name: "not an acl user", certFile: bobCert, keyFile: bobKey, reply: "-NOAUTH Authentication required.\r\n"},
+		{name: "no certificate", reply: "-NOAUTH Authentication required.\r\n"},
+	}
+	for _, tt := range tests {
+		client, err := dialTLS(t, tlsAddr, ca.file, tt.certFile, tt.keyFile)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := client.do("ACL", "WHOAMI"); got != tt.reply {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.reply)
+		}
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := makeCA(t, dir, "ca")
+	otherCA := makeCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, dir, "server")
+	clientCert, clientKey := ca.issue(t, dir, "client")
+
+	if _, err := tlsutil.NewReloadableConfig(serverCert, serverKey, "", tls.RequireAndVerifyClientCert); err == nil {
+		t.Error("requiring client certificates without CA should fail")
+	}
+	reloadable, err := tlsutil.NewReloadableConfig(serverCert, serverKey, ca.file, tls.RequireAndVerifyClientCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, _ := startTestServer(t)
+	addr := startTLSServer(t, h, reloadable.Config())
+
+	// tls-auth-clients yes 时必须提供证书
+	if client, err := dialTLS(t, addr, ca.file, "", ""); err == nil {
+		client.send(encodeCommand("PING"))
+		if _, err = readRawReply(client.reader); err == nil {
+			t.Error("connection without client certificate should be refused")
+		}
+	}
+	client, err := dialTLS(t, addr, ca.file, clientCert, clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := client.do("PING"); got != "+PONG\r\n" {
+		t.Fatalf("got %q", got)
+	}
+
+	// 替换证书文件后重新加载，新的连接使用新证书，已经建立的连接不受影响
+	newCert, newKey := otherCA.issue(t, dir, "server")
+	if newCert != serverCert || newKey != serverKey {
+		t.Fatal("certificate should be written to the same file")
+	}
+	if err = reloadable.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dialTLS(t, addr, ca.file, clientCert, clientKey); err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Errorf("server certificate should be replaced, got %v", err)
+	}
+	if _, err = dialTLS(t, addr, otherCA.file, clientCert, clientKey); err != nil {
+		t.Errorf("dial with new CA failed: %v", err)
+	}
+	if got := client.do("PING"); got != "+PONG\r\n" {
+		t.Errorf("existing connection should not be affected, got %q", got)
+	}
+
+	// 重新加载失败时保留原来的证书
+	if err = os.WriteFile(serverCert, []byte("invalid"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = reloadable.Reload(); err == nil {
+		t.Error("reload invalid certificate should fail")
+	}
+	if _, err = dialTLS(t, addr, otherCA.file, clientCert, clientKey); err != nil {
+		t.Errorf("certificate should be kept after failed reload: %v", err)
+	}
+}
+
+func TestParseClientAuth(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected tls.ClientAuthType
+		wantErr  bool
+	}{
+		{value: "", expected: tls.RequireAndVerifyClientCert},
+		{value: "YES", expected: tls.RequireAndVerifyClientCert},
+		{value: "optional", expected: tls.VerifyClientCertIfGiven},
+		{value: "no", expected: tls.NoClientCert},
+		{value: "maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := tlsutil.ParseClientAuth(tt.value)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.expected) {
+			t.Errorf("%q: got %v, %v", tt.value, got, err)
+		}
+	}
+}
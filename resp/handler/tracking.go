@@ -0,0 +1,497 @@
+package handler
+
+import (
+	"go-redis/acl"
+	"go-redis/config"
+	"go-redis/database"
+	"go-redis/interface/resp"
+	"go-redis/resp/connection"
+	"go-redis/resp/reply"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+/*
+客户端缓存(client side caching)
+CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+	默认模式  记录连接读过的 key，key 被修改时通知一次后删除记录，再次读取后重新记录
+	BCAST     不记录读过的 key，任何 key 被修改时都通知前缀匹配的连接，不指定前缀时匹配所有 key
+	OPTIN     只记录 CLIENT CACHING yes 之后下一条命令读取的 key
+	OPTOUT    不记录 CLIENT CACHING no 之后下一条命令读取的 key
+	NOLOOP    不通知连接自己修改的 key
+失效通知：
+	RESP3 连接收到推送数据 >2 invalidate [key ...]
+	REDIRECT 时通知发送给另一个连接，RESP2 连接需要订阅 __redis__:invalidate 频道；重定向的连接关闭后，RESP3 连接收到 tracking-redir-broken
+	FLUSHDB、FLUSHALL 之后通知的 key 为 null，表示所有 key 都失效
+	默认模式记录的 key 数超过 tracking-table-max-keys 时淘汰部分 key，并向读过这些 key 的连接发送失效通知
+	关闭 tracking 或者连接关闭时删除该连接记录的 key；没有连接开启 tracking 时跳过命令的 key 解析
+只有通过本节点执行的写命令会通知，集群 proxy 模式下其他节点直接修改的 key 不会通知
+*/
+
+// invalidateChannel is the channel which receives invalidation messages of REDIRECT
+const invalidateChannel = "__redis__:invalidate"
+
+const defaultTrackingTableMaxKeys = 1000000
+
+var (
+	invalidateBytes  = []byte("invalidate")
+	redirBrokenBytes = []byte("tracking-redir-broken")
+)
+
+// values of CLIENT CACHING for the next command
+const (
+	cachingUnset = iota
+	cachingYes
+	cachingNo
+)
+
+// trackingState is the tracking options of a connection
+type trackingState struct {
+	redirect int64    // 接收失效通知的连接 id，0 表示连接自身
+	bcast    bool     // 广播模式
+	prefixes []string // 广播模式下关注的 key 前缀
+	optIn    bool
+	optOut   bool
+	noLoop   bool
+	caching  int // CLIENT CACHING 对下一条命令的设置
+}
+
+// isTrackingRead returns whether the keys read by the next command should be tracked
+func (s *trackingState) isTrackingRead() bool {
+	if s.bcast {
+		return false
+	}
+	if s.optIn {
+		return s.caching == cachingYes
+	}
+	if s.optOut {
+		return s.caching != cachingNo
+	}
+	return true
+}
+
+// matchPrefix returns the keys which match the prefixes of bcast mode
+func (s *trackingState) matchPrefix(keys []string) []string {
+	if len(s.prefixes) == 0 {
+		return keys
+	}
+	matched := make([]string, 0, len(keys))
+	for _, key := range keys {
+		for _, prefix := range s.prefixes {
+			if strings.HasPrefix(key, prefix) {
+				matched = append(matched, key)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// tracking stores the tracking connections and the keys read by them
+type tracking struct {
+	mu      sync.Mutex
+	clients map[int64]*trackingState      // 开启了 tracking 的连接
+	keys    map[string]map[int64]struct{} // key -> 读过该 key 的连接 id(默认模式)
+	owned   map[int64]map[string]struct{} // 连接 id -> 该连接记录的 key，关闭 tracking 时删除
+	enabled atomic.Int64                  // 开启了 tracking 的连接数，不加锁读取
+}
+
+func makeTracking() *tracking {
+	return &tracking{
+		clients: make(map[int64]*trackingState),
+		keys:    make(map[string]map[int64]struct{}),
+		owned:   make(map[int64]map[string]struct{}),
+	}
+}
+
+// getTrackingTableMaxKeys returns the max number of keys recorded in default mode
+func getTrackingTableMaxKeys() int {
+	maxKeys := config.Load().TrackingTableMaxKeys
+	if maxKeys <= 0 {
+		return defaultTrackingTableMaxKeys
+	}
+	return maxKeys
+}
+
+// isActive returns whether any connection has turned on tracking
+func (t *tracking) isActive() bool {
+	return t.enabled.Load() > 0
+}
+
+// keysCount returns the number of keys recorded in default mode
+func (t *tracking) keysCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.keys)
+}
+
+// invalidation is a message to be sent after releasing lock
+type invalidation struct {
+	client int64    // 开启 tracking 的连接
+	keys   []string // 为 nil 时表示所有 key 失效
+}
+
+func (t *tracking) getState(id int64) *trackingState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.clients[id]
+}
+
+// enable turns on tracking of connection, the options override the previous ones
+func (t *tracking) enable(id int64, state *trackingState) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[id]; !ok {
+		t.enabled.Add(1)
+	}
+	t.clients[id] = state
+	// 切换到广播模式后不再需要默认模式记录的 key
+	if state.bcast {
+		t.forget(id)
+	}
+}
+
+// disable turns off tracking of connection, and removes the keys recorded for it
+func (t *tracking) disable(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.clients[id]; !ok {
+		return
+	}
+	delete(t.clients, id)
+	t.enabled.Add(-1)
+	t.forget(id)
+}
+
+// forget removes the keys recorded for connection, caller should hold lock
+func (t *tracking) forget(id int64) {
+	for key := range t.owned[id] {
+		ids := t.keys[key]
+		delete(ids, id)
+		if len(ids) == 0 {
+			delete(t.keys, key)
+		}
+	}
+	delete(t.owned, id)
+}
+
+// removeKey removes the record of key, returns the connections which have read it, caller should hold lock
+func (t *tracking) removeKey(key string) map[int64]struct{} {
+	ids, ok := t.keys[key]
+	if !ok {
+		return nil
+	}
+	delete(t.keys, key)
+	for id := range ids {
+		if keys := t.owned[id]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(t.owned, id)
+			}
+		}
+	}
+	return ids
+}
+
+// setCaching sets CLIENT CACHING for the next command
+func (t *tracking) setCaching(id int64, caching int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if state, ok := t.clients[id]; ok {
+		state.caching = caching
+	}
+}
+
+// afterRead records the keys read by connection and resets CLIENT CACHING
+// 记录的 key 超过 tracking-table-max-keys 时淘汰其他 key，返回需要发送的失效通知
+func (t *tracking) afterRead(id int64, keys []string) []*invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state, ok := t.clients[id]
+	if !ok {
+		return nil
+	}
+	// CLIENT CACHING 只对下一条命令生效，先判断再重置
+	tracked := state.isTrackingRead()
+	state.caching = cachingUnset
+	if !tracked || len(keys) == 0 {
+		return nil
+	}
+	owned, ok := t.owned[id]
+	if !ok {
+		owned = make(map[string]struct{})
+		t.owned[id] = owned
+	}
+	for _, key := range keys {
+		ids, ok := t.keys[key]
+		if !ok {
+			ids = make(map[int64]struct{})
+			t.keys[key] = ids
+		}
+		ids[id] = struct{}{}
+		owned[key] = struct{}{}
+	}
+	return t.evict(keys)
+}
+
+// evict removes keys until the table does not exceed tracking-table-max-keys, caller should hold lock
+// map 的遍历顺序是随机的，相当于随机淘汰；刚读取的 key 不会被淘汰
+func (t *tracking) evict(reading []string) []*invalidation {
+	excess := len(t.keys) - getTrackingTableMaxKeys()
+	if excess <= 0 {
+		return nil
+	}
+	keep := make(map[string]struct{}, len(reading))
+	for _, key := range reading {
+		keep[key] = struct{}{}
+	}
+	var messages []*invalidation
+	for key := range t.keys {
+		if excess <= 0 {
+			break
+		}
+		if _, ok := keep[key]; ok {
+			continue
+		}
+		for id := range t.removeKey(key) {
+			messages = append(messages, &invalidation{client: id, keys: []string{key}})
+		}
+		excess--
+	}
+	return messages
+}
+
+// invalidate collects the invalidation messages of modified keys, keys is nil if all keys are flushed
+func (t *tracking) invalidate(writer int64, keys []string) []*invalidation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	messages := make([]*invalidation, 0)
+	if keys == nil {
+		// FLUSHDB、FLUSHALL 通知所有开启 tracking 的连接
+		t.keys = make(map[string]map[int64]struct{})
+		t.owned = make(map[int64]map[string]struct{})
+		for id := range t.clients {
+			messages = append(messages, &invalidation{client: id})
+		}
+		return messages
+	}
+	// 默认模式：通知读过该 key 的连接，通知后删除记录
+	for _, key := range keys {
+		for id := range t.removeKey(key) {
+			state, ok := t.clients[id]
+			if !ok || state.bcast || (state.noLoop && id == writer) {
+				continue
+			}
+			messages = append(messages, &invalidation{client: id, keys: []string{key}})
+		}
+	}
+	// 广播模式：通知前缀匹配的连接
+	for id, state := range t.clients {
+		if !state.bcast || (state.noLoop && id == writer) {
+			continue
+		}
+		if matched := state.matchPrefix(keys); len(matched) > 0 {
+			messages = append(messages, &invalidation{client: id, keys: matched})
+		}
+	}
+	return messages
+}
+
+// getClient returns the active connection of id
+func (h *RespHandler) getClient(id int64) *connection.Connection {
+	if c, ok := h.clientIDs.Load(id); ok {
+		return c.(*connection.Connection)
+	}
+	return nil
+}
+
+// trackAfterExec sends invalidation messages after write commands, and records keys after read commands
+func (h *RespHandler) trackAfterExec(client *connection.Connection, cmdName string, args [][]byte, result resp.Reply) {
+	// 没有连接开启 tracking 时不需要记录和通知，也不需要解析命令的 key
+	if !h.tracking.isActive() {
+		return
+	}
+	if cmdName == "exec-local" && len(args) > 1 {
+		// 其他节点转发的命令
+		args = args[1:]
+		cmdName = strings.ToLower(string(args[0]))
+	}
+	if acl.IsWriteCommand(cmdName) {
+		if result == nil || reply.IsErrorReply(result) {
+			return
+		}
+		var keys []string
+		if cmdName == "flushdb" || cmdName == "flushall" {
+			keys = nil
+		} else {
+			keys, _ = database.GetRelatedKeys(args)
+			if len(keys) == 0 {
+				return
+			}
+		}
+		h.sendInvalidations(h.tracking.invalidate(client.GetID(), keys))
+		return
+	}
+	_, readKeys := database.GetRelatedKeys(args)
+	h.sendInvalidations(h.tracking.afterRead(client.GetID(), readKeys))
+}
+
+// sendInvalidations sends invalidation messages to tracking connections or their redirect connections
+func (h *RespHandler) sendInvalidations(messages []*invalidation) {
+	for _, msg := range messages {
+		state := h.tracking.getState(msg.client)
+		if state == nil {
+			continue
+		}
+		var keysReply resp.Reply = reply.MakeNullBulkReply()
+		if msg.keys != nil {
+			keysReply = reply.MakeMultiBulkReply(toBytes(msg.keys))
+		}
+		target := h.getClient(msg.client)
+		if state.redirect != 0 {
+			redirect := h.getClient(state.redirect)
+			if redirect == nil {
+				// 重定向的连接已经关闭
+				if target != nil && target.GetProtocol() == reply.RESP3 {
+					_ = target.Write(reply.MakePushReply([]resp.Reply{
+						reply.MakeBulkReply(redirBrokenBytes),
+						reply.MakeIntReply(state.redirect),
+					}).ToRESP3())
+				}
+				continue
+			}
+			target = redirect
+		}
+		if target == nil {
+			continue
+		}
+		if target.GetProtocol() == reply.RESP3 {
+			_ = target.Write(reply.MakePushReply([]resp.Reply{
+				reply.MakeBulkReply(invalidateBytes),
+				keysReply,
+			}).ToRESP3())
+		} else if h.hub.IsSubscribed(target, invalidateChannel) {
+			_ = target.Write(reply.MakePushReply([]resp.Reply{
+				reply.MakeBulkReply([]byte("message")),
+				reply.MakeBulkReply([]byte(invalidateChannel)),
+				keysReply,
+			}).ToBytes())
+		}
+	}
+}
+
+func toBytes(keys []string) [][]byte {
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = []byte(key)
+	}
+	return result
+}
+
+// execClientTracking turns on or off tracking
+// CLIENT TRACKING ON|OFF [REDIRECT client-id] [PREFIX prefix ...] [BCAST] [OPTIN] [OPTOUT] [NOLOOP]
+func (h *RespHandler) execClientTracking(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) < 3 {
+		return reply.MakeArgNumErrReply("client|tracking")
+	}
+	state := &trackingState{}
+	for i := 3; i < len(args); i++ {
+		option := strings.ToLower(string(args[i]))
+		switch {
+		case option == "redirect" && i+1 < len(args):
+			id, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return reply.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			state.redirect = id
+			i++
+		case option == "prefix" && i+1 < len(args):
+			state.prefixes = append(state.prefixes, string(args[i+1]))
+			i++
+		case option == "bcast":
+			state.bcast = true
+		case option == "optin":
+			state.optIn = true
+		case option == "optout":
+			state.optOut = true
+		case option == "noloop":
+			state.noLoop = true
+		default:
+			return reply.MakeSyntaxErrReply()
+		}
+	}
+
+	switch strings.ToLower(string(args[2])) {
+	case "off":
+		h.tracking.disable(client.GetID())
+		return reply.MakeOkReply()
+	case "on":
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	if len(state.prefixes) > 0 && !state.bcast {
+		return reply.MakeErrReply("ERR PREFIX option requires BCAST mode to be enabled")
+	}
+	if state.optIn && state.optOut {
+		return reply.MakeErrReply("ERR You can't use both OPTIN and OPTOUT")
+	}
+	if state.bcast && (state.optIn || state.optOut) {
+		return reply.MakeErrReply("ERR OPTIN and OPTOUT are not compatible with BCAST")
+	}
+	if state.redirect != 0 && state.redirect != client.GetID() && h.getClient(state.redirect) == nil {
+		return reply.MakeErrReply("ERR The client ID you want redirect to does not exist")
+	}
+	if state.redirect == client.GetID() {
+		state.redirect = 0
+	}
+	// 广播模式关注的 key 前缀需要有访问权限，不指定前缀时需要能访问所有 key
+	if client.GetUser() != "" && state.bcast {
+		prefixes := state.prefixes
+		if len(prefixes) == 0 {
+			prefixes = []string{""}
+		}
+		for _, prefix := range prefixes {
+			if denied := h.acl.CheckKeyPrefix(client, prefix); denied != nil {
+				return denied
+			}
+		}
+	}
+	h.tracking.enable(client.GetID(), state)
+	return reply.MakeOkReply()
+}
+
+// execClientCaching sets whether the keys read by the next command are tracked
+// CLIENT CACHING YES|NO
+func (h *RespHandler) execClientCaching(client *connection.Connection, args [][]byte) resp.Reply {
+	if len(args) != 3 {
+		return reply.MakeArgNumErrReply("client|caching")
+	}
+	state := h.tracking.getState(client.GetID())
+	switch strings.ToLower(string(args[2])) {
+	case "yes":
+		if state == nil || !state.optIn {
+			return reply.MakeErrReply("ERR CLIENT CACHING YES is only valid when tracking is enabled in OPTIN mode.")
+		}
+		h.tracking.setCaching(client.GetID(), cachingYes)
+	case "no":
+		if state == nil || !state.optOut {
+			return reply.MakeErrReply("ERR CLIENT CACHING NO is only valid when tracking is enabled in OPTOUT mode.")
+		}
+		h.tracking.setCaching(client.GetID(), cachingNo)
+	default:
+		return reply.MakeSyntaxErrReply()
+	}
+	return reply.MakeOkReply()
+}
+
+// getTrackingRedirect returns the redirect of CLIENT GETREDIR and CLIENT LIST
+// -1 表示没有开启 tracking，0 表示没有重定向
+func (h *RespHandler) getTrackingRedirect(client *connection.Connection) int64 {
+	state := h.tracking.getState(client.GetID())
+	if state == nil {
+		return -1
+	}
+	return state.redirect
+}
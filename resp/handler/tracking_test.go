@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// invalidatePush 返回 RESP3 下的失效推送，keys 为 nil 时表示全部失效
+func invalidatePush(keys ...string) string {
+	return ">2\r\n$10\r\ninvalidate\r\n" + keysRaw(keys)
+}
+
+func keysRaw(keys []string) string {
+	if keys == nil {
+		return "_\r\n"
+	}
+	raw := "*" + strconv.Itoa(len(keys)) + "\r\n"
+	for _, key := range keys {
+		raw += "$" + strconv.Itoa(len(key)) + "\r\n" + key + "\r\n"
+	}
+	return raw
+}
+
+// expectNoPush 通过 PING 确认连接上没有待读取的推送
+func (c *testClient) expectNoPush() {
+	c.t.Helper()
+	if got := c.do("PING"); got != "+PONG\r\n" {
+		c.t.Fatalf("expect no push, got %q", got)
+	}
+}
+
+func (c *testClient) expectPush(want string) {
+	c.t.Helper()
+	if got := c.read(); got != want {
+		c.t.Fatalf("got push %q, want %q", got, want)
+	}
+}
+
+// dialTracking 建立 RESP3 连接并以给定的参数开启 tracking
+func dialTracking(t *testing.T, addr string, options ...string) *testClient {
+	c := dial(t, addr)
+	c.do("HELLO", "3")
+	args := append([]string{"CLIENT", "TRACKING", "ON"}, options...)
+	if got := c.do(args...); got != "+OK\r\n" {
+		t.Fatalf("%v: %q", args, got)
+	}
+	return c
+}
+
+func TestTrackingDefault(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTracking(t, addr)
+	writer := dial(t, addr)
+
+	c.do("GET", "k")
+	writer.do("SET", "k", "v")
+	c.expectPush(invalidatePush("k"))
+	// 通知之后不再跟踪，直到再次读取
+	writer.do("SET", "k", "v2")
+	c.expectNoPush()
+
+	c.do("MGET", "a", "b")
+	writer.do("DEL", "a", "b")
+	// 每个 key 单独通知
+	c.expectPush(invalidatePush("a"))
+	c.expectPush(invalidatePush("b"))
+
+	// 默认模式下自己的写入同样会收到通知
+	c.do("GET", "k")
+	if got := c.do("SET", "k", "v3"); got != invalidatePush("k") {
+		t.Fatalf("self write should be notified before reply, got %q", got)
+	}
+	c.expectPush("+OK\r\n")
+
+	c.do("GET", "k")
+	writer.do("FLUSHDB")
+	c.expectPush(invalidatePush())
+
+	// 关闭 tracking 后不再通知
+	c.do("GET", "k")
+	if got := c.do("CLIENT", "TRACKING", "OFF"); got != "+OK\r\n" {
+		t.Fatalf("CLIENT TRACKING OFF: %q", got)
+	}
+	writer.do("SET", "k", "v")
+	c.expectNoPush()
+}
+
+func TestTrackingNoLoop(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTracking(t, addr, "NOLOOP")
+	writer := dial(t, addr)
+
+	c.do("GET", "k")
+	if got := c.do("SET", "k", "v"); got != "+OK\r\n" {
+		t.Fatalf("NOLOOP should skip self write, got %q", got)
+	}
+	c.expectNoPush()
+	// 自己的写入同样会删除记录，需要重新读取
+	c.do("GET", "k")
+	writer.do("SET", "k", "v2")
+	c.expectPush(invalidatePush("k"))
+}
+
+func TestTrackingBroadcast(t *testing.T) {
+	_, addr := startTestServer(t)
+	c := dialTracking(t, addr, "BCAST", "PREFIX", "user:", "PREFIX", "order:")
+	writer := dial(t, addr)
+
+	// 广播模式不需要先读取
+	writer.do("SET", "user:1", "a")
+	c.expectPush(invalidatePush("user:1"))
+	writer.do("SET", "other", "a")
+	c.expectNoPush()
+	writer.do("MSET", "order:1", "a", "other", "b")
+	c.expectPush(invalidatePush("order:1"))
+
+	if got := c.do("CLIENT", "TRACKING", "ON", "PREFIX", "x"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("PREFIX without BCAST should fail, got %q", got)
+	}
+}
+
+func TestTrackingOptInOptOut(t *testing.T) {
+	_, addr := startTestServer(t)
+	writer := dial(t, addr)
+
+	in := dialTracking(t, addr, "OPTIN")
+	in.do("GET", "a")
+	writer.do("SET", "a", "1")
+	in.expectNoPush()
+	if got := in.do("CLIENT", "CACHING", "yes"); got != "+OK\r\n" {
+		t.Fatalf("CLIENT CACHING yes: %q", got)
+	}
+	in.do("GET", "a")
+	writer.do("SET", "a", "2")
+	in.expectPush(invalidatePush("a"))
+	// CLIENT CACHING 只对下一条命令生效
+	in.do("CLIENT", "CACHING", "yes")
+	in.do("PING")
+	in.do("GET", "a")
+	writer.do("SET", "a", "3")
+	in.expectNoPush()
+
+	out := dialTracking(t, addr, "OPTOUT")
+	out.do("CLIENT", "CACHING", "no")
+	out.do("GET", "b")
+	writer.do("SET", "b", "1")
+	out.expectNoPush()
+	out.do("GET", "b")
+	writer.do("SET", "b", "2")
+	out.expectPush(invalidatePush("b"))
+
+	if got := out.do("CLIENT", "CACHING", "yes"); !strings.HasPrefix(got, "-ERR") {
+		t.Errorf("CLIENT CACHING yes in OPTOUT mode should fail, got %q", got)
+	}
+}
+
+func TestTrackingRedirect(t *testing.T) {
+	_, addr := startTestServer(t)
+	writer := dial(t, addr)
+	sub := dial(t, addr)
+	id := strings.TrimSuffix(strings.TrimPrefix(sub.do("CLIENT", "ID"), ":"), "\r\n")
+	sub.do("SUBSCRIBE", "__redis__:invalidate")
+
+	c := dialTracking(t, addr, "REDIRECT", id)
+	c.do("GET", "k")
+	writer.do("SET", "k", "v")
+	want := "*3\r\n$7\r\nmessage\r\n$20\r\n__redis__:invalidate\r\n" + keysRaw([]string{"k"})
+	if got := sub.read(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	c.expectNoPush()
+
+	// 重定向的连接关闭后通知原连接
+	_ = sub.conn.Close()
+	c.do("GET", "k")
+	deadline := time.Now().Add(readTimeout)
+	for strings.Contains(writer.do("CLIENT", "LIST"), "id="+id+" ") {
+		if time.Now().After(deadline) {
+			t.Fatal("redirect connection is not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	writer.do("SET", "k", "v")
+	want = ">2\r\n$21\r\ntracking-redir-broken\r\n:" + id + "\r\n"
+	if got := c.read(); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTrackingTableMaxKeys(t *testing.T) {
+	setConfig(t, "tracking-table-max-keys", "2")
+	_, addr := startTestServer(t)
+	c := dialTracking(t, addr)
+
+	c.do("GET", "a")
+	c.do("GET", "b")
+	// 超过上限时淘汰 key 并通知客户端
+	if got := c.do("GET", "c"); !strings.HasPrefix(got, ">2\r\n$10\r\ninvalidate\r\n*1\r\n") {
+		t.Fatalf("expect eviction push, got %q", got)
+	}
+	if got := c.read(); got != "_\r\n" {
+		t.Fatalf("GET reply: %q", got)
+	}
+}
@@ -0,0 +1,146 @@
+package parser
+
+import (
+	"errors"
+)
+
+/*
+inline 命令
+telnet、nc 等工具直接发送以空格分隔的文本命令，如 SET key "hello world"\r\n
+参数的拆分规则与 redis-cli 的 sdssplitargs 相同：
+	双引号中支持转义字符 \n \r \t \b \a \\ \" 以及十六进制 \xHH
+	单引号中只支持转义 \'
+	引号结束后必须是空白字符或行尾
+*/
+
+// maxInlineSize is the max length of inline command and the header lines of multi bulk
+// 超过该长度仍没有读到行尾时认为是非法请求，关闭连接，避免无限制地占用内存
+const maxInlineSize = 64 * 1024
+
+var (
+	errTooBigInline     = errors.New("protocol error: too big inline request")
+	errUnbalancedQuotes = errors.New("protocol error: unbalanced quotes in request")
+)
+
+// isProtocolHeader returns whether the line starts with a type prefix of RESP
+// 不以类型前缀开头的行是 inline 命令
+func isProtocolHeader(msg []byte) bool {
+	switch msg[0] {
+	case '*', '$', '+', '-', ':':
+		return true
+	}
+	return isRESP3Header(msg)
+}
+
+// parseInline splits inline command into arguments
+// line 不包含行尾的 \r\n
+func parseInline(line []byte) ([][]byte, error) {
+	args := make([][]byte, 0)
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+		var arg []byte
+		inDoubleQuotes, inSingleQuotes := false, false
+		for done := false; !done; {
+			if inDoubleQuotes {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					arg = append(arg, hexDigitToInt(line[i+2])*16+hexDigitToInt(line[i+3]))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						c = '\n'
+					case 'r':
+						c = '\r'
+					case 't':
+						c = '\t'
+					case 'b':
+						c = '\b'
+					case 'a':
+						c = '\a'
+					default:
+						c = line[i]
+					}
+					arg = append(arg, c)
+				} else if c == '"' {
+					// 结束的引号之后必须是空白字符或行尾
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			} else if inSingleQuotes {
+				if i == len(line) {
+					return nil, errUnbalancedQuotes
+				}
+				c := line[i]
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					arg = append(arg, '\'')
+					i++
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			} else {
+				if i == len(line) {
+					break
+				}
+				switch c := line[i]; {
+				case isSpace(c):
+					done = true
+				case c == '"':
+					inDoubleQuotes = true
+				case c == '\'':
+					inSingleQuotes = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		if arg == nil {
+			arg = []byte{} // "" 是一个空字符串参数
+		}
+		args = append(args, arg)
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\n', '\r', '\t', '\v', '\f':
+		return true
+	}
+	return false
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func hexDigitToInt(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
@@ -0,0 +1,87 @@
+package parser
+
+import (
+	"go-redis/resp/reply"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseInline(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+		err  error
+	}{
+		{line: "set key value", args: []string{"set", "key", "value"}},
+		{line: "  get\t key  ", args: []string{"get", "key"}},
+		{line: "", args: []string{}},
+		{line: `set key "hello world"`, args: []string{"set", "key", "hello world"}},
+		{line: `set key ""`, args: []string{"set", "key", ""}},
+		{line: `set key "a\nb\r\t\"c\\"`, args: []string{"set", "key", "a\nb\r\t\"c\\"}},
+		{line: `set key "\x41\x4a\x7a"`, args: []string{"set", "key", "AJz"}},
+		{line: `set key "\x4"`, args: []string{"set", "key", "x4"}},
+		{line: `set key 'it\'s "raw" \n'`, args: []string{"set", "key", `it's "raw" \n`}},
+		{line: `set k"ey" v`, args: []string{"set", "key", "v"}},
+		{line: `set key "value`, err: errUnbalancedQuotes},
+		{line: `set key 'value`, err: errUnbalancedQuotes},
+		{line: `set key "value"x`, err: errUnbalancedQuotes},
+		{line: `set key 'value'x`, err: errUnbalancedQuotes},
+	}
+	for _, tt := range tests {
+		args, err := parseInline([]byte(tt.line))
+		if err != tt.err {
+			t.Errorf("%q: error %v, want %v", tt.line, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := make([]string, len(args))
+		for i, arg := range args {
+			got[i] = string(arg)
+		}
+		if !reflect.DeepEqual(got, tt.args) {
+			t.Errorf("%q: got %q, want %q", tt.line, got, tt.args)
+		}
+	}
+}
+
+func TestParseInlineStream(t *testing.T) {
+	input := "PING\r\n\r\nset key \"a b\"\n" + "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" + "get \"key\r\n" + "echo done\r\n"
+	var results []string
+	for payload := range ParseStreamWithLimits(strings.NewReader(input), &Limits{}) {
+		if payload.Err != nil {
+			results = append(results, "error: "+payload.Err.Error())
+			continue
+		}
+		cmd := payload.Data.(*reply.MultiBulkReply)
+		args := make([]string, len(cmd.Args))
+		for i, arg := range cmd.Args {
+			args[i] = string(arg)
+		}
+		results = append(results, strings.Join(args, "|"))
+	}
+	expected := []string{
+		"PING",
+		"set|key|a b",
+		"GET|key",
+		"error: " + errUnbalancedQuotes.Error(),
+		"echo|done",
+		"error: EOF",
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("got %q, want %q", results, expected)
+	}
+}
+
+func TestParseTooBigInline(t *testing.T) {
+	input := strings.Repeat("a", 2*maxInlineSize)
+	var last *Payload
+	for payload := range ParseStreamWithLimits(strings.NewReader(input), &Limits{}) {
+		last = payload
+	}
+	if last == nil || last.Err != errTooBigInline {
+		t.Errorf("expected %v, got %v", errTooBigInline, last)
+	}
+}
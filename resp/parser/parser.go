@@ -7,9 +7,11 @@ import (
 	"go-redis/lib/logger"
 	"go-redis/resp/reply"
 	"io"
+	"math/big"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync/atomic"
 )
 
 // Payload stores redis.Reply or error
@@ -22,12 +24,56 @@ type Payload struct {
 	Err  error
 }
 
+// Limits restricts the requests sent by clients, zero means unlimited
+// 超过限制时解析器返回错误并停止解析，由调用方关闭连接
+type Limits struct {
+	MaxBulkLen     int64 // proto-max-bulk-len，单个字符串的最大长度
+	MaxQueryBuffer int64 // client-query-buffer-limit，一条命令的最大长度
+	// 正在读取的命令已经读取的字节数，用于 CLIENT LIST 的 qbuf
+	queryBuffer atomic.Int64
+}
+
+// QueryBufferSize returns the bytes of the command being read
+func (l *Limits) QueryBufferSize() int64 {
+	return l.queryBuffer.Load()
+}
+
+var (
+	// ErrInvalidBulkLength is returned when the length of bulk string exceeds proto-max-bulk-len
+	ErrInvalidBulkLength = errors.New("ERR Protocol error: invalid bulk length")
+	// ErrInvalidMultiBulkLength is returned when the length of array is invalid
+	ErrInvalidMultiBulkLength = errors.New("ERR Protocol error: invalid multibulk length")
+	// ErrQueryBufferLimit is returned when the command exceeds client-query-buffer-limit
+	ErrQueryBufferLimit = errors.New("ERR Protocol error: query buffer limit exceeded")
+	// ErrExpectedBulk is returned when the array sent by clients contains elements other than bulk string
+	ErrExpectedBulk = errors.New("ERR Protocol error: expected '$'")
+)
+
+const (
+	// maxMultiBulkLen is the max length of array sent by clients, the same as redis
+	maxMultiBulkLen = 1024 * 1024
+	// maxNestingDepth is the max depth of nested aggregate types in replies
+	// 嵌套的数组、map 等递归读取，限制深度避免栈溢出
+	maxNestingDepth = 128
+)
+
+// errTooDeep is returned when aggregate types in reply are nested too deep
+var errTooDeep = errors.New("protocol error: aggregate types nested too deep")
+
 // ParseStream reads data from io.Reader and send payloads through channel
 // 异步解析数据流。实现异步解析，异步返回。
 // 这里的返回值也是一个只读的 channel，读取这个 channel 就可以异步非阻塞的得到解析结果
 func ParseStream(reader io.Reader) <-chan *Payload {
 	ch := make(chan *Payload)
-	go parse0(reader, ch)
+	go parse0(reader, ch, nil)
+	return ch
+}
+
+// ParseStreamWithLimits parses requests from clients, stops parsing if limits are exceeded
+// 用于解析客户端发送的命令，AOF、节点之间的回复不受限制
+func ParseStreamWithLimits(reader io.Reader, limits *Limits) <-chan *Payload {
+	ch := make(chan *Payload)
+	go parse0(reader, ch, limits)
 	return ch
 }
 
@@ -38,6 +84,25 @@ type readState struct {
 	msgType           byte     // 当前读取的消息类型
 	args              [][]byte // 表示已经读取的参数列表。例如 set k v 就有三个，每一个都是 []byte
 	bulkLen           int64    // 正在读取的块数据的长度
+	readingBody       bool     // 已经读取到 $n 头部，下一行是字符串的内容
+	// 数组中除字符串以外的元素(整数、状态、错误、嵌套数组)，下标与 args 对应，字符串元素为 nil
+	// 如 CLUSTER SLOTS、SCAN 的回复
+	elements []resp.Reply
+	size     int64 // 当前数据已经读取的字节数
+}
+
+// count adds the bytes of line to the size of current data
+// 一条命令的所有行(包括嵌套元素的行)累计不能超过 client-query-buffer-limit，超过时返回错误
+func (s *readState) count(line []byte, limits *Limits) error {
+	if limits == nil {
+		return nil
+	}
+	s.size += int64(len(line))
+	limits.queryBuffer.Store(s.size)
+	if limits.MaxQueryBuffer > 0 && s.size > limits.MaxQueryBuffer {
+		return ErrQueryBufferLimit
+	}
+	return nil
 }
 
 // finished 判断解析是否完成
@@ -46,7 +111,7 @@ func (s *readState) finished() bool {
 }
 
 // parse0 解析 tcp 到来的数据
-func parse0(reader io.Reader, ch chan<- *Payload) {
+func parse0(reader io.Reader, ch chan<- *Payload, limits *Limits) {
 	// 这里捕获 panic，避免带崩其他协程
 	defer func() {
 		if err := recover(); err != nil {
@@ -63,7 +128,15 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 	for {
 		// read line
 		var ioErr bool
-		msg, ioErr, err = readLine(bufReader, &state)
+		msg, ioErr, err = readLine(bufReader, &state, limits)
+		if err == nil && limits != nil {
+			if !state.readingMultiLine {
+				state.size = 0 // 新的命令
+			}
+			if err = state.count(msg, limits); err != nil {
+				ioErr = true
+			}
+		}
 		if err != nil {
 			if ioErr { // encounter io err, stop read
 				ch <- &Payload{
@@ -87,12 +160,34 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 		// 根据不同的数据类型，进行数据解析
 		if !state.readingMultiLine {
 			// receive new response
+			// 客户端发送的命令只能是数组或者 inline 命令，与 redis 相同，其他开头的行都按 inline 命令解析
+			if limits != nil && msg[0] != '*' {
+				args, err := parseInline(msg[:len(msg)-2])
+				if err != nil {
+					ch <- &Payload{
+						Err: err,
+					}
+				} else if len(args) > 0 { // 忽略空行
+					ch <- &Payload{
+						Data: reply.MakeMultiBulkReply(args),
+					}
+				}
+				continue
+			}
 			// 如果还没在多行解析模式下，且用户发来的第一个字符是 *
 			// 则表示该数据是多行数据
 			// 则由 parseMultiBulkHeader 解析，并将 state 改为多行解析模式
 			if msg[0] == '*' {
 				// multi bulk reply
 				err = parseMultiBulkHeader(msg, &state)
+				if err == nil && limits != nil && state.expectedArgsCount > maxMultiBulkLen {
+					// 客户端发送的数组过长，停止解析
+					ch <- &Payload{
+						Err: ErrInvalidMultiBulkLength,
+					}
+					close(ch)
+					return
+				}
 				if err != nil {
 					ch <- &Payload{
 						Err: errors.New("protocol error: " + string(msg)),
@@ -131,6 +226,34 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 					state = readState{} // reset state
 					continue
 				}
+			} else if isRESP3Header(msg) {
+				// RESP3 的数据，由 readElement 完整读取
+				result, ioErr, err := readElement(bufReader, msg, &state, limits, 0)
+				if err != nil && ioErr {
+					ch <- &Payload{
+						Err: err,
+					}
+					close(ch)
+					return
+				}
+				ch <- &Payload{
+					Data: result,
+					Err:  err,
+				}
+				continue
+			} else if !isProtocolHeader(msg) {
+				// inline 命令，如 telnet 中输入的 PING
+				args, err := parseInline(msg[:len(msg)-2])
+				if err != nil {
+					ch <- &Payload{
+						Err: err,
+					}
+				} else if len(args) > 0 { // 忽略空行
+					ch <- &Payload{
+						Data: reply.MakeMultiBulkReply(args),
+					}
+				}
+				continue
 			} else {
 				// single line reply
 				// 解析单行数据，不会更改解析器 state 的状态
@@ -147,7 +270,33 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 			// 已经是多行模式了
 			// 这里还是在读取多行数据
 			// 现在每一行就是一个独立的字符串进行处理即可
-			err = readBody(msg, &state)
+			if limits != nil && state.msgType == '*' && !state.readingBody && msg[0] != '$' {
+				// 客户端发送的数组只能包含字符串，不解析嵌套的元素
+				ch <- &Payload{
+					Err: ErrExpectedBulk,
+				}
+				close(ch)
+				return
+			}
+			if state.msgType == '*' && !state.readingBody && isElementHeader(msg) {
+				// 数组中的非字符串元素
+				var element resp.Reply
+				element, ioErr, err = readElement(bufReader, msg, &state, limits, 0)
+				if err != nil {
+					ch <- &Payload{
+						Err: err,
+					}
+					if ioErr {
+						close(ch)
+						return
+					}
+					state = readState{} // reset state
+					continue
+				}
+				state.addElement(element)
+			} else {
+				err = readBody(msg, &state)
+			}
 			if err != nil {
 				ch <- &Payload{
 					Err: errors.New("protocol error: " + string(msg)),
@@ -161,7 +310,9 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 			// 通过 ch 进行数据发送
 			if state.finished() {
 				var result resp.Reply
-				if state.msgType == '*' {
+				if state.msgType == '*' && state.elements != nil {
+					result = state.makeMultiRawReply()
+				} else if state.msgType == '*' {
 					result = reply.MakeMultiBulkReply(state.args)
 				} else if state.msgType == '$' {
 					result = reply.MakeBulkReply(state.args[0]) // 单行字符串，注意传参方式
@@ -171,6 +322,9 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 					Err:  err,
 				}
 				state = readState{}
+				if limits != nil {
+					limits.queryBuffer.Store(0)
+				}
 			}
 		}
 	}
@@ -180,7 +334,7 @@ func parse0(reader io.Reader, ch chan<- *Payload) {
 // 返回读取到的数据，是否遇到 io 错误，以及错误信息
 // 情况1: 没有预设个数，直接按照 \r\n 进行切分
 // 情况2: 之前读到 $ 数字，严格读取字符个数。防止 \r\n 是数据内容的一部分
-func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
+func readLine(bufReader *bufio.Reader, state *readState, limits *Limits) ([]byte, bool, error) {
 	var msg []byte
 	var err error
 	// 如果 bulkLen == 0 则函数一直读取，直到遇到 \n 为止
@@ -188,10 +342,14 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	// *3\r\n$3\r\nSET\r\n$3\r\nKEY\r\n$5\r\nVALUE\r\n
 	// 先读取 *3\r\n, 则返回 *3
 	if state.bulkLen == 0 {
-		msg, err = bufReader.ReadBytes('\n')
+		msg, err = readLimitedLine(bufReader)
 		if err != nil {
 			return nil, true, err
 		}
+		// inline 命令允许只以 \n 结尾，如 nc 发送的命令
+		if !state.readingMultiLine && (len(msg) < 2 || msg[len(msg)-2] != '\r') && !isProtocolHeader(msg) {
+			msg = append(msg[:len(msg)-1], '\r', '\n')
+		}
 		// 如果倒数第二个字符不是 \r 的话，就不是以 \r\n 结尾的，返回协议错误
 		if len(msg) < 2 || msg[len(msg)-2] != '\r' {
 			return nil, false, errors.New("protocol error: " + string(msg))
@@ -201,6 +359,13 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 		// 待读取的字节数确定, 之前已经读到了 $数字
 		// $3 SET\r\n$3\r\nKEY\r\n$5\r\nVALUE\r\n
 		// 已经读到了 $3, 那么需要读到 set\r\n 总共 3+2=5 个字符
+		// 先检查长度再分配内存，避免客户端声明一个巨大的长度耗尽内存
+		if limits != nil && limits.MaxBulkLen > 0 && state.bulkLen > limits.MaxBulkLen {
+			return nil, true, ErrInvalidBulkLength
+		}
+		if limits != nil && limits.MaxQueryBuffer > 0 && state.size+state.bulkLen > limits.MaxQueryBuffer {
+			return nil, true, ErrQueryBufferLimit
+		}
 		msg = make([]byte, state.bulkLen+2)
 		_, err = io.ReadFull(bufReader, msg)
 		if err != nil {
@@ -219,6 +384,24 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	return msg, false, nil
 }
 
+// readLimitedLine reads until \n, returns errTooBigInline if the line is longer than maxInlineSize
+func readLimitedLine(bufReader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		part, err := bufReader.ReadSlice('\n')
+		line = append(line, part...)
+		if err == nil {
+			return line, nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return nil, err
+		}
+		if len(line) > maxInlineSize {
+			return nil, errTooBigInline
+		}
+	}
+}
+
 // parseMultiBulkHeader 解析多行字符串(数组)的首行头部信息
 // 针对 *3\r\n$3\r\nSET\r\n$3\r\nKEY\r\n$5\r\nVALUE\r\n 例子而言
 // 首先由 readLine 读取到 *3\r\n, 接下来会由  parseMultiBulkHeader 进行解析
@@ -246,7 +429,7 @@ func parseMultiBulkHeader(msg []byte, state *readState) error {
 		state.msgType = msg[0]                      // msgType 记录当前读取的回复类型
 		state.readingMultiLine = true               // 读取多行字符串标记置为 true
 		state.expectedArgsCount = int(expectedLine) // 记录当前读取的参数个数
-		state.args = make([][]byte, 0, expectedLine)
+		state.args = make([][]byte, 0, min(expectedLine, maxMultiBulkLen))
 		return nil
 	} else {
 		return errors.New("protocol error: " + string(msg))
@@ -265,9 +448,10 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 { // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
 		state.msgType = msg[0]
 		state.readingMultiLine = true     // 将多行字符串标记位置为 true
+		state.readingBody = true          // 下一行是字符串的内容
 		state.expectedArgsCount = 1       // 表示该字符串只包含一个元素，就是这个简单字符串
 		state.args = make([][]byte, 0, 1) // 这里创建一个数组，用于存储该简单字符串，就只包含一个元素
 		return nil
@@ -310,15 +494,24 @@ func readBody(msg []byte, state *readState) error {
 	// 先去除掉最后的 \r\n
 	line := msg[0 : len(msg)-2]
 	var err error
-	if line[0] == '$' { // 如果是 $ 开头的话，就是一个 bulk reply
+	// 已经读取过 $n 头部，这一行就是字符串内容，即使以 $ 开头或者为空
+	if state.readingBody {
+		state.args = append(state.args, line)
+		state.readingBody = false
+		return nil
+	}
+	if len(line) > 0 && line[0] == '$' { // 如果是 $ 开头的话，就是一个 bulk reply
 		// bulk reply
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
+		if err != nil || state.bulkLen < -1 {
 			return errors.New("protocol error: " + string(msg))
 		}
-		if state.bulkLen <= 0 { // null bulk in multi bulks
-			state.args = append(state.args, []byte{})
+		if state.bulkLen == -1 { // null bulk in multi bulks
+			state.args = append(state.args, nil)
 			state.bulkLen = 0
+		} else {
+			// 空字符串 $0 的内容是一个空行，仍然需要读取
+			state.readingBody = true
 		}
 	} else {
 		// 如果不是以 $ 开头的话，就是一个简单字符串
@@ -327,3 +520,157 @@ func readBody(msg []byte, state *readState) error {
 	}
 	return nil
 }
+
+// isElementHeader returns whether the line in array is an element other than bulk string
+func isElementHeader(msg []byte) bool {
+	switch msg[0] {
+	case '*', ':', '+', '-':
+		return true
+	}
+	return isRESP3Header(msg)
+}
+
+// isRESP3Header returns whether the line is the first line of RESP3 types
+// % map, ~ set, , double, # boolean, _ null, ( big number, = verbatim string, > push, | attribute
+func isRESP3Header(msg []byte) bool {
+	switch msg[0] {
+	case '%', '~', ',', '#', '_', '(', '=', '>', '|':
+		return true
+	}
+	return false
+}
+
+// addElement appends an element other than bulk string to array
+func (s *readState) addElement(element resp.Reply) {
+	if s.elements == nil {
+		s.elements = make([]resp.Reply, len(s.args), s.expectedArgsCount)
+	}
+	for len(s.elements) < len(s.args) {
+		s.elements = append(s.elements, nil)
+	}
+	s.args = append(s.args, nil)
+	s.elements = append(s.elements, element)
+}
+
+// makeMultiRawReply makes reply of array which contains elements other than bulk string
+func (s *readState) makeMultiRawReply() resp.Reply {
+	replies := make([]resp.Reply, len(s.args))
+	for i, arg := range s.args {
+		if i < len(s.elements) && s.elements[i] != nil {
+			replies[i] = s.elements[i]
+		} else if arg == nil {
+			replies[i] = &reply.NullBulkReply{}
+		} else {
+			replies[i] = reply.MakeBulkReply(arg)
+		}
+	}
+	return reply.MakeMultiRawReply(replies)
+}
+
+// readElement reads an element of array other than bulk string, nested arrays are read recursively
+// RESP3 的数据(map、set、double 等)无论在数组中还是单独出现，都由该方法读取
+// msg is the first line of the element, depth is the number of aggregate types containing the element
+// 元素读取的字节数累加到 root 中，与外层数据一起受 client-query-buffer-limit 限制
+// 返回读取到的元素，是否遇到 io 错误，以及错误信息
+func readElement(bufReader *bufio.Reader, msg []byte, root *readState, limits *Limits, depth int) (resp.Reply, bool, error) {
+	line := string(msg[1 : len(msg)-2])
+	switch msg[0] {
+	case '*', '~', '>', '%', '|':
+		// 剩余的数据无法跳过，按 io 错误处理，停止解析
+		if depth >= maxNestingDepth {
+			return nil, true, errTooDeep
+		}
+		count, err := strconv.ParseInt(line, 10, 32)
+		if err != nil || count < -1 {
+			return nil, false, errors.New("protocol error: " + string(msg))
+		}
+		if count <= 0 && msg[0] == '*' {
+			return &reply.EmptyMultiBulkReply{}, false, nil
+		}
+		if msg[0] == '%' || msg[0] == '|' {
+			count *= 2 // 键值交替
+		}
+		replies := make([]resp.Reply, 0, min(max(count, 0), maxMultiBulkLen))
+		for i := int64(0); i < count; i++ {
+			element, ioErr, err := readNextElement(bufReader, root, limits, depth+1)
+			if err != nil {
+				return nil, ioErr, err
+			}
+			replies = append(replies, element)
+		}
+		switch msg[0] {
+		case '~':
+			return reply.MakeSetReply(replies), false, nil
+		case '>':
+			return reply.MakePushReply(replies), false, nil
+		case '%':
+			return reply.MakeMapReply(replies), false, nil
+		case '|':
+			// 属性之后是实际的数据
+			element, ioErr, err := readNextElement(bufReader, root, limits, depth+1)
+			if err != nil {
+				return nil, ioErr, err
+			}
+			return reply.MakeAttributeReply(replies, element), false, nil
+		}
+		return reply.MakeMultiRawReply(replies), false, nil
+	case '$', '=':
+		var state readState
+		if err := parseBulkHeader(msg, &state); err != nil {
+			return nil, false, err
+		}
+		if state.bulkLen == -1 {
+			return &reply.NullBulkReply{}, false, nil
+		}
+		state.size = root.size // readLine 分配内存前检查累计的长度
+		body, ioErr, err := readLine(bufReader, &state, limits)
+		if err != nil {
+			return nil, ioErr, err
+		}
+		if err = root.count(body, limits); err != nil {
+			return nil, true, err
+		}
+		body = body[:len(body)-2]
+		if msg[0] == '$' {
+			return reply.MakeBulkReply(body), false, nil
+		}
+		// =15\r\ntxt:Some string\r\n
+		if len(body) < 4 || body[3] != ':' {
+			return nil, false, errors.New("protocol error: " + string(msg))
+		}
+		return reply.MakeVerbatimReply(string(body[:3]), body[4:]), false, nil
+	case ',':
+		value, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return nil, false, errors.New("protocol error: " + string(msg))
+		}
+		return reply.MakeDoubleReply(value), false, nil
+	case '#':
+		if line != "t" && line != "f" {
+			return nil, false, errors.New("protocol error: " + string(msg))
+		}
+		return reply.MakeBooleanReply(line == "t"), false, nil
+	case '_':
+		return reply.MakeNullReply(), false, nil
+	case '(':
+		if _, ok := new(big.Int).SetString(line, 10); !ok {
+			return nil, false, errors.New("protocol error: " + string(msg))
+		}
+		return reply.MakeBigNumberReply(line), false, nil
+	}
+	result, err := parseSingleLineReply(msg)
+	return result, false, err
+}
+
+// readNextElement reads the next element of aggregate type
+func readNextElement(bufReader *bufio.Reader, root *readState, limits *Limits, depth int) (resp.Reply, bool, error) {
+	var state readState
+	line, ioErr, err := readLine(bufReader, &state, limits)
+	if err != nil {
+		return nil, ioErr, err
+	}
+	if err = root.count(line, limits); err != nil {
+		return nil, true, err
+	}
+	return readElement(bufReader, line, root, limits, depth)
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"bytes"
+	"go-redis/resp/reply"
+	"io"
+	"strings"
+	"testing"
+)
+
+// parseAll parses all payloads of input, io.EOF at the end is not included
+func parseAll(input string) []*Payload {
+	var payloads []*Payload
+	for payload := range ParseStream(strings.NewReader(input)) {
+		if payload.Err == io.EOF {
+			break
+		}
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+func TestParseRESP3(t *testing.T) {
+	values := []string{
+		"%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n,1.5\r\n",
+		"~2\r\n+a\r\n+b\r\n",
+		">3\r\n$10\r\ninvalidate\r\n*1\r\n$1\r\nk\r\n_\r\n",
+		"|1\r\n$3\r\nttl\r\n:3\r\n$1\r\nv\r\n",
+		",-inf\r\n",
+		"#t\r\n",
+		"#f\r\n",
+		"_\r\n",
+		"(12345678901234567890\r\n",
+		"=6\r\ntxt:hi\r\n",
+		"*2\r\n%1\r\n+a\r\n#f\r\n~1\r\n:1\r\n",
+	}
+	payloads := parseAll(strings.Join(values, ""))
+	if len(payloads) != len(values) {
+		t.Fatalf("expected %d payloads, got %d", len(values), len(payloads))
+	}
+	for i, payload := range payloads {
+		if payload.Err != nil {
+			t.Errorf("parse %q failed: %v", values[i], payload.Err)
+			continue
+		}
+		if got := string(reply.Encode(payload.Data, reply.RESP3)); got != values[i] {
+			t.Errorf("got %q, want %q", got, values[i])
+		}
+	}
+}
+
+func TestParseInvalidRESP3(t *testing.T) {
+	for _, input := range []string{",abc\r\n", "#x\r\n", "(12a\r\n", "=5\r\nhello\r\n"} {
+		payloads := parseAll(input)
+		if len(payloads) == 0 || payloads[0].Err == nil {
+			t.Errorf("%q should be rejected", input)
+		}
+	}
+}
+
+func TestParseMultiBulk(t *testing.T) {
+	payloads := parseAll("*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$0\r\n\r\n*2\r\n$3\r\nGET\r\n$-1\r\n")
+	if len(payloads) != 2 {
+		t.Fatalf("expected 2 payloads, got %d", len(payloads))
+	}
+	cmd, ok := payloads[0].Data.(*reply.MultiBulkReply)
+	if !ok || !bytes.Equal(bytes.Join(cmd.Args, []byte(" ")), []byte("SET k ")) {
+		t.Errorf("unexpected payload %v", payloads[0].Data)
+	}
+	cmd, ok = payloads[1].Data.(*reply.MultiBulkReply)
+	if !ok || len(cmd.Args) != 2 || cmd.Args[1] != nil {
+		t.Errorf("null bulk should be parsed as nil, got %v", payloads[1].Data)
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		bulkLen  int64
+		queryBuf int64
+		err      error
+	}{
+		{name: "unlimited", input: "*2\r\n$3\r\nGET\r\n$10\r\n0123456789\r\n"},
+		{name: "within limits", input: "*2\r\n$3\r\nGET\r\n$10\r\n0123456789\r\n", bulkLen: 10, queryBuf: 64},
+		// 声明的长度超过限制时不分配内存，直接返回错误
+		{name: "bulk too long", input: "*2\r\n$3\r\nGET\r\n$1000000000\r\n", bulkLen: 10, err: ErrInvalidBulkLength},
+		{name: "bulk exceeds query buffer", input: "*2\r\n$3\r\nGET\r\n$100\r\n", queryBuf: 64, err: ErrQueryBufferLimit},
+		{name: "too many arguments", input: "*10\r\n" + strings.Repeat("$5\r\nhello\r\n", 10), queryBuf: 64, err: ErrQueryBufferLimit},
+		{name: "inline", input: "SET k " + strings.Repeat("v", 100) + "\r\n", queryBuf: 64, err: ErrQueryBufferLimit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limits := &Limits{MaxBulkLen: tt.bulkLen, MaxQueryBuffer: tt.queryBuf}
+			payload := <-ParseStreamWithLimits(strings.NewReader(tt.input), limits)
+			if tt.err == nil {
+				if payload.Err != nil {
+					t.Fatalf("unexpected error: %v", payload.Err)
+				}
+				return
+			}
+			if payload.Err != tt.err {
+				t.Fatalf("got error %v, want %v", payload.Err, tt.err)
+			}
+		})
+	}
+}
@@ -8,7 +8,7 @@ import (
 
 var (
 	// 空回复
-	nullBulkReplyBytes = []byte("$-1")
+	nullBulkReplyBytes = []byte("$-1\r\n")
 
 	// CRLF is the line separator of redis serialization protocol
 	// RESP 固定结尾
@@ -34,7 +34,7 @@ func MakeBulkReply(arg []byte) *BulkReply {
 
 // ToBytes marshal redis.Reply
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
+	if r.Arg == nil {
 		return nullBulkReplyBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
@@ -74,6 +74,32 @@ func (r *MultiBulkReply) ToBytes() []byte {
 	return buf.Bytes()
 }
 
+/* ---- Multi Raw Reply ---- */
+
+// MultiRawReply stores a list of replies, each of them could be any type
+// 回复一个嵌套数组，数组中的每个元素可以是任意类型的回复
+// 如 CLUSTER SLOTS 中同时包含数字、字符串和数组
+type MultiRawReply struct {
+	Replies []resp.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []resp.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, re := range r.Replies {
+		buf.Write(re.ToBytes())
+	}
+	return buf.Bytes()
+}
+
 /* ---- Status Reply ---- */
 
 // StatusReply stores a simple status string
@@ -154,3 +180,9 @@ func MakeErrReply(status string) *StandardErrReply {
 func IsErrorReply(reply resp.Reply) bool {
 	return reply.ToBytes()[0] == '-'
 }
+
+// IsOKReply returns true if the given reply is +OK
+// 其他节点回复的 +OK 经过解析后是 StatusReply，不能通过类型判断
+func IsOKReply(reply resp.Reply) bool {
+	return string(reply.ToBytes()) == "+OK\r\n"
+}
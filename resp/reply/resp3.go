@@ -0,0 +1,329 @@
+package reply
+
+import (
+	"bytes"
+	"go-redis/interface/resp"
+	"math"
+	"strconv"
+)
+
+/*
+RESP3 回复类型
+客户端通过 HELLO 3 切换到 RESP3 后，回复使用 RESP3 编码，否则降级为 RESP2 编码：
+	map       %   RESP2 中为键值交替的数组
+	set       ~   RESP2 中为数组
+	double    ,   RESP2 中为字符串
+	boolean   #   RESP2 中为整数 1/0
+	null      _   RESP2 中为 $-1
+	big number (  RESP2 中为字符串
+	verbatim  =   RESP2 中为字符串
+	push      >   RESP2 中为数组
+	attribute |   RESP2 中忽略属性，只回复其后的数据
+*/
+
+// protocol versions
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+var nullBytes = []byte("_\r\n")
+
+// Encode marshals reply with the given protocol version
+func Encode(r resp.Reply, protocol int) []byte {
+	if protocol == RESP3 {
+		if r3, ok := r.(resp.RESP3Reply); ok {
+			return r3.ToRESP3()
+		}
+	}
+	return r.ToBytes()
+}
+
+// encodeAggregate marshals aggregate reply whose elements are encoded with the same protocol version
+func encodeAggregate(prefix byte, count int, replies []resp.Reply, protocol int) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(prefix)
+	buf.WriteString(strconv.Itoa(count) + CRLF)
+	for _, re := range replies {
+		buf.Write(Encode(re, protocol))
+	}
+	return buf.Bytes()
+}
+
+// ToRESP3 marshals null bulk as RESP3 null
+func (r *BulkReply) ToRESP3() []byte {
+	if r.Arg == nil {
+		return nullBytes
+	}
+	return r.ToBytes()
+}
+
+// ToRESP3 marshals null bulk as RESP3 null
+func (r *NullBulkReply) ToRESP3() []byte {
+	return nullBytes
+}
+
+// ToRESP3 marshals nil elements as RESP3 null
+func (r *MultiBulkReply) ToRESP3() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Args)) + CRLF)
+	for _, arg := range r.Args {
+		if arg == nil {
+			buf.Write(nullBytes)
+		} else {
+			buf.WriteString("$" + strconv.Itoa(len(arg)) + CRLF + string(arg) + CRLF)
+		}
+	}
+	return buf.Bytes()
+}
+
+// ToRESP3 marshals elements with RESP3
+func (r *MultiRawReply) ToRESP3() []byte {
+	return encodeAggregate('*', len(r.Replies), r.Replies, RESP3)
+}
+
+/* ---- Map Reply ---- */
+
+// MapReply stores key-value pairs, Pairs is key1, value1, key2, value2...
+// 键值对回复，以 % 开头，如 HELLO 的回复
+type MapReply struct {
+	Pairs []resp.Reply
+}
+
+// MakeMapReply creates MapReply
+func MakeMapReply(pairs []resp.Reply) *MapReply {
+	return &MapReply{
+		Pairs: pairs,
+	}
+}
+
+// ToBytes marshals as array of keys and values
+func (r *MapReply) ToBytes() []byte {
+	return encodeAggregate('*', len(r.Pairs), r.Pairs, RESP2)
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *MapReply) ToRESP3() []byte {
+	return encodeAggregate('%', len(r.Pairs)/2, r.Pairs, RESP3)
+}
+
+/* ---- Set Reply ---- */
+
+// SetReply stores unordered unique elements
+// 集合回复，以 ~ 开头
+type SetReply struct {
+	Replies []resp.Reply
+}
+
+// MakeSetReply creates SetReply
+func MakeSetReply(replies []resp.Reply) *SetReply {
+	return &SetReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshals as array
+func (r *SetReply) ToBytes() []byte {
+	return encodeAggregate('*', len(r.Replies), r.Replies, RESP2)
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *SetReply) ToRESP3() []byte {
+	return encodeAggregate('~', len(r.Replies), r.Replies, RESP3)
+}
+
+/* ---- Push Reply ---- */
+
+// PushReply is out-of-band data sent to client, such as invalidation messages
+// 服务端主动推送的数据，以 > 开头
+type PushReply struct {
+	Replies []resp.Reply
+}
+
+// MakePushReply creates PushReply
+func MakePushReply(replies []resp.Reply) *PushReply {
+	return &PushReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshals as array
+func (r *PushReply) ToBytes() []byte {
+	return encodeAggregate('*', len(r.Replies), r.Replies, RESP2)
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *PushReply) ToRESP3() []byte {
+	return encodeAggregate('>', len(r.Replies), r.Replies, RESP3)
+}
+
+/* ---- Attribute Reply ---- */
+
+// AttributeReply is auxiliary key-value pairs followed by the actual reply
+// 属性回复，以 | 开头，属性之后是实际的回复
+type AttributeReply struct {
+	Pairs []resp.Reply
+	Reply resp.Reply
+}
+
+// MakeAttributeReply creates AttributeReply
+func MakeAttributeReply(pairs []resp.Reply, r resp.Reply) *AttributeReply {
+	return &AttributeReply{
+		Pairs: pairs,
+		Reply: r,
+	}
+}
+
+// ToBytes marshals the actual reply only
+func (r *AttributeReply) ToBytes() []byte {
+	return r.Reply.ToBytes()
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *AttributeReply) ToRESP3() []byte {
+	var buf bytes.Buffer
+	buf.Write(encodeAggregate('|', len(r.Pairs)/2, r.Pairs, RESP3))
+	buf.Write(Encode(r.Reply, RESP3))
+	return buf.Bytes()
+}
+
+/* ---- Double Reply ---- */
+
+// DoubleReply stores a float64 number
+// 浮点数回复，以 , 开头
+type DoubleReply struct {
+	Value float64
+}
+
+// MakeDoubleReply creates DoubleReply
+func MakeDoubleReply(value float64) *DoubleReply {
+	return &DoubleReply{
+		Value: value,
+	}
+}
+
+func (r *DoubleReply) format() string {
+	switch {
+	case math.IsInf(r.Value, 1):
+		return "inf"
+	case math.IsInf(r.Value, -1):
+		return "-inf"
+	case math.IsNaN(r.Value):
+		return "nan"
+	}
+	return strconv.FormatFloat(r.Value, 'f', -1, 64)
+}
+
+// ToBytes marshals as bulk string
+func (r *DoubleReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.format())).ToBytes()
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *DoubleReply) ToRESP3() []byte {
+	return []byte("," + r.format() + CRLF)
+}
+
+/* ---- Boolean Reply ---- */
+
+// BooleanReply stores true or false
+// 布尔回复，以 # 开头
+type BooleanReply struct {
+	Value bool
+}
+
+// MakeBooleanReply creates BooleanReply
+func MakeBooleanReply(value bool) *BooleanReply {
+	return &BooleanReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshals as integer 1 or 0
+func (r *BooleanReply) ToBytes() []byte {
+	if r.Value {
+		return []byte(":1\r\n")
+	}
+	return []byte(":0\r\n")
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *BooleanReply) ToRESP3() []byte {
+	if r.Value {
+		return []byte("#t\r\n")
+	}
+	return []byte("#f\r\n")
+}
+
+/* ---- Null Reply ---- */
+
+// NullReply is RESP3 null
+// 空值回复，RESP3 中以 _ 开头
+type NullReply struct{}
+
+// MakeNullReply creates NullReply
+func MakeNullReply() *NullReply {
+	return &NullReply{}
+}
+
+// ToBytes marshals as null bulk
+func (r *NullReply) ToBytes() []byte {
+	return nullBulkBytes
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *NullReply) ToRESP3() []byte {
+	return nullBytes
+}
+
+/* ---- Big Number Reply ---- */
+
+// BigNumberReply stores an integer out of the range of int64
+// 大整数回复，以 ( 开头
+type BigNumberReply struct {
+	Value string
+}
+
+// MakeBigNumberReply creates BigNumberReply
+func MakeBigNumberReply(value string) *BigNumberReply {
+	return &BigNumberReply{
+		Value: value,
+	}
+}
+
+// ToBytes marshals as bulk string
+func (r *BigNumberReply) ToBytes() []byte {
+	return MakeBulkReply([]byte(r.Value)).ToBytes()
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *BigNumberReply) ToRESP3() []byte {
+	return []byte("(" + r.Value + CRLF)
+}
+
+/* ---- Verbatim Reply ---- */
+
+// VerbatimReply stores a string with its format, such as txt or mkd
+// 带格式的字符串回复，以 = 开头，如 INFO 的回复
+type VerbatimReply struct {
+	Format string // 3 个字符
+	Text   []byte
+}
+
+// MakeVerbatimReply creates VerbatimReply
+func MakeVerbatimReply(format string, text []byte) *VerbatimReply {
+	return &VerbatimReply{
+		Format: format,
+		Text:   text,
+	}
+}
+
+// ToBytes marshals as bulk string
+func (r *VerbatimReply) ToBytes() []byte {
+	return MakeBulkReply(r.Text).ToBytes()
+}
+
+// ToRESP3 marshals redis.Reply
+func (r *VerbatimReply) ToRESP3() []byte {
+	return []byte("=" + strconv.Itoa(len(r.Format)+1+len(r.Text)) + CRLF + r.Format + ":" + string(r.Text) + CRLF)
+}
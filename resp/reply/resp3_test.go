@@ -0,0 +1,47 @@
+package reply
+
+import (
+	"go-redis/interface/resp"
+	"math"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		reply resp.Reply
+		resp2 string
+		resp3 string
+	}{
+		{reply: MakeMapReply([]resp.Reply{MakeBulkReply([]byte("a")), MakeIntReply(1)}),
+			resp2: "*2\r\n$1\r\na\r\n:1\r\n", resp3: "%1\r\n$1\r\na\r\n:1\r\n"},
+		{reply: MakeSetReply([]resp.Reply{MakeBulkReply([]byte("a"))}),
+			resp2: "*1\r\n$1\r\na\r\n", resp3: "~1\r\n$1\r\na\r\n"},
+		{reply: MakePushReply([]resp.Reply{MakeBulkReply([]byte("invalidate")), MakeNullReply()}),
+			resp2: "*2\r\n$10\r\ninvalidate\r\n$-1\r\n", resp3: ">2\r\n$10\r\ninvalidate\r\n_\r\n"},
+		{reply: MakeAttributeReply([]resp.Reply{MakeBulkReply([]byte("ttl")), MakeIntReply(3)}, MakeIntReply(1)),
+			resp2: ":1\r\n", resp3: "|1\r\n$3\r\nttl\r\n:3\r\n:1\r\n"},
+		{reply: MakeDoubleReply(1.5), resp2: "$3\r\n1.5\r\n", resp3: ",1.5\r\n"},
+		{reply: MakeDoubleReply(math.Inf(-1)), resp2: "$4\r\n-inf\r\n", resp3: ",-inf\r\n"},
+		{reply: MakeBooleanReply(true), resp2: ":1\r\n", resp3: "#t\r\n"},
+		{reply: MakeBooleanReply(false), resp2: ":0\r\n", resp3: "#f\r\n"},
+		{reply: MakeNullReply(), resp2: "$-1\r\n", resp3: "_\r\n"},
+		{reply: MakeNullBulkReply(), resp2: "$-1\r\n", resp3: "_\r\n"},
+		{reply: MakeBigNumberReply("12345678901234567890"),
+			resp2: "$20\r\n12345678901234567890\r\n", resp3: "(12345678901234567890\r\n"},
+		{reply: MakeVerbatimReply("txt", []byte("hi")), resp2: "$2\r\nhi\r\n", resp3: "=6\r\ntxt:hi\r\n"},
+		{reply: MakeMultiBulkReply([][]byte{[]byte("a"), nil}),
+			resp2: "*2\r\n$1\r\na\r\n$-1\r\n", resp3: "*2\r\n$1\r\na\r\n_\r\n"},
+		// 嵌套的数据按照相同的协议版本编码
+		{reply: MakeMultiRawReply([]resp.Reply{MakeMapReply([]resp.Reply{MakeBulkReply([]byte("a")), MakeBooleanReply(true)})}),
+			resp2: "*1\r\n*2\r\n$1\r\na\r\n:1\r\n", resp3: "*1\r\n%1\r\n$1\r\na\r\n#t\r\n"},
+		{reply: MakeOkReply(), resp2: "+OK\r\n", resp3: "+OK\r\n"},
+	}
+	for _, tt := range tests {
+		if got := string(Encode(tt.reply, RESP2)); got != tt.resp2 {
+			t.Errorf("RESP2: got %q, want %q", got, tt.resp2)
+		}
+		if got := string(Encode(tt.reply, RESP3)); got != tt.resp3 {
+			t.Errorf("RESP3: got %q, want %q", got, tt.resp3)
+		}
+	}
+}
@@ -6,57 +6,103 @@ package tcp
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"go-redis/interface/tcp"
 	"go-redis/lib/logger"
+	"go-redis/lib/tlsutil"
 	"net"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
+	"time"
 )
 
 // Config stores tcp server properties
 type Config struct {
 	Address string
+	// 非空时同一个端口同时接受普通连接和 TLS 连接，用于集群节点之间的 TLS 连接
+	TLSConfig *tls.Config
+	// tls-port 的监听地址，为空时不监听，只接受 TLS 连接
+	TLSAddress      string
+	ClientTLSConfig *tls.Config
+	// 客户端连接的 TCP keepalive 探测间隔，0 表示关闭 SO_KEEPALIVE
+	KeepAlive time.Duration
+	// Unix socket 文件路径，为空时不监听；UnixSocketPerm 为 0 时使用默认权限
+	UnixSocket     string
+	UnixSocketPerm os.FileMode
+	// 收到 SIGHUP 时调用，如重新加载证书，不关闭服务
+	OnReload func()
 }
 
 // ListenAndServeWithSignal binds port and handle requests, blocking until receive stop signal
 func ListenAndServeWithSignal(cfg *Config, handler tcp.Handler) error {
 	// 标准做法，监听信号，收到信号后，关闭 listener，关闭 handler
 	closeChan := make(chan struct{})
-	sigCh := make(chan os.Signal)
+	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT)
 	go func() {
-		sig := <-sigCh
-		switch sig {
-		case syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
-			closeChan <- struct{}{}
+		for sig := range sigCh {
+			switch sig {
+			case syscall.SIGHUP:
+				// SIGHUP 重新加载配置，不退出
+				if cfg.OnReload != nil {
+					cfg.OnReload()
+				}
+			case syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGINT:
+				closeChan <- struct{}{}
+				return
+			}
 		}
 	}()
 
 	// 监听端口
-	listener, err := net.Listen("tcp", cfg.Address)
+	listener, err := listenTCP(cfg.Address, cfg.KeepAlive)
 	if err != nil {
 		return err
 	}
+	if cfg.TLSConfig != nil {
+		listener = tlsutil.SniffListener(listener, cfg.TLSConfig)
+	}
 	logger.Info(fmt.Sprintf("bind: %s, start listening...", cfg.Address))
+	listeners := []net.Listener{listener}
+
+	if cfg.TLSAddress != "" {
+		tlsListener, err := listenTCP(cfg.TLSAddress, cfg.KeepAlive)
+		if err != nil {
+			_ = listener.Close()
+			return err
+		}
+		listeners = append(listeners, tls.NewListener(tlsListener, cfg.ClientTLSConfig))
+		logger.Info(fmt.Sprintf("bind tls: %s, start listening...", cfg.TLSAddress))
+	}
+
+	if cfg.UnixSocket != "" {
+		unixListener, err := listenUnix(cfg.UnixSocket, cfg.UnixSocketPerm)
+		if err != nil {
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, unixListener)
+		logger.Info(fmt.Sprintf("bind unix socket: %s, start listening...", cfg.UnixSocket))
+	}
 
 	// 启动 tcp server
-	ListenAndServe(listener, handler, closeChan)
+	ListenAndServe(listeners, handler, closeChan)
 	return nil
 }
 
-// ListenAndServe binds port and handle requests, blocking until close
-func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
+// ListenAndServe handles requests from all listeners with the same handler, blocking until close
+func ListenAndServe(listeners []net.Listener, handler tcp.Handler, closeChan <-chan struct{}) {
 	// listen signal
 	// 监听到关闭信号后，关闭 listener，关闭 handler
 	// 确保 socket 连接正确释放
 	go func() {
 		<-closeChan
 		logger.Info("shutting down...")
-		_ = listener.Close() // listener.Accept() will return err immediately
-		_ = handler.Close()  // close connections
+		closeListeners(listeners) // listener.Accept() will return err immediately
+		_ = handler.Close()       // close connections
 	}()
 
 	// listen port
@@ -64,32 +110,80 @@ func ListenAndServe(listener net.Listener, handler tcp.Handler, closeChan <-chan
 	// 确保 socket 连接正确释放
 	defer func() {
 		// close during unexpected error
-		_ = listener.Close()
+		closeListeners(listeners)
 		_ = handler.Close()
 	}()
 
 	// 等待组，等待所有的连接处理完成后再退出
 	ctx := context.Background()
 	var waitDone sync.WaitGroup
-	for {
-		// 循环接收新连接
-		conn, err := listener.Accept()
-		if err != nil {
-			break
-		}
-		// handle
-		logger.Info("accept link")
-		// 一个协程处理一个连接
-		waitDone.Add(1)
+	var acceptDone sync.WaitGroup
+	for _, listener := range listeners {
+		acceptDone.Add(1)
 		go func() {
-			// 防止 handle 出现 panic，这里最好还是使用 defer
-			// 业务处理完毕，连接断开，释放资源
-			defer func() {
-				waitDone.Done()
-			}()
-			handler.Handle(ctx, conn)
+			defer acceptDone.Done()
+			for {
+				// 循环接收新连接
+				conn, err := listener.Accept()
+				if err != nil {
+					// 任何一个 listener 出错都关闭服务
+					closeListeners(listeners)
+					return
+				}
+				// handle
+				logger.Info("accept link")
+				// 一个协程处理一个连接
+				waitDone.Add(1)
+				go func() {
+					// 防止 handle 出现 panic，这里最好还是使用 defer
+					// 业务处理完毕，连接断开，释放资源
+					defer func() {
+						waitDone.Done()
+					}()
+					handler.Handle(ctx, conn)
+				}()
+			}
 		}()
 	}
+	acceptDone.Wait()
 	// 这里需要等待所有的连接处理完成后，再退出
 	waitDone.Wait()
 }
+
+// listenTCP listens on tcp address, sets SO_KEEPALIVE of accepted connections
+func listenTCP(addr string, keepAlive time.Duration) (net.Listener, error) {
+	lc := net.ListenConfig{KeepAlive: keepAlive}
+	if keepAlive <= 0 {
+		// ListenConfig 的 KeepAlive 为 0 时使用默认间隔，负数才会关闭
+		lc.KeepAlive = -1
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// listenUnix listens on unix socket, removes the stale socket file left by last crash
+// 关闭 listener 时 socket 文件会被删除
+func listenUnix(path string, perm os.FileMode) (net.Listener, error) {
+	if info, err := os.Stat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+		}
+		_ = os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if perm != 0 {
+		if err := os.Chmod(path, perm); err != nil {
+			_ = listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, listener := range listeners {
+		_ = listener.Close()
+	}
+}
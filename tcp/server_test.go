@@ -0,0 +1,100 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// socketDir returns a short temporary directory, the path of unix socket is limited to about 100 bytes
+func socketDir(t *testing.T) string {
+	dir, err := os.MkdirTemp("", "sock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func TestListenUnix(t *testing.T) {
+	dir := socketDir(t)
+	path := filepath.Join(dir, "redis.sock")
+
+	// 上次崩溃残留的 socket 文件
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	_ = stale.Close()
+	listener, err := listenUnix(path, 0700)
+	if err != nil {
+		t.Fatalf("stale socket file should be removed: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0700 {
+		t.Errorf("permission should be 0700, got %o", info.Mode().Perm())
+	}
+	_ = listener.Close()
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("socket file should be removed after closed")
+	}
+
+	regular := filepath.Join(dir, "regular")
+	if err = os.WriteFile(regular, []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = listenUnix(regular, 0); err == nil {
+		t.Error("regular file should not be removed")
+	}
+}
+
+func TestListenAndServe(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(socketDir(t), "redis.sock")
+	unixListener, err := listenUnix(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	closeChan := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		ListenAndServe([]net.Listener{tcpListener, unixListener}, MakeHandler(), closeChan)
+		close(done)
+	}()
+
+	for _, addr := range []net.Addr{tcpListener.Addr(), unixListener.Addr()} {
+		conn, err := net.Dial(addr.Network(), addr.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(3 * time.Second))
+		if _, err = conn.Write([]byte("hello\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil || line != "hello\n" {
+			t.Errorf("%s: got %q, %v", addr.Network(), line, err)
+		}
+		_ = conn.Close()
+	}
+
+	closeChan <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("server does not stop")
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Error("socket file should be removed after server stopped")
+	}
+}